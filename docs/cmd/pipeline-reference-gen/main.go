@@ -11,7 +11,7 @@ import (
 	"text/template"
 
 	"chainguard.dev/melange/pkg/config"
-	"sigs.k8s.io/yaml"
+	"gopkg.in/yaml.v3"
 
 	_ "embed"
 )
@@ -22,6 +22,7 @@ var (
 	tmpl = template.Must(template.New("").Funcs(template.FuncMap{
 		"anchor": func(s string) string {
 			out := strings.ReplaceAll(s, "/", "")
+			out = strings.ReplaceAll(out, "@", "")
 			return out
 		},
 	}).Parse(tmplRaw))
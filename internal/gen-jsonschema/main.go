@@ -27,6 +27,19 @@ func main() {
 		log.Fatal(err)
 	}
 	schema := r.Reflect(config.Configuration{})
+
+	// vars maps to strings, but can declare variables too, which the
+	// configuration is decoded with in place of their defaults.
+	decl := r.Reflect(config.VarDeclaration{})
+	schema.Definitions["VarDeclaration"] = decl.Definitions["VarDeclaration"]
+	if vars, ok := schema.Definitions["Configuration"].Properties.Get("vars"); ok {
+		vars.AdditionalProperties = &jsonschema.Schema{
+			OneOf: []*jsonschema.Schema{
+				{Type: "string"},
+				{Ref: "#/$defs/VarDeclaration"},
+			},
+		}
+	}
 	b := new(bytes.Buffer)
 	enc := json.NewEncoder(b)
 	enc.SetIndent("", "  ")
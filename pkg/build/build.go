@@ -37,20 +37,22 @@ import (
 	apko_build "chainguard.dev/apko/pkg/build"
 	apko_types "chainguard.dev/apko/pkg/build/types"
 	"chainguard.dev/apko/pkg/options"
-	"chainguard.dev/apko/pkg/sbom/generator/spdx"
 	"cloud.google.com/go/storage"
 	"github.com/chainguard-dev/clog"
 	purl "github.com/package-url/packageurl-go"
 	"github.com/yookoala/realpath"
 	"github.com/zealic/xignore"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/exp/maps"
+	"golang.org/x/sync/errgroup"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 	"k8s.io/kube-openapi/pkg/util/sets"
 
 	"chainguard.dev/melange/pkg/config"
 	"chainguard.dev/melange/pkg/container"
+	"chainguard.dev/melange/pkg/egress"
 	"chainguard.dev/melange/pkg/index"
 	"chainguard.dev/melange/pkg/linter"
 	"chainguard.dev/melange/pkg/sbom"
@@ -111,6 +113,10 @@ type Build struct {
 	Interactive           bool
 	Remove                bool
 	LintRequire, LintWarn []string
+	LintReport            *linter.Report
+	LintPlugins           map[string]string
+	LintFix               bool
+	LintBaseline          *linter.Baseline
 	DefaultCPU            string
 	DefaultCPUModel       string
 	DefaultDisk           string
@@ -121,6 +127,90 @@ type Build struct {
 
 	EnabledBuildOptions []string
 
+	// The combination of matrix values to build, if the configuration has a matrix.
+	Matrix map[string]string
+
+	// Where pipelines from git repositories are cached.
+	RemotePipelineCacheDir string
+
+	// Whether to cut the build off from the network once it has fetched its
+	// sources.
+	Hermetic bool
+
+	// Hosts that the build can connect to, in addition to the
+	// configuration's egress allowlist, and where to write a report of the
+	// hosts it tried to connect to.
+	EgressAllow    []string
+	EgressReport   string
+	egressProxy    *egress.Proxy
+	egressProxyURL string
+
+	// Whether to snapshot the workspace after each top-level step, and resume
+	// from the last snapshot of a build that failed.
+	Resume bool
+
+	// The names, ids or uses of the steps to pause the build before and
+	// after, with a shell in the guest.
+	BreakBefore, BreakAfter []string
+
+	// The compiler cache to use, CompilerCacheCcache or CompilerCacheSccache,
+	// if any.
+	CompilerCache string
+
+	// Whether to cache the downloads and build outputs of the Go and Rust
+	// toolchains in the guest, and how big the caches can grow.
+	CacheToolchains    bool
+	ToolchainCacheSize string
+
+	// Whether to cache the guest environments that builds lay out, by the
+	// packages they're resolved to, and how big the cache can grow.
+	CacheGuest     bool
+	GuestCacheSize string
+
+	// Commands to run on the host, with sh -c, before and after the build.
+	PreBuildHooks, PostBuildHooks []string
+
+	// Host directories to mount into the guest, after melange's own.
+	Mounts []container.BindMount
+
+	// The kinds of subpackages, such as dev and doc, to split from every
+	// package that doesn't opt out of them.
+	DefaultSplits []string
+
+	// The formats, such as sbom.FormatSPDX, that the SBOMs of the packages
+	// are written in.
+	SBOMFormats []string
+
+	// Whether to reject misindented keys of the configuration, and unknown
+	// fields in the pipelines it uses.
+	Strict bool
+
+	// The environment variables that ${{env.*}} can substitute in the
+	// configuration.
+	AllowedEnv []string
+
+	// Whether to report how much each step grows the workspace by, and how
+	// much disk the workspace can use, such as 20GB, before the build fails.
+	WorkspaceUsage bool
+	WorkspaceQuota string
+	disk           *diskTracker
+
+	// Secrets to make available to steps, and the directory on the host that
+	// they're written to for the runner.
+	Secrets    []Secret
+	secretsDir string
+	redactor   *strings.Replacer
+
+	// Whether to write the output of each step to files of its own, under
+	// the output directory, and where to write the build's event stream, or
+	// - for stdout.
+	StepLogs   bool
+	EventsFile string
+	recorder   *stepRecorder
+
+	// Called with each event of the build, such as for showing its progress.
+	EventHandler func(Event)
+
 	// Initialized in New and mutated throughout the build process as we gain
 	// visibility into our packages' (including subpackages') composition. This is
 	// how we get "build-time" SBOMs!
@@ -134,6 +224,7 @@ func New(ctx context.Context, opts ...Option) (*Build, error) {
 		OutDir:          ".",
 		CacheDir:        "./melange-cache/",
 		Arch:            apko_types.ParseArchitecture(runtime.GOARCH),
+		SBOMFormats:     []string{sbom.FormatSPDX},
 	}
 
 	for _, opt := range opts {
@@ -149,7 +240,7 @@ func New(ctx context.Context, opts ...Option) (*Build, error) {
 	// temporary directory for it.  Otherwise, ensure we are in a
 	// subdir for this specific build context.
 	if b.WorkspaceDir != "" {
-		b.WorkspaceDir = filepath.Join(b.WorkspaceDir, b.Arch.ToAPK())
+		b.WorkspaceDir = filepath.Join(b.WorkspaceDir, b.Arch.ToAPK(), config.MatrixName(b.Matrix))
 
 		// Get the absolute path to the workspace dir, which is needed for bind
 		// mounts.
@@ -167,22 +258,8 @@ func New(ctx context.Context, opts ...Option) (*Build, error) {
 		b.WorkspaceDir = tmpdir
 	}
 
-	// If no config file is explicitly requested for the build context
-	// we check if .melange.yaml or melange.yaml exist.
-	checks := []string{".melange.yaml", ".melange.yml", "melange.yaml", "melange.yml"}
-	if b.ConfigFile == "" {
-		for _, chk := range checks {
-			if _, err := os.Stat(chk); err == nil {
-				log.Infof("no configuration file provided -- using %s", chk)
-				b.ConfigFile = chk
-				break
-			}
-		}
-	}
-
-	// If no config file could be automatically detected, error.
-	if b.ConfigFile == "" {
-		return nil, fmt.Errorf("melange.yaml is missing")
+	if err := b.detectConfigFile(ctx); err != nil {
+		return nil, err
 	}
 	if b.ConfigFileRepositoryURL == "" {
 		return nil, fmt.Errorf("config file repository URL was not set")
@@ -204,6 +281,11 @@ func New(ctx context.Context, opts ...Option) (*Build, error) {
 		config.WithDefaultMemory(b.DefaultMemory),
 		config.WithDefaultTimeout(b.DefaultTimeout),
 		config.WithCommit(b.ConfigFileRepositoryCommit),
+		config.WithMatrix(b.Matrix),
+		config.WithArch(b.Arch),
+		config.WithDefaultSplits(b.DefaultSplits),
+		config.WithStrict(b.Strict),
+		config.WithAllowedEnv(b.AllowedEnv),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load configuration: %w", err)
@@ -252,6 +334,52 @@ func New(ctx context.Context, opts ...Option) (*Build, error) {
 	return &b, nil
 }
 
+// detectConfigFile checks if .melange.yaml or melange.yaml exist, if no
+// config file is explicitly requested for the build context.
+func (b *Build) detectConfigFile(ctx context.Context) error {
+	checks := []string{".melange.yaml", ".melange.yml", "melange.yaml", "melange.yml"}
+	if b.ConfigFile == "" {
+		for _, chk := range checks {
+			if _, err := os.Stat(chk); err == nil {
+				clog.FromContext(ctx).Infof("no configuration file provided -- using %s", chk)
+				b.ConfigFile = chk
+				break
+			}
+		}
+	}
+
+	// If no config file could be automatically detected, error.
+	if b.ConfigFile == "" {
+		return fmt.Errorf("melange.yaml is missing")
+	}
+	return nil
+}
+
+// MatrixCombinations returns the combinations of matrix values that the
+// configuration the options refer to should be built for, or nil if it
+// doesn't have a matrix.
+func MatrixCombinations(ctx context.Context, opts ...Option) ([]map[string]string, error) {
+	var b Build
+	for _, opt := range opts {
+		if err := opt(&b); err != nil {
+			return nil, err
+		}
+	}
+	if err := b.detectConfigFile(ctx); err != nil {
+		return nil, err
+	}
+
+	cfg, err := config.ParseConfiguration(ctx,
+		b.ConfigFile,
+		config.WithEnvFileForParsing(b.EnvFile),
+		config.WithVarsFileForParsing(b.VarsFile),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
+	return cfg.MatrixCombinations(), nil
+}
+
 func (b *Build) Close(ctx context.Context) error {
 	log := clog.FromContext(ctx)
 	errs := []error{}
@@ -291,7 +419,7 @@ func (b *Build) buildGuest(ctx context.Context, imgConfig apko_types.ImageConfig
 		}...)
 	}
 
-	bc, err := apko_build.New(ctx, guestFS,
+	opts := []apko_build.Option{
 		apko_build.WithImageConfiguration(imgConfig),
 		apko_build.WithArch(b.Arch),
 		apko_build.WithExtraKeys(b.ExtraKeys),
@@ -299,7 +427,50 @@ func (b *Build) buildGuest(ctx context.Context, imgConfig apko_types.ImageConfig
 		apko_build.WithExtraPackages(b.ExtraPackages),
 		apko_build.WithCache(b.ApkCacheDir, false, apk.NewCache(true)),
 		apko_build.WithTempDir(tmp),
-		apko_build.WithIgnoreSignatures(b.IgnoreSignatures))
+		apko_build.WithIgnoreSignatures(b.IgnoreSignatures),
+	}
+
+	// if the runner needs an image, create an OCI image from the directory and load it.
+	loader := b.Runner.OCIImageLoader()
+	if loader == nil {
+		return "", fmt.Errorf("runner %s does not support OCI image loading", b.Runner.Name())
+	}
+
+	var cacheDir, cacheKey string
+	if b.CacheGuest {
+		// Resolve the packages without installing them, to see whether a
+		// guest with them has been cached.
+		rbc, err := apko_build.New(ctx, apkofs.NewMemFS(), opts...)
+		if err != nil {
+			return "", fmt.Errorf("unable to create build context: %w", err)
+		}
+		pkgs, _, err := rbc.BuildPackageList(ctx)
+		if err != nil {
+			return "", fmt.Errorf("resolving guest packages: %w", err)
+		}
+		if cacheKey, err = b.guestCacheKey(imgConfig, pkgs); err != nil {
+			return "", fmt.Errorf("computing guest cache key: %w", err)
+		}
+		if cacheDir, err = guestCacheDir(); err != nil {
+			return "", err
+		}
+
+		path := filepath.Join(cacheDir, cacheKey+".tar.gz")
+		layer, err := restoreGuest(path, guestFS)
+		switch {
+		case err == nil:
+			log.Infof("using cached guest %s", path)
+			return loader.LoadImage(ctx, layer, b.Arch, rbc)
+		case errors.Is(err, fs.ErrNotExist):
+			log.Infof("no cached guest for %d packages, building one", len(pkgs))
+		default:
+			// Don't trip over the same broken guest in the next build.
+			os.Remove(path)
+			return "", fmt.Errorf("restoring cached guest %s: %w", path, err)
+		}
+	}
+
+	bc, err := apko_build.New(ctx, guestFS, opts...)
 	if err != nil {
 		return "", fmt.Errorf("unable to create build context: %w", err)
 	}
@@ -311,11 +482,6 @@ func (b *Build) buildGuest(ctx context.Context, imgConfig apko_types.ImageConfig
 	if err := bc.BuildImage(ctx); err != nil {
 		return "", fmt.Errorf("unable to generate image: %w", err)
 	}
-	// if the runner needs an image, create an OCI image from the directory and load it.
-	loader := b.Runner.OCIImageLoader()
-	if loader == nil {
-		return "", fmt.Errorf("runner %s does not support OCI image loading", b.Runner.Name())
-	}
 	layerTarGZ, layer, err := bc.ImageLayoutToLayer(ctx)
 	if err != nil {
 		return "", err
@@ -324,6 +490,10 @@ func (b *Build) buildGuest(ctx context.Context, imgConfig apko_types.ImageConfig
 
 	log.Infof("using %s for image layer", layerTarGZ)
 
+	if b.CacheGuest {
+		b.cacheGuest(ctx, cacheDir, cacheKey, layerTarGZ)
+	}
+
 	ref, err := loader.LoadImage(ctx, layer, b.Arch, bc)
 	if err != nil {
 		return "", err
@@ -394,6 +564,13 @@ func (b *Build) applyBuildOption(bo config.BuildOption) error {
 		b.Configuration.Environment.Contents.Packages = pkgList
 	}
 
+	if len(bo.Environment.Environment) != 0 && b.Configuration.Environment.Environment == nil {
+		b.Configuration.Environment.Environment = make(map[string]string)
+	}
+	for k, v := range bo.Environment.Environment {
+		b.Configuration.Environment.Environment[k] = v
+	}
+
 	return nil
 }
 
@@ -523,6 +700,81 @@ func fetchBucket(ctx context.Context, cacheSource string, cmm CacheMembershipMap
 	return tmp, nil
 }
 
+// How many independent subpackage pipelines run at once. They mostly move
+// files around, so this bounds the load on the guest rather than matching
+// its CPUs, which the host doesn't know for every runner.
+const maxConcurrentSubpackages = 8
+
+// runSubpackagePipelines runs the pipelines of the subpackages in order,
+// except that consecutive independent subpackages run theirs concurrently.
+// Interactive builds, and builds with breakpoints, run everything in order,
+// so that only one step at a time can drop into a debug shell.
+func (b *Build) runSubpackagePipelines(ctx context.Context, pr *pipelineRunner) error {
+	log := clog.FromContext(ctx)
+
+	run := func(ctx context.Context, sp *config.Subpackage) (err error) {
+		if b.recorder != nil {
+			finish := b.recorder.startSubpackage(ctx, sp.Name)
+			defer func() { finish(err) }()
+		}
+		if !b.isBuildLess() {
+			log.Infof("running pipeline for subpackage %s", sp.Name)
+
+			ctx := withSubpackage(clog.WithLogger(ctx, log.With("subpackage", sp.Name)), sp.Name)
+			ctx, span := otel.Tracer("melange").Start(ctx, sp.Name, trace.WithAttributes(attrSubpackage.String(sp.Name)))
+
+			err := pr.runPipelines(ctx, sp.Pipeline)
+			endSpan(span, err)
+			if err != nil {
+				return fmt.Errorf("unable to run subpackage %s pipeline: %w", sp.Name, err)
+			}
+		}
+
+		return os.MkdirAll(filepath.Join(b.WorkspaceDir, melangeOutputDirName, sp.Name), 0o755)
+	}
+
+	sequential := b.Interactive || len(b.BreakBefore)+len(b.BreakAfter) > 0
+
+	sps := b.Configuration.Subpackages
+	for i := 0; i < len(sps); {
+		if !sps[i].Independent || sequential {
+			if err := run(ctx, &sps[i]); err != nil {
+				return err
+			}
+			i++
+			continue
+		}
+
+		g, gctx := errgroup.WithContext(ctx)
+		g.SetLimit(maxConcurrentSubpackages)
+		for ; i < len(sps) && sps[i].Independent; i++ {
+			sp := &sps[i]
+			g.Go(func() error {
+				return run(gctx, sp)
+			})
+		}
+		if err := g.Wait(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fetchSteps returns how many of the steps in pipelines a hermetic build runs
+// with the network: those up to the last one that fetches sources with fetch
+// or git-checkout.
+func fetchSteps(pipelines []config.Pipeline) int {
+	n := 0
+	for i, p := range pipelines {
+		switch config.UsesName(p.Uses) {
+		case "fetch", "git-checkout":
+			n = i + 1
+		}
+	}
+	return n
+}
+
 // isBuildLess returns true if the build context does not actually do any building.
 // TODO(kaniini): Improve the heuristic for this by checking for uses/runs statements
 // in the pipeline.
@@ -669,14 +921,150 @@ func (b *Build) populateWorkspace(ctx context.Context, src fs.FS) error {
 }
 
 type linterTarget struct {
-	pkgName  string
-	disabled []string // checks that are downgraded from required -> warn
+	pkgName    string
+	checks     config.Checks
+	runtime    []string
+	noProvides bool
+}
+
+// lintOptions returns the linters to run on the package lt, and how to run
+// them.
+func (b *Build) lintOptions(lt linterTarget, siblings map[string]string, rules []linter.Rule) ([]string, []string, []linter.Option) {
+	// Downgrade disabled checks from required to warn
+	require := slices.DeleteFunc(slices.Clone(b.LintRequire), func(s string) bool {
+		return slices.Contains(lt.checks.Disabled, s)
+	})
+	warn := slices.CompactFunc(append(slices.Clone(b.LintWarn), lt.checks.Disabled...), func(a, b string) bool {
+		return a == b
+	})
+
+	severities := make(map[string]linter.Severity, len(lt.checks.Severity))
+	for name, sev := range lt.checks.Severity {
+		severities[name] = linter.Severity(sev)
+	}
+
+	environment := b.GuestDir
+	if b.isBuildLess() {
+		environment = ""
+	}
+
+	return require, warn, []linter.Option{
+		linter.WithSeverities(severities),
+		linter.WithIgnores(lt.checks.Ignore),
+		linter.WithReport(b.LintReport),
+		linter.WithRuntimeDependencies(lt.runtime),
+		linter.WithScriptlets(b.scriptlets(lt.pkgName).Scripts()),
+		linter.WithNoProvides(lt.noProvides),
+		linter.WithRPaths(lt.checks.RPaths),
+		linter.WithRoots(lt.checks.Roots),
+		linter.WithSizeLimits(lt.checks.MaxFileSize, lt.checks.MaxPackageSize),
+		linter.WithSiblings(siblings),
+		linter.WithWorkspaceDir(b.WorkspaceDir),
+		linter.WithBuildEnvironment(environment),
+		linter.WithArch(b.Arch.ToAPK()),
+		linter.WithRules(rules),
+		linter.WithPlugins(b.LintPlugins),
+		linter.WithFix(b.LintFix),
+		linter.WithBaseline(b.LintBaseline),
+	}
+}
+
+// scriptlets returns the scriptlets of the package or subpackage named name,
+// including the triggers that the build adds.
+func (b *Build) scriptlets(name string) *config.Scriptlets {
+	if name == b.Configuration.Package.Name {
+		return b.Configuration.Package.Scriptlets
+	}
+	for _, sp := range b.Configuration.Subpackages {
+		if sp.Name == name {
+			return sp.Scriptlets
+		}
+	}
+	return nil
 }
 
-func (b *Build) BuildPackage(ctx context.Context) error {
+// lintBuild runs the linters on what the build put in the workspace for each
+// package.
+func (b *Build) lintBuild(ctx context.Context, linterQueue []linterTarget, siblings map[string]string, rules []linter.Rule) (err error) {
+	log := clog.FromContext(ctx)
+	ctx, span := otel.Tracer("melange").Start(ctx, "LintBuild")
+	defer func() { endSpan(span, err) }()
+
+	for _, lt := range linterQueue {
+		log.Infof("running package linters for %s", lt.pkgName)
+		require, warn, opts := b.lintOptions(lt, siblings, rules)
+		if err := linter.LintBuild(ctx, lt.pkgName, siblings[lt.pkgName], require, warn, opts...); err != nil {
+			return fmt.Errorf("unable to lint package %s: %w", lt.pkgName, err)
+		}
+	}
+	return nil
+}
+
+// lintProvides runs the linters on what the emitted packages provide.
+func (b *Build) lintProvides(ctx context.Context, linterQueue []linterTarget, siblings map[string]string, rules []linter.Rule, providers map[string]linter.Provider) (err error) {
+	ctx, span := otel.Tracer("melange").Start(ctx, "LintProvides")
+	defer func() { endSpan(span, err) }()
+
+	for _, lt := range linterQueue {
+		require, warn, opts := b.lintOptions(lt, siblings, rules)
+		opts = append(opts, linter.WithProviders(providers))
+		if err := linter.LintProvides(ctx, lt.pkgName, require, warn, opts...); err != nil {
+			return fmt.Errorf("unable to lint package %s: %w", lt.pkgName, err)
+		}
+	}
+	return nil
+}
+
+// BuildPackage builds the package, writing the logs of its steps and the
+// events of the build, if asked to.
+func (b *Build) BuildPackage(ctx context.Context) (err error) {
+	ctx, span := otel.Tracer("melange").Start(ctx, "BuildPackage", trace.WithAttributes(
+		attrPackage.String(b.Configuration.Package.Name),
+		attrVersion.String(b.Configuration.Package.FullVersion()),
+		attrArch.String(b.Arch.ToAPK()),
+	))
+	defer func() { endSpan(span, err) }()
+
+	rec, closeEvents, err := b.newStepRecorder()
+	if err != nil {
+		return err
+	}
+	if rec != nil {
+		defer closeEvents()
+		b.recorder = rec
+
+		var subpackages []string
+		for _, sp := range b.Configuration.Subpackages {
+			subpackages = append(subpackages, sp.Name)
+		}
+		rec.emit(ctx, Event{Type: EventBuildStarted, Subpackages: subpackages})
+	}
+
+	b.disk = b.newDiskTracker(ctx)
+	if rec != nil {
+		rec.disk = b.disk
+	}
+
+	began := time.Now()
+	err = b.runPreBuildHooks(ctx)
+	if err == nil {
+		err = b.buildPackage(ctx)
+	}
+	// Post-build hooks run even if the build failed, so that they can say
+	// that it did.
+	if herr := b.runPostBuildHooks(ctx, began, err); herr != nil {
+		err = errors.Join(err, herr)
+	}
+	b.disk.report(ctx)
+
+	if rec != nil {
+		rec.finish(ctx, began, err)
+	}
+	return err
+}
+
+func (b *Build) buildPackage(ctx context.Context) error {
 	log := clog.FromContext(ctx)
-	ctx, span := otel.Tracer("melange").Start(ctx, "BuildPackage")
-	defer span.End()
 
 	b.summarize(ctx)
 
@@ -695,6 +1083,11 @@ func (b *Build) BuildPackage(ctx context.Context) error {
 	pkg := &b.Configuration.Package
 	arch := b.Arch.ToAPK()
 
+	// Leave out the subpackages that aren't built for this architecture,
+	// before they get SBOMs and what their pipelines need is added to the
+	// environment.
+	b.skipSubpackagesNotBuiltFor(ctx, b.Arch)
+
 	// Add the APK package(s) to their respective SBOMs. We do this early in the
 	// build process so that we can later add more kinds of packages that relate to
 	// these packages, as we learn more during the build.
@@ -705,8 +1098,8 @@ func (b *Build) BuildPackage(ctx context.Context) error {
 		apkSubPkg := &sbom.Package{
 			Name:            sp.Name,
 			Version:         pkg.FullVersion(),
-			Copyright:       pkg.FullCopyright(),
-			LicenseDeclared: pkg.LicenseExpression(),
+			Copyright:       sp.FullCopyright(*pkg),
+			LicenseDeclared: sp.LicenseExpression(*pkg),
 			Namespace:       namespace,
 			Arch:            arch,
 			PURL:            pkg.PackageURLForSubpackage(namespace, arch, sp.Name),
@@ -738,6 +1131,12 @@ func (b *Build) BuildPackage(ctx context.Context) error {
 		}
 	}
 
+	if b.Hermetic {
+		if _, ok := b.Runner.(container.NetworkDisabler); !ok {
+			return fmt.Errorf("the %s runner doesn't support hermetic builds", b.Runner.Name())
+		}
+	}
+
 	log.Infof("evaluating pipelines for package requirements")
 	if err := b.Compile(ctx); err != nil {
 		return fmt.Errorf("compiling build: %w", err)
@@ -752,6 +1151,9 @@ func (b *Build) BuildPackage(ctx context.Context) error {
 		}
 		if !result {
 			log.Infof("skipping subpackage %s because %s == false", sp.Name, sp.If)
+			if b.recorder != nil {
+				b.recorder.emit(ctx, Event{Type: EventSubpackageSkipped, Subpackage: sp.Name})
+			}
 		}
 
 		return !result
@@ -761,11 +1163,48 @@ func (b *Build) BuildPackage(ctx context.Context) error {
 		return fmt.Errorf("adding SBOM package for build config file: %w", err)
 	}
 
+	if err := b.prepareCompilerCache(ctx); err != nil {
+		return err
+	}
+	if err := b.prepareToolchainCaches(); err != nil {
+		return err
+	}
+	if err := b.prepareNamedCaches(ctx); err != nil {
+		return err
+	}
+	if err := b.prepareMounts(ctx); err != nil {
+		return err
+	}
+
+	stopEgressProxy, err := b.startEgressProxy(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := stopEgressProxy(); err != nil {
+			log.Warnf("%v", err)
+		}
+	}()
+
+	ctx, cleanupSecrets, err := b.prepareSecrets(ctx)
+	if err != nil {
+		return fmt.Errorf("preparing secrets: %w", err)
+	}
+	defer cleanupSecrets()
+
 	pr := &pipelineRunner{
 		interactive: b.Interactive,
 		debug:       b.Debug,
 		config:      b.workspaceConfig(ctx),
 		runner:      b.Runner,
+		secretEnv:   loadSecretEnv(b.Secrets),
+		breakBefore: b.BreakBefore,
+		breakAfter:  b.BreakAfter,
+		recorder:    b.recorder,
+		disk:        b.disk,
+	}
+	if b.recorder != nil {
+		b.recorder.redact = b.redactor
 	}
 
 	if b.EmptyWorkspace {
@@ -788,6 +1227,7 @@ func (b *Build) BuildPackage(ctx context.Context) error {
 
 	linterQueue := []linterTarget{}
 	cfg := b.workspaceConfig(ctx)
+	var resume *resumer
 
 	if !b.isBuildLess() {
 		// Prepare guest directory
@@ -816,6 +1256,10 @@ func (b *Build) BuildPackage(ctx context.Context) error {
 			return fmt.Errorf("unable to populate cache: %w", err)
 		}
 
+		if err := b.setupToolchainCaches(ctx, cfg); err != nil {
+			return fmt.Errorf("unable to set up toolchain caches: %w", err)
+		}
+
 		if err := b.Runner.StartPod(ctx, cfg); err != nil {
 			return fmt.Errorf("unable to start pod: %w", err)
 		}
@@ -827,13 +1271,46 @@ func (b *Build) BuildPackage(ctx context.Context) error {
 			}()
 		}
 
+		res, err := b.prepareResume(ctx)
+		if err != nil {
+			return err
+		}
+		resume = res
+
+		if b.egressProxy != nil {
+			if err := b.Runner.(container.EgressProxier).RestrictToHost(ctx, cfg); err != nil {
+				return fmt.Errorf("restricting the network to the egress proxy: %w", err)
+			}
+		}
+
+		b.compilerCacheStats(ctx, cfg, true)
+
 		// run the main pipeline
 		log.Debug("running the main pipeline")
 		pipelines := b.Configuration.Pipeline
-		if err := pr.runPipelines(ctx, pipelines); err != nil {
+		fetching := len(pipelines)
+		if b.Hermetic {
+			fetching = fetchSteps(pipelines)
+		}
+		if err := b.runSteps(ctx, pr, resume, pipelines, 0, fetching); err != nil {
+			return fmt.Errorf("unable to run package %s pipeline: %w", b.Configuration.Name(), err)
+		}
+		if b.Hermetic {
+			log.Infof("hermetic build: disabling the network after %d steps", fetching)
+			if err := b.Runner.(container.NetworkDisabler).DisableNetwork(ctx, cfg); err != nil {
+				return fmt.Errorf("disabling the network: %w", err)
+			}
+			// Some runners can still reach the host, and so the proxy.
+			if b.egressProxy != nil {
+				b.egressProxy.Close()
+			}
+		}
+		if err := b.runSteps(ctx, pr, resume, pipelines, fetching, len(pipelines)); err != nil {
 			return fmt.Errorf("unable to run package %s pipeline: %w", b.Configuration.Name(), err)
 		}
 
+		b.compilerCacheStats(ctx, cfg, false)
+
 		for i, p := range pipelines {
 			uniqueID := strconv.Itoa(i)
 			pkg, err := p.SBOMPackageForUpstreamSource(b.Configuration.Package.LicenseExpression(), namespace, uniqueID)
@@ -849,39 +1326,34 @@ func (b *Build) BuildPackage(ctx context.Context) error {
 			b.SBOMGroup.AddUpstreamSourcePackage(pkg)
 		}
 
-		// add the main package to the linter queue
-		lintTarget := linterTarget{
-			pkgName:  b.Configuration.Package.Name,
-			disabled: b.Configuration.Package.Checks.Disabled,
-		}
-		linterQueue = append(linterQueue, lintTarget)
-	}
-
-	// run any pipelines for subpackages
-	for _, sp := range b.Configuration.Subpackages {
-		sp := sp
-		if !b.isBuildLess() {
-			log.Infof("running pipeline for subpackage %s", sp.Name)
-
-			ctx := clog.WithLogger(ctx, log.With("subpackage", sp.Name))
-
-			if err := pr.runPipelines(ctx, sp.Pipeline); err != nil {
-				return fmt.Errorf("unable to run subpackage %s pipeline: %w", sp.Name, err)
+		for i, p := range pipelines {
+			if config.UsesName(p.Uses) != "patch/apply" {
+				continue
+			}
+			pkgs, err := patchSBOMPackages(ctx, os.DirFS(b.SourceDir), p, b.Configuration.Package.LicenseExpression(), namespace, strconv.Itoa(i))
+			if err != nil {
+				return fmt.Errorf("creating SBOM packages for patches: %w", err)
+			}
+			for _, pkg := range pkgs {
+				b.SBOMGroup.AddUpstreamSourcePackage(pkg)
 			}
-		}
-
-		if err := os.MkdirAll(filepath.Join(b.WorkspaceDir, melangeOutputDirName, sp.Name), 0o755); err != nil {
-			return err
 		}
 
 		// add the main package to the linter queue
 		lintTarget := linterTarget{
-			pkgName:  sp.Name,
-			disabled: sp.Checks.Disabled,
+			pkgName:    b.Configuration.Package.Name,
+			checks:     b.Configuration.Package.Checks,
+			runtime:    b.Configuration.Package.Dependencies.Runtime,
+			noProvides: b.Configuration.Package.Options != nil && b.Configuration.Package.Options.NoProvides,
 		}
 		linterQueue = append(linterQueue, lintTarget)
 	}
 
+	// run any pipelines for subpackages
+	if err := b.runSubpackagePipelines(ctx, pr); err != nil {
+		return err
+	}
+
 	// Retrieve the post build workspace from the runner
 	log.Infof("retrieving workspace from builder: %s", cfg.PodID)
 	fsys := apkofs.DirFS(b.WorkspaceDir)
@@ -890,22 +1362,51 @@ func (b *Build) BuildPackage(ctx context.Context) error {
 	}
 	log.Infof("retrieved and wrote post-build workspace to: %s", b.WorkspaceDir)
 
-	// perform package linting
-	for _, lt := range linterQueue {
-		log.Infof("running package linters for %s", lt.pkgName)
-		path := filepath.Join(b.WorkspaceDir, melangeOutputDirName, lt.pkgName)
+	if err := b.moveSubpackageContents(ctx); err != nil {
+		return err
+	}
 
-		// Downgrade disabled checks from required to warn
-		require := slices.DeleteFunc(b.LintRequire, func(s string) bool {
-			return slices.Contains(lt.disabled, s)
-		})
-		warn := slices.CompactFunc(append(b.LintWarn, lt.disabled...), func(a, b string) bool {
-			return a == b
-		})
+	if err := b.omitEmptySubpackages(ctx); err != nil {
+		return err
+	}
 
-		if err := linter.LintBuild(ctx, lt.pkgName, path, require, warn); err != nil {
-			return fmt.Errorf("unable to lint package %s: %w", lt.pkgName, err)
+	if err := b.addRecordedSBOMPackages(ctx); err != nil {
+		return err
+	}
+
+	if err := b.addKernelModuleTriggers(ctx); err != nil {
+		return err
+	}
+
+	for _, sp := range b.Configuration.Subpackages {
+		// add the subpackage to the linter queue
+		lintTarget := linterTarget{
+			pkgName:    sp.Name,
+			checks:     sp.Checks,
+			runtime:    sp.Dependencies.Runtime,
+			noProvides: sp.Options != nil && sp.Options.NoProvides,
 		}
+		linterQueue = append(linterQueue, lintTarget)
+	}
+
+	// perform package linting
+	rules := make([]linter.Rule, 0, len(b.Configuration.Linters))
+	for _, l := range b.Configuration.Linters {
+		rules = append(rules, linter.Rule{
+			Name:      l.Name,
+			Path:      l.Path,
+			PathRegex: l.PathRegex,
+			Content:   l.Content,
+			Message:   l.Message,
+			Severity:  linter.Severity(l.Severity),
+		})
+	}
+	siblings := make(map[string]string, len(linterQueue))
+	for _, lt := range linterQueue {
+		siblings[lt.pkgName] = filepath.Join(b.WorkspaceDir, melangeOutputDirName, lt.pkgName)
+	}
+	if err := b.lintBuild(ctx, linterQueue, siblings, rules); err != nil {
+		return err
 	}
 
 	li, err := b.Configuration.Package.LicensingInfos(b.WorkspaceDir)
@@ -913,38 +1414,69 @@ func (b *Build) BuildPackage(ctx context.Context) error {
 		return fmt.Errorf("gathering licensing infos: %w", err)
 	}
 	b.SBOMGroup.SetLicensingInfos(li)
+	for _, sp := range b.Configuration.Subpackages {
+		if len(sp.Copyright) == 0 {
+			continue
+		}
+		doc := b.SBOMGroup.Document(sp.Name)
+		if doc == nil {
+			continue
+		}
+		spli, err := sp.LicensingInfos(b.Configuration.Package, b.WorkspaceDir)
+		if err != nil {
+			return fmt.Errorf("gathering licensing infos of %s: %w", sp.Name, err)
+		}
+		// Along with the package's, which its upstream sources are under.
+		doc.LicensingInfos = maps.Clone(li)
+		maps.Copy(doc.LicensingInfos, spli)
+	}
 
-	// Convert the SBOMs we've been working on to their SPDX representation, and
-	// write them to disk. We'll handle any subpackages first, and then the main
-	// package, but the order doesn't really matter.
+	// Convert the SBOMs we've been working on to their representations in
+	// each format, and write them to disk. We'll handle any subpackages first,
+	// and then the main package, but the order doesn't really matter.
 
 	for _, sp := range b.Configuration.Subpackages {
-		spSBOM := b.SBOMGroup.Document(sp.Name)
-		spdxDoc := spSBOM.ToSPDX(ctx)
 		log.Infof("writing SBOM for subpackage %s", sp.Name)
-		if err := b.writeSBOM(sp.Name, &spdxDoc); err != nil {
+		if err := b.writeSBOMs(ctx, sp.Name, b.SBOMGroup.Document(sp.Name)); err != nil {
 			return fmt.Errorf("writing SBOM for %s: %w", sp.Name, err)
 		}
 	}
 
-	spdxDoc := pSBOM.ToSPDX(ctx)
 	log.Infof("writing SBOM for %s", pkg.Name)
-	if err := b.writeSBOM(pkg.Name, &spdxDoc); err != nil {
+	if err := b.writeSBOMs(ctx, pkg.Name, pSBOM); err != nil {
 		return fmt.Errorf("writing SBOM for %s: %w", pkg.Name, err)
 	}
 
 	// emit main package
-	if err := b.Emit(ctx, pkg); err != nil {
+	pc, err := b.emit(ctx, pkg)
+	if err != nil {
 		return fmt.Errorf("unable to emit package: %w", err)
 	}
+	emitted := []*PackageBuild{pc}
 
 	// emit subpackages
 	for _, sp := range b.Configuration.Subpackages {
 		sp := sp
 
-		if err := b.Emit(ctx, pkgFromSub(&sp)); err != nil {
+		pc, err := b.emit(ctx, pkgFromSub(&sp))
+		if err != nil {
 			return fmt.Errorf("unable to emit package: %w", err)
 		}
+		emitted = append(emitted, pc)
+	}
+
+	// What packages provide is only known once they have been emitted.
+	providers := make(map[string]linter.Provider, len(emitted))
+	for _, pc := range emitted {
+		providers[pc.PackageName] = linter.Provider{
+			Provides:         pc.Dependencies.Provides,
+			Runtime:          pc.Dependencies.Runtime,
+			Replaces:         pc.Dependencies.Replaces,
+			ProviderPriority: pc.Dependencies.ProviderPriority,
+		}
+	}
+	if err := b.lintProvides(ctx, linterQueue, siblings, rules, providers); err != nil {
+		return err
 	}
 
 	if !b.isBuildLess() {
@@ -962,6 +1494,12 @@ func (b *Build) BuildPackage(ctx context.Context) error {
 		log.Warnf("unable to clean workspace: %s", err)
 	}
 
+	if resume != nil {
+		if err := resume.remove(); err != nil {
+			log.Warnf("unable to remove build snapshots: %s", err)
+		}
+	}
+
 	// generate APKINDEX.tar.gz and sign it
 	if b.GenerateIndex {
 		packageDir := filepath.Join(b.OutDir, b.Arch.ToAPK())
@@ -998,10 +1536,55 @@ func (b *Build) BuildPackage(ctx context.Context) error {
 	return nil
 }
 
-// writeSBOM encodes the given SPDX document to JSON and writes it to the
-// filesystem in the directory `/var/lib/db/sbom`. The pkgName parameter should
-// be set to the name of the origin package or subpackage.
-func (b Build) writeSBOM(pkgName string, doc *spdx.Document) error {
+// skipSubpackagesNotBuiltFor removes the subpackages that aren't built for
+// arch from the configuration, along with their SBOMs.
+func (b *Build) skipSubpackagesNotBuiltFor(ctx context.Context, arch apko_types.Architecture) {
+	log := clog.FromContext(ctx)
+
+	b.Configuration.Subpackages = slices.DeleteFunc(b.Configuration.Subpackages, func(sp config.Subpackage) bool {
+		if sp.BuiltFor(arch) {
+			return false
+		}
+		log.Infof("skipping subpackage %s because it isn't built for %s", sp.Name, arch.ToAPK())
+		if b.recorder != nil {
+			b.recorder.emit(ctx, Event{Type: EventSubpackageSkipped, Subpackage: sp.Name})
+		}
+		if b.SBOMGroup != nil {
+			b.SBOMGroup.Remove(sp.Name)
+		}
+		return true
+	})
+}
+
+// writeSBOMs writes the SBOM of the origin package or subpackage pkgName in
+// each of the formats of the build.
+func (b Build) writeSBOMs(ctx context.Context, pkgName string, doc *sbom.Document) error {
+	for _, format := range b.SBOMFormats {
+		var v any
+		switch format {
+		case sbom.FormatSPDX:
+			spdxDoc := doc.ToSPDX(ctx)
+			v = &spdxDoc
+		case sbom.FormatCycloneDX:
+			cdxDoc, err := doc.ToCycloneDX()
+			if err != nil {
+				return fmt.Errorf("converting SBOM of %s to CycloneDX: %w", pkgName, err)
+			}
+			v = &cdxDoc
+		default:
+			return fmt.Errorf("unknown SBOM format %q", format)
+		}
+		if err := b.writeSBOM(pkgName, format, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeSBOM encodes the given SBOM document in the format to JSON and writes
+// it to the filesystem in the directory `/var/lib/db/sbom`. The pkgName
+// parameter should be set to the name of the origin package or subpackage.
+func (b Build) writeSBOM(pkgName, format string, doc any) error {
 	apkFSPath := filepath.Join(b.WorkspaceDir, melangeOutputDirName, pkgName)
 	sbomDirPath := filepath.Join(apkFSPath, "/var/lib/db/sbom")
 	if err := os.MkdirAll(sbomDirPath, os.FileMode(0o755)); err != nil {
@@ -1009,21 +1592,22 @@ func (b Build) writeSBOM(pkgName string, doc *spdx.Document) error {
 	}
 
 	pkgVersion := b.Configuration.Package.FullVersion()
-	sbomPath := getPathForPackageSBOM(sbomDirPath, pkgName, pkgVersion)
+	sbomPath := getPathForPackageSBOM(sbomDirPath, pkgName, pkgVersion, format)
 	f, err := os.Create(sbomPath)
 	if err != nil {
 		return fmt.Errorf("opening SBOM file for writing: %w", err)
 	}
+	defer f.Close()
 
 	enc := json.NewEncoder(f)
 	enc.SetIndent("", "  ")
 	enc.SetEscapeHTML(true)
 
 	if err := enc.Encode(doc); err != nil {
-		return fmt.Errorf("encoding SPDX SBOM: %w", err)
+		return fmt.Errorf("encoding %s SBOM: %w", format, err)
 	}
 
-	return nil
+	return f.Close()
 }
 
 func (b *Build) addSBOMPackageForBuildConfigFile() error {
@@ -1044,10 +1628,16 @@ func (b *Build) addSBOMPackageForBuildConfigFile() error {
 	return nil
 }
 
-func getPathForPackageSBOM(sbomDirPath, pkgName, pkgVersion string) string {
+// sbomFileExtensions are the extensions of the SBOM files by their formats.
+var sbomFileExtensions = map[string]string{
+	sbom.FormatSPDX:      ".spdx.json",
+	sbom.FormatCycloneDX: ".cdx.json",
+}
+
+func getPathForPackageSBOM(sbomDirPath, pkgName, pkgVersion, format string) string {
 	return filepath.Join(
 		sbomDirPath,
-		fmt.Sprintf("%s-%s.spdx.json", pkgName, pkgVersion),
+		fmt.Sprintf("%s-%s%s", pkgName, pkgVersion, sbomFileExtensions[format]),
 	)
 }
 
@@ -1103,6 +1693,16 @@ func (b *Build) buildWorkspaceConfig(ctx context.Context) *container.Config {
 		}
 	}
 
+	for _, c := range b.Configuration.Caches {
+		src, err := realpath.Realpath(b.namedCachePath(c.Name))
+		if err != nil {
+			log.Warnf("could not resolve path for %s cache, so it isn't mounted: %s", c.Name, err)
+			continue
+		}
+		mounts = append(mounts, container.BindMount{Source: src, Destination: c.Target})
+	}
+	mounts = append(mounts, b.Mounts...)
+
 	// TODO(kaniini): Disable networking capability according to the pipeline requirements.
 	caps := container.Capabilities{
 		Networking: true,
@@ -1119,6 +1719,7 @@ func (b *Build) buildWorkspaceConfig(ctx context.Context) *container.Config {
 		WorkspaceDir: b.WorkspaceDir,
 		Timeout:      b.Configuration.Package.Timeout,
 		RunAs:        b.Configuration.Environment.Accounts.RunAs,
+		SecretsDir:   b.secretsDir,
 	}
 
 	if b.Configuration.Package.Resources != nil {
@@ -1132,6 +1733,18 @@ func (b *Build) buildWorkspaceConfig(ctx context.Context) *container.Config {
 		cfg.Environment[k] = v
 	}
 
+	if b.egressProxyURL != "" {
+		for k, v := range egressEnvironment(b.egressProxyURL) {
+			cfg.Environment[k] = v
+		}
+	}
+
+	if b.CompilerCache != "" {
+		for k, v := range compilerCacheEnvironment(b.CompilerCache) {
+			cfg.Environment[k] = v
+		}
+	}
+
 	return &cfg
 }
 
@@ -1163,8 +1776,12 @@ func (b *Build) retrieveWorkspace(ctx context.Context, fs apkofs.FullFS) error {
 		return err
 	}
 	defer gr.Close()
-	tr := tar.NewReader(gr)
 
+	return extractWorkspace(fs, tar.NewReader(gr))
+}
+
+// extractWorkspace unpacks the workspace in tr to fs.
+func extractWorkspace(fs apkofs.FullFS, tr *tar.Reader) error {
 	for {
 		hdr, err := tr.Next()
 		if errors.Is(err, io.EOF) {
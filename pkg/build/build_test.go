@@ -15,13 +15,19 @@
 package build
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"chainguard.dev/melange/pkg/config"
+	"chainguard.dev/melange/pkg/container"
+	"chainguard.dev/melange/pkg/sbom"
 
 	apko_types "chainguard.dev/apko/pkg/build/types"
 	"github.com/chainguard-dev/clog/slogtest"
@@ -379,3 +385,240 @@ func TestSourceDateEpoch(t *testing.T) {
 		})
 	}
 }
+
+// barrierRunner records the scripts it runs. Scripts that contain "wait"
+// don't finish until want of them are running at once.
+type barrierRunner struct {
+	container.Runner
+	want int
+
+	mu      sync.Mutex
+	waiting int
+	release chan struct{}
+	ran     []string
+}
+
+func (r *barrierRunner) Run(ctx context.Context, _ *container.Config, _ map[string]string, cmd ...string) error {
+	script := cmd[len(cmd)-1]
+
+	r.mu.Lock()
+	r.ran = append(r.ran, script)
+	if !strings.Contains(script, "wait") {
+		r.mu.Unlock()
+		return nil
+	}
+	r.waiting++
+	if r.waiting == r.want {
+		close(r.release)
+	}
+	r.mu.Unlock()
+
+	select {
+	case <-r.release:
+		return nil
+	case <-time.After(5 * time.Second):
+		return fmt.Errorf("independent pipelines didn't run concurrently")
+	}
+}
+
+func TestIndependentSubpackages(t *testing.T) {
+	ctx := slogtest.Context(t)
+
+	runner := &barrierRunner{want: 2, release: make(chan struct{})}
+	b := &Build{
+		WorkspaceDir: t.TempDir(),
+		Configuration: config.Configuration{
+			Pipeline: []config.Pipeline{{Runs: "make"}},
+			Subpackages: []config.Subpackage{{
+				Name:        "foo-dev",
+				Independent: true,
+				Pipeline:    []config.Pipeline{{Runs: "wait for foo-dev"}},
+			}, {
+				Name:        "foo-doc",
+				Independent: true,
+				Pipeline:    []config.Pipeline{{Runs: "wait for foo-doc"}},
+			}, {
+				Name:     "foo-extra",
+				Pipeline: []config.Pipeline{{Runs: "move foo-extra"}},
+			}},
+		},
+	}
+	pr := &pipelineRunner{config: &container.Config{}, runner: runner}
+	require.NoError(t, b.runSubpackagePipelines(ctx, pr))
+
+	// The dependent subpackage runs after the independent ones.
+	require.Len(t, runner.ran, 3)
+	require.Contains(t, runner.ran[2], "move foo-extra")
+	for _, sp := range b.Configuration.Subpackages {
+		require.DirExists(t, filepath.Join(b.WorkspaceDir, melangeOutputDirName, sp.Name))
+	}
+}
+
+// overlapRunner records how many scripts it ran at once at most.
+type overlapRunner struct {
+	container.Runner
+
+	mu      sync.Mutex
+	running int
+	most    int
+}
+
+func (r *overlapRunner) Run(context.Context, *container.Config, map[string]string, ...string) error {
+	r.mu.Lock()
+	r.running++
+	r.most = max(r.most, r.running)
+	r.mu.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+
+	r.mu.Lock()
+	r.running--
+	r.mu.Unlock()
+	return nil
+}
+
+func TestIndependentSubpackagesWithBreakpoints(t *testing.T) {
+	ctx := slogtest.Context(t)
+
+	for _, b := range []*Build{
+		{BreakBefore: []string{"unused"}},
+		{BreakAfter: []string{"unused"}},
+		{Interactive: true},
+	} {
+		runner := &overlapRunner{}
+		b.WorkspaceDir = t.TempDir()
+		b.Configuration = config.Configuration{
+			Pipeline: []config.Pipeline{{Runs: "make"}},
+			Subpackages: []config.Subpackage{{
+				Name:        "foo-dev",
+				Independent: true,
+				Pipeline:    []config.Pipeline{{Runs: "move foo-dev"}},
+			}, {
+				Name:        "foo-doc",
+				Independent: true,
+				Pipeline:    []config.Pipeline{{Runs: "move foo-doc"}},
+			}},
+		}
+		pr := &pipelineRunner{config: &container.Config{}, runner: runner}
+		require.NoError(t, b.runSubpackagePipelines(ctx, pr))
+
+		// Only one step at a time may drop into a debug shell.
+		require.Equal(t, 1, runner.most)
+	}
+}
+
+func TestSubpackageNeeds(t *testing.T) {
+	ctx := slogtest.Context(t)
+
+	b := &Build{
+		Arch: apko_types.ParseArchitecture("x86_64"),
+		Configuration: config.Configuration{
+			Pipeline: []config.Pipeline{{Runs: "make"}},
+			Subpackages: []config.Subpackage{{
+				Name:     "foo-doc",
+				Needs:    &config.Needs{Packages: []string{"gtk-doc"}},
+				Pipeline: []config.Pipeline{{Runs: "make docs"}},
+			}, {
+				Name:     "foo-man",
+				If:       "${{build.arch}} == 'riscv64'",
+				Needs:    &config.Needs{Packages: []string{"scdoc"}},
+				Pipeline: []config.Pipeline{{Runs: "make man"}},
+			}},
+		},
+	}
+	require.NoError(t, b.Compile(ctx))
+
+	// The packages are resolved with the rest of the environment, but only
+	// for the subpackages that are built.
+	require.Contains(t, b.Configuration.Environment.Contents.Packages, "gtk-doc")
+	require.NotContains(t, b.Configuration.Environment.Contents.Packages, "scdoc")
+}
+
+func TestFetchSteps(t *testing.T) {
+	for _, c := range []struct {
+		uses []string
+		want int
+	}{
+		{uses: nil, want: 0},
+		{uses: []string{"autoconf/configure", "autoconf/make"}, want: 0},
+		{uses: []string{"fetch", "patch/apply", "git-checkout@v2", "go/build"}, want: 3},
+		{uses: []string{"git-checkout", "autoconf/make", "strip"}, want: 1},
+	} {
+		var pipelines []config.Pipeline
+		for _, uses := range c.uses {
+			pipelines = append(pipelines, config.Pipeline{Uses: uses})
+		}
+		require.Equal(t, c.want, fetchSteps(pipelines), c.uses)
+	}
+}
+
+func TestWriteSBOMs(t *testing.T) {
+	var b Build
+	if err := WithSBOMFormats([]string{"swid"})(&b); err == nil {
+		t.Error("swid: expected error")
+	}
+	if err := WithSBOMFormats([]string{sbom.FormatCycloneDX, sbom.FormatSPDX, sbom.FormatCycloneDX})(&b); err != nil {
+		t.Fatal(err)
+	}
+	require.Equal(t, []string{sbom.FormatCycloneDX, sbom.FormatSPDX}, b.SBOMFormats)
+
+	b.WorkspaceDir = t.TempDir()
+	b.Configuration = config.Configuration{Package: config.Package{Name: "foo", Version: "1.2.3"}}
+	doc := sbom.NewDocument()
+	doc.AddPackageAndSetDescribed(&sbom.Package{Name: "foo", Version: "1.2.3-r0"})
+	require.NoError(t, b.writeSBOMs(context.Background(), "foo", doc))
+
+	dir := filepath.Join(b.WorkspaceDir, melangeOutputDirName, "foo", "var/lib/db/sbom")
+	for name, want := range map[string]string{
+		"foo-1.2.3-r0.cdx.json":  `"bomFormat": "CycloneDX"`,
+		"foo-1.2.3-r0.spdx.json": `"spdxVersion": "SPDX-2.3"`,
+	} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		require.NoError(t, err)
+		require.Contains(t, string(data), want)
+	}
+}
+
+func TestSkipSubpackagesNotBuiltFor(t *testing.T) {
+	ctx := slogtest.Context(t)
+
+	b := &Build{
+		Configuration: config.Configuration{
+			Package: config.Package{Name: "foo"},
+			Subpackages: []config.Subpackage{{
+				Name: "foo-dev",
+			}, {
+				Name:               "foo-firmware",
+				TargetArchitecture: []string{"aarch64"},
+			}},
+		},
+	}
+	b.SBOMGroup = NewSBOMGroup(slices.Collect(b.Configuration.AllPackageNames())...)
+
+	b.skipSubpackagesNotBuiltFor(ctx, apko_types.ParseArchitecture("x86_64"))
+
+	require.Len(t, b.Configuration.Subpackages, 1)
+	require.Equal(t, "foo-dev", b.Configuration.Subpackages[0].Name)
+	require.NotNil(t, b.SBOMGroup.Document("foo"))
+	require.NotNil(t, b.SBOMGroup.Document("foo-dev"))
+	require.Nil(t, b.SBOMGroup.Document("foo-firmware"), "skipped subpackages get no SBOM")
+}
+
+func TestEgressAllowlistNeedsEnforcement(t *testing.T) {
+	ctx := slogtest.Context(t)
+
+	// Commands share the host's network with bubblewrap, so it can't hold
+	// them to an allowlist.
+	b := &Build{
+		Runner:      container.BubblewrapRunner(true),
+		EgressAllow: []string{"github.com"},
+	}
+	_, err := b.startEgressProxy(ctx)
+	require.ErrorContains(t, err, "doesn't support egress allowlists")
+
+	// Builds without an allowlist don't need a proxy.
+	b.EgressAllow = nil
+	stop, err := b.startEgressProxy(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stop())
+}
@@ -79,7 +79,7 @@ func cacheItemsForBuild(configFile string) (CacheMembershipMap, error) {
 	// Look for fetch nodes.
 	it := yit.FromNode(pipelineNode).
 		RecurseNodes().
-		Filter(yit.WithMapValue("fetch"))
+		Filter(renovate.UsesPipeline("fetch"))
 
 	for fetchNode, ok := it(); ok; fetchNode, ok = it() {
 		if err := visitFetch(fetchNode, &cmm); err != nil {
@@ -15,11 +15,17 @@
 package build
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"maps"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
 
 	"chainguard.dev/melange/pkg/cond"
 	"chainguard.dev/melange/pkg/config"
@@ -30,6 +36,8 @@ import (
 
 const unidentifiablePipeline = "???"
 
+var pipelineVersionRegex = regexp.MustCompile(`^v[1-9][0-9]*$`)
+
 func (t *Test) Compile(ctx context.Context) error {
 	cfg := t.Configuration
 
@@ -42,7 +50,9 @@ func (t *Test) Compile(ctx context.Context) error {
 	}
 
 	ignore := &Compiled{
-		PipelineDirs: t.PipelineDirs,
+		PipelineDirs:           t.PipelineDirs,
+		RemotePipelineCacheDir: t.RemotePipelineCacheDir,
+		Strict:                 t.Strict,
 	}
 
 	// We want to evaluate this but not accumulate its deps.
@@ -69,7 +79,9 @@ func (t *Test) Compile(ctx context.Context) error {
 		}
 
 		test := &Compiled{
-			PipelineDirs: t.PipelineDirs,
+			PipelineDirs:           t.PipelineDirs,
+			RemotePipelineCacheDir: t.RemotePipelineCacheDir,
+			Strict:                 t.Strict,
 		}
 
 		te := &cfg.Subpackages[i].Test.Environment.Contents
@@ -87,7 +99,9 @@ func (t *Test) Compile(ctx context.Context) error {
 
 	if cfg.Test != nil {
 		test := &Compiled{
-			PipelineDirs: t.PipelineDirs,
+			PipelineDirs:           t.PipelineDirs,
+			RemotePipelineCacheDir: t.RemotePipelineCacheDir,
+			Strict:                 t.Strict,
 		}
 
 		te := &t.Configuration.Test.Environment.Contents
@@ -119,7 +133,9 @@ func (b *Build) Compile(ctx context.Context) error {
 	}
 
 	c := &Compiled{
-		PipelineDirs: b.PipelineDirs,
+		PipelineDirs:           b.PipelineDirs,
+		RemotePipelineCacheDir: b.RemotePipelineCacheDir,
+		Strict:                 b.Strict,
 	}
 
 	if err := c.CompilePipelines(ctx, sm, cfg.Pipeline); err != nil {
@@ -140,12 +156,26 @@ func (b *Build) Compile(ctx context.Context) error {
 			return fmt.Errorf("compiling subpackage %q: %w", sp.Name, err)
 		}
 
+		// What the subpackage needs is resolved and installed with the rest
+		// of the environment, unless the subpackage isn't built.
+		if sp.Needs != nil {
+			built, err := shouldRun(sp.If)
+			if err != nil {
+				return fmt.Errorf("evaluating subpackage %q if: %w", sp.Name, err)
+			}
+			if built {
+				c.Needs = append(c.Needs, sp.Needs.Packages...)
+			}
+		}
+
 		if sp.Test == nil {
 			continue
 		}
 
 		tc := &Compiled{
-			PipelineDirs: b.PipelineDirs,
+			PipelineDirs:           b.PipelineDirs,
+			RemotePipelineCacheDir: b.RemotePipelineCacheDir,
+			Strict:                 b.Strict,
 		}
 		if err := tc.CompilePipelines(ctx, sm, sp.Test.Pipeline); err != nil {
 			return fmt.Errorf("compiling subpackage %q tests: %w", sp.Name, err)
@@ -165,7 +195,9 @@ func (b *Build) Compile(ctx context.Context) error {
 
 	if cfg.Test != nil {
 		tc := &Compiled{
-			PipelineDirs: b.PipelineDirs,
+			PipelineDirs:           b.PipelineDirs,
+			RemotePipelineCacheDir: b.RemotePipelineCacheDir,
+			Strict:                 b.Strict,
 		}
 
 		if err := tc.CompilePipelines(ctx, sm, cfg.Test.Pipeline); err != nil {
@@ -184,7 +216,13 @@ func (b *Build) Compile(ctx context.Context) error {
 
 type Compiled struct {
 	PipelineDirs []string
-	Needs        []string
+	// Where pipelines from git repositories are cached, or "" for the user's
+	// cache directory.
+	RemotePipelineCacheDir string
+	Needs                  []string
+
+	// Whether to reject unknown fields in the pipelines that are used.
+	Strict bool
 }
 
 func (c *Compiled) CompilePipelines(ctx context.Context, sm *SubstitutionMap, pipelines []config.Pipeline) error {
@@ -202,32 +240,19 @@ func (c *Compiled) CompilePipelines(ctx context.Context, sm *SubstitutionMap, pi
 }
 
 func (c *Compiled) compilePipeline(ctx context.Context, sm *SubstitutionMap, pipeline *config.Pipeline, parent map[string]string) error {
-	log := clog.FromContext(ctx)
-	name, uses, with := pipeline.Name, pipeline.Uses, maps.Clone(pipeline.With)
+	name, uses, with, retries := pipeline.Name, pipeline.Uses, maps.Clone(pipeline.With), pipeline.Retries
 
 	if uses != "" {
-		var data []byte
-		// Set this to fail up front in case there are no pipeline dirs specified
-		// and we can't find them.
-		err := fmt.Errorf("could not find 'uses' pipeline %q", uses)
-
-		for _, pd := range c.PipelineDirs {
-			log.Debugf("trying to load pipeline %q from %q", uses, pd)
-			data, err = os.ReadFile(filepath.Join(pd, uses+".yaml"))
-			if err == nil {
-				log.Debugf("Found pipeline %s", string(data))
-				break
-			}
-		}
+		data, err := c.loadPipeline(ctx, uses)
 		if err != nil {
-			log.Debugf("trying to load pipeline %q from embedded fs pipelines/%q.yaml", uses, uses)
-			data, err = f.ReadFile("pipelines/" + uses + ".yaml")
-			if err != nil {
-				return fmt.Errorf("unable to load pipeline: %w", err)
-			}
+			return err
 		}
 
-		if err := yaml.Unmarshal(data, pipeline); err != nil {
+		// Don't let the pipeline's default retries overwrite ours in place.
+		pipeline.Retries = nil
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(c.Strict)
+		if err := dec.Decode(pipeline); err != nil && !errors.Is(err, io.EOF) {
 			return fmt.Errorf("unable to parse pipeline %q: %w", uses, err)
 		}
 
@@ -239,6 +264,11 @@ func (c *Compiled) compilePipeline(ctx context.Context, sm *SubstitutionMap, pip
 
 		// We want to keep the original name here because loading the pipeline will overwrite it.
 		pipeline.Name = name
+
+		// Retries set by the step override the pipeline's defaults.
+		if retries != nil {
+			pipeline.Retries = retries
+		}
 	}
 
 	if parent != nil {
@@ -255,6 +285,12 @@ func (c *Compiled) compilePipeline(ctx context.Context, sm *SubstitutionMap, pip
 		return fmt.Errorf("mutating with: %w", err)
 	}
 
+	for _, k := range slices.Sorted(maps.Keys(pipeline.Inputs)) {
+		if err := validateInput(pipeline.Inputs[k], mutated[fmt.Sprintf("${{inputs.%s}}", k)]); err != nil {
+			return fmt.Errorf("invalid input %q to pipeline %q: %w", k, uses, err)
+		}
+	}
+
 	// allow input mutations on needs.packages
 	if pipeline.Needs != nil {
 		for i := range pipeline.Needs.Packages {
@@ -292,6 +328,16 @@ func (c *Compiled) compilePipeline(ctx context.Context, sm *SubstitutionMap, pip
 			p.WorkDir = pipeline.WorkDir
 		}
 
+		// Likewise for retries.
+		if p.Retries == nil {
+			p.Retries = pipeline.Retries
+		}
+
+		// Nested pipelines set the outputs of the pipeline that uses them.
+		if p.ID == "" {
+			p.ID = pipeline.ID
+		}
+
 		if err := c.compilePipeline(ctx, sm, p, mutated); err != nil {
 			return fmt.Errorf("compiling Pipeline[%d]: %w", i, err)
 		}
@@ -319,6 +365,66 @@ func (c *Compiled) compilePipeline(ctx context.Context, sm *SubstitutionMap, pip
 	return nil
 }
 
+// loadPipeline returns the contents of the pipeline that uses refers to.
+func (c *Compiled) loadPipeline(ctx context.Context, uses string) ([]byte, error) {
+	log := clog.FromContext(ctx)
+
+	remote, ok, err := parseRemotePipeline(uses)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		data, err := loadRemotePipeline(ctx, c.RemotePipelineCacheDir, remote)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load pipeline: %w", err)
+		}
+		return data, nil
+	}
+
+	file, err := pipelineFile(uses)
+	if err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	// Set this to fail up front in case there are no pipeline dirs specified
+	// and we can't find them.
+	err = fmt.Errorf("could not find 'uses' pipeline %q", uses)
+
+	for _, pd := range c.PipelineDirs {
+		log.Debugf("trying to load pipeline %q from %q", uses, pd)
+		data, err = os.ReadFile(filepath.Join(pd, file))
+		if err == nil {
+			log.Debugf("Found pipeline %s", string(data))
+			break
+		}
+	}
+	if err != nil {
+		log.Debugf("trying to load pipeline %q from embedded fs pipelines/%q", uses, file)
+		data, err = f.ReadFile("pipelines/" + file)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load pipeline: %w", err)
+		}
+	}
+	return data, nil
+}
+
+// pipelineFile returns the file that holds the pipeline uses refers to,
+// relative to a pipeline directory. A version can be selected with an
+// @v<N> suffix, as in go/build@v2, which is kept in go/build@v2.yaml. Version
+// 1 is the pipeline without a suffix, so that uses without one keep getting
+// the same pipeline when new versions are added.
+func pipelineFile(uses string) (string, error) {
+	name, version, ok := strings.Cut(uses, "@")
+	if !ok || version == "v1" {
+		return name + ".yaml", nil
+	}
+	if !pipelineVersionRegex.MatchString(version) {
+		return "", fmt.Errorf("invalid version %q of pipeline %q: must be of the form v<N>", version, name)
+	}
+	return uses + ".yaml", nil
+}
+
 func identity(p *config.Pipeline) string {
 	if p.Name != "" {
 		return p.Name
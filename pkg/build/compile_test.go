@@ -15,12 +15,19 @@
 package build
 
 import (
+	"bytes"
 	"context"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
 	"slices"
+	"strings"
 	"testing"
 
 	apko_types "chainguard.dev/apko/pkg/build/types"
 	"chainguard.dev/melange/pkg/config"
+	"gopkg.in/yaml.v3"
 )
 
 func TestCompileEmpty(t *testing.T) {
@@ -69,6 +76,162 @@ func TestInheritWorkdir(t *testing.T) {
 	}
 }
 
+func TestRetries(t *testing.T) {
+	build := &Build{
+		Configuration: config.Configuration{
+			Pipeline: []config.Pipeline{{
+				Retries: &config.Retries{Count: 1},
+				Pipeline: []config.Pipeline{{}, {
+					Retries: &config.Retries{Count: 2},
+				}},
+			}, {
+				Uses: "fetch",
+				With: map[string]string{"uri": "https://example.com/foo.tar.gz"},
+			}, {
+				Uses:    "fetch",
+				With:    map[string]string{"uri": "https://example.com/foo.tar.gz"},
+				Retries: &config.Retries{Count: 0},
+			}},
+		},
+	}
+
+	if err := build.Compile(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, c := range []struct {
+		name string
+		p    config.Pipeline
+		want int
+	}{
+		{"inherited", build.Configuration.Pipeline[0].Pipeline[0], 1},
+		{"overridden", build.Configuration.Pipeline[0].Pipeline[1], 2},
+		{"fetch overridden", build.Configuration.Pipeline[2].Pipeline[0], 0},
+	} {
+		if c.p.Retries == nil {
+			t.Fatalf("%s: no retries", c.name)
+		}
+		if got := c.p.Retries.Count; got != c.want {
+			t.Errorf("%s: want %d retries, got %d", c.name, c.want, got)
+		}
+	}
+
+	// fetch only retries the download itself, not verifying it.
+	if r := build.Configuration.Pipeline[1].Pipeline[0].Retries; r != nil {
+		t.Errorf("fetch default: want no retries, got %d", r.Count)
+	}
+}
+
+func TestTypedInputs(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "typed.yaml"), []byte(`
+inputs:
+  name:
+    default: ${{package.name}}
+  jobs:
+    type: int
+    default: 4
+  static:
+    type: bool
+    default: false
+  tls:
+    type: enum
+    values: [openssl, boringssl]
+
+pipeline:
+  - runs: build --jobs=${{inputs.jobs}} --static=${{inputs.static}} --tls=${{inputs.tls}}
+`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "broken.yaml"), []byte(`
+inputs:
+  ratio:
+    type: float
+`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	compile := func(uses string, with map[string]string) error {
+		b := &Build{
+			PipelineDirs: []string{dir},
+			Configuration: config.Configuration{
+				Package: config.Package{Name: "foo"},
+				Pipeline: []config.Pipeline{{
+					Uses: uses,
+					With: with,
+				}},
+			},
+		}
+		return b.Compile(context.Background())
+	}
+
+	if err := compile("typed", map[string]string{"jobs": "8", "static": "true", "tls": "boringssl"}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := compile("broken", nil); err == nil || !strings.Contains(err.Error(), `unknown input type "float"`) {
+		t.Errorf("want unknown input type error, got %v", err)
+	}
+
+	for _, c := range []struct {
+		with    map[string]string
+		wantErr string
+	}{
+		{with: map[string]string{"jobs": "eight"}, wantErr: `invalid input "jobs" to pipeline "typed": "eight" is not an int`},
+		{with: map[string]string{"static": "yes"}, wantErr: `invalid input "static" to pipeline "typed": "yes" is not a bool`},
+		{with: map[string]string{"tls": "libressl"}, wantErr: `invalid input "tls" to pipeline "typed": "libressl" is not one of openssl, boringssl`},
+		{with: map[string]string{"jbos": "8"}, wantErr: `undefined input "jbos" to pipeline "typed"`},
+	} {
+		err := compile("typed", c.with)
+		if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+			t.Errorf("%v: want error containing %q, got %v", c.with, c.wantErr, err)
+		}
+	}
+}
+
+func TestVersionedPipelines(t *testing.T) {
+	dir := t.TempDir()
+	for file, runs := range map[string]string{
+		"foo.yaml":    "foo --old",
+		"foo@v2.yaml": "foo --new",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, file), []byte("pipeline:\n  - runs: "+runs+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	compile := func(uses string) (string, error) {
+		b := &Build{
+			PipelineDirs: []string{dir},
+			Configuration: config.Configuration{
+				Pipeline: []config.Pipeline{{Uses: uses}},
+			},
+		}
+		if err := b.Compile(context.Background()); err != nil {
+			return "", err
+		}
+		return b.Configuration.Pipeline[0].Pipeline[0].Runs, nil
+	}
+
+	for uses, want := range map[string]string{
+		"foo":    "foo --old",
+		"foo@v1": "foo --old",
+		"foo@v2": "foo --new",
+	} {
+		got, err := compile(uses)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", uses, err)
+		} else if got != want {
+			t.Errorf("%s: want runs %q, got %q", uses, want, got)
+		}
+	}
+
+	for _, uses := range []string{"foo@v3", "foo@latest", "foo@v0"} {
+		if _, err := compile(uses); err == nil {
+			t.Errorf("%s: expected error", uses)
+		}
+	}
+}
+
 func TestCompileTest(t *testing.T) {
 	test := &Test{
 		Package: "main",
@@ -115,3 +278,48 @@ func TestCompileTest(t *testing.T) {
 		t.Errorf("subpackage test packages: want %v, got %v", want, got)
 	}
 }
+
+func TestStrictPipelines(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "foo.yaml"), []byte("pipline:\n  - runs: foo\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	compile := func(strict bool) error {
+		b := &Build{
+			PipelineDirs: []string{dir},
+			Strict:       strict,
+			Configuration: config.Configuration{
+				Pipeline: []config.Pipeline{{Uses: "foo"}},
+			},
+		}
+		return b.Compile(context.Background())
+	}
+
+	if err := compile(false); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := compile(true); err == nil || !strings.Contains(err.Error(), "field pipline not found") {
+		t.Errorf("want unknown field error, got %v", err)
+	}
+
+	// The built-in pipelines have to be valid in strict mode too.
+	if err := fs.WalkDir(f, "pipelines", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || path.Ext(p) != ".yaml" {
+			return err
+		}
+		data, err := f.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		var pipeline config.Pipeline
+		if err := dec.Decode(&pipeline); err != nil {
+			t.Errorf("%s: %v", p, err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	"chainguard.dev/melange/pkg/container"
+	"github.com/chainguard-dev/clog"
+)
+
+// The compiler caches that builds can use.
+const (
+	CompilerCacheCcache  = "ccache"
+	CompilerCacheSccache = "sccache"
+)
+
+// compilerCacheEnvironment returns the environment variables that point
+// compiler, and the C/C++ pipelines, at the compiler cache tool, which keeps
+// its cache in a directory of the same name in the cache directory.
+func compilerCacheEnvironment(tool string) map[string]string {
+	dir := path.Join(container.DefaultCacheDir, tool)
+	env := map[string]string{
+		"MELANGE_COMPILER_LAUNCHER":   tool,
+		"CMAKE_C_COMPILER_LAUNCHER":   tool,
+		"CMAKE_CXX_COMPILER_LAUNCHER": tool,
+	}
+	switch tool {
+	case CompilerCacheCcache:
+		env["CCACHE_DIR"] = dir
+		// Objects built from the same source in different workspaces can
+		// be shared.
+		env["CCACHE_BASEDIR"] = container.DefaultWorkspaceDir
+	case CompilerCacheSccache:
+		env["SCCACHE_DIR"] = dir
+	}
+	return env
+}
+
+// prepareCompilerCache adds the compiler cache tool to the build
+// environment, and makes its directory in the cache directory, so that it's
+// there to be mounted into the guest.
+func (b *Build) prepareCompilerCache(ctx context.Context) error {
+	log := clog.FromContext(ctx)
+
+	if b.CompilerCache == "" {
+		return nil
+	}
+	if b.CacheDir == "" {
+		return fmt.Errorf("caching compiler output with %s needs a cache directory", b.CompilerCache)
+	}
+	if err := os.MkdirAll(filepath.Join(b.CacheDir, b.CompilerCache), 0o755); err != nil {
+		return fmt.Errorf("creating %s cache: %w", b.CompilerCache, err)
+	}
+	if b.Runner.Name() == container.QemuName {
+		log.Warnf("the %s runner doesn't mount the cache directory, so the %s cache only lasts for this build", b.Runner.Name(), b.CompilerCache)
+	}
+
+	b.Configuration.Environment.Contents.Packages = append(b.Configuration.Environment.Contents.Packages, b.CompilerCache)
+	return nil
+}
+
+// compilerCacheStats zeroes the compiler cache's statistics if zero is true,
+// and otherwise shows them, with the hit rate of the build's compilations.
+// Failing to do either doesn't fail the build.
+func (b *Build) compilerCacheStats(ctx context.Context, cfg *container.Config, zero bool) {
+	log := clog.FromContext(ctx)
+
+	if b.CompilerCache == "" {
+		return
+	}
+	// sccache keeps its statistics in a server that's stopped along with
+	// each step here, so they'd always be empty.
+	if b.CompilerCache == CompilerCacheSccache && b.Runner.Name() == container.BubblewrapName {
+		if !zero {
+			log.Infof("%s statistics aren't available with the %s runner", b.CompilerCache, b.Runner.Name())
+		}
+		return
+	}
+
+	flag := "--show-stats"
+	if zero {
+		flag = "--zero-stats"
+	} else {
+		log.Infof("%s statistics for this build:", b.CompilerCache)
+	}
+	if err := b.Runner.Run(ctx, cfg, nil, "/bin/sh", "-c", b.CompilerCache+" "+flag); err != nil {
+		log.Warnf("unable to run %s %s: %v", b.CompilerCache, flag, err)
+	}
+}
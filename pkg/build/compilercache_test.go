@@ -0,0 +1,64 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCompilerCacheEnvironment(t *testing.T) {
+	for _, c := range []struct {
+		tool string
+		want map[string]string
+	}{{
+		tool: CompilerCacheCcache,
+		want: map[string]string{
+			"MELANGE_COMPILER_LAUNCHER":   "ccache",
+			"CMAKE_C_COMPILER_LAUNCHER":   "ccache",
+			"CMAKE_CXX_COMPILER_LAUNCHER": "ccache",
+			"CCACHE_DIR":                  "/var/cache/melange/ccache",
+			"CCACHE_BASEDIR":              "/home/build",
+		},
+	}, {
+		tool: CompilerCacheSccache,
+		want: map[string]string{
+			"MELANGE_COMPILER_LAUNCHER":   "sccache",
+			"CMAKE_C_COMPILER_LAUNCHER":   "sccache",
+			"CMAKE_CXX_COMPILER_LAUNCHER": "sccache",
+			"SCCACHE_DIR":                 "/var/cache/melange/sccache",
+		},
+	}} {
+		if diff := cmp.Diff(c.want, compilerCacheEnvironment(c.tool)); diff != "" {
+			t.Errorf("%s: environment mismatch (-want +got):\n%s", c.tool, diff)
+		}
+	}
+}
+
+func TestWithCompilerCache(t *testing.T) {
+	for _, tool := range []string{"", CompilerCacheCcache, CompilerCacheSccache} {
+		var b Build
+		if err := WithCompilerCache(tool)(&b); err != nil {
+			t.Errorf("%q: unexpected error: %v", tool, err)
+		}
+		if b.CompilerCache != tool {
+			t.Errorf("%q: got %q", tool, b.CompilerCache)
+		}
+	}
+	if err := WithCompilerCache("distcc")(&Build{}); err == nil {
+		t.Error("distcc: expected error")
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+
+	"chainguard.dev/melange/pkg/util"
+	"github.com/chainguard-dev/clog"
+)
+
+// moveSubpackageContents moves the paths in the main package that match the
+// contents of each subpackage into it, in the order the subpackages are
+// listed, so that a path goes to the first subpackage that it matches the
+// contents of.
+func (b *Build) moveSubpackageContents(ctx context.Context) error {
+	log := clog.FromContext(ctx)
+
+	out := filepath.Join(b.WorkspaceDir, melangeOutputDirName)
+	name := b.Configuration.Package.Name
+	for _, sp := range b.Configuration.Subpackages {
+		if len(sp.Contents) == 0 {
+			continue
+		}
+		moved, err := moveMatching(filepath.Join(out, name), filepath.Join(out, sp.Name), sp.Contents)
+		if err != nil {
+			return fmt.Errorf("moving contents of %s: %w", sp.Name, err)
+		}
+		if len(moved) == 0 {
+			log.Warnf("nothing in %s matches the contents of %s", name, sp.Name)
+		}
+		for _, p := range moved {
+			log.Infof("moved %s from %s to %s", p, name, sp.Name)
+		}
+	}
+	return nil
+}
+
+// moveMatching moves the paths under src that match any of globs to the
+// same paths under dst, and returns them. Directories that moving them
+// leaves empty in src are removed.
+func moveMatching(src, dst string, globs []string) ([]string, error) {
+	var moved []string
+	err := filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == src && errors.Is(err, fs.ErrNotExist) {
+				return fs.SkipAll
+			}
+			return err
+		}
+		if p == src {
+			return nil
+		}
+
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		matched := false
+		for _, glob := range globs {
+			if matched, err = util.MatchGlob(glob, rel); err != nil {
+				return err
+			} else if matched {
+				break
+			}
+		}
+		if !matched {
+			return nil
+		}
+
+		if err := mkdirParents(src, dst, path.Dir(rel)); err != nil {
+			return err
+		}
+		if err := movePath(p, filepath.Join(dst, filepath.FromSlash(rel))); err != nil {
+			return err
+		}
+		moved = append(moved, rel)
+		if d.IsDir() {
+			return fs.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rel := range moved {
+		for dir := path.Dir(rel); dir != "."; dir = path.Dir(dir) {
+			// Only empty directories can be removed.
+			if os.Remove(filepath.Join(src, filepath.FromSlash(dir))) != nil {
+				break
+			}
+		}
+	}
+	return moved, nil
+}
+
+// mkdirParents makes the directory dir, and those it's in, under dst, with
+// the modes of the same directories under src.
+func mkdirParents(src, dst, dir string) error {
+	if dir == "." {
+		return os.MkdirAll(dst, 0o755)
+	}
+	if err := mkdirParents(src, dst, path.Dir(dir)); err != nil {
+		return err
+	}
+	target := filepath.Join(dst, filepath.FromSlash(dir))
+	if _, err := os.Lstat(target); err == nil {
+		return nil
+	}
+	fi, err := os.Stat(filepath.Join(src, filepath.FromSlash(dir)))
+	if err != nil {
+		return err
+	}
+	if err := os.Mkdir(target, fi.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Chmod(target, fi.Mode().Perm())
+}
+
+// movePath moves from to to. If both are directories, what's in from is
+// moved into to.
+func movePath(from, to string) error {
+	tfi, err := os.Lstat(to)
+	if errors.Is(err, fs.ErrNotExist) {
+		return os.Rename(from, to)
+	} else if err != nil {
+		return err
+	}
+	ffi, err := os.Lstat(from)
+	if err != nil {
+		return err
+	}
+	if !ffi.IsDir() || !tfi.IsDir() {
+		return fmt.Errorf("%s already exists", to)
+	}
+
+	des, err := os.ReadDir(from)
+	if err != nil {
+		return err
+	}
+	for _, de := range des {
+		if err := movePath(filepath.Join(from, de.Name()), filepath.Join(to, de.Name())); err != nil {
+			return err
+		}
+	}
+	return os.Remove(from)
+}
@@ -0,0 +1,105 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"chainguard.dev/melange/pkg/config"
+	"github.com/chainguard-dev/clog/slogtest"
+	"github.com/stretchr/testify/require"
+)
+
+// listTree returns the paths under dir, with a / after directories.
+func listTree(t *testing.T, dir string) []string {
+	t.Helper()
+	var paths []string
+	require.NoError(t, filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || p == dir {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, p)
+		if d.IsDir() {
+			rel += "/"
+		}
+		paths = append(paths, filepath.ToSlash(rel))
+		return nil
+	}))
+	slices.Sort(paths)
+	return paths
+}
+
+func TestMoveSubpackageContents(t *testing.T) {
+	ctx := slogtest.Context(t)
+	ws := t.TempDir()
+	out := filepath.Join(ws, melangeOutputDirName)
+
+	for _, f := range []string{
+		"foo/usr/bin/foo",
+		"foo/usr/include/foo/foo.h",
+		"foo/usr/lib/libfoo.so.1",
+		"foo/usr/lib/libfoo.a",
+		"foo/usr/lib/pkgconfig/foo.pc",
+		"foo/usr/share/man/man1/foo.1",
+		// What a subpackage's pipeline put there already.
+		"foo-dev/usr/include/foo/config.h",
+	} {
+		p := filepath.Join(out, filepath.FromSlash(f))
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0o755))
+		require.NoError(t, os.WriteFile(p, nil, 0o644))
+	}
+	require.NoError(t, os.Chmod(filepath.Join(out, "foo", "usr", "share"), 0o700))
+	require.NoError(t, os.Symlink("libfoo.so.1", filepath.Join(out, "foo", "usr", "lib", "libfoo.so")))
+
+	b := &Build{
+		Configuration: config.Configuration{
+			Package: config.Package{Name: "foo"},
+			Subpackages: []config.Subpackage{
+				{Name: "foo-dev", Contents: []string{"usr/include", "usr/lib/*.a", "usr/lib/*.so", "/usr/lib/pkgconfig/**"}},
+				{Name: "foo-doc", Contents: []string{"usr/share/**"}},
+				// Everything it would match has gone to foo-dev.
+				{Name: "foo-static", Contents: []string{"usr/lib/*.a"}},
+				{Name: "foo-tools", Pipeline: []config.Pipeline{{Runs: "true"}}},
+			},
+		},
+		WorkspaceDir: ws,
+	}
+	require.NoError(t, b.moveSubpackageContents(ctx))
+
+	require.Equal(t, []string{"usr/", "usr/bin/", "usr/bin/foo", "usr/lib/", "usr/lib/libfoo.so.1"}, listTree(t, filepath.Join(out, "foo")))
+	require.Equal(t, []string{
+		"usr/", "usr/include/", "usr/include/foo/", "usr/include/foo/config.h", "usr/include/foo/foo.h",
+		"usr/lib/", "usr/lib/libfoo.a", "usr/lib/libfoo.so", "usr/lib/pkgconfig/", "usr/lib/pkgconfig/foo.pc",
+	}, listTree(t, filepath.Join(out, "foo-dev")))
+	require.Equal(t, []string{"usr/", "usr/share/", "usr/share/man/", "usr/share/man/man1/", "usr/share/man/man1/foo.1"}, listTree(t, filepath.Join(out, "foo-doc")))
+
+	// The directories that are made in subpackages have the modes of those
+	// in the main package.
+	fi, err := os.Stat(filepath.Join(out, "foo-doc", "usr", "share"))
+	require.NoError(t, err)
+	require.Equal(t, fs.FileMode(0o700), fi.Mode().Perm())
+
+	// A file can't be moved over one that's in the subpackage already.
+	for _, f := range []string{"foo/usr/bin/bar", "foo-tools/usr/bin/bar"} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(out, filepath.FromSlash(f))), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(out, filepath.FromSlash(f)), nil, 0o755))
+	}
+	b.Configuration.Subpackages = []config.Subpackage{{Name: "foo-tools", Contents: []string{"usr/bin/bar"}}}
+	require.ErrorContains(t, b.moveSubpackageContents(ctx), "already exists")
+}
@@ -0,0 +1,242 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"chainguard.dev/melange/pkg/container"
+	"github.com/chainguard-dev/clog"
+	"github.com/dustin/go-humanize"
+)
+
+// How often the workspace is measured while a step runs, to stop it as soon
+// as it goes over its quota.
+const diskPollInterval = 5 * time.Second
+
+// How many of the steps that grew the workspace the most are reported.
+const diskReportSteps = 10
+
+// diskUsage is how much disk the workspace, and the packages being written
+// to melange-out in it, use.
+type diskUsage struct {
+	workspace, output uint64
+}
+
+// stepGrowth is how much a step grew the workspace and melange-out by.
+type stepGrowth struct {
+	step              string
+	workspace, output int64
+}
+
+// diskTracker tracks how much disk the workspace uses as the build runs,
+// and stops a step that takes it over its quota.
+type diskTracker struct {
+	dir string
+	// 0 if the workspace has no quota.
+	quota    uint64
+	interval time.Duration
+
+	mu       sync.Mutex
+	measured bool
+	last     diskUsage
+	steps    []stepGrowth
+}
+
+// newDiskTracker returns a tracker for the build's workspace, or nil if the
+// build doesn't track its disk usage, or can't because its runner doesn't
+// mount the workspace from the host.
+func (b *Build) newDiskTracker(ctx context.Context) *diskTracker {
+	if !b.WorkspaceUsage && b.WorkspaceQuota == "" {
+		return nil
+	}
+	if m, ok := b.Runner.(container.WorkspaceMounter); !ok || !m.MountsWorkspace() {
+		clog.FromContext(ctx).Warnf("the %s runner doesn't mount the workspace from the host, so its disk usage isn't tracked", b.Runner.Name())
+		return nil
+	}
+
+	t := &diskTracker{dir: b.WorkspaceDir, interval: diskPollInterval}
+	if b.WorkspaceQuota != "" {
+		// Checked by WithWorkspaceUsage.
+		t.quota, _ = humanize.ParseBytes(b.WorkspaceQuota)
+	}
+	return t
+}
+
+// measureDisk returns how much disk the files under dir use, and those under
+// melange-out in it. Files that go away, or can't be read, while it's
+// looking don't count.
+func measureDisk(dir string) (diskUsage, error) {
+	var u diskUsage
+	out := filepath.Join(dir, melangeOutputDirName)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dir {
+				return err
+			}
+			if d != nil && d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		size := uint64(fi.Size())
+		u.workspace += size
+		if rel, err := filepath.Rel(out, path); err == nil && filepath.IsLocal(rel) {
+			u.output += size
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		err = nil
+	}
+	return u, err
+}
+
+// overQuota returns the error for the workspace using u, if that's over the
+// quota.
+func (t *diskTracker) overQuota(u diskUsage) error {
+	if t.quota == 0 || u.workspace <= t.quota {
+		return nil
+	}
+	return fmt.Errorf("the workspace is using %s, which is over its quota of %s", humanize.Bytes(u.workspace), humanize.Bytes(t.quota))
+}
+
+// watch returns a context to run step in, which is canceled if the step
+// takes the workspace over its quota, and a function to call when the step
+// has finished, which records how much it grew the workspace by, and
+// returns the error for it having gone over the quota, if it did. Only
+// steps that run a script of their own are measured, so that a step isn't
+// measured along with the steps in it.
+func (t *diskTracker) watch(ctx context.Context, step string, runs bool) (context.Context, func() error) {
+	if t == nil || !runs {
+		return ctx, func() error { return nil }
+	}
+	log := clog.FromContext(ctx)
+
+	t.mu.Lock()
+	if !t.measured {
+		u, err := measureDisk(t.dir)
+		if err != nil {
+			log.Warnf("unable to measure the workspace: %v", err)
+		}
+		t.last, t.measured = u, true
+	}
+	before := t.last
+	t.mu.Unlock()
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		if t.quota == 0 {
+			return
+		}
+		ticker := time.NewTicker(t.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				u, err := measureDisk(t.dir)
+				if err == nil {
+					if err := t.overQuota(u); err != nil {
+						cancel(err)
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	return ctx, func() error {
+		close(stop)
+		<-stopped
+		defer cancel(nil)
+
+		u, err := measureDisk(t.dir)
+		if err != nil {
+			log.Warnf("unable to measure the workspace: %v", err)
+			u = before
+		}
+		t.mu.Lock()
+		t.last = u
+		t.steps = append(t.steps, stepGrowth{
+			step:      step,
+			workspace: int64(u.workspace) - int64(before.workspace),
+			output:    int64(u.output) - int64(before.output),
+		})
+		t.mu.Unlock()
+
+		if cause := context.Cause(ctx); cause != nil && !errors.Is(cause, context.Canceled) {
+			return cause
+		}
+		return t.overQuota(u)
+	}
+}
+
+// usage returns how much disk the workspace used when it was last measured.
+func (t *diskTracker) usage() diskUsage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.last
+}
+
+// report logs how much disk the workspace and melange-out use, and the steps
+// that grew the workspace the most.
+func (t *diskTracker) report(ctx context.Context) {
+	if t == nil {
+		return
+	}
+	log := clog.FromContext(ctx)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	log.Infof("workspace disk usage: %s, of which %s is in %s", humanize.Bytes(t.last.workspace), humanize.Bytes(t.last.output), melangeOutputDirName)
+
+	steps := slices.Clone(t.steps)
+	slices.SortStableFunc(steps, func(a, b stepGrowth) int {
+		return cmp.Compare(b.workspace, a.workspace)
+	})
+	for i, s := range steps {
+		if i == diskReportSteps || s.workspace <= 0 {
+			break
+		}
+		log.Infof("  %s grew the workspace by %s, and %s by %s", s.step, humanize.Bytes(uint64(s.workspace)), melangeOutputDirName, signedBytes(s.output))
+	}
+}
+
+// signedBytes returns n as a number of bytes, which can be negative.
+func signedBytes(n int64) string {
+	if n < 0 {
+		return "-" + humanize.Bytes(uint64(-n))
+	}
+	return humanize.Bytes(uint64(n))
+}
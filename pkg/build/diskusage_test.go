@@ -0,0 +1,68 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/chainguard-dev/clog/slogtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiskTracker(t *testing.T) {
+	ctx := slogtest.Context(t)
+	dir := t.TempDir()
+	out := filepath.Join(dir, melangeOutputDirName, "foo")
+	require.NoError(t, os.MkdirAll(out, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "source.c"), make([]byte, 100), 0o644))
+
+	dt := &diskTracker{dir: dir, quota: 1000, interval: time.Millisecond}
+
+	// A step that grows the workspace and melange-out.
+	_, ran := dt.watch(ctx, "build", true)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "foo.o"), make([]byte, 300), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(out, "foo"), make([]byte, 200), 0o644))
+	require.NoError(t, ran())
+	require.Equal(t, diskUsage{workspace: 600, output: 200}, dt.usage())
+
+	// Steps that run no script of their own aren't measured.
+	_, ran = dt.watch(ctx, "nested", false)
+	require.NoError(t, ran())
+
+	// A step that takes the workspace over its quota is stopped while it
+	// runs.
+	stepCtx, ran := dt.watch(ctx, "bloat", true)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "bloat"), make([]byte, 1000), 0o644))
+	select {
+	case <-stepCtx.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatal("step wasn't stopped for going over the quota")
+	}
+	require.ErrorContains(t, ran(), "the workspace is using 1.6 kB, which is over its quota of 1.0 kB")
+
+	require.Equal(t, []stepGrowth{
+		{step: "build", workspace: 500, output: 200},
+		{step: "bloat", workspace: 1000},
+	}, dt.steps)
+
+	// Without a quota, steps aren't stopped.
+	dt.quota = 0
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "more"), make([]byte, 1000), 0o644))
+	_, ran = dt.watch(ctx, "more", true)
+	require.NoError(t, ran())
+}
@@ -0,0 +1,113 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+
+	"chainguard.dev/melange/pkg/container"
+	"chainguard.dev/melange/pkg/egress"
+	"github.com/chainguard-dev/clog"
+)
+
+// egressAllowlist returns the hosts that the build can connect to, from the
+// configuration and EgressAllow, and whether the build is limited to them at
+// all.
+func (b *Build) egressAllowlist() ([]string, bool) {
+	var allow []string
+	if b.Configuration.Egress != nil {
+		allow = append(allow, b.Configuration.Egress.Allow...)
+	}
+	allow = append(allow, b.EgressAllow...)
+	return allow, b.Configuration.Egress != nil || len(b.EgressAllow) != 0
+}
+
+// startEgressProxy starts a proxy that enforces the build's egress
+// allowlist, if it has one, for steps to reach the network through. It
+// returns a function that stops the proxy and reports the hosts that were
+// contacted.
+func (b *Build) startEgressProxy(ctx context.Context) (func() error, error) {
+	log := clog.FromContext(ctx)
+
+	allow, ok := b.egressAllowlist()
+	if !ok {
+		return func() error { return nil }, nil
+	}
+	proxier, ok := b.Runner.(container.EgressProxier)
+	if !ok {
+		return nil, fmt.Errorf("the %s runner doesn't support egress allowlists", b.Runner.Name())
+	}
+
+	policy, err := egress.NewPolicy(allow)
+	if err != nil {
+		return nil, err
+	}
+	proxy, err := egress.NewProxy(policy, "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("starting egress proxy: %w", err)
+	}
+	go func() {
+		if err := proxy.Serve(ctx); err != nil {
+			log.Errorf("egress proxy: %v", err)
+		}
+	}()
+
+	port := proxy.Addr().(*net.TCPAddr).Port
+	b.egressProxy = proxy
+	b.egressProxyURL = "http://" + net.JoinHostPort(proxier.HostLoopbackAddress(), strconv.Itoa(port))
+	log.Infof("limiting the build to the hosts in its egress allowlist with a proxy at %s", b.egressProxyURL)
+
+	return func() error {
+		proxy.Close()
+
+		report := proxy.Report()
+		for _, c := range report {
+			verdict := "allowed"
+			if !c.Allowed {
+				verdict = "blocked"
+			}
+			log.Infof("egress: %s %s (%d connections)", verdict, c.Host, c.Requests)
+		}
+		if b.EgressReport == "" {
+			return nil
+		}
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(b.EgressReport, append(data, '\n'), 0o644); err != nil {
+			return fmt.Errorf("writing egress report: %w", err)
+		}
+		return nil
+	}, nil
+}
+
+// egressEnvironment returns the environment variables that point steps at
+// the egress proxy.
+func egressEnvironment(proxyURL string) map[string]string {
+	env := map[string]string{}
+	for _, k := range []string{"http_proxy", "https_proxy", "HTTP_PROXY", "HTTPS_PROXY"} {
+		env[k] = proxyURL
+	}
+	for _, k := range []string{"no_proxy", "NO_PROXY"} {
+		env[k] = "localhost,127.0.0.1,::1"
+	}
+	return env
+}
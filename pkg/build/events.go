@@ -0,0 +1,343 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"chainguard.dev/melange/pkg/config"
+	"chainguard.dev/melange/pkg/container"
+	"github.com/chainguard-dev/clog"
+)
+
+// The types of the events in a build's event stream.
+const (
+	EventBuildStarted  = "build-started"
+	EventBuildFinished = "build-finished"
+	EventStepStarted   = "step-started"
+	EventStepFinished  = "step-finished"
+
+	EventSubpackageStarted  = "subpackage-started"
+	EventSubpackageFinished = "subpackage-finished"
+	EventSubpackageSkipped  = "subpackage-skipped"
+)
+
+// Event is a line of a build's event stream.
+type Event struct {
+	Time       time.Time `json:"time"`
+	Type       string    `json:"type"`
+	Package    string    `json:"package"`
+	Arch       string    `json:"arch"`
+	Subpackage string    `json:"subpackage,omitempty"`
+
+	// The subpackages of the package, when the build starts.
+	Subpackages []string `json:"subpackages,omitempty"`
+
+	// Steps are numbered in the order they start in, from 1, and a nested
+	// step has the number of the step it's in as its parent.
+	Step   int    `json:"step,omitempty"`
+	Parent int    `json:"parent,omitempty"`
+	Name   string `json:"name,omitempty"`
+	Uses   string `json:"uses,omitempty"`
+
+	// Only set once the build or step has finished. The exit code is that of
+	// the command that failed, if it's known.
+	Duration float64 `json:"duration,omitempty"`
+	ExitCode *int    `json:"exit-code,omitempty"`
+	Error    string  `json:"error,omitempty"`
+
+	// How much disk the workspace, and melange-out in it, use once the step
+	// or build has finished, if the build tracks its disk usage.
+	WorkspaceBytes uint64 `json:"workspace-bytes,omitempty"`
+	OutputBytes    uint64 `json:"output-bytes,omitempty"`
+
+	// The files that the step's output is written to, if any.
+	Stdout string `json:"stdout,omitempty"`
+	Stderr string `json:"stderr,omitempty"`
+}
+
+type (
+	stepKey       struct{}
+	subpackageKey struct{}
+)
+
+// withSubpackage returns a context for running the pipeline of the
+// subpackage name in.
+func withSubpackage(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, subpackageKey{}, name)
+}
+
+// stepRecorder writes the output of each step of a build to files of its
+// own, and writes the events of the build to an event stream.
+type stepRecorder struct {
+	pkg, arch string
+	// Where step logs are written, if they are.
+	logDir string
+	// Replaces secrets in step logs, once they're known.
+	redact *strings.Replacer
+
+	// Called with each event, as well as it being written to the event
+	// stream, if there is one.
+	observe func(Event)
+
+	// Tracks the disk usage of the build, if it does.
+	disk *diskTracker
+
+	mu     sync.Mutex
+	events io.Writer
+	steps  int
+}
+
+// newStepRecorder returns a recorder for the build, and a function that
+// closes its event stream, or nil if the build has no step logs, event
+// stream or event handler.
+func (b *Build) newStepRecorder() (*stepRecorder, func() error, error) {
+	if !b.StepLogs && b.EventsFile == "" && b.EventHandler == nil {
+		return nil, nil, nil
+	}
+
+	r := &stepRecorder{pkg: b.Configuration.Package.Name, arch: b.Arch.ToAPK(), observe: b.EventHandler}
+	if b.StepLogs {
+		r.logDir = filepath.Join(b.OutDir, "logs", r.arch)
+		// Don't leave the logs of another build's steps among this one's.
+		names := []string{r.pkg}
+		for _, sp := range b.Configuration.Subpackages {
+			names = append(names, sp.Name)
+		}
+		for _, name := range names {
+			if err := os.RemoveAll(filepath.Join(r.logDir, name)); err != nil {
+				return nil, nil, fmt.Errorf("removing old step logs: %w", err)
+			}
+		}
+	}
+
+	closeEvents := func() error { return nil }
+	switch b.EventsFile {
+	case "":
+	case "-":
+		r.events = os.Stdout
+	default:
+		f, err := os.OpenFile(b.EventsFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if err != nil {
+			return nil, nil, fmt.Errorf("opening event stream: %w", err)
+		}
+		r.events = f
+		closeEvents = f.Close
+	}
+	return r, closeEvents, nil
+}
+
+// emit writes e to the event stream, and passes it to the event handler, if
+// there are either.
+func (r *stepRecorder) emit(ctx context.Context, e Event) {
+	e.Time = time.Now().UTC()
+	e.Package, e.Arch = r.pkg, r.arch
+	if r.redact != nil {
+		e.Error = r.redact.Replace(e.Error)
+	}
+	if r.observe != nil {
+		r.observe(e)
+	}
+	if r.events == nil {
+		return
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		clog.FromContext(ctx).Warnf("unable to encode build event: %v", err)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.events.Write(append(data, '\n')); err != nil {
+		clog.FromContext(ctx).Warnf("unable to write build event: %v", err)
+	}
+}
+
+// finish emits the event for the end of the build, which started at began.
+func (r *stepRecorder) finish(ctx context.Context, began time.Time, err error) {
+	e := Event{Type: EventBuildFinished, Duration: time.Since(began).Seconds()}
+	if r.disk != nil {
+		u := r.disk.usage()
+		e.WorkspaceBytes, e.OutputBytes = u.workspace, u.output
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	r.emit(ctx, e)
+}
+
+// startSubpackage emits the event for the start of the pipeline of the
+// subpackage name, and returns a function to call with its result when it
+// has finished.
+func (r *stepRecorder) startSubpackage(ctx context.Context, name string) func(error) {
+	r.emit(ctx, Event{Type: EventSubpackageStarted, Subpackage: name})
+	began := time.Now()
+	return func(err error) {
+		e := Event{Type: EventSubpackageFinished, Subpackage: name, Duration: time.Since(began).Seconds()}
+		if err != nil {
+			e.Error = err.Error()
+		}
+		r.emit(ctx, e)
+	}
+}
+
+// start emits the event for the start of pipeline, and opens its logs if it
+// has a script to run. It returns a context to run the step in, and a
+// function to call with the step's result when it has finished.
+func (r *stepRecorder) start(ctx context.Context, pipeline *config.Pipeline) (context.Context, func(error), error) {
+	r.mu.Lock()
+	r.steps++
+	step := r.steps
+	r.mu.Unlock()
+
+	parent, _ := ctx.Value(stepKey{}).(int)
+	subpackage, _ := ctx.Value(subpackageKey{}).(string)
+	e := Event{
+		Type:       EventStepStarted,
+		Subpackage: subpackage,
+		Step:       step,
+		Parent:     parent,
+		Name:       describe(pipeline, identity(pipeline)),
+		Uses:       pipeline.Uses,
+	}
+
+	var logs []*redactWriter
+	closeLogs := func() {
+		for _, l := range logs {
+			if err := l.Close(); err != nil {
+				clog.FromContext(ctx).Warnf("unable to write step log: %v", err)
+			}
+		}
+	}
+	switch {
+	case r.logDir == "":
+	case pipeline.Runs == "":
+		// Nested steps don't write to the logs of the step they're in.
+		ctx = container.WithOutput(ctx, io.Discard, io.Discard)
+	default:
+		dir := filepath.Join(r.logDir, cmp.Or(subpackage, r.pkg))
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return ctx, nil, fmt.Errorf("creating step logs: %w", err)
+		}
+		base := filepath.Join(dir, fmt.Sprintf("%03d-%s", step, logName(e.Name)))
+		e.Stdout, e.Stderr = base+".stdout.log", base+".stderr.log"
+		for _, p := range []string{e.Stdout, e.Stderr} {
+			f, err := os.Create(p)
+			if err != nil {
+				closeLogs()
+				return ctx, nil, fmt.Errorf("creating step log: %w", err)
+			}
+			logs = append(logs, &redactWriter{w: f, r: r.redact})
+		}
+		ctx = container.WithOutput(ctx, logs[0], logs[1])
+	}
+
+	r.emit(ctx, e)
+	began := time.Now()
+	return context.WithValue(ctx, stepKey{}, step), func(err error) {
+		closeLogs()
+		e.Type = EventStepFinished
+		e.Duration = time.Since(began).Seconds()
+		if r.disk != nil && pipeline.Runs != "" {
+			u := r.disk.usage()
+			e.WorkspaceBytes, e.OutputBytes = u.workspace, u.output
+		}
+		if err != nil {
+			e.Error = err.Error()
+			e.ExitCode = exitCode(err)
+		} else {
+			e.ExitCode = new(int)
+		}
+		r.emit(ctx, e)
+	}, nil
+}
+
+// logName returns name as something that can go in a file name.
+func logName(name string) string {
+	name = strings.Map(func(c rune) rune {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '.', c == '_', c == '-':
+			return c
+		}
+		return '-'
+	}, name)
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return cmp.Or(strings.Trim(name, "-."), "step")
+}
+
+// exitCode returns the exit code of the command that err is the failure of,
+// if the runner says what it was.
+func exitCode(err error) *int {
+	var code interface{ ExitCode() int }
+	if errors.As(err, &code) {
+		c := code.ExitCode()
+		return &c
+	}
+	// As returned by the qemu runner.
+	var status interface{ ExitStatus() int }
+	if errors.As(err, &status) {
+		c := status.ExitStatus()
+		return &c
+	}
+	return nil
+}
+
+// redactWriter replaces secrets in what's written to w, a line at a time so
+// that a secret isn't missed for being split across writes.
+type redactWriter struct {
+	w   io.WriteCloser
+	r   *strings.Replacer
+	buf []byte
+}
+
+func (w *redactWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	if i := bytes.LastIndexByte(w.buf, '\n'); i >= 0 {
+		if err := w.flush(w.buf[:i+1]); err != nil {
+			return 0, err
+		}
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+func (w *redactWriter) flush(p []byte) error {
+	if w.r != nil {
+		p = []byte(w.r.Replace(string(p)))
+	}
+	_, err := w.w.Write(p)
+	return err
+}
+
+// Close writes what's left of the last line, and closes w.
+func (w *redactWriter) Close() error {
+	err := w.flush(w.buf)
+	w.buf = nil
+	return errors.Join(err, w.w.Close())
+}
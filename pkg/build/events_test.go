@@ -0,0 +1,175 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	apko_types "chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/melange/pkg/config"
+	"chainguard.dev/melange/pkg/container"
+	"github.com/chainguard-dev/clog/slogtest"
+	"github.com/stretchr/testify/require"
+)
+
+// teeRunner runs commands on the host, writing their output wherever the
+// context says to, like the real runners.
+type teeRunner struct {
+	container.Runner
+}
+
+func (teeRunner) Run(ctx context.Context, _ *container.Config, envOverride map[string]string, cmd ...string) error {
+	c := exec.CommandContext(ctx, cmd[0], cmd[1:]...)
+	for k, v := range envOverride {
+		c.Env = append(c.Env, k+"="+v)
+	}
+	c.Stdout, c.Stderr = container.TeeOutput(ctx, io.Discard, io.Discard)
+	return c.Run()
+}
+
+func TestStepRecorder(t *testing.T) {
+	ctx := slogtest.Context(t)
+	out := t.TempDir()
+	events := filepath.Join(t.TempDir(), "events.json")
+
+	b := &Build{
+		Configuration: config.Configuration{Package: config.Package{Name: "foo"}},
+		Arch:          apko_types.ParseArchitecture("x86_64"),
+		OutDir:        out,
+		StepLogs:      true,
+		EventsFile:    events,
+	}
+	rec, closeEvents, err := b.newStepRecorder()
+	require.NoError(t, err)
+	rec.redact = strings.NewReplacer("hunter2", redacted)
+	r := &pipelineRunner{config: &container.Config{}, runner: teeRunner{}, recorder: rec}
+
+	_, err = r.runPipeline(ctx, &config.Pipeline{
+		Name: "outer",
+		Pipeline: []config.Pipeline{{
+			Name: "say hello/world",
+			Runs: "echo hello; printf 'the password is hunter'; printf '2\\n'; echo oops >&2",
+		}},
+	})
+	require.NoError(t, err)
+
+	ran, err := r.runPipeline(ctx, &config.Pipeline{If: "'a' == 'b'", Runs: "exit 1"})
+	require.NoError(t, err)
+	require.False(t, ran)
+
+	_, err = r.runPipeline(withSubpackage(ctx, "foo-dev"), &config.Pipeline{Runs: "exit 3"})
+	require.Error(t, err)
+	require.NoError(t, closeEvents())
+
+	logs := filepath.Join(out, "logs", "x86_64")
+	stdout, err := os.ReadFile(filepath.Join(logs, "foo", "002-say-hello-world.stdout.log"))
+	require.NoError(t, err)
+	require.Equal(t, "hello\nthe password is "+redacted+"\n", string(stdout))
+	stderr, err := os.ReadFile(filepath.Join(logs, "foo", "002-say-hello-world.stderr.log"))
+	require.NoError(t, err)
+	require.Equal(t, "oops\n", string(stderr))
+	require.FileExists(t, filepath.Join(logs, "foo-dev", "003-exit-3.stdout.log"))
+	require.NoFileExists(t, filepath.Join(logs, "foo", "001-outer.stdout.log"))
+
+	f, err := os.Open(events)
+	require.NoError(t, err)
+	defer f.Close()
+	var got []Event
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var e Event
+		require.NoError(t, json.Unmarshal(s.Bytes(), &e))
+		require.Equal(t, "foo", e.Package)
+		require.Equal(t, "x86_64", e.Arch)
+		got = append(got, e)
+	}
+	require.NoError(t, s.Err())
+
+	require.Len(t, got, 6)
+	for i, want := range []struct {
+		typ, name, subpackage string
+		step, parent          int
+	}{
+		{EventStepStarted, "outer", "", 1, 0},
+		{EventStepStarted, "say hello/world", "", 2, 1},
+		{EventStepFinished, "say hello/world", "", 2, 1},
+		{EventStepFinished, "outer", "", 1, 0},
+		{EventStepStarted, "exit 3", "foo-dev", 3, 0},
+		{EventStepFinished, "exit 3", "foo-dev", 3, 0},
+	} {
+		require.Equal(t, want.typ, got[i].Type, "event %d", i)
+		require.Equal(t, want.name, got[i].Name, "event %d", i)
+		require.Equal(t, want.subpackage, got[i].Subpackage, "event %d", i)
+		require.Equal(t, want.step, got[i].Step, "event %d", i)
+		require.Equal(t, want.parent, got[i].Parent, "event %d", i)
+	}
+	require.Nil(t, got[1].ExitCode)
+	require.Equal(t, 0, *got[2].ExitCode)
+	require.Equal(t, filepath.Join(logs, "foo", "002-say-hello-world.stdout.log"), got[2].Stdout)
+	require.Empty(t, got[3].Stdout)
+	require.Equal(t, 3, *got[5].ExitCode)
+	require.NotEmpty(t, got[5].Error)
+}
+
+func TestSubpackageEvents(t *testing.T) {
+	ctx := slogtest.Context(t)
+
+	var got []Event
+	b := &Build{
+		WorkspaceDir: t.TempDir(),
+		Configuration: config.Configuration{
+			Package:  config.Package{Name: "foo"},
+			Pipeline: []config.Pipeline{{Runs: "make"}},
+			Subpackages: []config.Subpackage{{
+				Name:     "foo-dev",
+				Pipeline: []config.Pipeline{{Runs: "true"}},
+			}, {
+				Name:     "foo-doc",
+				Pipeline: []config.Pipeline{{Runs: "false"}},
+			}},
+		},
+		EventHandler: func(e Event) { got = append(got, e) },
+	}
+	rec, _, err := b.newStepRecorder()
+	require.NoError(t, err)
+	b.recorder = rec
+	pr := &pipelineRunner{config: &container.Config{}, runner: hostRunner{}, recorder: rec}
+	require.Error(t, b.runSubpackagePipelines(ctx, pr))
+
+	var types []string
+	for _, e := range got {
+		types = append(types, e.Type+" "+e.Subpackage)
+	}
+	require.Equal(t, []string{
+		"subpackage-started foo-dev",
+		"step-started foo-dev",
+		"step-finished foo-dev",
+		"subpackage-finished foo-dev",
+		"subpackage-started foo-doc",
+		"step-started foo-doc",
+		"step-finished foo-doc",
+		"subpackage-finished foo-doc",
+	}, types)
+	require.Empty(t, got[3].Error)
+	require.NotEmpty(t, got[7].Error)
+}
@@ -0,0 +1,325 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"archive/tar"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"time"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+	apkofs "chainguard.dev/apko/pkg/apk/fs"
+	apko_types "chainguard.dev/apko/pkg/build/types"
+	"github.com/chainguard-dev/clog"
+	"github.com/dustin/go-humanize"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	v1types "github.com/google/go-containerregistry/pkg/v1/types"
+	"golang.org/x/sys/unix"
+)
+
+// DefaultGuestCacheSize is how big the guest cache can grow before the
+// least recently used guests are removed.
+const DefaultGuestCacheSize = "10GB"
+
+// guestCacheVersion is changed when what's cached for a guest, or how its
+// key is made, changes, so that guests cached by older versions of melange
+// aren't used.
+const guestCacheVersion = 1
+
+// guestCacheDir returns the directory that guests are cached in, in the
+// user's cache directory.
+func guestCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("finding the user cache directory: %w", err)
+	}
+	return filepath.Join(dir, "melange", "guests"), nil
+}
+
+// guestCacheKey returns the key of the guest that the build lays out from
+// imgConfig, once apko has resolved it to pkgs. Guests with the same key
+// have the same packages, from the same repositories, installed in the same
+// way, so one can be used in place of another.
+func (b *Build) guestCacheKey(imgConfig apko_types.ImageConfiguration, pkgs []*apk.RepositoryPackage) (string, error) {
+	resolved := make([]string, 0, len(pkgs))
+	for _, pkg := range pkgs {
+		resolved = append(resolved, strings.Join([]string{pkg.Name, pkg.Version, pkg.ChecksumString(), pkg.URL()}, " "))
+	}
+	slices.Sort(resolved)
+
+	key := struct {
+		Version       int
+		Apko          string
+		Arch          string
+		Environment   apko_types.ImageConfiguration
+		ExtraKeys     []string
+		ExtraRepos    []string
+		ExtraPackages []string
+		Packages      []string
+	}{
+		Version:       guestCacheVersion,
+		Apko:          apkoVersion(),
+		Arch:          b.Arch.ToAPK(),
+		Environment:   imgConfig,
+		ExtraKeys:     b.ExtraKeys,
+		ExtraRepos:    b.ExtraRepos,
+		ExtraPackages: b.ExtraPackages,
+		Packages:      resolved,
+	}
+	data, err := json.Marshal(key)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// apkoVersion returns the version of apko that melange was built with, as
+// it's apko that lays out guests.
+func apkoVersion() string {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	for _, dep := range bi.Deps {
+		if dep.Path == "chainguard.dev/apko" {
+			if dep.Replace != nil {
+				return dep.Replace.Path + "@" + dep.Replace.Version
+			}
+			return dep.Version
+		}
+	}
+	return ""
+}
+
+// restoreGuest lays out the guest cached at path in guestFS, and returns the
+// layer that it was cached as. It returns an error satisfying
+// errors.Is(err, fs.ErrNotExist) if there is no guest cached at path.
+func restoreGuest(path string, guestFS apkofs.FullFS) (v1.Layer, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	// Guests are removed in the order they were last used in.
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err != nil {
+		return nil, err
+	}
+
+	layer, err := tarball.LayerFromFile(path, tarball.WithMediaType(v1types.OCILayer))
+	if err != nil {
+		return nil, err
+	}
+	rc, err := layer.Uncompressed()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	if err := extractGuest(guestFS, tar.NewReader(rc)); err != nil {
+		return nil, err
+	}
+	return layer, nil
+}
+
+// extractGuest unpacks the guest in tr to guestFS as it was cached, with the
+// ownership and full modes of its files, and its device nodes and FIFOs.
+func extractGuest(guestFS apkofs.FullFS, tr *tar.Reader) error {
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		mode := hdr.FileInfo().Mode()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := guestFS.MkdirAll(hdr.Name, mode.Perm()); err != nil {
+				return fmt.Errorf("unable to create directory %s: %w", hdr.Name, err)
+			}
+
+		case tar.TypeReg:
+			f, err := guestFS.OpenFile(hdr.Name, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode.Perm())
+			if err != nil {
+				return fmt.Errorf("unable to open file %s: %w", hdr.Name, err)
+			}
+			if _, err := io.CopyN(f, tr, hdr.Size); err != nil {
+				f.Close()
+				return fmt.Errorf("unable to copy file %s: %w", hdr.Name, err)
+			}
+			if err := f.Close(); err != nil {
+				return fmt.Errorf("unable to close file %s: %w", hdr.Name, err)
+			}
+
+		case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+			typ := map[byte]uint32{tar.TypeChar: unix.S_IFCHR, tar.TypeBlock: unix.S_IFBLK, tar.TypeFifo: unix.S_IFIFO}[hdr.Typeflag]
+			dev := int(unix.Mkdev(uint32(hdr.Devmajor), uint32(hdr.Devminor)))
+			if err := guestFS.Mknod(hdr.Name, typ|uint32(mode.Perm()), dev); err != nil {
+				return fmt.Errorf("unable to create special file %s: %w", hdr.Name, err)
+			}
+
+		case tar.TypeSymlink:
+			// The filesystem can't change the ownership of the link itself,
+			// and its mode doesn't matter.
+			if err := guestFS.Symlink(hdr.Linkname, hdr.Name); err != nil {
+				return fmt.Errorf("unable to create symlink %s -> %s: %w", hdr.Name, hdr.Linkname, err)
+			}
+			continue
+
+		case tar.TypeLink:
+			// The link shares the ownership and mode of its target.
+			if err := guestFS.Link(hdr.Linkname, hdr.Name); err != nil {
+				return fmt.Errorf("unable to create hard link %s -> %s: %w", hdr.Name, hdr.Linkname, err)
+			}
+			continue
+
+		default:
+			// apko doesn't lay out anything else in guests, so there's
+			// nothing else to restore.
+			continue
+		}
+
+		// Change the ownership first, as that clears the setuid and setgid
+		// bits.
+		if err := guestFS.Chown(hdr.Name, hdr.Uid, hdr.Gid); err != nil {
+			return fmt.Errorf("unable to change the ownership of %s: %w", hdr.Name, err)
+		}
+		if err := guestFS.Chmod(hdr.Name, mode&(fs.ModePerm|fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky)); err != nil {
+			return fmt.Errorf("unable to change the mode of %s: %w", hdr.Name, err)
+		}
+
+		for k, v := range hdr.PAXRecords {
+			attr, ok := strings.CutPrefix(k, "SCHILY.xattr.")
+			if !ok {
+				continue
+			}
+			if err := guestFS.SetXattr(hdr.Name, attr, []byte(v)); err != nil {
+				return fmt.Errorf("unable to set xattr %s on %s: %w", attr, hdr.Name, err)
+			}
+		}
+	}
+}
+
+// saveGuest caches the layer of a guest, at layerTarGZ, at path.
+func saveGuest(layerTarGZ, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	in, err := os.Open(layerTarGZ)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	// Write the guest next to where it goes and then move it there, so that
+	// a build never sees half of one.
+	out, err := os.CreateTemp(filepath.Dir(path), ".guest-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), path)
+}
+
+// cacheGuest caches the layer of the guest with key, at layerTarGZ, and
+// then removes the least recently used guests until the cache is no bigger
+// than it can be. As the guest has been built by then, failing to cache it
+// doesn't fail the build.
+func (b *Build) cacheGuest(ctx context.Context, dir, key, layerTarGZ string) {
+	log := clog.FromContext(ctx)
+
+	path := filepath.Join(dir, key+".tar.gz")
+	if err := saveGuest(layerTarGZ, path); err != nil {
+		log.Warnf("unable to cache guest: %v", err)
+		return
+	}
+	log.Infof("cached guest as %s", path)
+
+	limit, err := humanize.ParseBytes(cmp.Or(b.GuestCacheSize, DefaultGuestCacheSize))
+	if err != nil {
+		log.Warnf("parsing guest cache size: %v", err)
+		return
+	}
+	if err := evictGuests(ctx, dir, limit, path); err != nil {
+		log.Warnf("unable to remove old guests: %v", err)
+	}
+}
+
+// evictGuests removes the least recently used guests in dir until they take
+// up no more than limit bytes. The guest at inUse is never removed, even if
+// it's bigger than limit on its own.
+func evictGuests(ctx context.Context, dir string, limit uint64, inUse string) error {
+	log := clog.FromContext(ctx)
+
+	des, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var entries []fs.FileInfo
+	var total uint64
+	for _, de := range des {
+		if !de.Type().IsRegular() || !strings.HasSuffix(de.Name(), ".tar.gz") {
+			continue
+		}
+		fi, err := de.Info()
+		if err != nil {
+			return err
+		}
+		entries = append(entries, fi)
+		total += uint64(fi.Size())
+	}
+
+	slices.SortFunc(entries, func(a, b fs.FileInfo) int { return a.ModTime().Compare(b.ModTime()) })
+	for _, fi := range entries {
+		if total <= limit {
+			break
+		}
+		path := filepath.Join(dir, fi.Name())
+		if path == inUse {
+			continue
+		}
+		log.Infof("removing cached guest %s (%s), as cached guests are bigger than %s", fi.Name(), humanize.Bytes(uint64(fi.Size())), humanize.Bytes(limit))
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		total -= uint64(fi.Size())
+	}
+	return nil
+}
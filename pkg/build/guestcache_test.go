@@ -0,0 +1,216 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+	apkofs "chainguard.dev/apko/pkg/apk/fs"
+	apko_types "chainguard.dev/apko/pkg/build/types"
+)
+
+func TestGuestCacheKey(t *testing.T) {
+	repo := (&apk.Repository{URI: "https://packages.wolfi.dev/os/x86_64"}).WithIndex(&apk.APKIndex{})
+	pkg := func(name, version string, checksum byte) *apk.RepositoryPackage {
+		return apk.NewRepositoryPackage(&apk.Package{Name: name, Version: version, Checksum: []byte{checksum}}, repo)
+	}
+	imgConfig := apko_types.ImageConfiguration{Contents: apko_types.ImageContents{Packages: []string{"busybox", "go"}}}
+	b := &Build{Arch: apko_types.ParseArchitecture("x86_64")}
+
+	key := func(b *Build, imgConfig apko_types.ImageConfiguration, pkgs ...*apk.RepositoryPackage) string {
+		k, err := b.guestCacheKey(imgConfig, pkgs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+	want := key(b, imgConfig, pkg("busybox", "1.36.1-r7", 1), pkg("go", "1.22.5-r0", 2))
+
+	if got := key(b, imgConfig, pkg("go", "1.22.5-r0", 2), pkg("busybox", "1.36.1-r7", 1)); got != want {
+		t.Errorf("the order of the packages changed the key")
+	}
+	for name, got := range map[string]string{
+		"version":  key(b, imgConfig, pkg("busybox", "1.36.1-r7", 1), pkg("go", "1.22.6-r0", 2)),
+		"checksum": key(b, imgConfig, pkg("busybox", "1.36.1-r7", 1), pkg("go", "1.22.5-r0", 3)),
+		"package":  key(b, imgConfig, pkg("busybox", "1.36.1-r7", 1)),
+		"arch":     key(&Build{Arch: apko_types.ParseArchitecture("aarch64")}, imgConfig, pkg("busybox", "1.36.1-r7", 1), pkg("go", "1.22.5-r0", 2)),
+		"accounts": key(b, apko_types.ImageConfiguration{
+			Contents: imgConfig.Contents,
+			Accounts: apko_types.ImageAccounts{RunAs: "build"},
+		}, pkg("busybox", "1.36.1-r7", 1), pkg("go", "1.22.5-r0", 2)),
+	} {
+		if got == want {
+			t.Errorf("a different %s didn't change the key", name)
+		}
+	}
+}
+
+func TestGuestCache(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	// A layer like apko's, with a directory, a file and a link to it.
+	layerTarGZ := writeGuestLayer(t,
+		&tar.Header{Typeflag: tar.TypeDir, Name: "etc/", Mode: 0o755},
+		&tar.Header{Typeflag: tar.TypeReg, Name: "etc/os-release", Mode: 0o644, Size: 6},
+		&tar.Header{Typeflag: tar.TypeSymlink, Name: "etc/release", Linkname: "os-release"},
+	)
+
+	path := filepath.Join(dir, "abc.tar.gz")
+	if _, err := restoreGuest(path, apkofs.NewMemFS()); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("want not exist restoring an uncached guest, got %v", err)
+	}
+
+	b := &Build{}
+	b.cacheGuest(ctx, dir, "abc", layerTarGZ)
+
+	guestDir := t.TempDir()
+	layer, err := restoreGuest(path, apkofs.DirFS(guestDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mt, err := layer.MediaType(); err != nil || mt != "application/vnd.oci.image.layer.v1.tar+gzip" {
+		t.Errorf("want an OCI layer, got %q (%v)", mt, err)
+	}
+	data, err := os.ReadFile(filepath.Join(guestDir, "etc", "release"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "wolfi\n" {
+		t.Errorf("want the cached guest's os-release, got %q", data)
+	}
+}
+
+// writeGuestLayer writes a layer with the entries hdrs, whose regular files
+// all contain "wolfi\n", and returns its path.
+func writeGuestLayer(t *testing.T, hdrs ...*tar.Header) string {
+	t.Helper()
+
+	layerTarGZ := filepath.Join(t.TempDir(), "layer.tar.gz")
+	f, err := os.Create(layerTarGZ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	for _, hdr := range hdrs {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Size != 0 {
+			if _, err := tw.Write([]byte("wolfi\n")); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for _, c := range []interface{ Close() error }{tw, gw, f} {
+		if err := c.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return layerTarGZ
+}
+
+func TestRestoreGuestFaithfully(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	layerTarGZ := writeGuestLayer(t,
+		&tar.Header{Typeflag: tar.TypeDir, Name: "tmp/", Mode: 0o1777},
+		&tar.Header{Typeflag: tar.TypeDir, Name: "home/", Mode: 0o755},
+		&tar.Header{Typeflag: tar.TypeDir, Name: "home/build/", Mode: 0o755, Uid: 1000, Gid: 1000},
+		&tar.Header{Typeflag: tar.TypeReg, Name: "home/build/.profile", Mode: 0o644, Uid: 1000, Gid: 1000, Size: 6},
+		&tar.Header{Typeflag: tar.TypeDir, Name: "usr/", Mode: 0o755},
+		&tar.Header{Typeflag: tar.TypeDir, Name: "usr/bin/", Mode: 0o755},
+		&tar.Header{Typeflag: tar.TypeReg, Name: "usr/bin/su", Mode: 0o4755, Size: 6},
+		&tar.Header{Typeflag: tar.TypeDir, Name: "run/", Mode: 0o755},
+		&tar.Header{Typeflag: tar.TypeFifo, Name: "run/initctl", Mode: 0o600},
+	)
+	b := &Build{}
+	b.cacheGuest(ctx, dir, "abc", layerTarGZ)
+
+	guestFS := apkofs.NewMemFS()
+	if _, err := restoreGuest(filepath.Join(dir, "abc.tar.gz"), guestFS); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		path     string
+		mode     fs.FileMode
+		uid, gid int
+	}{
+		{"tmp", fs.ModeSticky | 0o777, 0, 0},
+		{"home/build", 0o755, 1000, 1000},
+		{"home/build/.profile", 0o644, 1000, 1000},
+		{"usr/bin/su", fs.ModeSetuid | 0o755, 0, 0},
+	} {
+		fi, err := guestFS.Stat(c.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := fi.Mode() &^ fs.ModeDir; got != c.mode {
+			t.Errorf("%s: want mode %v, got %v", c.path, c.mode, got)
+		}
+		hdr, ok := fi.Sys().(*tar.Header)
+		if !ok {
+			t.Fatalf("%s: no ownership in %T", c.path, fi.Sys())
+		}
+		if hdr.Uid != c.uid || hdr.Gid != c.gid {
+			t.Errorf("%s: want owner %d:%d, got %d:%d", c.path, c.uid, c.gid, hdr.Uid, hdr.Gid)
+		}
+	}
+	if _, err := guestFS.Stat("run/initctl"); err != nil {
+		t.Errorf("want the FIFO restored: %v", err)
+	}
+}
+
+func TestEvictGuests(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	for i, name := range []string{"a.tar.gz", "b.tar.gz", "c.tar.gz"} {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, make([]byte, 1000), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		mtime := now.Add(time.Duration(i) * time.Hour)
+		if err := os.Chtimes(p, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The oldest guest is in use, so the next oldest is removed instead.
+	if err := evictGuests(context.Background(), dir, 2000, filepath.Join(dir, "a.tar.gz")); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]bool{
+		"a.tar.gz": true,
+		"b.tar.gz": false,
+		"c.tar.gz": true,
+	} {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if got := err == nil; got != want {
+			t.Errorf("%s: want exists %t, got %t", name, want, got)
+		}
+	}
+}
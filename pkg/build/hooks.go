@@ -0,0 +1,119 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"chainguard.dev/melange/internal/logwriter"
+	"github.com/chainguard-dev/clog"
+)
+
+// The kinds of host hooks, as given to them in MELANGE_HOOK.
+const (
+	hookPreBuild  = "pre-build"
+	hookPostBuild = "post-build"
+)
+
+// hookEnv returns the environment that the build's hooks of kind are run
+// with: melange's own, and the build's metadata.
+func (b *Build) hookEnv(kind string) []string {
+	pkg := b.Configuration.Package
+	return append(os.Environ(),
+		"MELANGE_HOOK="+kind,
+		"MELANGE_PACKAGE="+pkg.Name,
+		"MELANGE_VERSION="+pkg.Version,
+		"MELANGE_EPOCH="+strconv.FormatUint(pkg.Epoch, 10),
+		"MELANGE_FULL_VERSION="+pkg.FullVersion(),
+		"MELANGE_ARCH="+b.Arch.ToAPK(),
+		"MELANGE_CONFIG_FILE="+b.ConfigFile,
+		"MELANGE_OUT_DIR="+filepath.Join(b.OutDir, b.Arch.ToAPK()),
+		"MELANGE_WORKSPACE_DIR="+b.WorkspaceDir,
+	)
+}
+
+// runPreBuildHooks runs the build's pre-build hooks, in order, stopping at
+// the first that fails.
+func (b *Build) runPreBuildHooks(ctx context.Context) error {
+	return runHooks(ctx, hookPreBuild, b.PreBuildHooks, b.hookEnv(hookPreBuild))
+}
+
+// runPostBuildHooks runs the build's post-build hooks, in order, stopping at
+// the first that fails, with the result of the build, which started at
+// began and failed with buildErr if it isn't nil.
+func (b *Build) runPostBuildHooks(ctx context.Context, began time.Time, buildErr error) error {
+	if len(b.PostBuildHooks) == 0 {
+		return nil
+	}
+
+	env := b.hookEnv(hookPostBuild)
+	env = append(env, "MELANGE_DURATION="+strconv.FormatFloat(time.Since(began).Seconds(), 'f', 0, 64))
+	if buildErr != nil {
+		msg := buildErr.Error()
+		if b.redactor != nil {
+			msg = b.redactor.Replace(msg)
+		}
+		env = append(env, "MELANGE_RESULT=failure", "MELANGE_ERROR="+msg)
+	} else {
+		env = append(env, "MELANGE_RESULT=success", "MELANGE_PACKAGES="+strings.Join(b.builtPackages(), " "))
+	}
+	return runHooks(ctx, hookPostBuild, b.PostBuildHooks, env)
+}
+
+// builtPackages returns the files of the packages that the build wrote.
+func (b *Build) builtPackages() []string {
+	names := []string{b.Configuration.Package.Name}
+	for _, sp := range b.Configuration.Subpackages {
+		names = append(names, sp.Name)
+	}
+
+	var files []string
+	for _, name := range names {
+		pb := PackageBuild{Build: b, Origin: &b.Configuration.Package, PackageName: name, OutDir: filepath.Join(b.OutDir, b.Arch.ToAPK())}
+		if _, err := os.Stat(pb.Filename()); err == nil {
+			files = append(files, pb.Filename())
+		}
+	}
+	return files
+}
+
+// runHooks runs each of hooks with sh -c on the host, with env, logging
+// what they write.
+func runHooks(ctx context.Context, kind string, hooks []string, env []string) error {
+	log := clog.FromContext(ctx)
+
+	for _, hook := range hooks {
+		log.Infof("running %s hook: %s", kind, hook)
+
+		stdout, stderr := logwriter.New(log.Info), logwriter.New(log.Warn)
+		cmd := exec.CommandContext(ctx, "/bin/sh", "-c", hook)
+		cmd.Env = env
+		cmd.Stdout, cmd.Stderr = stdout, stderr
+		err := cmd.Run()
+		stdout.Close()
+		stderr.Close()
+		if err != nil {
+			return fmt.Errorf("%s hook %q: %w", kind, hook, err)
+		}
+	}
+	return nil
+}
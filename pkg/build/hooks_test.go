@@ -0,0 +1,72 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	apko_types "chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/melange/pkg/config"
+	"github.com/chainguard-dev/clog/slogtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHooks(t *testing.T) {
+	ctx := slogtest.Context(t)
+	out := t.TempDir()
+	record := filepath.Join(t.TempDir(), "hooks")
+
+	b := &Build{
+		Configuration: config.Configuration{
+			Package:     config.Package{Name: "foo", Version: "1.0", Epoch: 2},
+			Subpackages: []config.Subpackage{{Name: "foo-dev"}, {Name: "foo-doc"}},
+		},
+		Arch:   apko_types.ParseArchitecture("x86_64"),
+		OutDir: out,
+		PreBuildHooks: []string{
+			`echo "$MELANGE_HOOK $MELANGE_PACKAGE $MELANGE_FULL_VERSION $MELANGE_ARCH $MELANGE_OUT_DIR" >> ` + record,
+		},
+		PostBuildHooks: []string{
+			`echo "$MELANGE_HOOK $MELANGE_RESULT $MELANGE_PACKAGES$MELANGE_ERROR" >> ` + record,
+			"exit 4",
+			"echo unreachable >> " + record,
+		},
+		redactor: strings.NewReplacer("hunter2", redacted),
+	}
+	require.NoError(t, os.MkdirAll(filepath.Join(out, "x86_64"), 0o755))
+	for _, name := range []string{"foo-1.0-r2.apk", "foo-dev-1.0-r2.apk"} {
+		require.NoError(t, os.WriteFile(filepath.Join(out, "x86_64", name), nil, 0o644))
+	}
+
+	require.NoError(t, b.runPreBuildHooks(ctx))
+	err := b.runPostBuildHooks(ctx, time.Now(), nil)
+	require.ErrorContains(t, err, `post-build hook "exit 4"`)
+	err = b.runPostBuildHooks(ctx, time.Now(), errors.New("the password is hunter2"))
+	require.Error(t, err)
+
+	got, err := os.ReadFile(record)
+	require.NoError(t, err)
+	arch := filepath.Join(out, "x86_64")
+	require.Equal(t, strings.Join([]string{
+		"pre-build foo 1.0-r2 x86_64 " + arch,
+		"post-build success " + filepath.Join(arch, "foo-1.0-r2.apk") + " " + filepath.Join(arch, "foo-dev-1.0-r2.apk"),
+		"post-build failure the password is " + redacted,
+	}, "\n")+"\n", string(got))
+}
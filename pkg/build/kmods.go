@@ -0,0 +1,119 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"slices"
+
+	"chainguard.dev/melange/pkg/config"
+	"github.com/chainguard-dev/clog"
+)
+
+// kernelModuleRe matches the paths of kernel modules, compressed or not, in
+// the modules directory of a kernel.
+var kernelModuleRe = regexp.MustCompile(`^(usr/)?lib/modules/[^/]+/.+\.ko(\.gz|\.xz|\.zst)?$`)
+
+// depmodTrigger regenerates the module dependency lists of the kernels whose
+// modules directories it's triggered on, so that modprobe finds modules that
+// were installed or removed.
+const depmodTrigger = `#!/bin/sh
+command -v depmod >/dev/null || exit 0
+for dir in "$@"; do
+	kver="${dir#*/modules/}"
+	kver="${kver%%/*}"
+	[ -d "${dir%%/modules/*}/modules/$kver" ] || continue
+	depmod -a "$kver" || :
+done
+`
+
+// addKernelModuleTriggers gives the packages that install kernel modules a
+// trigger on the directories they install them to that runs depmod, unless
+// they have a trigger of their own.
+func (b *Build) addKernelModuleTriggers(ctx context.Context) error {
+	log := clog.FromContext(ctx)
+
+	out := filepath.Join(b.WorkspaceDir, melangeOutputDirName)
+	add := func(name string, scriptlets **config.Scriptlets) error {
+		dirs, err := kernelModuleDirs(filepath.Join(out, name))
+		if err != nil {
+			return fmt.Errorf("looking for kernel modules in %s: %w", name, err)
+		}
+		if len(dirs) == 0 {
+			return nil
+		}
+		if *scriptlets != nil && (*scriptlets).Trigger.Script != "" {
+			log.Infof("%s installs kernel modules, but has a trigger already, not adding one to run depmod", name)
+			return nil
+		}
+
+		log.Infof("%s installs kernel modules, adding a trigger to run depmod", name)
+		if *scriptlets == nil {
+			*scriptlets = &config.Scriptlets{}
+		}
+		(*scriptlets).Trigger = config.Trigger{
+			Script: depmodTrigger,
+			Paths:  dirs,
+		}
+		return nil
+	}
+
+	if err := add(b.Configuration.Package.Name, &b.Configuration.Package.Scriptlets); err != nil {
+		return err
+	}
+	for i := range b.Configuration.Subpackages {
+		sp := &b.Configuration.Subpackages[i]
+		if err := add(sp.Name, &sp.Scriptlets); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// kernelModuleDirs returns the directories, as absolute paths in the
+// package, that the package whose contents are in dir installs kernel
+// modules to. It's fine for dir not to exist.
+func kernelModuleDirs(dir string) ([]string, error) {
+	var dirs []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == dir && errors.Is(err, fs.ErrNotExist) {
+				return fs.SkipAll
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		if kernelModuleRe.MatchString(filepath.ToSlash(rel)) {
+			dirs = append(dirs, "/"+filepath.ToSlash(filepath.Dir(rel)))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(dirs)
+	return slices.Compact(dirs), nil
+}
@@ -0,0 +1,67 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"chainguard.dev/melange/pkg/config"
+	"github.com/chainguard-dev/clog/slogtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddKernelModuleTriggers(t *testing.T) {
+	ctx := slogtest.Context(t)
+	dir := t.TempDir()
+	out := filepath.Join(dir, melangeOutputDirName)
+
+	own := &config.Scriptlets{Trigger: config.Trigger{Script: "#!/bin/sh\n", Paths: []string{"/lib/modules/*"}}}
+	b := &Build{
+		WorkspaceDir: dir,
+		Configuration: config.Configuration{
+			Package: config.Package{Name: "foo"},
+			Subpackages: []config.Subpackage{
+				{Name: "foo-doc"},
+				{Name: "foo-extra"},
+				{Name: "foo-own", Scriptlets: own},
+			},
+		},
+	}
+	write := func(name, path string) {
+		p := filepath.Join(out, name, path)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0o755))
+		require.NoError(t, os.WriteFile(p, nil, 0o644))
+	}
+	write("foo", "lib/modules/6.6.30/extra/foo.ko")
+	write("foo", "lib/modules/6.6.30/extra/bar.ko.zst")
+	write("foo", "usr/lib/modules/6.6.31/updates/foo.ko.xz")
+	write("foo", "usr/share/doc/foo/README.ko")
+	write("foo-extra", "lib/modules/6.6.30/extra/foo-extra.ko")
+	write("foo-own", "lib/modules/6.6.30/extra/foo-own.ko")
+
+	require.NoError(t, b.addKernelModuleTriggers(ctx))
+
+	pkg := b.Configuration.Package.Scriptlets
+	require.NotNil(t, pkg)
+	require.Equal(t, depmodTrigger, pkg.Trigger.Script)
+	require.Equal(t, []string{"/lib/modules/6.6.30/extra", "/usr/lib/modules/6.6.31/updates"}, pkg.Trigger.Paths)
+
+	require.Nil(t, b.Configuration.Subpackages[0].Scriptlets)
+	require.Equal(t, []string{"/lib/modules/6.6.30/extra"}, b.Configuration.Subpackages[1].Scriptlets.Trigger.Paths)
+	require.Equal(t, own, b.Configuration.Subpackages[2].Scriptlets)
+	require.Equal(t, "#!/bin/sh\n", own.Trigger.Script)
+}
@@ -0,0 +1,102 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"chainguard.dev/melange/pkg/container"
+	"github.com/chainguard-dev/clog"
+)
+
+// The directories in the guest that host directories can't be mounted at,
+// as melange mounts its own directories there.
+var reservedMountTargets = []string{
+	"/",
+	container.DefaultWorkspaceDir,
+	container.DefaultCacheDir,
+	container.DefaultSecretsDir,
+}
+
+// ParseMount parses a host directory to mount into the guest, given as
+// host=<path>,dest=<path>, with ro after them to mount it read-only.
+func ParseMount(s string) (container.BindMount, error) {
+	var m container.BindMount
+	for _, field := range strings.Split(s, ",") {
+		k, v, _ := strings.Cut(field, "=")
+		switch {
+		case k == "host":
+			m.Source = v
+		case k == "dest":
+			m.Destination = v
+		case field == "ro":
+			m.ReadOnly = true
+		default:
+			return m, fmt.Errorf("mount %q must be of the form host=<path>,dest=<path>[,ro]", s)
+		}
+	}
+	if m.Source == "" || m.Destination == "" {
+		return m, fmt.Errorf("mount %q must be of the form host=<path>,dest=<path>[,ro]", s)
+	}
+
+	if !path.IsAbs(m.Destination) || path.Clean(m.Destination) != m.Destination {
+		return m, fmt.Errorf("mount %q: dest must be a clean absolute path", s)
+	}
+	out := path.Join(container.DefaultWorkspaceDir, melangeOutputDirName)
+	for _, r := range append(reservedMountTargets, out) {
+		if m.Destination == r {
+			return m, fmt.Errorf("mount %q: dest can't be %s", s, r)
+		}
+	}
+	if strings.HasPrefix(m.Destination, out+"/") {
+		return m, fmt.Errorf("mount %q: dest can't be in %s, or it would be packaged", s, out)
+	}
+
+	src, err := filepath.Abs(m.Source)
+	if err != nil {
+		return m, err
+	}
+	m.Source = src
+	return m, nil
+}
+
+// prepareMounts checks that the host directories to mount into the guest
+// are there, and that the runner can mount them.
+func (b *Build) prepareMounts(ctx context.Context) error {
+	log := clog.FromContext(ctx)
+
+	if len(b.Mounts) == 0 {
+		return nil
+	}
+	if b.Runner.Name() == container.QemuName {
+		return fmt.Errorf("the %s runner can't mount host directories into the guest", b.Runner.Name())
+	}
+	for _, m := range b.Mounts {
+		if _, err := os.Stat(m.Source); err != nil {
+			return fmt.Errorf("mounting %s: %w", m.Source, err)
+		}
+		mode := "read-write"
+		if m.ReadOnly {
+			mode = "read-only"
+		}
+		log.Infof("mounting %s at %s, %s", m.Source, m.Destination, mode)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"chainguard.dev/melange/pkg/container"
+)
+
+func TestParseMount(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for s, want := range map[string]container.BindMount{
+		"host=/srv/data,dest=/work/data":      {Source: "/srv/data", Destination: "/work/data"},
+		"dest=/etc/ssl/ca.pem,host=ca.pem,ro": {Source: filepath.Join(wd, "ca.pem"), Destination: "/etc/ssl/ca.pem", ReadOnly: true},
+		"host=/srv/src,dest=/home/build/src":  {Source: "/srv/src", Destination: "/home/build/src"},
+	} {
+		got, err := ParseMount(s)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", s, err)
+		} else if got != want {
+			t.Errorf("%s: got %+v, want %+v", s, got, want)
+		}
+	}
+
+	for _, s := range []string{
+		"/srv/data:/work/data",
+		"host=/srv/data",
+		"dest=/work/data",
+		"host=/srv/data,dest=/work/data,rw",
+		"host=/srv/data,dest=/work/data,ro=false",
+		"host=/srv/data,dest=work/data",
+		"host=/srv/data,dest=/work/../data",
+		"host=/srv/data,dest=/",
+		"host=/srv/data,dest=/home/build",
+		"host=/srv/data,dest=/var/cache/melange",
+		"host=/srv/data,dest=/home/build/melange-out/foo",
+	} {
+		if _, err := ParseMount(s); err == nil {
+			t.Errorf("%s: expected error", s)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"chainguard.dev/melange/pkg/container"
+	"github.com/chainguard-dev/clog"
+)
+
+// namedCacheDir is where the caches that packages declare are kept in the
+// cache directory, by the name of the package and then of the cache.
+const namedCacheDir = "caches"
+
+// namedCachePath returns the directory on the host that the package's cache
+// name is kept in.
+func (b *Build) namedCachePath(name string) string {
+	return filepath.Join(b.CacheDir, namedCacheDir, b.Configuration.Package.Name, name)
+}
+
+// prepareNamedCaches makes the directories of the caches that the package
+// declares, so that they're there to be mounted into the guest.
+func (b *Build) prepareNamedCaches(ctx context.Context) error {
+	log := clog.FromContext(ctx)
+
+	caches := b.Configuration.Caches
+	if len(caches) == 0 {
+		return nil
+	}
+	if b.CacheDir == "" {
+		return fmt.Errorf("the package's caches need a cache directory")
+	}
+	if b.Runner.Name() == container.QemuName {
+		log.Warnf("the %s runner doesn't mount the package's caches, so they only last for this build", b.Runner.Name())
+	}
+	for _, c := range caches {
+		if err := os.MkdirAll(b.namedCachePath(c.Name), 0o755); err != nil {
+			return fmt.Errorf("creating %s cache: %w", c.Name, err)
+		}
+		log.Infof("mounting %s cache %s at %s", c.Name, b.namedCachePath(c.Name), c.Target)
+	}
+	return nil
+}
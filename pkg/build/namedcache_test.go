@@ -0,0 +1,65 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"chainguard.dev/melange/pkg/config"
+	"chainguard.dev/melange/pkg/container"
+	"github.com/chainguard-dev/clog/slogtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNamedCaches(t *testing.T) {
+	ctx := slogtest.Context(t)
+	cacheDir := t.TempDir()
+
+	b := &Build{
+		Configuration: config.Configuration{
+			Package:  config.Package{Name: "foo", Version: "1.0"},
+			Pipeline: []config.Pipeline{{Runs: "bazel build //..."}},
+			Caches: []config.Cache{
+				{Name: "bazel", Target: "/root/.cache/bazel"},
+				{Name: "gradle", Target: "/root/.gradle"},
+			},
+		},
+		CacheDir:     cacheDir,
+		WorkspaceDir: t.TempDir(),
+		Runner:       container.BubblewrapRunner(true),
+	}
+	require.NoError(t, b.prepareNamedCaches(ctx))
+
+	var mounts []container.BindMount
+	for _, m := range b.buildWorkspaceConfig(ctx).Mounts {
+		if m.Destination == "/root/.cache/bazel" || m.Destination == "/root/.gradle" {
+			mounts = append(mounts, m)
+		}
+	}
+	require.Equal(t, []container.BindMount{
+		{Source: filepath.Join(cacheDir, "caches", "foo", "bazel"), Destination: "/root/.cache/bazel"},
+		{Source: filepath.Join(cacheDir, "caches", "foo", "gradle"), Destination: "/root/.gradle"},
+	}, mounts)
+	for _, m := range mounts {
+		fi, err := os.Stat(m.Source)
+		require.NoError(t, err)
+		require.True(t, fi.IsDir())
+	}
+
+	b.CacheDir = ""
+	require.ErrorContains(t, b.prepareNamedCaches(ctx), "need a cache directory")
+}
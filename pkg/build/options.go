@@ -17,11 +17,16 @@ package build
 import (
 	"fmt"
 	"os"
+	"slices"
 	"time"
 
 	apko_types "chainguard.dev/apko/pkg/build/types"
 	"chainguard.dev/apko/pkg/options"
+	"chainguard.dev/melange/pkg/config"
 	"chainguard.dev/melange/pkg/container"
+	"chainguard.dev/melange/pkg/linter"
+	"chainguard.dev/melange/pkg/sbom"
+	"github.com/dustin/go-humanize"
 )
 
 type Option func(*Build) error
@@ -71,6 +76,41 @@ func WithLintWarn(linters []string) Option {
 	}
 }
 
+// WithLintPlugins sets the external programs to run as linters, keyed by
+// linter name.
+func WithLintPlugins(plugins map[string]string) Option {
+	return func(b *Build) error {
+		b.LintPlugins = plugins
+		return nil
+	}
+}
+
+// WithLintFix lets linters fix the problems they can in the package, rather
+// than fail.
+func WithLintFix(fix bool) Option {
+	return func(b *Build) error {
+		b.LintFix = fix
+		return nil
+	}
+}
+
+// WithLintBaseline sets the known linter findings, which are reported as
+// warnings rather than failing the build.
+func WithLintBaseline(baseline *linter.Baseline) Option {
+	return func(b *Build) error {
+		b.LintBaseline = baseline
+		return nil
+	}
+}
+
+// WithLintReport sets the report that linter findings are recorded in.
+func WithLintReport(report *linter.Report) Option {
+	return func(b *Build) error {
+		b.LintReport = report
+		return nil
+	}
+}
+
 // WithBuildDate sets the timestamps for the build context.
 // The string is parsed according to RFC3339.
 // An empty string is a special case and will default to
@@ -136,6 +176,234 @@ func WithPipelineDir(pipelineDir string) Option {
 	}
 }
 
+// WithHermetic sets whether to cut the build off from the network once it has
+// fetched its sources.
+func WithHermetic(hermetic bool) Option {
+	return func(b *Build) error {
+		b.Hermetic = hermetic
+		return nil
+	}
+}
+
+// WithEgressAllow adds hosts that the build can connect to, to those in the
+// configuration's egress allowlist, and limits the build to them even if the
+// configuration doesn't have one.
+func WithEgressAllow(allow []string) Option {
+	return func(b *Build) error {
+		b.EgressAllow = allow
+		return nil
+	}
+}
+
+// WithEgressReport sets where to write a report of the hosts that a build
+// with an egress allowlist tried to connect to.
+func WithEgressReport(path string) Option {
+	return func(b *Build) error {
+		b.EgressReport = path
+		return nil
+	}
+}
+
+// WithResume sets whether to snapshot the workspace after each top-level
+// step of the main pipeline, and resume from the last snapshot of a build
+// that failed.
+func WithResume(resume bool) Option {
+	return func(b *Build) error {
+		b.Resume = resume
+		return nil
+	}
+}
+
+// WithStepLogs sets whether to write the output of each step to files of
+// its own, under the output directory.
+func WithStepLogs(stepLogs bool) Option {
+	return func(b *Build) error {
+		b.StepLogs = stepLogs
+		return nil
+	}
+}
+
+// WithEventsFile sets where to append the build's event stream, or - for
+// stdout.
+func WithEventsFile(path string) Option {
+	return func(b *Build) error {
+		b.EventsFile = path
+		return nil
+	}
+}
+
+// WithEventHandler sets a function to call with each event of the build,
+// from whichever goroutine the event happens in.
+func WithEventHandler(handler func(Event)) Option {
+	return func(b *Build) error {
+		b.EventHandler = handler
+		return nil
+	}
+}
+
+// WithBreakpoints sets the names, ids or uses of the steps to pause the build
+// before and after, with a shell in the guest.
+func WithBreakpoints(before, after []string) Option {
+	return func(b *Build) error {
+		b.BreakBefore = before
+		b.BreakAfter = after
+		return nil
+	}
+}
+
+// WithCompilerCache sets the compiler cache to use, ccache or sccache, which
+// keeps its cache in the cache directory.
+func WithCompilerCache(tool string) Option {
+	return func(b *Build) error {
+		switch tool {
+		case "", CompilerCacheCcache, CompilerCacheSccache:
+		default:
+			return fmt.Errorf("unknown compiler cache %q, must be %s or %s", tool, CompilerCacheCcache, CompilerCacheSccache)
+		}
+		b.CompilerCache = tool
+		return nil
+	}
+}
+
+// WithCacheToolchains sets whether to cache the downloads and build outputs
+// of the Go and Rust toolchains in the cache directory, and how big the
+// caches can grow, such as 20GB, before the least recently used are removed.
+func WithCacheToolchains(cache bool, size string) Option {
+	return func(b *Build) error {
+		if size != "" {
+			if _, err := humanize.ParseBytes(size); err != nil {
+				return fmt.Errorf("invalid toolchain cache size %q: %w", size, err)
+			}
+		}
+		b.CacheToolchains = cache
+		b.ToolchainCacheSize = size
+		return nil
+	}
+}
+
+// WithCacheGuest sets whether to cache the guest environments that builds
+// lay out, so that a build whose environment resolves to the same packages
+// as an earlier one's doesn't install them again, and how big the cache can
+// grow, such as 10GB, before the least recently used are removed.
+func WithCacheGuest(cache bool, size string) Option {
+	return func(b *Build) error {
+		if size != "" {
+			if _, err := humanize.ParseBytes(size); err != nil {
+				return fmt.Errorf("invalid guest cache size %q: %w", size, err)
+			}
+		}
+		b.CacheGuest = cache
+		b.GuestCacheSize = size
+		return nil
+	}
+}
+
+// WithWorkspaceUsage sets whether to report how much each step grows the
+// workspace by, and how much disk the workspace can use, such as 20GB,
+// before the step that takes it over fails, if it has a quota.
+func WithWorkspaceUsage(report bool, quota string) Option {
+	return func(b *Build) error {
+		if quota != "" {
+			if _, err := humanize.ParseBytes(quota); err != nil {
+				return fmt.Errorf("invalid workspace quota %q: %w", quota, err)
+			}
+		}
+		b.WorkspaceUsage = report
+		b.WorkspaceQuota = quota
+		return nil
+	}
+}
+
+// WithMounts sets the host directories to mount into the guest.
+func WithMounts(mounts []container.BindMount) Option {
+	return func(b *Build) error {
+		b.Mounts = mounts
+		return nil
+	}
+}
+
+// WithDefaultSplits sets the kinds of subpackages, such as dev and doc, to
+// split from every package that doesn't opt out of them.
+func WithDefaultSplits(kinds []string) Option {
+	return func(b *Build) error {
+		for _, kind := range kinds {
+			if !slices.Contains(config.DefaultSplitKinds(), kind) {
+				return fmt.Errorf("unknown default split %q, must be one of %q", kind, config.DefaultSplitKinds())
+			}
+		}
+		b.DefaultSplits = kinds
+		return nil
+	}
+}
+
+// WithSBOMFormats sets the formats, out of sbom.Formats, that the SBOMs of
+// the packages are written in. Without any, they're written in SPDX.
+func WithSBOMFormats(formats []string) Option {
+	return func(b *Build) error {
+		if len(formats) == 0 {
+			return nil
+		}
+		var unique []string
+		for _, format := range formats {
+			if !slices.Contains(sbom.Formats(), format) {
+				return fmt.Errorf("unknown SBOM format %q, must be one of %q", format, sbom.Formats())
+			}
+			if !slices.Contains(unique, format) {
+				unique = append(unique, format)
+			}
+		}
+		b.SBOMFormats = unique
+		return nil
+	}
+}
+
+// WithStrict sets whether to reject misindented keys of the configuration,
+// which have no value but are followed by other keys, and unknown fields in
+// the pipelines it uses.
+func WithStrict(strict bool) Option {
+	return func(b *Build) error {
+		b.Strict = strict
+		return nil
+	}
+}
+
+// WithAllowedEnv sets the environment variables that ${{env.*}} can
+// substitute the values of in the configuration, such as a build number
+// that CI sets.
+func WithAllowedEnv(names []string) Option {
+	return func(b *Build) error {
+		b.AllowedEnv = names
+		return nil
+	}
+}
+
+// WithHooks sets the commands to run on the host before and after the
+// build, with the build's metadata in their environment.
+func WithHooks(preBuild, postBuild []string) Option {
+	return func(b *Build) error {
+		b.PreBuildHooks = preBuild
+		b.PostBuildHooks = postBuild
+		return nil
+	}
+}
+
+// WithSecrets sets the secrets to make available to the build's steps.
+func WithSecrets(secrets []Secret) Option {
+	return func(b *Build) error {
+		b.Secrets = secrets
+		return nil
+	}
+}
+
+// WithRemotePipelineCacheDir sets the directory to cache pipelines from git
+// repositories in.
+func WithRemotePipelineCacheDir(dir string) Option {
+	return func(b *Build) error {
+		b.RemotePipelineCacheDir = dir
+		return nil
+	}
+}
+
 // WithSourceDir sets the source directory to use.
 func WithSourceDir(sourceDir string) Option {
 	return func(b *Build) error {
@@ -264,6 +532,15 @@ func WithVarsFile(varsFile string) Option {
 	}
 }
 
+// WithMatrix sets the combination of matrix values to build, for
+// configurations that have a matrix.
+func WithMatrix(combo map[string]string) Option {
+	return func(b *Build) error {
+		b.Matrix = combo
+		return nil
+	}
+}
+
 // WithNamespace takes a string to be used as the namespace in PackageURLs
 // identifying the built apk in the generated SBOM. If no namespace is provided
 // "unknown" will be listed as namespace.
@@ -77,6 +77,7 @@ type PackageBuild struct {
 	Description   string
 	URL           string
 	Commit        string
+	Copyright     []config.Copyright
 }
 
 func pkgFromSub(sub *config.Subpackage) *config.Package {
@@ -88,11 +89,19 @@ func pkgFromSub(sub *config.Subpackage) *config.Package {
 		Description:  sub.Description,
 		URL:          sub.URL,
 		Commit:       sub.Commit,
+		Copyright:    sub.Copyright,
 	}
 }
 
 func (b *Build) Emit(ctx context.Context, pkg *config.Package) error {
-	pc := PackageBuild{
+	_, err := b.emit(ctx, pkg)
+	return err
+}
+
+// emit is Emit, but also returns the package that was built, whose
+// dependencies include the generated ones.
+func (b *Build) emit(ctx context.Context, pkg *config.Package) (*PackageBuild, error) {
+	pc := &PackageBuild{
 		Build:        b,
 		Origin:       &b.Configuration.Package,
 		PackageName:  pkg.Name,
@@ -105,13 +114,18 @@ func (b *Build) Emit(ctx context.Context, pkg *config.Package) error {
 		Description:  pkg.Description,
 		URL:          pkg.URL,
 		Commit:       pkg.Commit,
+		Copyright:    pkg.Copyright,
+	}
+	if len(pc.Copyright) == 0 {
+		// Subpackages without their own are under the package's.
+		pc.Copyright = pc.Origin.Copyright
 	}
 
 	if !b.StripOriginName {
 		pc.OriginName = pc.Origin.Name
 	}
 
-	return pc.EmitPackage(ctx)
+	return pc, pc.EmitPackage(ctx)
 }
 
 // AppendBuildLog will create or append a list of packages that were built by melange build
@@ -156,7 +170,7 @@ commit = {{.Commit}}
 {{- if ne .Build.SourceDateEpoch.Unix 0 }}
 builddate = {{ .Build.SourceDateEpoch.Unix }}
 {{- end}}
-{{- range $copyright := .Origin.Copyright }}
+{{- range $copyright := .Copyright }}
 license = {{ $copyright.License }}
 {{- end }}
 {{- range $dep := .Dependencies.Runtime }}
@@ -177,6 +191,9 @@ provider_priority = {{ .Dependencies.ProviderPriority }}
 {{- if .Dependencies.ReplacesPriority }}
 replaces_priority = {{ .Dependencies.ReplacesPriority }}
 {{- end }}
+{{- if .Dependencies.InstallIf }}
+install_if = {{ range $i, $dep := .Dependencies.InstallIf }}{{ if $i }} {{ end }}{{ $dep }}{{ end }}
+{{- end }}
 {{- if .Scriptlets}}{{ if .Scriptlets.Trigger.Paths }}
 triggers = {{ range $item := .Scriptlets.Trigger.Paths }}{{ $item }} {{ end }}
 {{- end }}{{ end }}
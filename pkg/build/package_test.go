@@ -119,6 +119,69 @@ url = https://chainguard.dev
 commit = deadbeef
 builddate = 12345678
 datahash = baadf00d
+`,
+	}, {
+		name: "install if",
+		pb: &PackageBuild{
+			Build: &Build{
+				SourceDateEpoch: time.Unix(0, 0),
+			},
+			Origin:        pkg,
+			PackageName:   "foo-bash-completion",
+			Arch:          "aarch64",
+			InstalledSize: 666,
+			OriginName:    "foo",
+			Description:   "I'm a unit test",
+			URL:           "https://chainguard.dev",
+			Commit:        "deadbeef",
+			Dependencies: config.Dependencies{
+				Runtime:   []string{"bash-completion"},
+				InstallIf: []string{"foo=1.2.3-r4", "bash-completion"},
+			},
+			DataHash: "baadf00d",
+		},
+		want: `# Generated by melange
+pkgname = foo-bash-completion
+pkgver = 1.2.3-r4
+arch = aarch64
+size = 666
+origin = foo
+pkgdesc = I'm a unit test
+url = https://chainguard.dev
+commit = deadbeef
+depend = bash-completion
+install_if = foo=1.2.3-r4 bash-completion
+datahash = baadf00d
+`,
+	}, {
+		name: "license",
+		pb: &PackageBuild{
+			Build: &Build{
+				SourceDateEpoch: time.Unix(0, 0),
+			},
+			Origin:        pkg,
+			PackageName:   "foo-doc",
+			Arch:          "aarch64",
+			InstalledSize: 666,
+			OriginName:    "foo",
+			Description:   "I'm a unit test",
+			URL:           "https://chainguard.dev",
+			Commit:        "deadbeef",
+			Copyright:     []config.Copyright{{License: "CC-BY-4.0"}, {License: "GFDL-1.3-or-later"}},
+			DataHash:      "baadf00d",
+		},
+		want: `# Generated by melange
+pkgname = foo-doc
+pkgver = 1.2.3-r4
+arch = aarch64
+size = 666
+origin = foo
+pkgdesc = I'm a unit test
+url = https://chainguard.dev
+commit = deadbeef
+license = CC-BY-4.0
+license = GFDL-1.3-or-later
+datahash = baadf00d
 `,
 	}}
 
@@ -0,0 +1,141 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+
+	"chainguard.dev/melange/pkg/config"
+	"chainguard.dev/melange/pkg/sbom"
+	"github.com/chainguard-dev/clog"
+)
+
+// patchSBOMPackages returns a package for each patch that a patch/apply step
+// applies, with its hash, so that the SBOM records how the upstream source
+// was changed. The patches are read from the source directory in fsys, and
+// are taken to be under license, like the package. Patches that aren't there,
+// such as ones that come with the upstream source, can't be hashed before the
+// build, and are left out.
+func patchSBOMPackages(ctx context.Context, fsys fs.FS, p config.Pipeline, license, supplier, uniqueID string) ([]*sbom.Package, error) {
+	log := clog.FromContext(ctx)
+
+	workdir := "/home/build"
+	if p.WorkDir != "" {
+		workdir = path.Join(workdir, p.WorkDir)
+		if path.IsAbs(p.WorkDir) {
+			workdir = p.WorkDir
+		}
+	}
+
+	var patches []string
+	switch {
+	case p.With["series"] != "":
+		series, ok := sourcePath(workdir, p.With["series"])
+		var data []byte
+		if ok {
+			data, ok = readSourceFile(fsys, series)
+		}
+		if !ok {
+			log.Infof("patch series %s isn't in the source directory, so its patches aren't recorded in the SBOM", p.With["series"])
+			return nil, nil
+		}
+		s := bufio.NewScanner(bytes.NewReader(data))
+		for s.Scan() {
+			fields := strings.Fields(s.Text())
+			if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+				continue
+			}
+			patches = append(patches, path.Join(path.Dir(series), fields[0]))
+		}
+		if err := s.Err(); err != nil {
+			return nil, fmt.Errorf("reading patch series %s: %w", series, err)
+		}
+
+	case p.With["directory"] != "":
+		dir, ok := sourcePath(workdir, p.With["directory"])
+		var entries []fs.DirEntry
+		if ok {
+			var err error
+			entries, err = fs.ReadDir(fsys, dir)
+			ok = err == nil
+		}
+		if !ok {
+			log.Infof("patch directory %s isn't in the source directory, so its patches aren't recorded in the SBOM", p.With["directory"])
+			return nil, nil
+		}
+		for _, e := range entries {
+			if e.Type().IsRegular() && (strings.HasSuffix(e.Name(), ".patch") || strings.HasSuffix(e.Name(), ".diff")) {
+				patches = append(patches, path.Join(dir, e.Name()))
+			}
+		}
+
+	default:
+		for _, f := range strings.Fields(p.With["patches"]) {
+			patch, ok := sourcePath(workdir, f)
+			if !ok {
+				log.Infof("patch %s isn't in the source directory, so it isn't recorded in the SBOM", f)
+				continue
+			}
+			patches = append(patches, patch)
+		}
+	}
+
+	var pkgs []*sbom.Package
+	for i, patch := range patches {
+		data, ok := readSourceFile(fsys, patch)
+		if !ok {
+			log.Infof("patch %s isn't in the source directory, so it isn't recorded in the SBOM", patch)
+			continue
+		}
+		pkgs = append(pkgs, &sbom.Package{
+			IDComponents:    []string{"patch", uniqueID, strconv.Itoa(i)},
+			Name:            patch,
+			LicenseDeclared: license,
+			Namespace:       supplier,
+			Checksums:       map[string]string{"SHA256": fmt.Sprintf("%x", sha256.Sum256(data))},
+		})
+	}
+	return pkgs, nil
+}
+
+// sourcePath returns the path relative to the source directory of p, a path
+// in the guest relative to workdir, and reports whether p is in the source
+// directory at all. The workspace is populated from the source directory,
+// so this is where the file came from if it existed before the build.
+func sourcePath(workdir, p string) (string, bool) {
+	if !path.IsAbs(p) {
+		p = path.Join(workdir, p)
+	}
+	rel, ok := strings.CutPrefix(path.Clean(p), "/home/build/")
+	if !ok || strings.HasPrefix(rel, "melange-out/") {
+		return "", false
+	}
+	return rel, true
+}
+
+// readSourceFile returns the contents of the file at p in fsys, and reports
+// whether it could be read.
+func readSourceFile(fsys fs.FS, p string) ([]byte, bool) {
+	data, err := fs.ReadFile(fsys, p)
+	return data, err == nil
+}
@@ -0,0 +1,79 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+	"testing/fstest"
+
+	"chainguard.dev/melange/pkg/config"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPatchSBOMPackages(t *testing.T) {
+	fsys := fstest.MapFS{
+		"patches/series":         {Data: []byte("# fixes\n01-fix.patch\n\n02-cve.patch -p0\n")},
+		"patches/01-fix.patch":   {Data: []byte("fix")},
+		"patches/02-cve.patch":   {Data: []byte("cve")},
+		"patches/README":         {Data: []byte("not a patch")},
+		"subdir/03-extra.diff":   {Data: []byte("extra")},
+		"subdir/patches/04.diff": {Data: []byte("four")},
+	}
+	hash := func(s string) string {
+		return fmt.Sprintf("%x", sha256.Sum256([]byte(s)))
+	}
+
+	for _, c := range []struct {
+		name    string
+		workdir string
+		with    map[string]string
+		want    map[string]string
+	}{{
+		name: "series",
+		with: map[string]string{"series": "patches/series"},
+		want: map[string]string{"patches/01-fix.patch": hash("fix"), "patches/02-cve.patch": hash("cve")},
+	}, {
+		name: "directory",
+		with: map[string]string{"directory": "/home/build/patches"},
+		want: map[string]string{"patches/01-fix.patch": hash("fix"), "patches/02-cve.patch": hash("cve")},
+	}, {
+		name:    "patches in a working directory",
+		workdir: "subdir",
+		with:    map[string]string{"patches": "03-extra.diff\npatches/04.diff upstream/05.diff"},
+		want:    map[string]string{"subdir/03-extra.diff": hash("extra"), "subdir/patches/04.diff": hash("four")},
+	}, {
+		name: "series from upstream",
+		with: map[string]string{"series": "debian/patches/series"},
+		want: map[string]string{},
+	}} {
+		t.Run(c.name, func(t *testing.T) {
+			p := config.Pipeline{Uses: "patch/apply", WorkDir: c.workdir, With: c.with}
+			pkgs, err := patchSBOMPackages(context.Background(), fsys, p, "MIT", "wolfi", "0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := map[string]string{}
+			for _, pkg := range pkgs {
+				got[pkg.Name] = pkg.Checksums["SHA256"]
+			}
+			if diff := cmp.Diff(c.want, got); diff != "" {
+				t.Errorf("unexpected patches (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -15,6 +15,7 @@
 package build
 
 import (
+	"cmp"
 	"context"
 	"embed"
 	"fmt"
@@ -23,8 +24,10 @@ import (
 	"os/signal"
 	"path"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	apko_types "chainguard.dev/apko/pkg/build/types"
 	"chainguard.dev/melange/pkg/cond"
@@ -32,6 +35,7 @@ import (
 	"chainguard.dev/melange/pkg/container"
 	"chainguard.dev/melange/pkg/util"
 	"github.com/chainguard-dev/clog"
+	"go.opentelemetry.io/otel"
 )
 
 func (sm *SubstitutionMap) MutateWith(with map[string]string) (map[string]string, error) {
@@ -149,6 +153,57 @@ func validateWith(data map[string]string, inputs map[string]config.Input) (map[s
 	return data, nil
 }
 
+// validateInput checks that value is valid for input. Empty values are
+// valid, as they are how inputs that aren't required are left unset.
+func validateInput(input config.Input, value string) error {
+	switch input.Type {
+	case "", "string":
+	case "bool":
+		if value != "" && value != "true" && value != "false" {
+			return fmt.Errorf("%q is not a bool: must be true or false", value)
+		}
+	case "int":
+		if _, err := strconv.Atoi(value); value != "" && err != nil {
+			return fmt.Errorf("%q is not an int", value)
+		}
+	case "enum":
+		if len(input.Values) == 0 {
+			return fmt.Errorf("enum input has no values")
+		}
+		if value != "" && !slices.Contains(input.Values, value) {
+			return fmt.Errorf("%q is not one of %s", value, strings.Join(input.Values, ", "))
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown input type %q", input.Type)
+	}
+
+	if len(input.Values) != 0 {
+		return fmt.Errorf("only enum inputs can have values")
+	}
+	return nil
+}
+
+// Where step outputs are kept in the guest, in a file per step.
+var stepOutputsDir = "/tmp/melange-outputs"
+
+// loadStepOutputs is run before steps that set or use step outputs. It
+// exports the outputs that steps wrote to $MELANGE_OUTPUT, as name=value
+// lines, as the variables that ${{steps.<id>.outputs.<name>}} is substituted
+// with. Later lines override earlier ones.
+func loadStepOutputs() string {
+	return fmt.Sprintf(`mkdir -p '%s'
+for f in '%s'/*; do
+  [ -f "$f" ] || continue
+  while IFS='=' read -r k v || [ -n "$k" ]; do
+    if [ -n "$k" ]; then
+      export "%s=$v"
+    fi
+  done < "$f"
+done
+`, stepOutputsDir, stepOutputsDir, util.StepOutputVariable(`${f##*/}`, "$k"))
+}
+
 // Build a script to run as part of evalRun
 func buildEvalRunCommand(pipeline *config.Pipeline, debugOption rune, workdir string, fragment string) []string {
 	script := fmt.Sprintf(`set -e%c
@@ -164,15 +219,62 @@ type pipelineRunner struct {
 	interactive bool
 	config      *container.Config
 	runner      container.Runner
+
+	// Run before every step to export the secrets that steps get as
+	// environment variables.
+	secretEnv string
+
+	// The names, ids or uses of the steps to pause the build before and
+	// after.
+	breakBefore, breakAfter []string
+
+	// Writes the logs and events of the steps, if the build has either.
+	recorder *stepRecorder
+
+	// Tracks how much disk the steps use, if the build does.
+	disk *diskTracker
 }
 
-func (r *pipelineRunner) runPipeline(ctx context.Context, pipeline *config.Pipeline) (bool, error) {
-	log := clog.FromContext(ctx)
+// breaksAt reports whether pipeline is one of steps, by its name, id or
+// uses.
+func breaksAt(pipeline *config.Pipeline, steps []string) bool {
+	return slices.ContainsFunc(steps, func(s string) bool {
+		return s != "" && (s == pipeline.Name || s == pipeline.ID || s == pipeline.Uses)
+	})
+}
 
+func (r *pipelineRunner) runPipeline(ctx context.Context, pipeline *config.Pipeline) (bool, error) {
 	if result, err := shouldRun(pipeline.If); !result {
 		return result, err
 	}
 
+	ctx, span := otel.Tracer("melange").Start(ctx, cmp.Or(describe(pipeline, identity(pipeline)), "step"))
+	if pipeline.Uses != "" {
+		span.SetAttributes(attrStepUses.String(pipeline.Uses))
+	}
+	if pipeline.ID != "" {
+		span.SetAttributes(attrStepID.String(pipeline.ID))
+	}
+
+	var err error
+	if r.recorder == nil {
+		err = r.runStep(ctx, pipeline)
+	} else {
+		var finish func(error)
+		if ctx, finish, err = r.recorder.start(ctx, pipeline); err == nil {
+			err = r.runStep(ctx, pipeline)
+			finish(err)
+		}
+	}
+	endSpan(span, err)
+	return err == nil, err
+}
+
+// runStep runs pipeline, which is known to need running, and the steps
+// nested in it.
+func (r *pipelineRunner) runStep(ctx context.Context, pipeline *config.Pipeline) error {
+	log := clog.FromContext(ctx)
+
 	debugOption := ' '
 	if r.debug {
 		debugOption = 'x'
@@ -200,10 +302,25 @@ func (r *pipelineRunner) runPipeline(ctx context.Context, pipeline *config.Pipel
 		defer stop()
 	}
 
-	if id := identity(pipeline); id != unidentifiablePipeline {
+	id := identity(pipeline)
+	if id != unidentifiablePipeline {
 		log.Infof("running step %q", id)
 	}
 
+	// Breakpoints don't count towards the step's timeout.
+	breakCtx := ctx
+	if (pipeline.Breakpoint && r.interactive) || breaksAt(pipeline, r.breakBefore) {
+		if err := r.breakpoint(breakCtx, "before", pipeline, id, envOverride, workdir); err != nil {
+			return err
+		}
+	}
+
+	if to := pipeline.Timeout; to > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, to, fmt.Errorf("step exceeded its timeout of %s", to))
+		defer cancel()
+	}
+
 	slogs := []any{}
 	if pipeline.Name != "" {
 		slogs = append(slogs, "name", pipeline.Name)
@@ -215,18 +332,40 @@ func (r *pipelineRunner) runPipeline(ctx context.Context, pipeline *config.Pipel
 		ctx = clog.WithLogger(ctx, log.With(slogs...))
 	}
 
-	command := buildEvalRunCommand(pipeline, debugOption, workdir, pipeline.Runs)
-	if err := r.runner.Run(ctx, r.config, envOverride, command...); err != nil {
+	fragment := pipeline.Runs
+	if pipeline.ID != "" || strings.Contains(fragment, "${"+util.StepOutputPrefix) {
+		fragment = loadStepOutputs() + fragment
+	}
+	if fragment != "" {
+		fragment = r.secretEnv + fragment
+	}
+	if pipeline.ID != "" {
+		envOverride["MELANGE_OUTPUT"] = path.Join(stepOutputsDir, pipeline.ID)
+	}
+
+	command := buildEvalRunCommand(pipeline, debugOption, workdir, fragment)
+	runCtx, ranStep := r.disk.watch(ctx, describe(pipeline, id), pipeline.Runs != "")
+	err := r.run(runCtx, pipeline.Retries, envOverride, command)
+	if qerr := ranStep(); qerr != nil {
+		return fmt.Errorf("step %q: %w", describe(pipeline, id), qerr)
+	}
+	if err != nil {
+		// Say which step was running when the step or the whole build timed out.
+		if ctx.Err() != nil {
+			return fmt.Errorf("step %q: %w", describe(pipeline, id), context.Cause(ctx))
+		}
 		if err := r.maybeDebug(ctx, pipeline.Runs, envOverride, command, workdir, err); err != nil {
-			return false, err
+			return err
 		}
 	}
 
 	steps := 0
 
+	// Nested pipelines retry by themselves, with the retries they inherit
+	// unless they set their own, so their failures aren't retried here.
 	for _, p := range pipeline.Pipeline {
 		if ran, err := r.runPipeline(ctx, &p); err != nil {
-			return false, fmt.Errorf("unable to run pipeline: %w", err)
+			return fmt.Errorf("unable to run pipeline: %w", err)
 		} else if ran {
 			steps++
 		}
@@ -234,11 +373,52 @@ func (r *pipelineRunner) runPipeline(ctx context.Context, pipeline *config.Pipel
 
 	if assert := pipeline.Assertions; assert != nil {
 		if want := assert.RequiredSteps; want != steps {
-			return false, fmt.Errorf("pipeline did not run the required %d steps, only %d", want, steps)
+			return fmt.Errorf("pipeline did not run the required %d steps, only %d", want, steps)
+		}
+	}
+
+	if breaksAt(pipeline, r.breakAfter) {
+		if err := r.breakpoint(breakCtx, "after", pipeline, id, envOverride, workdir); err != nil {
+			return err
 		}
 	}
 
-	return true, nil
+	return nil
+}
+
+// describe returns id, or the first line of the script of a pipeline that
+// has no name.
+func describe(pipeline *config.Pipeline, id string) string {
+	if id != unidentifiablePipeline {
+		return id
+	}
+	line, _, _ := strings.Cut(strings.TrimSpace(pipeline.Runs), "\n")
+	return line
+}
+
+// How long to wait before the first retry of a pipeline, if it doesn't say.
+const defaultRetryBackoff = 5 * time.Second
+
+// run runs command, retrying it with exponential backoff as configured by retries.
+func (r *pipelineRunner) run(ctx context.Context, retries *config.Retries, envOverride map[string]string, command []string) error {
+	err := r.runner.Run(ctx, r.config, envOverride, command...)
+	if retries == nil {
+		return err
+	}
+
+	log := clog.FromContext(ctx)
+	backoff := cmp.Or(retries.Backoff, defaultRetryBackoff)
+	for attempt := 1; err != nil && attempt <= retries.Count; attempt++ {
+		log.Warnf("step failed: %v; retrying in %s (%d/%d)", err, backoff, attempt, retries.Count)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		err = r.runner.Run(ctx, r.config, envOverride, command...)
+		backoff *= 2
+	}
+	return err
 }
 
 func (r *pipelineRunner) maybeDebug(ctx context.Context, fragment string, envOverride map[string]string, cmd []string, workdir string, runErr error) error {
@@ -248,12 +428,47 @@ func (r *pipelineRunner) maybeDebug(ctx context.Context, fragment string, envOve
 
 	log := clog.FromContext(ctx)
 
-	dbg, ok := r.runner.(container.Debugger)
-	if !ok {
+	if _, ok := r.runner.(container.Debugger); !ok {
 		log.Errorf("TODO: Implement Debug() for Runner: %T", r.runner)
 		return runErr
 	}
 
+	log.Errorf("Step failed: %v\n%s", runErr, strings.Join(cmd, " "))
+	log.Info(fmt.Sprintf("Execing into pod %q to debug interactively.", r.config.PodID), "workdir", workdir)
+	log.Infof("Type 'exit 0' to continue the next pipeline step or 'exit 1' to abort.")
+
+	if err := r.shell(ctx, fragment, envOverride, workdir); err != nil {
+		return fmt.Errorf("failed to debug: %w; original error: %w", err, runErr)
+	}
+
+	// If Debug() returns succesfully (via exit 0), it is a signal to continue execution.
+	return nil
+}
+
+// breakpoint pauses the build before or after a step, as when says, with a
+// shell in the guest, and returns an error if the shell exits with one, to
+// abort the build.
+func (r *pipelineRunner) breakpoint(ctx context.Context, when string, pipeline *config.Pipeline, id string, envOverride map[string]string, workdir string) error {
+	log := clog.FromContext(ctx)
+
+	if _, ok := r.runner.(container.Debugger); !ok {
+		return fmt.Errorf("the %s runner doesn't support breakpoints", r.runner.Name())
+	}
+
+	log.Info(fmt.Sprintf("Breakpoint %s step %q: execing into pod %q.", when, describe(pipeline, id), r.config.PodID), "workdir", workdir)
+	log.Infof("Type 'exit 0' to continue the build or 'exit 1' to abort.")
+
+	if err := r.shell(ctx, pipeline.Runs, envOverride, workdir); err != nil {
+		return fmt.Errorf("breakpoint %s step %q: %w", when, describe(pipeline, id), err)
+	}
+	return nil
+}
+
+// shell runs an interactive shell in the guest, in workdir, with history in
+// its history, until it exits.
+func (r *pipelineRunner) shell(ctx context.Context, history string, envOverride map[string]string, workdir string) error {
+	dbg := r.runner.(container.Debugger)
+
 	// This is a bit of a hack but I want non-busybox shells to have a working history during interactive debugging,
 	// and I suspect busybox is the least helpful here, so just make everything read from $HOME/.ash_history.
 	if home, ok := envOverride["HOME"]; ok {
@@ -262,10 +477,6 @@ func (r *pipelineRunner) maybeDebug(ctx context.Context, fragment string, envOve
 		envOverride["HISTFILE"] = path.Join(home, ".ash_history")
 	}
 
-	log.Errorf("Step failed: %v\n%s", runErr, strings.Join(cmd, " "))
-	log.Info(fmt.Sprintf("Execing into pod %q to debug interactively.", r.config.PodID), "workdir", workdir)
-	log.Infof("Type 'exit 0' to continue the next pipeline step or 'exit 1' to abort.")
-
 	// If the context has already been cancelled, return before we mess with it.
 	if err := ctx.Err(); err != nil {
 		return err
@@ -273,21 +484,15 @@ func (r *pipelineRunner) maybeDebug(ctx context.Context, fragment string, envOve
 
 	// Don't cancel the context if we hit ctrl+C while debugging.
 	signal.Ignore(os.Interrupt)
+	// Reset to the default signal handling.
+	defer signal.Reset(os.Interrupt)
 
 	// Populate $HOME/.ash_history with the current command so you can hit up arrow to repeat it.
-	if err := os.WriteFile(filepath.Join(r.config.WorkspaceDir, ".ash_history"), []byte(fragment), 0644); err != nil {
+	if err := os.WriteFile(filepath.Join(r.config.WorkspaceDir, ".ash_history"), []byte(history), 0644); err != nil {
 		return fmt.Errorf("failed to write history file: %w", err)
 	}
 
-	if dbgErr := dbg.Debug(ctx, r.config, envOverride, []string{"/bin/sh", "-c", fmt.Sprintf("cd %s && exec /bin/sh", workdir)}...); dbgErr != nil {
-		return fmt.Errorf("failed to debug: %w; original error: %w", dbgErr, runErr)
-	}
-
-	// Reset to the default signal handling.
-	signal.Reset(os.Interrupt)
-
-	// If Debug() returns succesfully (via exit 0), it is a signal to continue execution.
-	return nil
+	return dbg.Debug(ctx, r.config, envOverride, []string{"/bin/sh", "-c", fmt.Sprintf("cd %s && exec /bin/sh", workdir)}...)
 }
 
 func (r *pipelineRunner) runPipelines(ctx context.Context, pipelines []config.Pipeline) error {
@@ -15,16 +15,26 @@
 package build
 
 import (
+	"context"
+	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"chainguard.dev/melange/pkg/config"
+	"chainguard.dev/melange/pkg/container"
 	"chainguard.dev/melange/pkg/util"
 	"gopkg.in/yaml.v3"
 
 	"github.com/chainguard-dev/clog/slogtest"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func Test_mutateStringFromMap(t *testing.T) {
@@ -73,6 +83,57 @@ func Test_substitutionMap(t *testing.T) {
 	}
 }
 
+func Test_substitutionMapFunctions(t *testing.T) {
+	cfg := config.Configuration{
+		Package: config.Package{
+			Name:    "Foo",
+			Version: "1.22.3_rc1",
+			Epoch:   4,
+		},
+		VarTransforms: []config.VarTransforms{
+			{From: "${{package.version}}", Function: "major", To: "major"},
+			{From: "${{package.version}}", Function: "minor", To: "minor"},
+			{From: "${{package.version}}", Function: "patch", To: "patch"},
+			{From: "v2", Function: "minor", To: "no-minor"},
+			{From: "${{package.name}}", Function: "upper", To: "upper"},
+			{From: "${{package.name}}", Function: "lower", To: "lower"},
+			{From: "a b/c+d", Function: "url-encode", To: "encoded"},
+			{From: "${{package.version}}", Function: "trim-suffix", Arg: "_rc1", To: "release"},
+			{From: "v${{package.version}}", Function: "trim-prefix", Arg: "v", To: "version"},
+			{From: "${{vars.minor}}", Function: "add", Arg: "1", To: "next-minor"},
+			{From: "${{package.epoch}}", Function: "subtract", Arg: "5", To: "previous-epoch"},
+		},
+	}
+	m, err := NewSubstitutionMap(&cfg, "", "", nil)
+	require.NoError(t, err)
+	for k, want := range map[string]string{
+		"major":          "1",
+		"minor":          "22",
+		"patch":          "3",
+		"no-minor":       "0",
+		"upper":          "FOO",
+		"lower":          "foo",
+		"encoded":        "a%20b%2Fc+d",
+		"release":        "1.22.3",
+		"version":        "1.22.3_rc1",
+		"next-minor":     "23",
+		"previous-epoch": "-1",
+	} {
+		require.Equal(t, want, m.Substitutions["${{vars."+k+"}}"], k)
+	}
+
+	for _, vt := range []config.VarTransforms{
+		{From: "${{package.name}}", Function: "major", To: "major"},
+		{From: "${{package.version}}", Function: "add", Arg: "1", To: "next"},
+		{From: "${{package.epoch}}", Function: "add", Arg: "one", To: "next"},
+		{From: "${{package.version}}", Function: "reverse", To: "reversed"},
+	} {
+		cfg.VarTransforms = []config.VarTransforms{vt}
+		_, err := NewSubstitutionMap(&cfg, "", "", nil)
+		require.Error(t, err, vt.Function)
+	}
+}
+
 func Test_MutateWith(t *testing.T) {
 	for _, tc := range []struct {
 		version string
@@ -172,3 +233,301 @@ func TestAllPipelines(t *testing.T) {
 		})
 	}
 }
+
+// flakyRunner fails the first failures runs.
+type flakyRunner struct {
+	container.Runner
+	failures int
+	runs     int
+}
+
+func (r *flakyRunner) Run(context.Context, *container.Config, map[string]string, ...string) error {
+	r.runs++
+	if r.runs <= r.failures {
+		return fmt.Errorf("run %d failed", r.runs)
+	}
+	return nil
+}
+
+func TestPipelineRetries(t *testing.T) {
+	ctx := slogtest.Context(t)
+
+	runner := &flakyRunner{failures: 2}
+	r := &pipelineRunner{config: &container.Config{}, runner: runner}
+	retries := &config.Retries{Count: 2, Backoff: time.Millisecond}
+	ran, err := r.runPipeline(ctx, &config.Pipeline{Runs: "true", Retries: retries})
+	require.NoError(t, err)
+	require.True(t, ran)
+	require.Equal(t, 3, runner.runs)
+
+	runner = &flakyRunner{failures: 3}
+	r = &pipelineRunner{config: &container.Config{}, runner: runner}
+	_, err = r.runPipeline(ctx, &config.Pipeline{Runs: "true", Retries: retries})
+	require.Error(t, err)
+	require.Equal(t, 3, runner.runs)
+
+	runner = &flakyRunner{failures: 1}
+	r = &pipelineRunner{config: &container.Config{}, runner: runner}
+	_, err = r.runPipeline(ctx, &config.Pipeline{Runs: "true"})
+	require.Error(t, err)
+	require.Equal(t, 1, runner.runs)
+}
+
+// scriptRunner counts the runs of each script, and fails those that
+// contain "fail".
+type scriptRunner struct {
+	container.Runner
+	runs map[string]int
+}
+
+func (r *scriptRunner) Run(_ context.Context, _ *container.Config, _ map[string]string, cmd ...string) error {
+	for script := range r.runs {
+		if strings.Contains(cmd[len(cmd)-1], script) {
+			r.runs[script]++
+			if strings.Contains(script, "fail") {
+				return fmt.Errorf("%s failed", script)
+			}
+		}
+	}
+	return nil
+}
+
+func TestNestedPipelineRetries(t *testing.T) {
+	ctx := slogtest.Context(t)
+
+	b := &Build{
+		Configuration: config.Configuration{
+			Pipeline: []config.Pipeline{{
+				Runs:    "parent-step",
+				Retries: &config.Retries{Count: 2, Backoff: time.Millisecond},
+				Pipeline: []config.Pipeline{{
+					Runs: "child-step-fail",
+				}},
+			}},
+		},
+	}
+	require.NoError(t, b.Compile(ctx))
+
+	runner := &scriptRunner{runs: map[string]int{"parent-step": 0, "child-step-fail": 0}}
+	r := &pipelineRunner{config: &container.Config{}, runner: runner}
+	_, err := r.runPipeline(ctx, &b.Configuration.Pipeline[0])
+	require.Error(t, err)
+
+	// The child retries by itself; the parent doesn't retry around it.
+	require.Equal(t, 1, runner.runs["parent-step"])
+	require.Equal(t, 3, runner.runs["child-step-fail"])
+}
+
+// hangingRunner runs commands that sleep until it is cancelled.
+type hangingRunner struct {
+	container.Runner
+}
+
+func (hangingRunner) Run(ctx context.Context, _ *container.Config, _ map[string]string, cmd ...string) error {
+	if !strings.Contains(strings.Join(cmd, " "), "sleep") {
+		return nil
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestPipelineTimeout(t *testing.T) {
+	ctx := slogtest.Context(t)
+
+	r := &pipelineRunner{config: &container.Config{}, runner: hangingRunner{}}
+	_, err := r.runPipeline(ctx, &config.Pipeline{
+		Name: "outer",
+		Pipeline: []config.Pipeline{{
+			Runs:    "sleep infinity\necho done",
+			Timeout: time.Millisecond,
+		}},
+	})
+	require.ErrorContains(t, err, `step "sleep infinity": step exceeded its timeout of 1ms`)
+
+	// The build's own timeout is reported the same way.
+	tctx, cancel := context.WithTimeoutCause(ctx, time.Millisecond, fmt.Errorf("build exceeded its timeout of 1ms"))
+	defer cancel()
+	_, err = r.runPipeline(tctx, &config.Pipeline{Name: "hang", Runs: "sleep infinity"})
+	require.ErrorContains(t, err, `step "hang": build exceeded its timeout of 1ms`)
+}
+
+// hostRunner runs commands on the host, with only the environment they are
+// given.
+type hostRunner struct {
+	container.Runner
+}
+
+func (hostRunner) Run(ctx context.Context, _ *container.Config, envOverride map[string]string, cmd ...string) error {
+	c := exec.CommandContext(ctx, cmd[0], cmd[1:]...)
+	for k, v := range envOverride {
+		c.Env = append(c.Env, k+"="+v)
+	}
+	out, err := c.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, out)
+	}
+	return nil
+}
+
+func TestStepOutputs(t *testing.T) {
+	ctx := slogtest.Context(t)
+	stepOutputsDir = t.TempDir()
+	workdir := t.TempDir()
+
+	b := &Build{
+		Configuration: config.Configuration{
+			Pipeline: []config.Pipeline{{
+				ID:      "resolve",
+				WorkDir: workdir,
+				Runs: `echo "version=1.2.3" >> "$MELANGE_OUTPUT"
+echo "path=/usr/lib/foo=bar" >> "$MELANGE_OUTPUT"
+printf "version=1.2.4" >> "$MELANGE_OUTPUT"`,
+			}, {
+				WorkDir: workdir,
+				Runs:    `echo "${{steps.resolve.outputs.version}} ${{steps.resolve.outputs.path}}" > out`,
+			}},
+		},
+	}
+	require.NoError(t, b.Compile(ctx))
+
+	r := &pipelineRunner{config: &container.Config{}, runner: hostRunner{}}
+	for _, p := range b.Configuration.Pipeline {
+		_, err := r.runPipeline(ctx, &p)
+		require.NoError(t, err)
+	}
+
+	out, err := os.ReadFile(filepath.Join(workdir, "out"))
+	require.NoError(t, err)
+	require.Equal(t, "1.2.4 /usr/lib/foo=bar\n", string(out))
+
+	// Outputs can't be used in conditions, which are evaluated before steps run.
+	b.Configuration.Pipeline = []config.Pipeline{{
+		If:   `${{steps.resolve.outputs.version}} == '1.2.4'`,
+		Runs: "true",
+	}}
+	require.ErrorContains(t, b.Compile(ctx), "step output steps.resolve.outputs.version can't be used here")
+}
+
+// recordingDebugger records the steps that run and the shells that are
+// opened between them, and fails shells after abortAfter of them.
+type recordingDebugger struct {
+	container.Runner
+	events     []string
+	shells     int
+	abortAfter int
+}
+
+func (r *recordingDebugger) Run(_ context.Context, _ *container.Config, _ map[string]string, cmd ...string) error {
+	for _, step := range []string{"configure", "compile", "install"} {
+		if strings.Contains(strings.Join(cmd, " "), step) {
+			r.events = append(r.events, "run "+step)
+		}
+	}
+	return nil
+}
+
+func (r *recordingDebugger) Debug(context.Context, *container.Config, map[string]string, ...string) error {
+	r.events = append(r.events, "shell")
+	r.shells++
+	if r.shells == r.abortAfter {
+		return fmt.Errorf("exit status 1")
+	}
+	return nil
+}
+
+func TestBreakpoints(t *testing.T) {
+	ctx := slogtest.Context(t)
+
+	pipeline := func() *config.Pipeline {
+		return &config.Pipeline{
+			Name: "outer",
+			Pipeline: []config.Pipeline{
+				{Name: "configure", Runs: "configure"},
+				{ID: "compile", Runs: "compile", Breakpoint: true},
+				{Uses: "autoconf/make-install", Runs: "install"},
+			},
+		}
+	}
+
+	for _, c := range []struct {
+		name                    string
+		interactive             bool
+		breakBefore, breakAfter []string
+		abortAfter              int
+		want                    []string
+		wantErr                 string
+	}{{
+		name: "no breakpoints",
+		want: []string{"run configure", "run compile", "run install"},
+	}, {
+		name:        "breakpoint attribute",
+		interactive: true,
+		want:        []string{"run configure", "shell", "run compile", "run install"},
+	}, {
+		name:        "by name, id and uses",
+		breakBefore: []string{"configure"},
+		breakAfter:  []string{"compile", "autoconf/make-install"},
+		want:        []string{"shell", "run configure", "run compile", "shell", "run install", "shell"},
+	}, {
+		name:       "after the outer step",
+		breakAfter: []string{"outer"},
+		want:       []string{"run configure", "run compile", "run install", "shell"},
+	}, {
+		name:        "abort",
+		breakBefore: []string{"compile"},
+		abortAfter:  1,
+		want:        []string{"run configure", "shell"},
+		wantErr:     `breakpoint before step "compile": exit status 1`,
+	}} {
+		t.Run(c.name, func(t *testing.T) {
+			runner := &recordingDebugger{abortAfter: c.abortAfter}
+			r := &pipelineRunner{
+				config:      &container.Config{WorkspaceDir: t.TempDir()},
+				runner:      runner,
+				interactive: c.interactive,
+				breakBefore: c.breakBefore,
+				breakAfter:  c.breakAfter,
+			}
+			_, err := r.runPipeline(ctx, pipeline())
+			if c.wantErr != "" {
+				require.ErrorContains(t, err, c.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, c.want, runner.events)
+		})
+	}
+}
+
+func TestStepSpans(t *testing.T) {
+	ctx := slogtest.Context(t)
+	spans := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+
+	r := &pipelineRunner{config: &container.Config{}, runner: hostRunner{}}
+	_, err := r.runPipeline(ctx, &config.Pipeline{
+		Name: "outer",
+		Uses: "test/outer",
+		Pipeline: []config.Pipeline{
+			{Name: "ok", Runs: "true"},
+			{If: "'a' == 'b'", Name: "skipped", Runs: "true"},
+			{Runs: "false"},
+		},
+	})
+	require.Error(t, err)
+
+	ended := spans.Ended()
+	require.Len(t, ended, 3)
+	require.Equal(t, "ok", ended[0].Name())
+	require.Equal(t, codes.Unset, ended[0].Status().Code)
+	require.Equal(t, "false", ended[1].Name())
+	require.Equal(t, codes.Error, ended[1].Status().Code)
+	require.Equal(t, ended[2].SpanContext().SpanID(), ended[1].Parent().SpanID())
+
+	outer := ended[2]
+	require.Equal(t, "outer", outer.Name())
+	require.Equal(t, codes.Error, outer.Status().Code)
+	require.Contains(t, outer.Attributes(), attrStepUses.String("test/outer"))
+}
@@ -0,0 +1,225 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/chainguard-dev/clog"
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+var commitRegex = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// Fetches into the remote pipeline cache are serialized, as builds for
+// several architectures compile their pipelines at the same time.
+var remotePipelineMu sync.Mutex
+
+// remotePipeline is a pipeline in a git repository, referred to by a `uses`
+// of the form <repository>//<path>@<commit>, such as
+// github.com/org/pipelines//rust/build@<commit>.
+type remotePipeline struct {
+	repo   string
+	path   string
+	commit string
+}
+
+// parseRemotePipeline parses uses as a remote pipeline, and reports whether
+// it is one.
+func parseRemotePipeline(uses string) (remotePipeline, bool, error) {
+	// Skip past the scheme, if any, so that its slashes aren't mistaken for
+	// the separator.
+	scheme := ""
+	if i := strings.Index(uses, "://"); i >= 0 {
+		scheme, uses = uses[:i+3], uses[i+3:]
+	}
+
+	repo, rest, ok := strings.Cut(uses, "//")
+	if !ok {
+		return remotePipeline{}, false, nil
+	}
+
+	p, commit, ok := strings.Cut(rest, "@")
+	if !ok || !commitRegex.MatchString(commit) {
+		return remotePipeline{}, true, fmt.Errorf("remote pipeline %q must be pinned to a full commit hash, as <repository>//<path>@<commit>", scheme+uses)
+	}
+	if repo == "" || p == "" || path.Clean(p) != p || strings.HasPrefix(p, "../") || strings.HasPrefix(p, "/") {
+		return remotePipeline{}, true, fmt.Errorf("invalid remote pipeline %q", scheme+uses)
+	}
+
+	return remotePipeline{repo: scheme + repo, path: p, commit: commit}, true, nil
+}
+
+// url returns the URL to fetch the pipeline's repository from, which is
+// over https unless the repository names a scheme.
+func (r remotePipeline) url() string {
+	if strings.Contains(r.repo, "://") {
+		return r.repo
+	}
+	return "https://" + r.repo
+}
+
+// defaultRemotePipelineCacheDir returns where remote pipelines are cached if
+// no cache directory is given.
+func defaultRemotePipelineCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "melange", "pipelines"), nil
+}
+
+// loadRemotePipeline returns the contents of the pipeline at r. The
+// repository is cached in cacheDir, and only fetched if it doesn't have the
+// commit yet. Every object from the commit down to the pipeline is checked
+// against its hash, so a tampered cache or a misbehaving server is caught.
+func loadRemotePipeline(ctx context.Context, cacheDir string, r remotePipeline) ([]byte, error) {
+	log := clog.FromContext(ctx)
+
+	if cacheDir == "" {
+		dir, err := defaultRemotePipelineCacheDir()
+		if err != nil {
+			return nil, fmt.Errorf("finding remote pipeline cache: %w", err)
+		}
+		cacheDir = dir
+	}
+
+	remotePipelineMu.Lock()
+	defer remotePipelineMu.Unlock()
+
+	url := r.url()
+	dir := filepath.Join(cacheDir, fmt.Sprintf("%x", sha256.Sum256([]byte(url))))
+
+	repo, err := git.PlainOpen(dir)
+	if errors.Is(err, git.ErrRepositoryNotExists) {
+		repo, err = git.PlainInit(dir, true)
+		if err == nil {
+			_, err = repo.CreateRemote(&gitconfig.RemoteConfig{Name: git.DefaultRemoteName, URLs: []string{url}})
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opening remote pipeline cache %s: %w", dir, err)
+	}
+
+	hash := plumbing.NewHash(r.commit)
+	if _, err := repo.Storer.EncodedObject(plumbing.CommitObject, hash); err != nil {
+		log.Infof("fetching %s at %s", url, r.commit)
+		if err := fetchCommit(ctx, repo, r.commit); err != nil {
+			return nil, fmt.Errorf("fetching %s: %w", url, err)
+		}
+	} else {
+		log.Debugf("using cached %s at %s", url, r.commit)
+	}
+
+	data, err := readVerified(repo, hash, r.path+".yaml")
+	if err != nil {
+		return nil, fmt.Errorf("reading %s.yaml from %s at %s: %w", r.path, url, r.commit, err)
+	}
+	return data, nil
+}
+
+// fetchCommit fetches commit into repo, falling back to fetching every branch
+// and tag from servers that don't let commits be fetched directly.
+func fetchCommit(ctx context.Context, repo *git.Repository, commit string) error {
+	err := repo.FetchContext(ctx, &git.FetchOptions{
+		RefSpecs: []gitconfig.RefSpec{gitconfig.RefSpec(commit + ":refs/pinned/" + commit)},
+		Depth:    1,
+	})
+	if errors.Is(err, git.ErrExactSHA1NotSupported) {
+		err = repo.FetchContext(ctx, &git.FetchOptions{
+			RefSpecs: []gitconfig.RefSpec{"+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*"},
+		})
+	}
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return err
+	}
+
+	if _, err := repo.Storer.EncodedObject(plumbing.CommitObject, plumbing.NewHash(commit)); err != nil {
+		return fmt.Errorf("commit %s not found", commit)
+	}
+	return nil
+}
+
+// readVerified returns the contents of the file at p in commit, walking down
+// to it one object at a time and checking each one against its hash.
+func readVerified(repo *git.Repository, commit plumbing.Hash, p string) ([]byte, error) {
+	obj, _, err := verifiedObject(repo, plumbing.CommitObject, commit)
+	if err != nil {
+		return nil, err
+	}
+	c, err := object.DecodeCommit(repo.Storer, obj)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := c.TreeHash
+	parts := strings.Split(p, "/")
+	for i, name := range parts {
+		obj, _, err := verifiedObject(repo, plumbing.TreeObject, hash)
+		if err != nil {
+			return nil, err
+		}
+		t, err := object.DecodeTree(repo.Storer, obj)
+		if err != nil {
+			return nil, err
+		}
+
+		entry, err := t.FindEntry(name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path.Join(parts[:i+1]...), err)
+		}
+		hash = entry.Hash
+	}
+
+	_, data, err := verifiedObject(repo, plumbing.BlobObject, hash)
+	return data, err
+}
+
+// verifiedObject returns the object with hash from repo, and its contents,
+// after checking that they match hash.
+func verifiedObject(repo *git.Repository, typ plumbing.ObjectType, hash plumbing.Hash) (plumbing.EncodedObject, []byte, error) {
+	obj, err := repo.Storer.EncodedObject(typ, hash)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s %s: %w", typ, hash, err)
+	}
+
+	rc, err := obj.Reader()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, nil, err
+	}
+	if got := plumbing.ComputeHash(typ, data); got != hash {
+		return nil, nil, fmt.Errorf("%s %s has hash %s", typ, hash, got)
+	}
+	return obj, data, nil
+}
@@ -0,0 +1,142 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"chainguard.dev/melange/pkg/config"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+func TestParseRemotePipeline(t *testing.T) {
+	const commit = "0123456789abcdef0123456789abcdef01234567"
+
+	for _, c := range []struct {
+		uses    string
+		want    remotePipeline
+		remote  bool
+		wantErr bool
+	}{
+		{uses: "fetch"},
+		{uses: "go/build"},
+		{
+			uses:   "github.com/org/pipelines//rust/build@" + commit,
+			want:   remotePipeline{repo: "github.com/org/pipelines", path: "rust/build", commit: commit},
+			remote: true,
+		},
+		{
+			uses:   "file:///srv/pipelines//build@" + commit,
+			want:   remotePipeline{repo: "file:///srv/pipelines", path: "build", commit: commit},
+			remote: true,
+		},
+		{uses: "github.com/org/pipelines//rust/build", remote: true, wantErr: true},
+		{uses: "github.com/org/pipelines//rust/build@main", remote: true, wantErr: true},
+		{uses: "github.com/org/pipelines//rust/build@0123456", remote: true, wantErr: true},
+		{uses: "github.com/org/pipelines//../build@" + commit, remote: true, wantErr: true},
+		{uses: "//build@" + commit, remote: true, wantErr: true},
+	} {
+		got, remote, err := parseRemotePipeline(c.uses)
+		if (err != nil) != c.wantErr {
+			t.Errorf("%s: want error %t, got %v", c.uses, c.wantErr, err)
+		}
+		if remote != c.remote {
+			t.Errorf("%s: want remote %t, got %t", c.uses, c.remote, remote)
+		}
+		if err == nil && got != c.want {
+			t.Errorf("%s: want %+v, got %+v", c.uses, c.want, got)
+		}
+	}
+}
+
+func TestRemotePipeline(t *testing.T) {
+	// Make a repository with a pipeline in it.
+	src := t.TempDir()
+	repo, err := git.PlainInit(src, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(src, "rust"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "rust", "build.yaml"), []byte(`
+inputs:
+  profile:
+    default: release
+
+pipeline:
+  - runs: cargo build --profile ${{inputs.profile}}
+`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wt.Add("rust/build.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := wt.Commit("add rust/build", &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Unix(0, 0)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache := t.TempDir()
+	compile := func(uses string) (*Build, error) {
+		b := &Build{
+			RemotePipelineCacheDir: cache,
+			Configuration: config.Configuration{
+				Pipeline: []config.Pipeline{{
+					Uses: uses,
+					With: map[string]string{"profile": "dev"},
+				}},
+			},
+		}
+		return b, b.Compile(context.Background())
+	}
+
+	uses := "file://" + src + "//rust/build@" + hash.String()
+	b, err := compile(uses)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, want := b.Configuration.Pipeline[0].Pipeline[0].Runs, "cargo build --profile dev"; got != want {
+		t.Errorf("want runs %q, got %q", want, got)
+	}
+
+	// The pipeline is cached, so it can be used without the repository.
+	if err := os.RemoveAll(src); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := compile(uses); err != nil {
+		t.Fatalf("unexpected error using cached pipeline: %v", err)
+	}
+
+	for _, uses := range []string{
+		"file://" + src + "//rust/test@" + hash.String(),
+		"file://" + src + "//rust/build@0123456789abcdef0123456789abcdef01234567",
+	} {
+		if _, err := compile(uses); err == nil {
+			t.Errorf("%s: expected error", uses)
+		}
+	}
+}
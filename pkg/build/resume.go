@@ -0,0 +1,333 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"syscall"
+
+	apkofs "chainguard.dev/apko/pkg/apk/fs"
+	"chainguard.dev/melange/pkg/config"
+	"chainguard.dev/melange/pkg/container"
+	"github.com/chainguard-dev/clog"
+)
+
+// resumeState identifies the build that a snapshot of the workspace was
+// taken during, by hashes of its package and build environment, and of the
+// top-level steps of its main pipeline that had run.
+type resumeState struct {
+	Build string   `json:"build"`
+	Steps []string `json:"steps"`
+}
+
+// newResumeState returns the state of cfg once every step of its main
+// pipeline has run.
+func newResumeState(cfg *config.Configuration) (resumeState, error) {
+	h := func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%x", sha256.Sum256(data)), nil
+	}
+
+	var s resumeState
+	var err error
+	s.Build, err = h(struct {
+		Package     config.Package
+		Environment any
+	}{cfg.Package, cfg.Environment})
+	if err != nil {
+		return s, err
+	}
+	for _, p := range cfg.Pipeline {
+		step, err := h(p)
+		if err != nil {
+			return s, err
+		}
+		s.Steps = append(s.Steps, step)
+	}
+	return s, nil
+}
+
+// resumableSteps returns how many of the top-level steps of pipelines a
+// build can be resumed after. Step outputs aren't in the workspace, so a
+// build can't be resumed after a step that has any.
+func resumableSteps(pipelines []config.Pipeline) int {
+	var hasID func(p *config.Pipeline) bool
+	hasID = func(p *config.Pipeline) bool {
+		return p.ID != "" || slices.ContainsFunc(p.Pipeline, func(p config.Pipeline) bool { return hasID(&p) })
+	}
+	for i, p := range pipelines {
+		if hasID(&p) {
+			return i
+		}
+	}
+	return len(pipelines)
+}
+
+// resumer snapshots the workspace after each top-level step of the main
+// pipeline, so that if the build fails, the next one can pick up where it
+// left off.
+type resumer struct {
+	dir   string
+	state resumeState
+	// The number of steps that the workspace was restored after, and the
+	// number that it can be snapshotted after.
+	from, limit int
+}
+
+// resumeDir returns where the snapshots of the build are kept.
+func (b *Build) resumeDir() (string, error) {
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	configFile, err := filepath.Abs(b.ConfigFile)
+	if err != nil {
+		return "", err
+	}
+	key := fmt.Sprintf("%x", sha256.Sum256([]byte(configFile+"\x00"+b.Arch.ToAPK()+"\x00"+config.MatrixName(b.Matrix))))
+	return filepath.Join(cache, "melange", "resume", fmt.Sprintf("%s-%s-%s", b.Configuration.Package.Name, b.Arch.ToAPK(), key[:12])), nil
+}
+
+// prepareResume restores the workspace from the snapshot of the last build
+// that failed, if it's a snapshot of this build, and returns a resumer to
+// take more. It returns nil if the build isn't resumable.
+func (b *Build) prepareResume(ctx context.Context) (*resumer, error) {
+	if !b.Resume {
+		return nil, nil
+	}
+	if m, ok := b.Runner.(container.WorkspaceMounter); !ok || !m.MountsWorkspace() {
+		return nil, fmt.Errorf("the %s runner doesn't support resuming builds", b.Runner.Name())
+	}
+
+	dir, err := b.resumeDir()
+	if err != nil {
+		return nil, fmt.Errorf("finding build snapshots: %w", err)
+	}
+	state, err := newResumeState(&b.Configuration)
+	if err != nil {
+		return nil, err
+	}
+	r := &resumer{dir: dir, state: state, limit: resumableSteps(b.Configuration.Pipeline)}
+	if err := r.restore(ctx, b.WorkspaceDir); err != nil {
+		return nil, fmt.Errorf("restoring build snapshot: %w", err)
+	}
+	return r, nil
+}
+
+// restore replaces the workspace with the snapshot in r's directory, if
+// the snapshot was taken after steps that this build would run first.
+func (r *resumer) restore(ctx context.Context, workspaceDir string) error {
+	log := clog.FromContext(ctx)
+
+	snapshot := filepath.Join(r.dir, "snapshot")
+	data, err := os.ReadFile(filepath.Join(snapshot, "state.json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		log.Infof("no snapshot of a failed build to resume from")
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var saved resumeState
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return err
+	}
+
+	n := len(saved.Steps)
+	switch {
+	case saved.Build != r.state.Build:
+		log.Infof("not resuming, as the package or its build environment has changed since the last build")
+		return nil
+	case n > r.limit || n > len(r.state.Steps) || !slices.Equal(saved.Steps, r.state.Steps[:n]):
+		log.Infof("not resuming, as the steps that the last build ran have changed since")
+		return nil
+	}
+
+	entries, err := os.ReadDir(workspaceDir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := removeAllWritable(filepath.Join(workspaceDir, e.Name())); err != nil {
+			return err
+		}
+	}
+	f, err := os.Open(filepath.Join(snapshot, "workspace.tar"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := extractWorkspace(apkofs.DirFS(workspaceDir), tar.NewReader(f)); err != nil {
+		return err
+	}
+
+	log.Infof("resuming the build after step %d of %d, from the snapshot in %s", n, len(r.state.Steps), snapshot)
+	r.from = n
+	return nil
+}
+
+// save replaces the snapshot in r's directory with one of the workspace,
+// taken after the first n steps have run.
+func (r *resumer) save(ctx context.Context, workspaceDir string, n int) error {
+	log := clog.FromContext(ctx)
+
+	snapshot := filepath.Join(r.dir, "snapshot")
+	tmp := snapshot + ".tmp"
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(tmp, 0o700); err != nil {
+		return err
+	}
+
+	f, err := os.Create(filepath.Join(tmp, "workspace.tar"))
+	if err != nil {
+		return err
+	}
+	if err := writeWorkspaceTar(f, workspaceDir); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(resumeState{Build: r.state.Build, Steps: r.state.Steps[:n]})
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(tmp, "state.json"), data, 0o600); err != nil {
+		return err
+	}
+
+	// A snapshot is only ever replaced whole.
+	if err := os.RemoveAll(snapshot); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, snapshot); err != nil {
+		return err
+	}
+	log.Debugf("snapshotted the workspace after step %d to %s", n, snapshot)
+	return nil
+}
+
+// remove removes the snapshots, once the build has succeeded.
+func (r *resumer) remove() error {
+	return os.RemoveAll(r.dir)
+}
+
+// writeWorkspaceTar writes the contents of workspaceDir to w as a tar
+// archive, keeping hard links, for extractWorkspace to unpack.
+func writeWorkspaceTar(w io.Writer, workspaceDir string) error {
+	tw := tar.NewWriter(w)
+	links := map[[2]uint64]string{}
+
+	if err := filepath.WalkDir(workspaceDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == workspaceDir {
+			return nil
+		}
+		name, err := filepath.Rel(workspaceDir, p)
+		if err != nil {
+			return err
+		}
+		name = filepath.ToSlash(name)
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() && !fi.Mode().IsRegular() && fi.Mode()&fs.ModeSymlink == 0 {
+			return nil
+		}
+		var target string
+		if fi.Mode()&fs.ModeSymlink != 0 {
+			if target, err = os.Readlink(p); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(fi, target)
+		if err != nil {
+			return err
+		}
+		hdr.Name = name
+
+		if st, ok := fi.Sys().(*syscall.Stat_t); ok && fi.Mode().IsRegular() && st.Nlink > 1 {
+			key := [2]uint64{uint64(st.Dev), uint64(st.Ino)} //nolint:unconvert
+			if first, ok := links[key]; ok {
+				hdr.Typeflag = tar.TypeLink
+				hdr.Linkname = first
+				hdr.Size = 0
+			} else {
+				links[key] = name
+			}
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	}); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// runSteps runs the top-level steps of pipelines from lo up to hi, other
+// than those that ran before the snapshot that the build resumed from, if
+// it did, snapshotting the workspace after each one if it's resumable.
+func (b *Build) runSteps(ctx context.Context, pr *pipelineRunner, r *resumer, pipelines []config.Pipeline, lo, hi int) error {
+	log := clog.FromContext(ctx)
+
+	for i := lo; i < hi; i++ {
+		p := &pipelines[i]
+		if r != nil && i < r.from {
+			log.Infof("skipping step %q, which ran before the snapshot that the build resumed from", describe(p, identity(p)))
+			continue
+		}
+		if _, err := pr.runPipeline(ctx, p); err != nil {
+			return fmt.Errorf("unable to run pipeline: %w", err)
+		}
+		if r != nil && i < r.limit {
+			if err := r.save(ctx, b.WorkspaceDir, i+1); err != nil {
+				log.Warnf("unable to snapshot the workspace: %v", err)
+			}
+		}
+	}
+	return nil
+}
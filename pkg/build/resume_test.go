@@ -0,0 +1,85 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"chainguard.dev/melange/pkg/config"
+	"chainguard.dev/melange/pkg/container"
+	"github.com/chainguard-dev/clog/slogtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResumableSteps(t *testing.T) {
+	require.Equal(t, 2, resumableSteps([]config.Pipeline{{Runs: "a"}, {Runs: "b"}}))
+	require.Equal(t, 1, resumableSteps([]config.Pipeline{{Runs: "a"}, {ID: "b"}, {Runs: "c"}}))
+	require.Equal(t, 0, resumableSteps([]config.Pipeline{{Pipeline: []config.Pipeline{{ID: "a"}}}}))
+}
+
+func TestResume(t *testing.T) {
+	ctx := slogtest.Context(t)
+	workspace := t.TempDir()
+
+	steps := func(last string) []config.Pipeline {
+		return []config.Pipeline{
+			{WorkDir: workspace, Runs: "echo one >> log; mkdir -p out; ln -s ../log out/log"},
+			{WorkDir: workspace, Runs: "echo two >> log; echo data > data; ln data data.link"},
+			{WorkDir: workspace, Runs: last},
+		}
+	}
+	dir := filepath.Join(t.TempDir(), "resume")
+	resumerFor := func(pipelines []config.Pipeline) *resumer {
+		cfg := config.Configuration{Package: config.Package{Name: "test"}, Pipeline: pipelines}
+		state, err := newResumeState(&cfg)
+		require.NoError(t, err)
+		r := &resumer{dir: dir, state: state, limit: resumableSteps(pipelines)}
+		require.NoError(t, r.restore(ctx, workspace))
+		return r
+	}
+
+	b := &Build{WorkspaceDir: workspace}
+	pr := &pipelineRunner{config: &container.Config{}, runner: hostRunner{}}
+
+	// The last step fails, leaving a snapshot after the first two.
+	r := resumerFor(steps("false"))
+	require.Equal(t, 0, r.from)
+	require.Error(t, b.runSteps(ctx, pr, r, steps("false"), 0, 3))
+
+	// Fixing the last step resumes after the first two, in a workspace
+	// restored from the snapshot, even though the failed step changed it.
+	require.NoError(t, os.WriteFile(filepath.Join(workspace, "log"), []byte("garbage\n"), 0o644))
+	r = resumerFor(steps("echo three >> log"))
+	require.Equal(t, 2, r.from)
+	require.NoError(t, b.runSteps(ctx, pr, r, steps("echo three >> log"), 0, 3))
+
+	log, err := os.ReadFile(filepath.Join(workspace, "out", "log"))
+	require.NoError(t, err)
+	require.Equal(t, "one\ntwo\nthree\n", string(log))
+
+	data, err := os.Stat(filepath.Join(workspace, "data"))
+	require.NoError(t, err)
+	link, err := os.Stat(filepath.Join(workspace, "data.link"))
+	require.NoError(t, err)
+	require.True(t, os.SameFile(data, link), "hard link wasn't kept")
+
+	// Changing a step that ran before the snapshot starts over.
+	changed := steps("echo three >> log")
+	changed[0].Runs = "echo uno >> log"
+	r = resumerFor(changed)
+	require.Equal(t, 0, r.from)
+}
@@ -48,6 +48,12 @@ func (sg *SBOMGroup) Document(name string) *sbom.Document {
 	return sg.set[name]
 }
 
+// Remove drops the SBOM for the given package or subpackage name from the
+// group, for a subpackage that isn't built after all.
+func (sg *SBOMGroup) Remove(name string) {
+	delete(sg.set, name)
+}
+
 // AddBuildConfigurationPackage adds a package serving as the "build
 // configuration package" to all SBOMs in the group.
 func (sg *SBOMGroup) AddBuildConfigurationPackage(p *sbom.Package) {
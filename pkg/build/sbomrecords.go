@@ -0,0 +1,120 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"chainguard.dev/apko/pkg/sbom/generator/spdx"
+	"chainguard.dev/melange/pkg/sbom"
+	"github.com/chainguard-dev/clog"
+	purl "github.com/package-url/packageurl-go"
+)
+
+// sbomRecordsDirName is the directory in melange-out where pipelines record
+// the packages that went into the build, such as the crates that a Rust
+// binary was built from, for them to be added to the SBOMs. It's kept in
+// melange-out so that every runner hands it back with the packages.
+//
+// Each file in it has a package URL on each line, optionally followed by a
+// space and the SPDX license expression of the package.
+const sbomRecordsDirName = ".melange-sbom"
+
+// addRecordedSBOMPackages adds the packages that pipelines recorded to the
+// SBOMs of the package and its subpackages, as upstream sources.
+func (b *Build) addRecordedSBOMPackages(ctx context.Context) error {
+	dir := filepath.Join(b.WorkspaceDir, melangeOutputDirName, sbomRecordsDirName)
+	pkgs, err := recordedSBOMPackages(ctx, dir)
+	if err != nil {
+		return fmt.Errorf("reading packages recorded for the SBOM: %w", err)
+	}
+	for _, pkg := range pkgs {
+		b.SBOMGroup.AddUpstreamSourcePackage(pkg)
+	}
+	return nil
+}
+
+// recordedSBOMPackages returns the packages recorded in the files in dir,
+// once each, in the order of their package URLs. It's fine for dir not to
+// exist. Lines that aren't package URLs are skipped with a warning.
+func recordedSBOMPackages(ctx context.Context, dir string) ([]*sbom.Package, error) {
+	log := clog.FromContext(ctx)
+
+	des, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]*sbom.Package{}
+	for _, de := range des {
+		if !de.Type().IsRegular() {
+			continue
+		}
+		f, err := os.Open(filepath.Join(dir, de.Name()))
+		if err != nil {
+			return nil, err
+		}
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			line := strings.TrimSpace(s.Text())
+			if line == "" {
+				continue
+			}
+			u, license, _ := strings.Cut(line, " ")
+			pu, err := purl.FromString(u)
+			if err != nil {
+				log.Warnf("%s: skipping %q, which isn't a package URL: %v", de.Name(), u, err)
+				continue
+			}
+			key := pu.ToString()
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			license = strings.TrimSpace(license)
+			if license == "" {
+				license = spdx.NOASSERTION
+			}
+			seen[key] = &sbom.Package{
+				IDComponents:    []string{"recorded", pu.Type, pu.Namespace, pu.Name, pu.Version},
+				Name:            pu.Name,
+				Version:         pu.Version,
+				LicenseDeclared: license,
+				PURL:            &pu,
+			}
+		}
+		err = s.Err()
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", de.Name(), err)
+		}
+	}
+
+	pkgs := make([]*sbom.Package, 0, len(seen))
+	for _, key := range slices.Sorted(maps.Keys(seen)) {
+		pkgs = append(pkgs, seen[key])
+	}
+	return pkgs, nil
+}
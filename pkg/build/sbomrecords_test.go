@@ -0,0 +1,56 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/chainguard-dev/clog/slogtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordedSBOMPackages(t *testing.T) {
+	ctx := slogtest.Context(t)
+	dir := filepath.Join(t.TempDir(), sbomRecordsDirName)
+
+	// It's fine for nothing to have been recorded.
+	pkgs, err := recordedSBOMPackages(ctx, dir)
+	require.NoError(t, err)
+	require.Empty(t, pkgs)
+
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "cargo"), []byte(`pkg:cargo/serde@1.0.197 MIT OR Apache-2.0
+pkg:cargo/libc@0.2.153
+
+not a purl
+pkg:cargo/serde@1.0.197 MIT OR Apache-2.0
+`), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "npm"), []byte("pkg:npm/%40types/node@20.1.0 MIT\n"), 0o644))
+
+	pkgs, err = recordedSBOMPackages(ctx, dir)
+	require.NoError(t, err)
+	var got [][3]string
+	for _, p := range pkgs {
+		got = append(got, [3]string{p.PURL.ToString(), p.Version, p.LicenseDeclared})
+	}
+	require.Equal(t, [][3]string{
+		{"pkg:cargo/libc@0.2.153", "0.2.153", "NOASSERTION"},
+		{"pkg:cargo/serde@1.0.197", "1.0.197", "MIT OR Apache-2.0"},
+		{"pkg:npm/%40types/node@20.1.0", "20.1.0", "MIT"},
+	}, got)
+	require.Equal(t, "node", pkgs[2].Name)
+}
@@ -0,0 +1,204 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"chainguard.dev/melange/pkg/container"
+	"github.com/chainguard-dev/clog"
+)
+
+var (
+	secretNameRegex    = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9_.-]*$`)
+	secretEnvNameRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// Secret is something that steps need but that mustn't end up in the
+// workspace, the package, the logs or the SBOM, such as a token for a
+// private registry. Every step can read it from the file
+// container.DefaultSecretsDir/<Name>, which is outside the workspace, and it's
+// replaced in everything that's logged.
+type Secret struct {
+	Name string
+	// The file to read the secret from, or env:<VAR> to read it from the
+	// environment variable VAR.
+	Source string
+	// Whether steps also get the secret as the environment variable Name.
+	Env bool
+}
+
+// ParseSecret parses a secret given as <name>=<source>. If env is true, steps
+// get it as an environment variable as well as a file.
+func ParseSecret(s string, env bool) (Secret, error) {
+	name, source, ok := strings.Cut(s, "=")
+	if !ok || source == "" {
+		return Secret{}, fmt.Errorf("secret %q must be of the form <name>=<path> or <name>=env:<variable>", s)
+	}
+	if env {
+		if !secretEnvNameRegex.MatchString(name) {
+			return Secret{}, fmt.Errorf("secret %q must be a valid environment variable name", name)
+		}
+	} else if !secretNameRegex.MatchString(name) {
+		return Secret{}, fmt.Errorf("secret %q must match regex %q", name, secretNameRegex)
+	}
+	return Secret{Name: name, Source: source, Env: env}, nil
+}
+
+func (s Secret) value() (string, error) {
+	if v, ok := strings.CutPrefix(s.Source, "env:"); ok {
+		value, ok := os.LookupEnv(v)
+		if !ok {
+			return "", fmt.Errorf("secret %q: environment variable %s is not set", s.Name, v)
+		}
+		return value, nil
+	}
+	data, err := os.ReadFile(s.Source)
+	if err != nil {
+		return "", fmt.Errorf("secret %q: %w", s.Name, err)
+	}
+	return string(data), nil
+}
+
+// prepareSecrets writes the build's secrets into a new directory for the
+// runner to make available to steps, and returns a context whose logger
+// redacts them, and a function that removes the directory.
+func (b *Build) prepareSecrets(ctx context.Context) (context.Context, func(), error) {
+	if len(b.Secrets) == 0 {
+		return ctx, func() {}, nil
+	}
+
+	// Only the user running the build can get into the parent, but the
+	// secrets themselves are readable by the build user in the guest.
+	parent, err := os.MkdirTemp("", "melange-secrets-*")
+	if err != nil {
+		return ctx, nil, err
+	}
+	cleanup := func() { os.RemoveAll(parent) }
+
+	dir := filepath.Join(parent, "secrets")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		cleanup()
+		return ctx, nil, err
+	}
+
+	names := map[string]bool{}
+	var values []string
+	for _, s := range b.Secrets {
+		if names[s.Name] {
+			cleanup()
+			return ctx, nil, fmt.Errorf("secret %q is given more than once", s.Name)
+		}
+		names[s.Name] = true
+
+		v, err := s.value()
+		if err != nil {
+			cleanup()
+			return ctx, nil, err
+		}
+		if err := os.WriteFile(filepath.Join(dir, s.Name), []byte(v), 0o644); err != nil {
+			cleanup()
+			return ctx, nil, err
+		}
+		values = append(values, v, strings.TrimRight(v, "\n"))
+	}
+	b.secretsDir = dir
+
+	log := clog.FromContext(ctx)
+	h := newRedactHandler(log.Handler(), values)
+	b.redactor = h.r
+	return clog.WithLogger(ctx, clog.New(h)), cleanup, nil
+}
+
+// loadSecretEnv is run before every step if any secrets are given as
+// environment variables. It reads them from their files in a group whose
+// stderr is discarded, so that --debug doesn't trace their values.
+func loadSecretEnv(secrets []Secret) string {
+	var b strings.Builder
+	for _, s := range secrets {
+		if s.Env {
+			fmt.Fprintf(&b, "export %s=\"$(cat '%s')\"; ", s.Name, path.Join(container.DefaultSecretsDir, s.Name))
+		}
+	}
+	if b.Len() == 0 {
+		return ""
+	}
+	return "{ " + b.String() + "} 2>/dev/null\n"
+}
+
+const redacted = "[REDACTED]"
+
+// redactHandler replaces secret values in the messages and attributes of
+// records before passing them on.
+type redactHandler struct {
+	slog.Handler
+	r *strings.Replacer
+}
+
+func newRedactHandler(h slog.Handler, values []string) redactHandler {
+	var pairs []string
+	for _, v := range values {
+		if v != "" {
+			pairs = append(pairs, v, redacted)
+		}
+	}
+	return redactHandler{Handler: h, r: strings.NewReplacer(pairs...)}
+}
+
+func (h redactHandler) Handle(ctx context.Context, rec slog.Record) error {
+	out := slog.NewRecord(rec.Time, rec.Level, h.r.Replace(rec.Message), rec.PC)
+	rec.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(h.redact(a))
+		return true
+	})
+	return h.Handler.Handle(ctx, out)
+}
+
+func (h redactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, 0, len(attrs))
+	for _, a := range attrs {
+		redacted = append(redacted, h.redact(a))
+	}
+	return redactHandler{Handler: h.Handler.WithAttrs(redacted), r: h.r}
+}
+
+func (h redactHandler) WithGroup(name string) slog.Handler {
+	return redactHandler{Handler: h.Handler.WithGroup(name), r: h.r}
+}
+
+func (h redactHandler) redact(a slog.Attr) slog.Attr {
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindGroup:
+		group := v.Group()
+		redacted := make([]any, 0, len(group))
+		for _, ga := range group {
+			redacted = append(redacted, h.redact(ga))
+		}
+		return slog.Group(a.Key, redacted...)
+	case slog.KindString, slog.KindAny:
+		if s := v.String(); h.r.Replace(s) != s {
+			return slog.String(a.Key, h.r.Replace(s))
+		}
+	}
+	return a
+}
@@ -0,0 +1,87 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/chainguard-dev/clog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSecret(t *testing.T) {
+	s, err := ParseSecret("npmrc=/home/me/.npmrc", false)
+	require.NoError(t, err)
+	require.Equal(t, Secret{Name: "npmrc", Source: "/home/me/.npmrc"}, s)
+
+	s, err = ParseSecret("GITHUB_TOKEN=env:GH_TOKEN", true)
+	require.NoError(t, err)
+	require.Equal(t, Secret{Name: "GITHUB_TOKEN", Source: "env:GH_TOKEN", Env: true}, s)
+
+	for _, c := range []struct {
+		s   string
+		env bool
+	}{
+		{s: "npmrc"},
+		{s: "npmrc="},
+		{s: "../npmrc=/home/me/.npmrc"},
+		{s: "=env:TOKEN"},
+		{s: "my-token=env:TOKEN", env: true},
+	} {
+		_, err := ParseSecret(c.s, c.env)
+		require.Error(t, err, c.s)
+	}
+}
+
+func TestPrepareSecrets(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "npmrc"), []byte("//registry/:_authToken=npm-secret\n"), 0o600))
+	t.Setenv("MELANGE_TEST_TOKEN", "gh-secret")
+
+	var buf bytes.Buffer
+	ctx := clog.WithLogger(context.Background(), clog.New(slog.NewTextHandler(&buf, nil)))
+
+	b := &Build{Secrets: []Secret{
+		{Name: "npmrc", Source: filepath.Join(dir, "npmrc")},
+		{Name: "TOKEN", Source: "env:MELANGE_TEST_TOKEN", Env: true},
+	}}
+	ctx, cleanup, err := b.prepareSecrets(ctx)
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(filepath.Join(b.secretsDir, "TOKEN"))
+	require.NoError(t, err)
+	require.Equal(t, "gh-secret", string(data))
+
+	// Secrets are replaced in messages and attributes.
+	log := clog.FromContext(ctx).With("token", "gh-secret")
+	log.Info("//registry/:_authToken=npm-secret", "output", "token is gh-secret")
+	require.NotContains(t, buf.String(), "npm-secret")
+	require.NotContains(t, buf.String(), "gh-secret")
+	require.Contains(t, buf.String(), "token is "+redacted)
+
+	require.Equal(t, `{ export TOKEN="$(cat '/run/secrets/TOKEN')"; } 2>/dev/null`+"\n", loadSecretEnv(b.Secrets))
+
+	cleanup()
+	require.NoDirExists(t, b.secretsDir)
+
+	b.Secrets = append(b.Secrets, Secret{Name: "missing", Source: "env:MELANGE_TEST_UNSET"})
+	_, _, err = b.prepareSecrets(context.Background())
+	require.ErrorContains(t, err, "MELANGE_TEST_UNSET is not set")
+}
@@ -0,0 +1,75 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"slices"
+
+	"chainguard.dev/melange/pkg/config"
+	"github.com/chainguard-dev/clog"
+)
+
+// omitEmptySubpackages leaves the subpackages that are only wanted if
+// something was split into them, such as the default splits, out of the
+// build if nothing was.
+func (b *Build) omitEmptySubpackages(ctx context.Context) error {
+	log := clog.FromContext(ctx)
+
+	out := filepath.Join(b.WorkspaceDir, melangeOutputDirName)
+	var err error
+	b.Configuration.Subpackages = slices.DeleteFunc(b.Configuration.Subpackages, func(sp config.Subpackage) bool {
+		if !sp.OmitIfEmpty || err != nil {
+			return false
+		}
+		var empty bool
+		if empty, err = isEmptyDir(filepath.Join(out, sp.Name)); err != nil {
+			err = fmt.Errorf("checking whether %s is empty: %w", sp.Name, err)
+			return false
+		}
+		if empty {
+			log.Infof("skipping subpackage %s because nothing was split into it", sp.Name)
+			if b.recorder != nil {
+				b.recorder.emit(ctx, Event{Type: EventSubpackageSkipped, Subpackage: sp.Name})
+			}
+		}
+		return empty
+	})
+	return err
+}
+
+// isEmptyDir returns whether there's nothing but directories under dir, if
+// it exists at all.
+func isEmptyDir(dir string) (bool, error) {
+	empty := true
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == dir && errors.Is(err, fs.ErrNotExist) {
+				return fs.SkipAll
+			}
+			return err
+		}
+		if !d.IsDir() {
+			empty = false
+			return fs.SkipAll
+		}
+		return nil
+	})
+	return empty, err
+}
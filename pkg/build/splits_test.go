@@ -0,0 +1,59 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"chainguard.dev/melange/pkg/config"
+	"github.com/chainguard-dev/clog/slogtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOmitEmptySubpackages(t *testing.T) {
+	ctx := slogtest.Context(t)
+	dir := t.TempDir()
+	out := filepath.Join(dir, melangeOutputDirName)
+
+	b := &Build{
+		WorkspaceDir: dir,
+		Configuration: config.Configuration{
+			Package: config.Package{Name: "foo"},
+			Subpackages: []config.Subpackage{
+				{Name: "foo-empty"},
+				{Name: "foo-static", OmitIfEmpty: true},
+				{Name: "foo-dev", OmitIfEmpty: true},
+				{Name: "foo-doc", OmitIfEmpty: true},
+				{Name: "foo-lang", OmitIfEmpty: true},
+			},
+		},
+	}
+	// foo-static was never created, and foo-doc only has directories.
+	require.NoError(t, os.MkdirAll(filepath.Join(out, "foo-empty"), 0o755))
+	require.NoError(t, os.MkdirAll(filepath.Join(out, "foo-dev", "usr", "include"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(out, "foo-dev", "usr", "include", "foo.h"), nil, 0o644))
+	require.NoError(t, os.MkdirAll(filepath.Join(out, "foo-doc", "usr", "share", "doc"), 0o755))
+	require.NoError(t, os.MkdirAll(filepath.Join(out, "foo-lang", "usr", "share"), 0o755))
+	require.NoError(t, os.Symlink("../../foo", filepath.Join(out, "foo-lang", "usr", "share", "locale")))
+
+	require.NoError(t, b.omitEmptySubpackages(ctx))
+	var names []string
+	for _, sp := range b.Configuration.Subpackages {
+		names = append(names, sp.Name)
+	}
+	require.Equal(t, []string{"foo-empty", "foo-dev", "foo-lang"}, names)
+}
@@ -62,12 +62,17 @@ type Test struct {
 	ApkCacheDir       string
 	CacheSource       string
 	EnvFile           string
+	Strict            bool
+	AllowedEnv        []string
 	Runner            container.Runner
 	Debug             bool
 	DebugRunner       bool
 	Interactive       bool
 	Auth              map[string]options.Auth
 	IgnoreSignatures  bool
+
+	// Where pipelines from git repositories are cached.
+	RemotePipelineCacheDir string
 }
 
 func NewTest(ctx context.Context, opts ...TestOption) (*Test, error) {
@@ -109,6 +114,8 @@ func NewTest(ctx context.Context, opts ...TestOption) (*Test, error) {
 
 	parsedCfg, err := config.ParseConfiguration(ctx, t.ConfigFile,
 		config.WithEnvFileForParsing(t.EnvFile),
+		config.WithStrict(t.Strict),
+		config.WithAllowedEnv(t.AllowedEnv),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load configuration: %w", err)
@@ -340,6 +347,15 @@ func (t *Test) TestPackage(ctx context.Context) error {
 
 	pkg := &t.Configuration.Package
 
+	// Leave out the subpackages that aren't built for this architecture.
+	t.Configuration.Subpackages = slices.DeleteFunc(t.Configuration.Subpackages, func(sp config.Subpackage) bool {
+		if sp.BuiltFor(t.Arch) {
+			return false
+		}
+		log.Infof("skipping subpackage %s because it isn't built for %s", sp.Name, t.Arch.ToAPK())
+		return true
+	})
+
 	log.Infof("evaluating pipelines for package requirements")
 	if err := t.Compile(ctx); err != nil {
 		return fmt.Errorf("compiling test pipelines: %w", err)
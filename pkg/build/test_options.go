@@ -62,6 +62,15 @@ func WithTestPipelineDir(pipelineDir string) TestOption {
 	}
 }
 
+// WithTestRemotePipelineCacheDir sets the directory to cache pipelines from
+// git repositories in.
+func WithTestRemotePipelineCacheDir(dir string) TestOption {
+	return func(t *Test) error {
+		t.RemotePipelineCacheDir = dir
+		return nil
+	}
+}
+
 // WithSourceDir sets the source directory to use.
 func WithTestSourceDir(sourceDir string) TestOption {
 	return func(t *Test) error {
@@ -187,6 +196,24 @@ func WithTestEnvFile(envFile string) TestOption {
 	}
 }
 
+// WithTestStrict sets whether to reject misindented keys of the
+// configuration, and unknown fields in the pipelines it uses.
+func WithTestStrict(strict bool) TestOption {
+	return func(t *Test) error {
+		t.Strict = strict
+		return nil
+	}
+}
+
+// WithTestAllowedEnv sets the environment variables that ${{env.*}} can
+// substitute the values of in the configuration.
+func WithTestAllowedEnv(names []string) TestOption {
+	return func(t *Test) error {
+		t.AllowedEnv = names
+		return nil
+	}
+}
+
 func WithTestAuth(domain, user, pass string) TestOption {
 	return func(t *Test) error {
 		if t.Auth == nil {
@@ -0,0 +1,276 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"archive/tar"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+	"chainguard.dev/melange/pkg/container"
+	"github.com/chainguard-dev/clog"
+	"github.com/dustin/go-humanize"
+)
+
+// DefaultToolchainCacheSize is how big the toolchain caches can grow before
+// the least recently used ones are removed.
+const DefaultToolchainCacheSize = "20GB"
+
+// toolchainCacheDir is where toolchain caches are kept in the cache
+// directory.
+const toolchainCacheDir = "toolchains"
+
+// toolchain is a toolchain whose downloads and build outputs are cached
+// across builds.
+type toolchain struct {
+	name string
+	// The file that the package providing the toolchain installs, relative
+	// to the root of the guest.
+	binary string
+	// The environment variables that point the toolchain at its cache,
+	// relative to the cache.
+	env map[string]string
+}
+
+var toolchains = []toolchain{{
+	name:   "go",
+	binary: "usr/bin/go",
+	env: map[string]string{
+		"GOMODCACHE": "mod",
+		"GOCACHE":    "build",
+	},
+}, {
+	name:   "rust",
+	binary: "usr/bin/cargo",
+	env: map[string]string{
+		"CARGO_HOME": "cargo",
+	},
+}}
+
+// toolchainCache is the cache of a toolchain in the guest, which is keyed by
+// the package, and the version of it, that provides the toolchain, so that
+// what one version of a toolchain leaves behind isn't used by another.
+type toolchainCache struct {
+	toolchain toolchain
+	key       string
+}
+
+// dir returns the directory of the cache, relative to the cache directory.
+func (c toolchainCache) dir() string {
+	return path.Join(toolchainCacheDir, c.toolchain.name, c.key)
+}
+
+// environment returns the environment variables that point the toolchain
+// at its cache in the guest.
+func (c toolchainCache) environment() map[string]string {
+	env := map[string]string{}
+	for k, v := range c.toolchain.env {
+		env[k] = path.Join(container.DefaultCacheDir, c.dir(), v)
+	}
+	return env
+}
+
+// guestToolchainCaches returns the caches of the toolchains installed in the
+// guest at fsys.
+func guestToolchainCaches(fsys fs.FS) ([]toolchainCache, error) {
+	f, err := fsys.Open("lib/apk/db/installed")
+	if err != nil {
+		return nil, err
+	}
+	pkgs, err := apk.ParseInstalled(f)
+	if err != nil {
+		return nil, fmt.Errorf("reading installed packages: %w", err)
+	}
+
+	var caches []toolchainCache
+	for _, tc := range toolchains {
+		for _, pkg := range pkgs {
+			if slices.ContainsFunc(pkg.Files, func(h tar.Header) bool { return strings.TrimPrefix(h.Name, "/") == tc.binary }) {
+				caches = append(caches, toolchainCache{toolchain: tc, key: pkg.Name + "-" + pkg.Version})
+				break
+			}
+		}
+	}
+	return caches, nil
+}
+
+// prepareToolchainCaches makes the directory that toolchain caches are kept
+// in, so that the cache directory is there to be mounted into the guest.
+func (b *Build) prepareToolchainCaches() error {
+	if !b.CacheToolchains {
+		return nil
+	}
+	if b.CacheDir == "" {
+		return fmt.Errorf("caching toolchains needs a cache directory")
+	}
+	if err := os.MkdirAll(filepath.Join(b.CacheDir, toolchainCacheDir), 0o755); err != nil {
+		return fmt.Errorf("creating toolchain caches: %w", err)
+	}
+	return nil
+}
+
+// setupToolchainCaches points the toolchains installed in the guest at
+// their caches in the cache directory, after making room for them by
+// removing the least recently used caches of other toolchain versions.
+func (b *Build) setupToolchainCaches(ctx context.Context, cfg *container.Config) error {
+	log := clog.FromContext(ctx)
+
+	if !b.CacheToolchains {
+		return nil
+	}
+
+	caches, err := guestToolchainCaches(os.DirFS(b.GuestDir))
+	if err != nil {
+		return fmt.Errorf("finding toolchains in the guest: %w", err)
+	}
+	if len(caches) == 0 {
+		log.Debugf("no toolchains to cache")
+		return nil
+	}
+	if b.Runner.Name() == container.QemuName {
+		log.Warnf("the %s runner doesn't mount the cache directory, so toolchain caches only last for this build", b.Runner.Name())
+	}
+
+	var inUse []string
+	now := time.Now()
+	for _, c := range caches {
+		dir := filepath.Join(b.CacheDir, filepath.FromSlash(c.dir()))
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("creating %s toolchain cache: %w", c.toolchain.name, err)
+		}
+		// Caches are removed in the order they were last used in.
+		if err := os.Chtimes(dir, now, now); err != nil {
+			return err
+		}
+		inUse = append(inUse, c.dir())
+
+		log.Infof("caching %s toolchain %s in %s", c.toolchain.name, c.key, dir)
+		for k, v := range c.environment() {
+			cfg.Environment[k] = v
+		}
+	}
+
+	limit, err := humanize.ParseBytes(cmp.Or(b.ToolchainCacheSize, DefaultToolchainCacheSize))
+	if err != nil {
+		return fmt.Errorf("parsing toolchain cache size: %w", err)
+	}
+	if err := evictToolchainCaches(ctx, b.CacheDir, limit, inUse); err != nil {
+		log.Warnf("unable to remove old toolchain caches: %v", err)
+	}
+	return nil
+}
+
+// evictToolchainCaches removes the least recently used toolchain caches in
+// cacheDir until they take up no more than limit bytes. The caches in
+// inUse are never removed, even if they're bigger than limit on their own.
+func evictToolchainCaches(ctx context.Context, cacheDir string, limit uint64, inUse []string) error {
+	log := clog.FromContext(ctx)
+
+	type entry struct {
+		dir     string
+		size    uint64
+		modTime time.Time
+	}
+
+	root := filepath.Join(cacheDir, toolchainCacheDir)
+	var entries []entry
+	var total uint64
+	for _, tc := range toolchains {
+		des, err := os.ReadDir(filepath.Join(root, tc.name))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		for _, de := range des {
+			if !de.IsDir() {
+				continue
+			}
+			fi, err := de.Info()
+			if err != nil {
+				return err
+			}
+			dir := path.Join(toolchainCacheDir, tc.name, de.Name())
+			size, err := dirSize(filepath.Join(cacheDir, filepath.FromSlash(dir)))
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry{dir: dir, size: size, modTime: fi.ModTime()})
+			total += size
+		}
+	}
+
+	slices.SortFunc(entries, func(a, b entry) int { return a.modTime.Compare(b.modTime) })
+	for _, e := range entries {
+		if total <= limit {
+			break
+		}
+		if slices.Contains(inUse, e.dir) {
+			continue
+		}
+		log.Infof("removing toolchain cache %s (%s), as toolchain caches are bigger than %s", e.dir, humanize.Bytes(e.size), humanize.Bytes(limit))
+		if err := removeAllWritable(filepath.Join(cacheDir, filepath.FromSlash(e.dir))); err != nil {
+			return err
+		}
+		total -= e.size
+	}
+	return nil
+}
+
+// dirSize returns the total size of the regular files under dir.
+func dirSize(dir string) (uint64, error) {
+	var size uint64
+	err := filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			fi, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += uint64(fi.Size())
+		}
+		return nil
+	})
+	return size, err
+}
+
+// removeAllWritable removes dir, like os.RemoveAll, after making the
+// directories in it writable, as Go makes its module cache read-only.
+func removeAllWritable(dir string) error {
+	if err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return os.Chmod(p, 0o755)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
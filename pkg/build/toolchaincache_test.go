@@ -0,0 +1,107 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestGuestToolchainCaches(t *testing.T) {
+	fsys := fstest.MapFS{
+		"lib/apk/db/installed": &fstest.MapFile{Data: []byte(`P:busybox
+V:1.36.1-r7
+F:bin
+R:sh
+
+P:go-1.22
+V:1.22.5-r0
+F:usr/bin
+R:go
+R:gofmt
+
+P:rust
+V:1.80.0-r1
+F:usr/bin
+R:cargo
+R:rustc
+
+`)},
+	}
+
+	caches, err := guestToolchainCaches(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]map[string]string{}
+	for _, c := range caches {
+		got[c.key] = c.environment()
+	}
+	want := map[string]map[string]string{
+		"go-1.22-1.22.5-r0": {
+			"GOMODCACHE": "/var/cache/melange/toolchains/go/go-1.22-1.22.5-r0/mod",
+			"GOCACHE":    "/var/cache/melange/toolchains/go/go-1.22-1.22.5-r0/build",
+		},
+		"rust-1.80.0-r1": {
+			"CARGO_HOME": "/var/cache/melange/toolchains/rust/rust-1.80.0-r1/cargo",
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("caches mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestEvictToolchainCaches(t *testing.T) {
+	cacheDir := t.TempDir()
+	now := time.Now()
+	for i, dir := range []string{"go/go-1.21-1.21.0-r0", "rust/rust-1.79.0-r0", "go/go-1.22-1.22.5-r0"} {
+		p := filepath.Join(cacheDir, "toolchains", dir)
+		if err := os.MkdirAll(filepath.Join(p, "mod"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(p, "mod", "data"), make([]byte, 1000), 0o444); err != nil {
+			t.Fatal(err)
+		}
+		// Go makes its module cache read-only.
+		if err := os.Chmod(filepath.Join(p, "mod"), 0o555); err != nil {
+			t.Fatal(err)
+		}
+		mtime := now.Add(time.Duration(i) * time.Hour)
+		if err := os.Chtimes(p, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The oldest cache is in use, so the next oldest is removed instead.
+	if err := evictToolchainCaches(context.Background(), cacheDir, 2000, []string{"toolchains/go/go-1.21-1.21.0-r0"}); err != nil {
+		t.Fatal(err)
+	}
+	for dir, want := range map[string]bool{
+		"go/go-1.21-1.21.0-r0": true,
+		"rust/rust-1.79.0-r0":  false,
+		"go/go-1.22-1.22.5-r0": true,
+	} {
+		_, err := os.Stat(filepath.Join(cacheDir, "toolchains", dir))
+		if got := err == nil; got != want {
+			t.Errorf("%s: want exists %t, got %t", dir, want, got)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The attributes of the spans of a build.
+const (
+	attrPackage    = attribute.Key("melange.package")
+	attrVersion    = attribute.Key("melange.version")
+	attrArch       = attribute.Key("melange.arch")
+	attrSubpackage = attribute.Key("melange.subpackage")
+	attrStepUses   = attribute.Key("melange.step.uses")
+	attrStepID     = attribute.Key("melange.step.id")
+)
+
+// endSpan ends span, marking it as failed if err isn't nil.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -36,9 +36,15 @@ type apkbuildOptions struct {
 func ApkBuild() *cobra.Command {
 	o := &apkbuildOptions{}
 	cmd := &cobra.Command{
-		Use:     "apkbuild",
-		Short:   "Converts an APKBUILD package into a melange.yaml",
-		Long:    `Converts an APKBUILD package into a melange.yaml.`,
+		Use:   "apkbuild",
+		Short: "Converts an APKBUILD package into a melange.yaml",
+		Long: `Converts an APKBUILD package into a melange.yaml.
+
+The split functions of the subpackages are translated into their pipelines,
+the install scripts and triggers next to the APKBUILD are carried over as
+scriptlets, and the sources are fetched to check them against the APKBUILD's
+sha512sums. The secfixes of the APKBUILD are written as the advisories of the
+package, to <package>.advisories.yaml.`,
 		Example: `  convert apkbuild libx11`,
 		Args:    cobra.MinimumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
@@ -22,21 +22,23 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
 	"time"
 
 	apko_types "chainguard.dev/apko/pkg/build/types"
 	"chainguard.dev/melange/pkg/build"
+	"chainguard.dev/melange/pkg/config"
 	"chainguard.dev/melange/pkg/container"
 	"chainguard.dev/melange/pkg/container/dagger"
 	"chainguard.dev/melange/pkg/container/docker"
 	"chainguard.dev/melange/pkg/linter"
+	"chainguard.dev/melange/pkg/progress"
+	"chainguard.dev/melange/pkg/sbom"
 	"github.com/chainguard-dev/clog"
 	"github.com/go-git/go-git/v5"
 	"github.com/spf13/cobra"
 	"go.opentelemetry.io/otel"
-	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
-	"go.opentelemetry.io/otel/sdk/trace"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -46,6 +48,22 @@ func buildCmd() *cobra.Command {
 	var buildDate string
 	var workspaceDir string
 	var pipelineDir string
+	var remotePipelineCacheDir string
+	var secrets, secretEnvs []string
+	var hermetic bool
+	var egressAllow []string
+	var egressReport string
+	var cacheCompiler string
+	var cacheToolchains bool
+	var toolchainCacheSize string
+	var cacheGuest bool
+	var guestCacheSize string
+	var preBuildHooks, postBuildHooks []string
+	var mounts []string
+	var defaultSplits []string
+	var sbomFormats []string
+	var workspaceUsage bool
+	var workspaceQuota string
 	var sourceDir string
 	var cacheDir string
 	var cacheSource string
@@ -62,6 +80,8 @@ func buildCmd() *cobra.Command {
 	var dependencyLog string
 	var overlayBinSh string
 	var envFile string
+	var strict bool
+	var allowEnv []string
 	var varsFile string
 	var purlNamespace string
 	var buildOption []string
@@ -69,6 +89,11 @@ func buildCmd() *cobra.Command {
 	var debug bool
 	var debugRunner bool
 	var interactive bool
+	var breakBefore, breakAfter []string
+	var resume bool
+	var stepLogs bool
+	var showProgress bool
+	var eventsFile string
 	var remove bool
 	var runner string
 	var cpu, cpumodel, memory, disk string
@@ -76,6 +101,11 @@ func buildCmd() *cobra.Command {
 	var extraPackages []string
 	var libc string
 	var lintRequire, lintWarn []string
+	var lintReports map[string]string
+	var lintPlugins map[string]string
+	var lintBaseline string
+	var lintPolicy string
+	var lintFix bool
 	var ignoreSignatures bool
 	var cleanup bool
 	var configFileGitCommit string
@@ -83,6 +113,7 @@ func buildCmd() *cobra.Command {
 	var configFileLicense string
 
 	var traceFile string
+	var otlpEndpoint string
 
 	cmd := &cobra.Command{
 		Use:     "build",
@@ -99,28 +130,34 @@ func buildCmd() *cobra.Command {
 				buildConfigFilePath = args[0] // e.g. "crane.yaml"
 			}
 
-			if traceFile != "" {
-				w, err := os.Create(traceFile)
-				if err != nil {
-					return fmt.Errorf("creating trace file: %w", err)
-				}
-				defer w.Close()
-				exporter, err := stdouttrace.New(stdouttrace.WithWriter(w))
-				if err != nil {
-					return fmt.Errorf("creating stdout exporter: %w", err)
-				}
-				tp := trace.NewTracerProvider(trace.WithBatcher(exporter))
-				otel.SetTracerProvider(tp)
-
-				defer func() {
-					if err := tp.Shutdown(context.WithoutCancel(ctx)); err != nil {
-						log.Errorf("shutting down trace provider: %v", err)
-					}
-				}()
-
-				tctx, span := otel.Tracer("melange").Start(ctx, "build")
-				defer span.End()
-				ctx = tctx
+			ctx, stopTracing, err := startTracing(ctx, traceFile, otlpEndpoint)
+			if err != nil {
+				return err
+			}
+			defer stopTracing()
+			ctx, span := otel.Tracer("melange").Start(ctx, "build")
+			defer span.End()
+
+			if err := checkLintReports(lintReports); err != nil {
+				return err
+			}
+			lintReport := &linter.Report{}
+			baseline, err := loadLintBaseline(lintBaseline)
+			if err != nil {
+				return err
+			}
+			policy, err := loadLintPolicy(lintPolicy)
+			if err != nil {
+				return err
+			}
+			lintRequire, lintWarn = policy.Apply(lintRequire, lintWarn)
+
+			gc, err := loadGlobalConfig()
+			if err != nil {
+				return err
+			}
+			if !cmd.Flags().Changed("default-splits") {
+				defaultSplits = gc.DefaultSplits
 			}
 
 			r, err := getRunner(ctx, runner, remove)
@@ -147,6 +184,31 @@ func buildCmd() *cobra.Command {
 				configFileGitRepoURL = "https://unknown/unknown/unknown"
 			}
 
+			var buildSecrets []build.Secret
+			for _, s := range secrets {
+				secret, err := build.ParseSecret(s, false)
+				if err != nil {
+					return err
+				}
+				buildSecrets = append(buildSecrets, secret)
+			}
+			for _, s := range secretEnvs {
+				secret, err := build.ParseSecret(s, true)
+				if err != nil {
+					return err
+				}
+				buildSecrets = append(buildSecrets, secret)
+			}
+
+			var buildMounts []container.BindMount
+			for _, s := range mounts {
+				m, err := build.ParseMount(s)
+				if err != nil {
+					return err
+				}
+				buildMounts = append(buildMounts, m)
+			}
+
 			archs := apko_types.ParseArchitectures(archstrs)
 			options := []build.Option{
 				build.WithBuildDate(buildDate),
@@ -155,6 +217,19 @@ func buildCmd() *cobra.Command {
 				// builtin pipelines.
 				build.WithPipelineDir(pipelineDir),
 				build.WithPipelineDir(BuiltinPipelineDir),
+				build.WithRemotePipelineCacheDir(remotePipelineCacheDir),
+				build.WithSecrets(buildSecrets),
+				build.WithHermetic(hermetic),
+				build.WithEgressAllow(egressAllow),
+				build.WithEgressReport(egressReport),
+				build.WithCompilerCache(cacheCompiler),
+				build.WithCacheToolchains(cacheToolchains, toolchainCacheSize),
+				build.WithCacheGuest(cacheGuest, guestCacheSize),
+				build.WithMounts(buildMounts),
+				build.WithDefaultSplits(defaultSplits),
+				build.WithSBOMFormats(sbomFormats),
+				build.WithWorkspaceUsage(workspaceUsage, workspaceQuota),
+				build.WithHooks(append(gc.Hooks.PreBuild, preBuildHooks...), append(gc.Hooks.PostBuild, postBuildHooks...)),
 				build.WithCacheDir(cacheDir),
 				build.WithCacheSource(cacheSource),
 				build.WithPackageCacheDir(apkCacheDir),
@@ -170,17 +245,27 @@ func buildCmd() *cobra.Command {
 				build.WithBinShOverlay(overlayBinSh),
 				build.WithStripOriginName(stripOriginName),
 				build.WithEnvFile(envFile),
+				build.WithStrict(strict),
+				build.WithAllowedEnv(allowEnv),
 				build.WithVarsFile(varsFile),
 				build.WithNamespace(purlNamespace),
 				build.WithEnabledBuildOptions(buildOption),
 				build.WithCreateBuildLog(createBuildLog),
 				build.WithDebug(debug),
 				build.WithDebugRunner(debugRunner),
-				build.WithInteractive(interactive),
+				build.WithInteractive(interactive || len(breakBefore) != 0 || len(breakAfter) != 0),
+				build.WithBreakpoints(breakBefore, breakAfter),
+				build.WithResume(resume),
+				build.WithStepLogs(stepLogs),
+				build.WithEventsFile(eventsFile),
 				build.WithRemove(remove),
 				build.WithRunner(r),
 				build.WithLintRequire(lintRequire),
 				build.WithLintWarn(lintWarn),
+				build.WithLintReport(lintReport),
+				build.WithLintPlugins(lintPlugins),
+				build.WithLintFix(lintFix),
+				build.WithLintBaseline(baseline),
 				build.WithCPU(cpu),
 				build.WithCPUModel(cpumodel),
 				build.WithDisk(disk),
@@ -216,13 +301,58 @@ func buildCmd() *cobra.Command {
 				options = append(options, build.WithAuth(domain, user, pass))
 			}
 
-			return BuildCmd(ctx, archs, options...)
+			if showProgress && (interactive || len(breakBefore) != 0 || len(breakAfter) != 0) {
+				return errors.New("--progress can't be used with --interactive or breakpoints")
+			}
+			if showProgress && !progress.IsTerminal(os.Stderr) {
+				log.Warnf("not showing progress, as stderr isn't a terminal")
+				showProgress = false
+			}
+			var display *progress.Display
+			if showProgress {
+				display = progress.New(os.Stderr)
+				options = append(options, build.WithEventHandler(display.Handle))
+
+				prev := slog.Default()
+				slog.SetDefault(slog.New(display.Handler(prev.Handler())))
+				ctx = clog.WithLogger(ctx, clog.New(slog.Default().Handler()))
+				defer slog.SetDefault(prev)
+				display.Start()
+			}
+
+			buildErr := BuildCmd(ctx, archs, options...)
+			if display != nil {
+				display.Stop()
+			}
+			if len(lintReports) == 0 {
+				return buildErr
+			}
+			// Lint findings are most useful when the build failed, so write
+			// the reports regardless.
+			return errors.Join(buildErr, writeLintReports(lintReport, lintReports))
 		},
 	}
 
 	cmd.Flags().StringVar(&buildDate, "build-date", "", "date used for the timestamps of the files inside the image")
 	cmd.Flags().StringVar(&workspaceDir, "workspace-dir", "", "directory used for the workspace at /home/build")
 	cmd.Flags().StringVar(&pipelineDir, "pipeline-dir", "", "directory used to extend defined built-in pipelines")
+	cmd.Flags().StringVar(&remotePipelineCacheDir, "remote-pipeline-cache-dir", "", "directory used to cache pipelines from git repositories (defaults to the user's cache directory)")
+	cmd.Flags().BoolVar(&hermetic, "hermetic", false, "disable the network once the fetch and git-checkout steps of the main pipeline have run")
+	cmd.Flags().StringSliceVar(&egressAllow, "egress-allow", nil, "hosts, wildcard domains or CIDR blocks that the build can connect to, in addition to its egress allowlist")
+	cmd.Flags().StringVar(&egressReport, "egress-report", "", "file to write a JSON report of the hosts a build with an egress allowlist tried to connect to")
+	cmd.Flags().StringVar(&cacheCompiler, "cache-compiler", "", "cache C/C++ compiler output in the cache directory with ccache or sccache")
+	cmd.Flags().BoolVar(&cacheToolchains, "cache-toolchains", false, "cache the downloads and build outputs of the Go and Rust toolchains in the cache directory, for each toolchain version")
+	cmd.Flags().StringVar(&toolchainCacheSize, "toolchain-cache-size", build.DefaultToolchainCacheSize, "how big the toolchain caches can grow before the least recently used are removed")
+	cmd.Flags().BoolVar(&cacheGuest, "cache-guest", false, "cache the build environment by the packages it resolves to, so that builds with the same environment don't install it again")
+	cmd.Flags().StringVar(&guestCacheSize, "guest-cache-size", build.DefaultGuestCacheSize, "how big the cache of build environments can grow before the least recently used are removed")
+	cmd.Flags().StringArrayVar(&mounts, "mount", nil, "host directory to mount into the guest, as host=<path>,dest=<path>, with ,ro after them to mount it read-only; may be repeated")
+	cmd.Flags().StringSliceVar(&defaultSplits, "default-splits", nil, fmt.Sprintf("kinds of subpackages to split from every package that doesn't set no-default-splits, when anything is split into them (kinds: %q; defaults to default-splits in the melange config)", config.DefaultSplitKinds()))
+	cmd.Flags().BoolVar(&workspaceUsage, "workspace-usage", false, "report how much each step grows the workspace by at the end of the build")
+	cmd.Flags().StringVar(&workspaceQuota, "workspace-quota", "", "how much disk the workspace can use, such as 20GB, before the step that takes it over fails")
+	cmd.Flags().StringArrayVar(&preBuildHooks, "pre-build-hook", nil, "command to run on the host with sh -c before each build, after those in the melange config file; the build fails if it does")
+	cmd.Flags().StringArrayVar(&postBuildHooks, "post-build-hook", nil, "command to run on the host with sh -c after each build, even if it failed, after those in the melange config file")
+	cmd.Flags().StringArrayVar(&secrets, "secret", nil, "secret to make available to steps in /run/secrets, as <name>=<path> or <name>=env:<variable>")
+	cmd.Flags().StringArrayVar(&secretEnvs, "secret-env", nil, "secret to make available to steps in /run/secrets and as an environment variable, as <name>=<path> or <name>=env:<variable>")
 	cmd.Flags().StringVar(&sourceDir, "source-dir", "", "directory used for included sources")
 	cmd.Flags().StringVar(&cacheDir, "cache-dir", "./melange-cache/", "directory used for cached inputs")
 	cmd.Flags().StringVar(&cacheSource, "cache-source", "", "directory or bucket used for preloading the cache")
@@ -231,6 +361,8 @@ func buildCmd() *cobra.Command {
 	cmd.Flags().StringVar(&signingKey, "signing-key", "", "key to use for signing")
 	cmd.Flags().StringVar(&envFile, "env-file", "", "file to use for preloaded environment variables")
 	cmd.Flags().StringVar(&varsFile, "vars-file", "", "file to use for preloaded build configuration variables")
+	cmd.Flags().BoolVar(&strict, "strict", false, "reject misindented keys of the configuration, which have no value but are followed by other keys, and unknown fields in the pipelines it uses")
+	cmd.Flags().StringSliceVar(&allowEnv, "allow-env", []string{}, "environment variables that ${{env.NAME}} can substitute the values of in the configuration")
 	cmd.Flags().BoolVar(&generateIndex, "generate-index", true, "whether to generate APKINDEX.tar.gz")
 	cmd.Flags().BoolVar(&emptyWorkspace, "empty-workspace", false, "whether the build workspace should be empty")
 	cmd.Flags().BoolVar(&stripOriginName, "strip-origin-name", false, "whether origin names should be stripped (for bootstrap)")
@@ -238,6 +370,7 @@ func buildCmd() *cobra.Command {
 	cmd.Flags().StringVar(&dependencyLog, "dependency-log", "", "log dependencies to a specified file")
 	cmd.Flags().StringVar(&overlayBinSh, "overlay-binsh", "", "use specified file as /bin/sh overlay in build environment")
 	cmd.Flags().StringVar(&purlNamespace, "namespace", "unknown", "namespace to use in package URLs in SBOM (eg wolfi, alpine)")
+	cmd.Flags().StringSliceVar(&sbomFormats, "sbom-format", []string{sbom.FormatSPDX}, fmt.Sprintf("formats to write the SBOMs of the packages in, in /var/lib/db/sbom (formats: %q)", sbom.Formats()))
 	cmd.Flags().StringSliceVar(&archstrs, "arch", nil, "architectures to build for (e.g., x86_64,ppc64le,arm64) -- default is all, unless specified in config")
 	cmd.Flags().StringVar(&libc, "override-host-triplet-libc-substitution-flavor", "gnu", "override the flavor of libc for ${{host.triplet.*}} substitutions (e.g. gnu,musl) -- default is gnu")
 	cmd.Flags().StringSliceVar(&buildOption, "build-option", []string{}, "build options to enable")
@@ -249,15 +382,27 @@ func buildCmd() *cobra.Command {
 	cmd.Flags().BoolVar(&debug, "debug", false, "enables debug logging of build pipelines")
 	cmd.Flags().BoolVar(&debugRunner, "debug-runner", false, "when enabled, the builder pod will persist after the build succeeds or fails")
 	cmd.Flags().BoolVarP(&interactive, "interactive", "i", false, "when enabled, attaches stdin with a tty to the pod on failure")
+	cmd.Flags().StringSliceVar(&breakBefore, "break-before", nil, "names, ids or uses of steps to pause the build before, with a shell in the pod; implies --interactive")
+	cmd.Flags().BoolVar(&resume, "resume", false, "snapshot the workspace after each step of the main pipeline, and resume from the last snapshot if the previous build with --resume failed")
+	cmd.Flags().BoolVar(&showProgress, "progress", false, "show the steps that are running, how long they have taken, the status of subpackages and the tail of the log, in place of the whole log")
+	cmd.Flags().BoolVar(&stepLogs, "step-logs", false, "write the stdout and stderr of each step to files of their own, under logs/<arch>/<package> in the output directory")
+	cmd.Flags().StringVar(&eventsFile, "events", "", "append a stream of JSON events for the build and each of its steps to this file, or - for stdout")
+	cmd.Flags().StringSliceVar(&breakAfter, "break-after", nil, "names, ids or uses of steps to pause the build after, with a shell in the pod; implies --interactive")
 	cmd.Flags().BoolVar(&remove, "rm", true, "clean up intermediate artifacts (e.g. container images, temp dirs)")
-	cmd.Flags().StringVar(&cpu, "cpu", "", "default CPU resources to use for builds")
+	cmd.Flags().StringVar(&cpu, "cpu", "", "number of CPUs builds can use, such as 4 or 1.5, over the config's package.resources.cpu")
 	cmd.Flags().StringVar(&cpumodel, "cpumodel", "host", "default memory resources to use for builds")
 	cmd.Flags().StringVar(&disk, "disk", "", "disk size to use for builds")
-	cmd.Flags().StringVar(&memory, "memory", "", "default memory resources to use for builds")
+	cmd.Flags().StringVar(&memory, "memory", "", "memory builds can use, such as 8Gi, over the config's package.resources.memory")
 	cmd.Flags().DurationVar(&timeout, "timeout", 0, "default timeout for builds")
 	cmd.Flags().StringVar(&traceFile, "trace", "", "where to write trace output")
+	cmd.Flags().StringVar(&otlpEndpoint, "otlp-endpoint", "", "export trace spans to this OTLP/HTTP endpoint, such as http://localhost:4318 (defaults to $OTEL_EXPORTER_OTLP_TRACES_ENDPOINT or $OTEL_EXPORTER_OTLP_ENDPOINT, if set)")
 	cmd.Flags().StringSliceVar(&lintRequire, "lint-require", linter.DefaultRequiredLinters(), "linters that must pass")
 	cmd.Flags().StringSliceVar(&lintWarn, "lint-warn", linter.DefaultWarnLinters(), "linters that will generate warnings")
+	cmd.Flags().StringToStringVar(&lintReports, "lint-report", nil, fmt.Sprintf("write linter findings to files, as format=path (formats: %q)", linter.ReportFormats()))
+	cmd.Flags().BoolVar(&lintFix, "lint-fix", false, "fix the problems linters can fix (setuid/setgid bits, files in temp dirs and /var/empty, libtool archives) instead of failing")
+	cmd.Flags().StringVar(&lintBaseline, "lint-baseline", "", "YAML file listing known linter findings, which are reported as warnings rather than errors")
+	cmd.Flags().StringToStringVar(&lintPlugins, "lint-plugin", nil, "run an external program as a linter, as name=program")
+	cmd.Flags().StringVar(&lintPolicy, "lint-policy", "", "YAML file deciding which linters are enforced, only warn or are skipped, on top of --lint-require and --lint-warn")
 	cmd.Flags().BoolVar(&ignoreSignatures, "ignore-signatures", false, "ignore repository signature verification")
 	cmd.Flags().BoolVar(&cleanup, "cleanup", true, "when enabled, the temp dir used for the guest will be cleaned up after completion")
 	cmd.Flags().StringVar(&configFileGitCommit, "git-commit", "", "commit hash of the git repository containing the build config file (defaults to detecting HEAD)")
@@ -330,20 +475,41 @@ func BuildCmd(ctx context.Context, archs []apko_types.Architecture, baseOpts ...
 	//
 	// Yes, this happens.  Really.
 	// https://github.com/distroless/nginx/runs/7219233843?check_suite_focus=true
+	combos, err := build.MatrixCombinations(ctx, baseOpts...)
+	if err != nil {
+		return err
+	}
+	if combos == nil {
+		combos = []map[string]string{nil}
+	}
+
 	bcs := []*build.Build{}
 	for _, arch := range archs {
-		opts := append(baseOpts, build.WithArch(arch))
-
-		bc, err := build.New(ctx, opts...)
-		if errors.Is(err, build.ErrSkipThisArch) {
-			log.Warnf("skipping arch %s", arch)
-			continue
-		} else if err != nil {
-			return err
-		}
-		defer bc.Close(ctx)
+		// Which matrix combination produces each package, so that
+		// combinations don't overwrite each other's packages.
+		producers := map[string]string{}
+
+		for _, combo := range combos {
+			opts := slices.Concat(baseOpts, []build.Option{build.WithArch(arch), build.WithMatrix(combo)})
+
+			bc, err := build.New(ctx, opts...)
+			if errors.Is(err, build.ErrSkipThisArch) {
+				log.Warnf("skipping arch %s", arch)
+				continue
+			} else if err != nil {
+				return err
+			}
+			defer bc.Close(ctx)
+
+			for name := range bc.Configuration.AllPackageNames() {
+				if other, ok := producers[name]; ok {
+					return fmt.Errorf("matrix combinations %q and %q both produce package %q", other, config.MatrixName(combo), name)
+				}
+				producers[name] = config.MatrixName(combo)
+			}
 
-		bcs = append(bcs, bc)
+			bcs = append(bcs, bc)
+		}
 	}
 
 	if len(bcs) == 0 {
@@ -365,6 +531,9 @@ func BuildCmd(ctx context.Context, archs []apko_types.Architecture, baseOpts ...
 			lctx := ctx
 			if len(bcs) != 1 {
 				log := clog.New(slog.Default().Handler()).With("arch", bc.Arch.ToAPK())
+				if bc.Matrix != nil {
+					log = log.With("matrix", config.MatrixName(bc.Matrix))
+				}
 				lctx = clog.WithLogger(ctx, log)
 			}
 
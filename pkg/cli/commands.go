@@ -56,12 +56,15 @@ func New() *cobra.Command {
 	cmd.AddCommand(completion())
 	cmd.AddCommand(compile())
 	cmd.AddCommand(convert())
+	cmd.AddCommand(cycles())
+	cmd.AddCommand(fmtCmd())
 	cmd.AddCommand(indexCmd())
 	cmd.AddCommand(keygen())
 	cmd.AddCommand(lint())
 	cmd.AddCommand(packageVersion())
 	cmd.AddCommand(query())
 	cmd.AddCommand(scan())
+	cmd.AddCommand(schemaCmd())
 	cmd.AddCommand(signCmd())
 	cmd.AddCommand(signIndex())
 	cmd.AddCommand(test())
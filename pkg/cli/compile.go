@@ -33,6 +33,7 @@ func compile() *cobra.Command {
 	var buildDate string
 	var workspaceDir string
 	var pipelineDir string
+	var remotePipelineCacheDir string
 	var sourceDir string
 	var cacheDir string
 	var cacheSource string
@@ -49,6 +50,8 @@ func compile() *cobra.Command {
 	var dependencyLog string
 	var overlayBinSh string
 	var envFile string
+	var strict bool
+	var allowEnv []string
 	var varsFile string
 	var purlNamespace string
 	var buildOption []string
@@ -82,6 +85,7 @@ func compile() *cobra.Command {
 				// builtin pipelines.
 				build.WithPipelineDir(pipelineDir),
 				build.WithPipelineDir(BuiltinPipelineDir),
+				build.WithRemotePipelineCacheDir(remotePipelineCacheDir),
 				build.WithCacheDir(cacheDir),
 				build.WithCacheSource(cacheSource),
 				build.WithPackageCacheDir(apkCacheDir),
@@ -97,6 +101,8 @@ func compile() *cobra.Command {
 				build.WithBinShOverlay(overlayBinSh),
 				build.WithStripOriginName(stripOriginName),
 				build.WithEnvFile(envFile),
+				build.WithStrict(strict),
+				build.WithAllowedEnv(allowEnv),
 				build.WithVarsFile(varsFile),
 				build.WithNamespace(purlNamespace),
 				build.WithEnabledBuildOptions(buildOption),
@@ -145,6 +151,7 @@ func compile() *cobra.Command {
 	cmd.Flags().StringVar(&buildDate, "build-date", "", "date used for the timestamps of the files inside the image")
 	cmd.Flags().StringVar(&workspaceDir, "workspace-dir", "", "directory used for the workspace at /home/build")
 	cmd.Flags().StringVar(&pipelineDir, "pipeline-dir", "", "directory used to extend defined built-in pipelines")
+	cmd.Flags().StringVar(&remotePipelineCacheDir, "remote-pipeline-cache-dir", "", "directory used to cache pipelines from git repositories (defaults to the user's cache directory)")
 	cmd.Flags().StringVar(&sourceDir, "source-dir", "", "directory used for included sources")
 	cmd.Flags().StringVar(&cacheDir, "cache-dir", "./melange-cache/", "directory used for cached inputs")
 	cmd.Flags().StringVar(&cacheSource, "cache-source", "", "directory or bucket used for preloading the cache")
@@ -153,6 +160,8 @@ func compile() *cobra.Command {
 	cmd.Flags().StringVar(&signingKey, "signing-key", "", "key to use for signing")
 	cmd.Flags().StringVar(&envFile, "env-file", "", "file to use for preloaded environment variables")
 	cmd.Flags().StringVar(&varsFile, "vars-file", "", "file to use for preloaded build configuration variables")
+	cmd.Flags().BoolVar(&strict, "strict", false, "reject misindented keys of the configuration, which have no value but are followed by other keys, and unknown fields in the pipelines it uses")
+	cmd.Flags().StringSliceVar(&allowEnv, "allow-env", []string{}, "environment variables that ${{env.NAME}} can substitute the values of in the configuration")
 	cmd.Flags().BoolVar(&generateIndex, "generate-index", true, "whether to generate APKINDEX.tar.gz")
 	cmd.Flags().BoolVar(&emptyWorkspace, "empty-workspace", false, "whether the build workspace should be empty")
 	cmd.Flags().BoolVar(&stripOriginName, "strip-origin-name", false, "whether origin names should be stripped (for bootstrap)")
@@ -76,8 +76,10 @@ func convert() *cobra.Command {
 
 	cmd.AddCommand(
 		ApkBuild(),
+		DebianBuild(),
 		GemBuild(),
 		PythonBuild(),
+		RPMBuild(),
 	)
 	return cmd
 }
@@ -0,0 +1,76 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/chainguard-dev/clog"
+	"github.com/spf13/cobra"
+
+	"chainguard.dev/melange/pkg/graph"
+)
+
+func cycles() *cobra.Command {
+	var pipelineDirs []string
+	cmd := &cobra.Command{
+		Use:   "cycles [dir]",
+		Short: "Find cycles of build dependencies between Melange YAML files",
+		Long: `Find cycles of build dependencies between Melange YAML files.
+
+All the configurations in the directory, or the current one, are loaded, and
+each depends on those that build the packages in its build environment, or
+that its pipelines need, including the pipelines that they use, as their
+package or subpackage, or that provide them.
+A cycle is printed for each group of configurations that depend on each other,
+with the package that each needs from the next in parentheses after it, and
+the command fails if there are any.`,
+		Example: `  melange cycles
+  melange cycles ./os`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := "."
+			if len(args) > 0 {
+				dir = args[0]
+			}
+			return CyclesCmd(cmd.Context(), os.Stdout, dir, pipelineDirs)
+		},
+	}
+	cmd.Flags().StringSliceVar(&pipelineDirs, "pipeline-dirs", []string{}, "directories used to extend defined built-in pipelines")
+	return cmd
+}
+
+// CyclesCmd prints the cycles of build dependencies between the
+// configurations in the directory dir to w, and fails if there are any. The
+// pipelines that they use are looked up in pipelineDirs first.
+func CyclesCmd(ctx context.Context, w io.Writer, dir string, pipelineDirs []string) error {
+	g, err := graph.Load(ctx, dir, pipelineDirs)
+	if err != nil {
+		return err
+	}
+
+	cycles := g.Cycles()
+	if len(cycles) == 0 {
+		clog.FromContext(ctx).Infof("no cycles of build dependencies in %s", dir)
+		return nil
+	}
+	for _, c := range cycles {
+		fmt.Fprintln(w, c)
+	}
+	return fmt.Errorf("found %d cycles of build dependencies", len(cycles))
+}
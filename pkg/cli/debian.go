@@ -0,0 +1,70 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"context"
+
+	"chainguard.dev/melange/pkg/convert/debian"
+
+	"github.com/chainguard-dev/clog"
+	"github.com/spf13/cobra"
+)
+
+type debianOptions struct {
+	outDir                 string
+	additionalRepositories []string
+	additionalKeyrings     []string
+}
+
+// DebianBuild is the top-level `convert debian` cobra command
+func DebianBuild() *cobra.Command {
+	o := &debianOptions{}
+	cmd := &cobra.Command{
+		Use:   "debian",
+		Short: "Converts a Debian source package into a melange.yaml",
+		Long: `Converts a Debian source package, unpacked with its debian directory, into a melange.yaml.
+
+The build dependencies, patches, build system and upstream source are mapped
+from debian/control, debian/patches, debian/rules and debian/watch, as best
+they can be, and whatever can't be worked out is left as FIXME. The patches
+are copied into a directory named after the package, next to the melange.yaml.`,
+		Example: `
+# Convert the source package unpacked by apt-get source hello
+convert debian hello-2.10`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var err error
+			o.outDir, o.additionalRepositories, o.additionalKeyrings, err = getCommonValues(cmd, true)
+			if err != nil {
+				return err
+			}
+			return o.debianBuild(cmd.Context(), args[0])
+		},
+	}
+	return cmd
+}
+
+func (o debianOptions) debianBuild(ctx context.Context, dir string) error {
+	c := debian.Context{
+		OutDir:                 o.outDir,
+		AdditionalRepositories: o.additionalRepositories,
+		AdditionalKeyrings:     o.additionalKeyrings,
+	}
+
+	clog.FromContext(ctx).Infof("generating convert config file for Debian source package %s", dir)
+
+	return c.Generate(ctx, dir)
+}
@@ -0,0 +1,144 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/chainguard-dev/clog"
+	"github.com/chainguard-dev/yam/pkg/yam/formatted"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"chainguard.dev/melange/pkg/config"
+)
+
+// defaultFormatOptions are how configurations are formatted when there's no
+// .yam.yaml in the current directory.
+var defaultFormatOptions = formatted.EncodeOptions{
+	Indent:         2,
+	GapExpressions: []string{".", ".data", ".pipeline", ".subpackages"},
+}
+
+func fmtCmd() *cobra.Command {
+	var check bool
+	cmd := &cobra.Command{
+		Use:   "fmt",
+		Short: "Rewrite Melange YAML files in their canonical format",
+		Long: `Rewrite Melange YAML files in their canonical format.
+
+The keys of each mapping are put in their canonical order, with those that
+melange doesn't know last, and the scripts that pipelines run become literal
+blocks, unless they have trailing whitespace that a literal block can't hold.
+The files are indented, and their lists
+sorted and separated by blank lines, as the .yam.yaml file in the current
+directory says, if there is one, or with an indentation of two spaces and
+blank lines between the top-level keys, the steps of the pipeline and the
+subpackages otherwise. Comments are kept with the keys that they are on.
+
+With --check, the files are left as they are, and the command fails if any
+of them isn't formatted.`,
+		Example: `  melange fmt crane.yaml
+  melange fmt --check *.yaml`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return FormatCmd(cmd.Context(), check, args...)
+		},
+	}
+	cmd.Flags().BoolVar(&check, "check", false, "only check that the files are formatted, without rewriting them")
+	return cmd
+}
+
+// FormatCmd formats the configuration files, or with check, fails if any of
+// them isn't formatted.
+func FormatCmd(ctx context.Context, check bool, files ...string) error {
+	log := clog.FromContext(ctx)
+
+	opts := defaultFormatOptions
+	if o, err := formatted.ReadConfig(); err == nil {
+		opts = *o
+	}
+
+	var unformatted []string
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		out, err := formatConfig(data, opts)
+		if err != nil {
+			return fmt.Errorf("formatting %s: %w", file, err)
+		}
+		if bytes.Equal(data, out) {
+			continue
+		}
+
+		if check {
+			log.Infof("%s is not formatted", file)
+			unformatted = append(unformatted, file)
+			continue
+		}
+		fi, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(file, out, fi.Mode().Perm()); err != nil {
+			return err
+		}
+		log.Infof("formatted %s", file)
+	}
+
+	if len(unformatted) > 0 {
+		return fmt.Errorf("not formatted: %s", strings.Join(unformatted, ", "))
+	}
+	return nil
+}
+
+// formatConfig returns the configuration data in its canonical format.
+func formatConfig(data []byte, opts formatted.EncodeOptions) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Kind == 0 {
+		// The file is empty.
+		return data, nil
+	}
+	if err := config.Format(&doc); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if doc.HeadComment != "" {
+		// Such as a license header, which is on the document rather than on
+		// its first key.
+		fmt.Fprintf(&buf, "%s\n\n", doc.HeadComment)
+	}
+	enc, err := formatted.NewEncoder(&buf).UseOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := enc.Encode(doc.Content[0]); err != nil {
+		return nil, err
+	}
+	if doc.FootComment != "" {
+		fmt.Fprintf(&buf, "\n%s\n", doc.FootComment)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,67 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// globalConfig is the user's melange configuration, which applies to every
+// build they run, on top of the build's own configuration.
+type globalConfig struct {
+	Hooks struct {
+		// Commands to run on the host before and after each build.
+		PreBuild  []string `yaml:"pre-build"`
+		PostBuild []string `yaml:"post-build"`
+	} `yaml:"hooks"`
+	// The kinds of subpackages, such as dev and doc, to split from every
+	// package, unless --default-splits is given.
+	DefaultSplits []string `yaml:"default-splits"`
+}
+
+// loadGlobalConfig reads the user's melange configuration from
+// melange/config.yaml in their config directory, such as
+// ~/.config/melange/config.yaml. It's fine for there not to be one.
+func loadGlobalConfig() (*globalConfig, error) {
+	var gc globalConfig
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		// Without a config directory, there's no config in it.
+		return &gc, nil
+	}
+	path := filepath.Join(dir, "melange", "config.yaml")
+
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &gc, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("opening melange config: %w", err)
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(&gc); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("parsing melange config %s: %w", path, err)
+	}
+	return &gc, nil
+}
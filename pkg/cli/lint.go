@@ -16,7 +16,10 @@ package cli
 
 import (
 	"errors"
+	"fmt"
+	"os"
 	"runtime"
+	"slices"
 	"sync"
 
 	"github.com/chainguard-dev/clog"
@@ -29,21 +32,50 @@ import (
 
 func lint() *cobra.Command {
 	var lintRequire, lintWarn []string
+	var lintSeverity map[string]string
+	var lintReports map[string]string
+	var lintPlugins map[string]string
+	var lintBaseline string
+	var lintPolicy string
 	cmd := &cobra.Command{
 		Use:     "lint",
 		Short:   "EXPERIMENTAL COMMAND - Lints an APK, checking for problems and errors",
 		Long:    `Lint is an EXPERIMENTAL COMMAND - Lints an APK file, checking for problems and errors.`,
-		Example: `  melange lint [--enable=foo[,bar]] [--disable=baz] foo.apk`,
+		Example: `  melange lint [--lint-require=foo[,bar]] [--lint-warn=baz] [--lint-severity=qux=info] foo.apk`,
 		Args:    cobra.MinimumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			ctx := cmd.Context()
 			g, ctx := errgroup.WithContext(ctx)
 			g.SetLimit(runtime.GOMAXPROCS(0))
 
+			policy, err := loadLintPolicy(lintPolicy)
+			if err != nil {
+				return err
+			}
+			lintRequire, lintWarn = policy.Apply(lintRequire, lintWarn)
+
 			log := clog.FromContext(ctx)
 			log.Infof("Required checks: %v", lintRequire)
 			log.Infof("Warning checks: %v", lintWarn)
 
+			if err := checkLintReports(lintReports); err != nil {
+				return err
+			}
+			report := &linter.Report{}
+			baseline, err := loadLintBaseline(lintBaseline)
+			if err != nil {
+				return err
+			}
+
+			severities := make(map[string]linter.Severity, len(lintSeverity))
+			for name, sev := range lintSeverity {
+				s, err := linter.ParseSeverity(sev)
+				if err != nil {
+					return fmt.Errorf("--lint-severity %s: %w", name, err)
+				}
+				severities[name] = s
+			}
+
 			errs := []error{}
 			var mu sync.Mutex
 			for _, pkg := range args {
@@ -52,7 +84,12 @@ func lint() *cobra.Command {
 					if err := ctx.Err(); err != nil {
 						return err
 					}
-					if err := linter.LintAPK(ctx, pkg, lintRequire, lintWarn); err != nil {
+					if err := linter.LintAPK(ctx, pkg, lintRequire, lintWarn,
+						linter.WithSeverities(severities),
+						linter.WithReport(report),
+						linter.WithPlugins(lintPlugins),
+						linter.WithBaseline(baseline),
+					); err != nil {
 						mu.Lock()
 						defer mu.Unlock()
 						errs = append(errs, err)
@@ -63,15 +100,69 @@ func lint() *cobra.Command {
 			if err := g.Wait(); err != nil {
 				return err
 			}
+			if err := writeLintReports(report, lintReports); err != nil {
+				errs = append(errs, err)
+			}
 			return errors.Join(errs...)
 		},
 	}
 
 	cmd.Flags().StringSliceVar(&lintRequire, "lint-require", linter.DefaultRequiredLinters(), "linters that must pass")
 	cmd.Flags().StringSliceVar(&lintWarn, "lint-warn", linter.DefaultWarnLinters(), "linters that will generate warnings")
+	cmd.Flags().StringToStringVar(&lintReports, "lint-report", nil, fmt.Sprintf("write linter findings to files, as format=path (formats: %q)", linter.ReportFormats()))
+	cmd.Flags().StringVar(&lintBaseline, "lint-baseline", "", "YAML file listing known linter findings, which are reported as warnings rather than errors")
+	cmd.Flags().StringToStringVar(&lintPlugins, "lint-plugin", nil, "run an external program as a linter, as name=program")
+	cmd.Flags().StringVar(&lintPolicy, "lint-policy", "", "YAML file deciding which linters are enforced, only warn or are skipped, on top of --lint-require and --lint-warn")
+	cmd.Flags().StringToStringVar(&lintSeverity, "lint-severity", nil, "override the severity (error, warn or info) of linters, e.g. strip=error")
 
 	_ = cmd.Flags().Bool("fail-on-lint-warning", false, "DEPRECATED: DO NOT USE")
 	_ = cmd.Flags().MarkDeprecated("fail-on-lint-warning", "use --lint-require and --lint-warn instead")
 
 	return cmd
 }
+
+// checkLintReports validates the formats passed with --lint-report, so that a
+// typo fails before anything is linted rather than after.
+func checkLintReports(reports map[string]string) error {
+	for format := range reports {
+		if !slices.Contains(linter.ReportFormats(), format) {
+			return fmt.Errorf("unknown --lint-report format %q (must be one of %q)", format, linter.ReportFormats())
+		}
+	}
+	return nil
+}
+
+// loadLintBaseline loads the baseline passed with --lint-baseline, if any.
+func loadLintBaseline(path string) (*linter.Baseline, error) {
+	if path == "" {
+		return nil, nil
+	}
+	return linter.LoadBaseline(path)
+}
+
+// loadLintPolicy loads the policy passed with --lint-policy, if any.
+func loadLintPolicy(path string) (*linter.Policy, error) {
+	if path == "" {
+		return nil, nil
+	}
+	return linter.LoadPolicy(path)
+}
+
+// writeLintReports writes the report in each of the requested formats.
+func writeLintReports(report *linter.Report, reports map[string]string) error {
+	var errs []error
+	for format, path := range reports {
+		f, err := os.Create(path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("creating lint report: %w", err))
+			continue
+		}
+		if err := report.Write(f, format); err != nil {
+			errs = append(errs, fmt.Errorf("writing %s lint report %s: %w", format, path, err))
+		}
+		if err := f.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("closing lint report %s: %w", path, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -16,35 +16,53 @@ package cli
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"strings"
 	"text/template"
 
 	"chainguard.dev/melange/pkg/config"
+	"chainguard.dev/melange/pkg/jsonpath"
 	"github.com/spf13/cobra"
 )
 
 func query() *cobra.Command {
+	var allowEnv []string
 	cmd := &cobra.Command{
 		Use:   "query",
 		Short: "Query a Melange YAML file for information",
 		Long: `Query a Melange YAML file for information.
-		Uses templates with go templates syntax to query the YAML file.`,
-		Example: `  melange query config.yaml "{{ .Package.Name }}-{{ .Package.Version }}-{{ .Package.Epoch }}"`,
-		Args:    cobra.MinimumNArgs(2),
+
+The query is run on the configuration after it's parsed and its variables
+are substituted. A query that starts with $ is a JSONPath expression over the
+configuration's fields as they're named in the YAML file, and each value it
+selects is printed on a line of its own: strings as they are, and other values
+as JSON. Any other query is a Go template over the parsed configuration.`,
+		Example: `  melange query config.yaml '$.package.version'
+  melange query config.yaml '$.subpackages[*].name'
+  melange query config.yaml '$.pipeline[?(@.uses == "fetch")].with.uri'
+  melange query config.yaml "{{ .Package.Name }}-{{ .Package.Version }}-{{ .Package.Epoch }}"`,
+		Args: cobra.MinimumNArgs(2),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return QueryCmd(cmd.Context(), args[0], args[1])
+			return QueryCmd(cmd.Context(), args[0], args[1], config.WithAllowedEnv(allowEnv))
 		},
 	}
 
+	cmd.Flags().StringSliceVar(&allowEnv, "allow-env", []string{}, "environment variables that ${{env.NAME}} can substitute the values of in the configuration")
+
 	return cmd
 }
 
-func QueryCmd(ctx context.Context, configFile, pattern string) error {
-	config, err := config.ParseConfiguration(ctx, configFile)
+func QueryCmd(ctx context.Context, configFile, pattern string, opts ...config.ConfigurationParsingOption) error {
+	config, err := config.ParseConfiguration(ctx, configFile, opts...)
 	if err != nil {
 		return err
 	}
+	if strings.HasPrefix(strings.TrimSpace(pattern), "$") {
+		return queryPath(os.Stdout, config, pattern)
+	}
 	tmpl, err := template.New("query").Parse(pattern)
 	if err != nil {
 		return fmt.Errorf("invalid template: %w", err)
@@ -55,3 +73,35 @@ func QueryCmd(ctx context.Context, configFile, pattern string) error {
 	}
 	return nil
 }
+
+// queryPath prints the values that the JSONPath expression expr selects from
+// the configuration cfg to w, one on each line.
+func queryPath(w io.Writer, cfg *config.Configuration, expr string) error {
+	path, err := jsonpath.Parse(expr)
+	if err != nil {
+		return fmt.Errorf("invalid JSONPath expression: %w", err)
+	}
+
+	// The configuration as JSON has the field names of the YAML file.
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return fmt.Errorf("encoding configuration: %w", err)
+	}
+	var doc any
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return fmt.Errorf("decoding configuration: %w", err)
+	}
+
+	for _, v := range path.Eval(doc) {
+		if s, ok := v.(string); ok {
+			fmt.Fprintln(w, s)
+			continue
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, string(b))
+	}
+	return nil
+}
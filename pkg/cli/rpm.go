@@ -0,0 +1,75 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"context"
+
+	"chainguard.dev/melange/pkg/convert/rpm"
+
+	"github.com/chainguard-dev/clog"
+	"github.com/spf13/cobra"
+)
+
+type rpmOptions struct {
+	outDir                 string
+	additionalRepositories []string
+	additionalKeyrings     []string
+}
+
+// RPMBuild is the top-level `convert rpm` cobra command
+func RPMBuild() *cobra.Command {
+	o := &rpmOptions{}
+	cmd := &cobra.Command{
+		Use:   "rpm",
+		Short: "Converts an RPM spec file into a melange.yaml",
+		Long: `Converts an RPM spec file into a melange.yaml.
+
+The %prep, %build and %install sections are mapped to the pipelines that do
+the same, such as autoconf/configure for %configure, and the rest of their
+commands run as they are, with the macros they use expanded. BuildRequires
+are mapped to the build environment, and the subpackages that %package
+defines to subpackages with the files that their %files list.
+
+Whatever can't be translated is flagged by a TODO comment, and values that
+can't be worked out are left as FIXME. The patches next to the spec are
+copied into a directory named after the package, next to the melange.yaml.`,
+		Example: `
+# Convert the spec file of a Fedora package
+convert rpm hello/hello.spec`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var err error
+			o.outDir, o.additionalRepositories, o.additionalKeyrings, err = getCommonValues(cmd, true)
+			if err != nil {
+				return err
+			}
+			return o.rpmBuild(cmd.Context(), args[0])
+		},
+	}
+	return cmd
+}
+
+func (o rpmOptions) rpmBuild(ctx context.Context, specPath string) error {
+	c := rpm.Context{
+		OutDir:                 o.outDir,
+		AdditionalRepositories: o.additionalRepositories,
+		AdditionalKeyrings:     o.additionalKeyrings,
+	}
+
+	clog.FromContext(ctx).Infof("generating convert config file for RPM spec file %s", specPath)
+
+	return c.Generate(ctx, specPath)
+}
@@ -29,6 +29,7 @@ import (
 	"time"
 
 	"chainguard.dev/apko/pkg/apk/expandapk"
+	apko_types "chainguard.dev/apko/pkg/build/types"
 	"chainguard.dev/melange/pkg/build"
 	"chainguard.dev/melange/pkg/config"
 	"chainguard.dev/melange/pkg/sca"
@@ -181,6 +182,9 @@ func scanCmd(ctx context.Context, file string, sc *scanConfig) error {
 		infos := map[string]*pkginfo{}
 
 		for _, subpkg := range cfg.Subpackages {
+			if !subpkg.BuiltFor(apko_types.ParseArchitecture(arch)) {
+				continue
+			}
 			u := fmt.Sprintf("%s/%s/%s-%s-r%d.apk", sc.repo, arch, subpkg.Name, pkg.Version, pkg.Epoch)
 
 			var r io.Reader
@@ -38,9 +38,12 @@ func test() *cobra.Command {
 	var guestDir string
 	var archstrs []string
 	var pipelineDirs []string
+	var remotePipelineCacheDir string
 	var extraKeys []string
 	var extraRepos []string
 	var envFile string
+	var strict bool
+	var allowEnv []string
 	var overlayBinSh string
 	var testOption []string
 	var debug bool
@@ -76,6 +79,8 @@ func test() *cobra.Command {
 				build.WithTestBinShOverlay(overlayBinSh),
 				build.WithTestRunner(r),
 				build.WithTestEnvFile(envFile),
+				build.WithTestStrict(strict),
+				build.WithTestAllowedEnv(allowEnv),
 				build.WithTestDebug(debug),
 				build.WithTestDebugRunner(debugRunner),
 				build.WithTestInteractive(interactive),
@@ -97,6 +102,7 @@ func test() *cobra.Command {
 				options = append(options, build.WithTestPipelineDir(pipelineDirs[i]))
 			}
 			options = append(options, build.WithTestPipelineDir(BuiltinPipelineDir))
+			options = append(options, build.WithTestRemotePipelineCacheDir(remotePipelineCacheDir))
 
 			if auth, ok := os.LookupEnv("HTTP_AUTH"); !ok {
 				// Fine, no auth.
@@ -115,6 +121,7 @@ func test() *cobra.Command {
 
 	cmd.Flags().StringVar(&workspaceDir, "workspace-dir", "", "directory used for the workspace at /home/build")
 	cmd.Flags().StringSliceVar(&pipelineDirs, "pipeline-dirs", []string{}, "directories used to extend defined built-in pipelines")
+	cmd.Flags().StringVar(&remotePipelineCacheDir, "remote-pipeline-cache-dir", "", "directory used to cache pipelines from git repositories (defaults to the user's cache directory)")
 	cmd.Flags().StringVar(&sourceDir, "source-dir", "", "directory used for included sources")
 	cmd.Flags().StringVar(&cacheDir, "cache-dir", "", "directory used for cached inputs")
 	cmd.Flags().StringVar(&cacheSource, "cache-source", "", "directory or bucket used for preloading the cache")
@@ -126,6 +133,8 @@ func test() *cobra.Command {
 	cmd.Flags().StringVar(&runner, "runner", "", fmt.Sprintf("which runner to use to enable running commands, default is based on your platform. Options are %q", build.GetAllRunners()))
 	cmd.Flags().StringSliceVarP(&extraKeys, "keyring-append", "k", []string{}, "path to extra keys to include in the build environment keyring")
 	cmd.Flags().StringVar(&envFile, "env-file", "", "file to use for preloaded environment variables")
+	cmd.Flags().BoolVar(&strict, "strict", false, "reject misindented keys of the configuration, which have no value but are followed by other keys, and unknown fields in the pipelines it uses")
+	cmd.Flags().StringSliceVar(&allowEnv, "allow-env", []string{}, "environment variables that ${{env.NAME}} can substitute the values of in the configuration")
 	cmd.Flags().BoolVar(&debug, "debug", false, "enables debug logging of test pipelines (sets -x for steps)")
 	cmd.Flags().BoolVar(&debugRunner, "debug-runner", false, "when enabled, the builder pod will persist after the build succeeds or fails")
 	cmd.Flags().BoolVarP(&interactive, "interactive", "i", false, "when enabled, attaches stdin with a tty to the pod on failure")
@@ -0,0 +1,105 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/chainguard-dev/clog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	"go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"sigs.k8s.io/release-utils/version"
+)
+
+// startTracing sets up the global tracer provider to write spans to
+// traceFile, and to export them to an OTLP/HTTP endpoint, if either is
+// given. The endpoint can also be given with the standard
+// OTEL_EXPORTER_OTLP_ENDPOINT and OTEL_EXPORTER_OTLP_TRACES_ENDPOINT
+// environment variables. It returns a context that continues the trace in
+// the TRACEPARENT environment variable, if it's set, so that a build can
+// be part of a trace that started elsewhere, and a function that flushes
+// and stops the tracer provider.
+func startTracing(ctx context.Context, traceFile, otlpEndpoint string) (context.Context, func(), error) {
+	otlp := otlpEndpoint != "" || os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+	if traceFile == "" && !otlp {
+		return ctx, func() {}, nil
+	}
+
+	// Detected attributes, and those in OTEL_RESOURCE_ATTRIBUTES, override
+	// the service's name and version.
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName("melange"), semconv.ServiceVersion(version.GetVersionInfo().GitVersion)),
+		resource.WithHost(),
+		resource.WithTelemetrySDK(),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return ctx, nil, fmt.Errorf("detecting trace resource: %w", err)
+	}
+	opts := []trace.TracerProviderOption{trace.WithResource(res)}
+
+	var closers []func() error
+	if traceFile != "" {
+		w, err := os.Create(traceFile)
+		if err != nil {
+			return ctx, nil, fmt.Errorf("creating trace file: %w", err)
+		}
+		closers = append(closers, w.Close)
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(w))
+		if err != nil {
+			w.Close()
+			return ctx, nil, fmt.Errorf("creating stdout exporter: %w", err)
+		}
+		opts = append(opts, trace.WithBatcher(exporter))
+	}
+	if otlp {
+		var eopts []otlptracehttp.Option
+		if otlpEndpoint != "" {
+			eopts = append(eopts, otlptracehttp.WithEndpointURL(otlpEndpoint))
+		}
+		exporter, err := otlptracehttp.New(ctx, eopts...)
+		if err != nil {
+			for _, c := range closers {
+				c()
+			}
+			return ctx, nil, fmt.Errorf("creating OTLP exporter: %w", err)
+		}
+		opts = append(opts, trace.WithBatcher(exporter))
+	}
+
+	tp := trace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	if parent := os.Getenv("TRACEPARENT"); parent != "" {
+		ctx = propagation.TraceContext{}.Extract(ctx, propagation.MapCarrier{"traceparent": parent})
+	}
+
+	return ctx, func() {
+		if err := tp.Shutdown(context.WithoutCancel(ctx)); err != nil {
+			clog.FromContext(ctx).Errorf("shutting down trace provider: %v", err)
+		}
+		for _, c := range closers {
+			c()
+		}
+	}, nil
+}
@@ -16,40 +16,122 @@ package cond
 
 import (
 	"fmt"
+	"regexp"
+	"strings"
 
+	"chainguard.dev/apko/pkg/apk/apk"
 	"github.com/ijt/goparsify"
 )
 
-func combineOp(n *goparsify.Result) {
-	switch n.Child[1].Token {
-	case "&&":
-		n.Result = n.Child[0].Result == true && n.Child[2].Result == true
-	case "||":
-		n.Result = n.Child[0].Result == true || n.Child[2].Result == true
-	default:
-		panic(fmt.Errorf("unrecognized op"))
-	}
+// A condition is the result of parsing a boolean expression. Evaluation is
+// deferred until the whole expression has parsed, so that && and || can
+// short-circuit and errors aren't reported for alternatives the parser
+// backtracked out of.
+type condition func() (bool, error)
+
+func andOp(n *goparsify.Result) {
+	n.Result = chainOp(n, false)
+}
+
+func orOp(n *goparsify.Result) {
+	n.Result = chainOp(n, true)
 }
 
-func collapseOp(n *goparsify.Result) {
-	n.Result = true
-	for _, child := range n.Child {
-		if child.Result != true {
-			n.Result = false
-			return
+// chainOp combines the conditions of n, which is a condition followed by
+// any number of (operator, condition) pairs. Evaluation stops at the first
+// condition whose result is stop.
+func chainOp(n *goparsify.Result, stop bool) condition {
+	conds := []condition{n.Child[0].Result.(condition)}
+	for _, op := range n.Child[1].Child {
+		conds = append(conds, op.Child[1].Result.(condition))
+	}
+	if len(conds) == 1 {
+		return conds[0]
+	}
+	return func() (bool, error) {
+		for _, c := range conds {
+			result, err := c()
+			if err != nil {
+				return false, err
+			}
+			if result == stop {
+				return stop, nil
+			}
 		}
+		return !stop, nil
 	}
 }
 
+func notOp(n *goparsify.Result) {
+	c := n.Child[1].Result.(condition)
+	n.Result = condition(func() (bool, error) {
+		result, err := c()
+		return !result, err
+	})
+}
+
 func comparisonOp(n *goparsify.Result) {
-	switch n.Child[1].Token {
-	case "==":
-		n.Result = n.Child[0].Token == n.Child[2].Token
-	case "!=":
-		n.Result = n.Child[0].Token != n.Child[2].Token
-	default:
-		panic(fmt.Errorf("unrecognized op"))
+	left, op, right := n.Child[0].Token, n.Child[1].Token, n.Child[2].Token
+	n.Result = condition(func() (bool, error) {
+		switch op {
+		case "==":
+			return left == right, nil
+		case "!=":
+			return left != right, nil
+		}
+
+		cmp, err := compareVersions(left, right)
+		if err != nil {
+			return false, err
+		}
+		switch op {
+		case "<":
+			return cmp < 0, nil
+		case "<=":
+			return cmp <= 0, nil
+		case ">":
+			return cmp > 0, nil
+		case ">=":
+			return cmp >= 0, nil
+		default:
+			panic(fmt.Errorf("unrecognized op"))
+		}
+	})
+}
+
+// compareVersions compares two apk versions, returning -1, 0 or 1.
+func compareVersions(left, right string) (int, error) {
+	lv, err := apk.ParseVersion(left)
+	if err != nil {
+		return 0, fmt.Errorf("comparing %q with %q: %w", left, right, err)
 	}
+	rv, err := apk.ParseVersion(right)
+	if err != nil {
+		return 0, fmt.Errorf("comparing %q with %q: %w", left, right, err)
+	}
+	return apk.CompareVersions(lv, rv), nil
+}
+
+func callOp(n *goparsify.Result) {
+	fn, arg0, arg1 := n.Child[0].Token, n.Child[3].Token, n.Child[5].Token
+	n.Result = condition(func() (bool, error) {
+		switch fn {
+		case "contains":
+			return strings.Contains(arg0, arg1), nil
+		case "startsWith":
+			return strings.HasPrefix(arg0, arg1), nil
+		case "endsWith":
+			return strings.HasSuffix(arg0, arg1), nil
+		case "matches":
+			re, err := regexp.Compile(arg1)
+			if err != nil {
+				return false, fmt.Errorf("compiling %q for matches(): %w", arg1, err)
+			}
+			return re.MatchString(arg0), nil
+		default:
+			panic(fmt.Errorf("unrecognized function"))
+		}
+	})
 }
 
 // A VariableLookupFunction designates how variables should be
@@ -64,9 +146,18 @@ func NullLookup(key string) (string, error) {
 }
 
 // Evaluate evaluates an input expression.
-// Expressions are groups of string values combined with equal or unequal
-// comparators.  The order of comparison operations can be designated using
-// groups enclosed inside parenthesis.
+// Expressions compare string values, which are quoted strings or variables:
+//
+//   - == and != compare values as strings.
+//   - <, <=, > and >= compare values as apk versions, and fail if either
+//     value isn't one.
+//   - contains(s, substr), startsWith(s, prefix) and endsWith(s, suffix)
+//     test for substrings, and matches(s, regex) tests s against a
+//     regular expression.
+//
+// Comparisons can be negated with !, and combined with && and ||, where &&
+// binds more tightly than ||. The order of operations can be designated
+// using groups enclosed inside parenthesis.
 // An optional VariableLookupFunction can be provided to provide variable
 // lookups.
 func Evaluate(inputExpr string, lookupFns ...VariableLookupFunction) (bool, error) {
@@ -76,9 +167,8 @@ func Evaluate(inputExpr string, lookupFns ...VariableLookupFunction) (bool, erro
 		lookupFn = lookupFns[0]
 	}
 
-	equal := goparsify.Exact("==")
-	unequal := goparsify.Exact("!=")
-	comps := goparsify.Any(equal, unequal)
+	// Longer operators come first, so that < doesn't match the start of <=.
+	comps := goparsify.Any("==", "!=", "<=", ">=", "<", ">")
 
 	variableName := goparsify.Chars("a-zA-Z0-9.\\-_")
 	variable := goparsify.Seq("${{", variableName, "}}").Map(func(n *goparsify.Result) {
@@ -91,28 +181,26 @@ func Evaluate(inputExpr string, lookupFns ...VariableLookupFunction) (bool, erro
 	value := goparsify.Any(goparsify.StringLit("'\""), variable)
 	expr := goparsify.Seq(value, comps, value).Map(comparisonOp)
 
-	and := goparsify.Exact("&&")
-	or := goparsify.Exact("||")
-	chain := goparsify.Any(and, or)
-	combinedExpr := goparsify.Seq(expr, chain, expr).Map(combineOp)
-
-	exprChain := goparsify.Some(goparsify.Any(combinedExpr, expr), chain).Map(collapseOp)
+	fn := goparsify.Any("contains", "startsWith", "endsWith", "matches")
+	call := goparsify.Seq(fn, "(", goparsify.Cut(), value, ",", value, ")").Map(callOp)
 
-	group := goparsify.Seq("(", goparsify.Cut(), exprChain, ")").Map(func(n *goparsify.Result) {
+	var term, orChain goparsify.Parser
+	not := goparsify.Seq("!", &term).Map(notOp)
+	group := goparsify.Seq("(", goparsify.Cut(), &orChain, ")").Map(func(n *goparsify.Result) {
 		n.Result = n.Child[2].Result
 	})
-	groupOrExpr := goparsify.Any(group, exprChain)
-	combinedGroup := goparsify.Seq(groupOrExpr, chain, groupOrExpr).Map(combineOp)
+	term = goparsify.Any(group, not, call, expr)
 
-	groupChain := goparsify.Some(goparsify.Any(combinedGroup, groupOrExpr), chain).Map(collapseOp)
+	andChain := goparsify.Seq(term, goparsify.Many(goparsify.Seq("&&", term))).Map(andOp)
+	orChain = goparsify.Seq(andChain, goparsify.Many(goparsify.Seq("||", andChain))).Map(orOp)
 
-	result, _, err := goparsify.Run(groupChain, inputExpr, goparsify.UnicodeWhitespace)
+	result, _, err := goparsify.Run(orChain, inputExpr, goparsify.UnicodeWhitespace)
 	if err != nil {
 		return false, err
 	}
 
-	if rbool, ok := result.(bool); ok {
-		return rbool, nil
+	if c, ok := result.(condition); ok {
+		return c()
 	}
 
 	return false, fmt.Errorf("got non-boolean result from parser")
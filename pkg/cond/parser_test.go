@@ -85,3 +85,79 @@ func TestVariableLookupWhitespace(t *testing.T) {
 	require.NoErrorf(t, err, "got error: %v", err)
 	require.Equal(t, true, result, "${{ foo.bar }} definitely equals baz")
 }
+
+func TestExprNot(t *testing.T) {
+	result, err := Evaluate("!('rabbit' == 'hare')")
+	require.NoErrorf(t, err, "got error: %v", err)
+	require.Equal(t, true, result, "rabbits are not hares")
+
+	result, err = Evaluate("!'rabbit' == 'rabbit'")
+	require.NoErrorf(t, err, "got error: %v", err)
+	require.Equal(t, false, result, "! applies to the comparison")
+}
+
+func TestExprPrecedence(t *testing.T) {
+	// && binds more tightly than ||.
+	result, err := Evaluate("'a' == 'a' || 'a' == 'b' && 'a' == 'c'")
+	require.NoErrorf(t, err, "got error: %v", err)
+	require.Equal(t, true, result)
+
+	result, err = Evaluate("('a' == 'a' || 'a' == 'b') && 'a' == 'c'")
+	require.NoErrorf(t, err, "got error: %v", err)
+	require.Equal(t, false, result)
+}
+
+func TestExprVersions(t *testing.T) {
+	for _, c := range []struct {
+		expr string
+		want bool
+	}{
+		{"'1.10.0' > '1.9.2'", true},
+		{"'1.10.0' < '1.9.2'", false},
+		{"'1.2.3' >= '1.2.3'", true},
+		{"'1.2.3-r1' <= '1.2.3-r0'", false},
+		{"'2.0_rc1' < '2.0'", true},
+	} {
+		result, err := Evaluate(c.expr)
+		require.NoErrorf(t, err, "%s: got error: %v", c.expr, err)
+		require.Equalf(t, c.want, result, "%s", c.expr)
+	}
+
+	_, err := Evaluate("'rabbit' > '1.0'")
+	require.Error(t, err)
+
+	// The invalid comparison is never evaluated.
+	result, err := Evaluate("'a' == 'a' || 'rabbit' > '1.0'")
+	require.NoErrorf(t, err, "got error: %v", err)
+	require.Equal(t, true, result)
+}
+
+func TestExprFunctions(t *testing.T) {
+	for _, c := range []struct {
+		expr string
+		want bool
+	}{
+		{"contains('lagomorph', 'morph')", true},
+		{"contains('lagomorph', 'rabbit')", false},
+		{"startsWith('lagomorph', 'lago')", true},
+		{"endsWith('lagomorph', 'lago')", false},
+		{"matches('aarch64', '^(x86_64|aarch64)$')", true},
+		{"!matches('riscv64', '^(x86_64|aarch64)$')", true},
+	} {
+		result, err := Evaluate(c.expr)
+		require.NoErrorf(t, err, "%s: got error: %v", c.expr, err)
+		require.Equalf(t, c.want, result, "%s", c.expr)
+	}
+
+	_, err := Evaluate("matches('rabbit', '(')")
+	require.Error(t, err)
+
+	_, err = Evaluate("hops('rabbit', 'hare')")
+	require.Error(t, err)
+}
+
+func TestVariableLookupFunctions(t *testing.T) {
+	result, err := Evaluate("contains(${{foo.BAR_BAZ}}, 'baz') && ${{foo.bar}} == 'baz'", placeholderLookup)
+	require.NoErrorf(t, err, "got error: %v", err)
+	require.Equal(t, true, result)
+}
@@ -0,0 +1,152 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	apko_types "chainguard.dev/apko/pkg/build/types"
+)
+
+// ArchOverride describes how the build differs on an architecture.
+type ArchOverride struct {
+	// Optional: Variables to set on the architecture, over those in vars
+	Vars map[string]string `json:"vars,omitempty" yaml:"vars,omitempty"`
+	// Optional: Packages to add to or remove from the build environment on
+	// the architecture, and environment variables to set in it
+	Environment EnvironmentOption `json:"environment,omitempty" yaml:"environment,omitempty"`
+	// Optional: Changes to the steps of the main pipeline and the pipelines of
+	// subpackages on the architecture, by the id of the step
+	Pipeline map[string]StepOverride `json:"pipeline,omitempty" yaml:"pipeline,omitempty"`
+}
+
+// StepOverride describes how a step differs on an architecture.
+type StepOverride struct {
+	// Optional: Whether to leave the step, and the steps in it, out
+	Skip bool `json:"skip,omitempty" yaml:"skip,omitempty"`
+	// Optional: Inputs to give the pipeline the step uses, over those in its
+	// with
+	With map[string]string `json:"with,omitempty" yaml:"with,omitempty"`
+	// Optional: Environment variables to set for the step, over those in its
+	// environment
+	Environment map[string]string `json:"environment,omitempty" yaml:"environment,omitempty"`
+	// Optional: The script to run in place of the step's runs
+	Runs string `json:"runs,omitempty" yaml:"runs,omitempty"`
+}
+
+// applyArchOverrides applies the overrides for arch, if there are any, to
+// the configuration. Overrides for any architecture must name steps that
+// are in it.
+func (cfg *Configuration) applyArchOverrides(arch apko_types.Architecture) error {
+	ids := map[string]bool{}
+	var collect func(ps []Pipeline)
+	collect = func(ps []Pipeline) {
+		for _, p := range ps {
+			if p.ID != "" {
+				ids[p.ID] = true
+			}
+			collect(p.Pipeline)
+		}
+	}
+	collect(cfg.Pipeline)
+	for _, sp := range cfg.Subpackages {
+		collect(sp.Pipeline)
+	}
+
+	var override *ArchOverride
+	for _, name := range slices.Sorted(maps.Keys(cfg.ArchOverrides)) {
+		a := apko_types.ParseArchitecture(name)
+		if !slices.Contains(apko_types.AllArchs, a) {
+			return fmt.Errorf("arch-overrides: unknown architecture %q", name)
+		}
+		o := cfg.ArchOverrides[name]
+		for _, id := range slices.Sorted(maps.Keys(o.Pipeline)) {
+			if !ids[id] {
+				return fmt.Errorf("arch-overrides: %s: no step has id %q", name, id)
+			}
+		}
+		if arch != "" && a == arch {
+			if override != nil {
+				return fmt.Errorf("arch-overrides: more than one entry for %s", arch.ToAPK())
+			}
+			override = &o
+		}
+	}
+	if override == nil {
+		return nil
+	}
+
+	if cfg.Vars == nil {
+		cfg.Vars = map[string]string{}
+	}
+	maps.Copy(cfg.Vars, override.Vars)
+
+	lo := override.Environment.Contents.Packages
+	cfg.Environment.Contents.Packages = slices.DeleteFunc(cfg.Environment.Contents.Packages, func(pkg string) bool {
+		return slices.Contains(lo.Remove, pkg)
+	})
+	cfg.Environment.Contents.Packages = append(cfg.Environment.Contents.Packages, lo.Add...)
+	if len(override.Environment.Environment) != 0 {
+		if cfg.Environment.Environment == nil {
+			cfg.Environment.Environment = map[string]string{}
+		}
+		maps.Copy(cfg.Environment.Environment, override.Environment.Environment)
+	}
+
+	cfg.Pipeline = overrideSteps(cfg.Pipeline, override.Pipeline)
+	for i := range cfg.Subpackages {
+		cfg.Subpackages[i].Pipeline = overrideSteps(cfg.Subpackages[i].Pipeline, override.Pipeline)
+	}
+	return nil
+}
+
+// overrideSteps returns ps, and the steps in them, with the overrides for
+// their ids applied.
+func overrideSteps(ps []Pipeline, overrides map[string]StepOverride) []Pipeline {
+	var out []Pipeline
+	for _, p := range ps {
+		o, ok := overrides[p.ID]
+		if p.ID == "" || !ok {
+			p.Pipeline = overrideSteps(p.Pipeline, overrides)
+			out = append(out, p)
+			continue
+		}
+		if o.Skip {
+			continue
+		}
+		if len(o.With) != 0 {
+			p.With = maps.Clone(p.With)
+			if p.With == nil {
+				p.With = map[string]string{}
+			}
+			maps.Copy(p.With, o.With)
+		}
+		if len(o.Environment) != 0 {
+			p.Environment = maps.Clone(p.Environment)
+			if p.Environment == nil {
+				p.Environment = map[string]string{}
+			}
+			maps.Copy(p.Environment, o.Environment)
+		}
+		if o.Runs != "" {
+			p.Runs = o.Runs
+		}
+		p.Pipeline = overrideSteps(p.Pipeline, overrides)
+		out = append(out, p)
+	}
+	return out
+}
@@ -17,23 +17,24 @@ package config
 // ListOption describes an optional deviation to a list, for example, a
 // list of packages.
 type ListOption struct {
-	Add    []string `yaml:"add,omitempty"`
-	Remove []string `yaml:"remove,omitempty"`
+	Add    []string `json:"add,omitempty" yaml:"add,omitempty"`
+	Remove []string `json:"remove,omitempty" yaml:"remove,omitempty"`
 }
 
 // ContentsOption describes an optional deviation to an apko environment's
 // contents block.
 type ContentsOption struct {
-	Packages ListOption `yaml:"packages,omitempty"`
+	Packages ListOption `json:"packages,omitempty" yaml:"packages,omitempty"`
 }
 
 // EnvironmentOption describes an optional deviation to an apko environment.
 type EnvironmentOption struct {
-	Contents ContentsOption `yaml:"contents,omitempty"`
+	Contents    ContentsOption    `json:"contents,omitempty" yaml:"contents,omitempty"`
+	Environment map[string]string `json:"environment,omitempty" yaml:"environment,omitempty"`
 }
 
 // BuildOption describes an optional deviation to a package build.
 type BuildOption struct {
-	Vars        map[string]string `yaml:"vars,omitempty"`
-	Environment EnvironmentOption `yaml:"environment,omitempty"`
+	Vars        map[string]string `json:"vars,omitempty" yaml:"vars,omitempty"`
+	Environment EnvironmentOption `json:"environment,omitempty" yaml:"environment,omitempty"`
 }
@@ -25,12 +25,14 @@ import (
 	"path"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	apko_types "chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/melange/pkg/egress"
 	"chainguard.dev/melange/pkg/sbom"
 	purl "github.com/package-url/packageurl-go"
 
@@ -73,6 +75,30 @@ type Scriptlets struct {
 	PostUpgrade string `json:"post-upgrade,omitempty" yaml:"post-upgrade,omitempty"`
 }
 
+// Scripts returns the scripts that are set, keyed by the names of the files in
+// the control section of the package that apk reads them from, such as
+// .post-install.
+func (s *Scriptlets) Scripts() map[string]string {
+	scripts := map[string]string{}
+	if s == nil {
+		return scripts
+	}
+	for name, script := range map[string]string{
+		".trigger":        s.Trigger.Script,
+		".pre-install":    s.PreInstall,
+		".post-install":   s.PostInstall,
+		".pre-deinstall":  s.PreDeinstall,
+		".post-deinstall": s.PostDeinstall,
+		".pre-upgrade":    s.PreUpgrade,
+		".post-upgrade":   s.PostUpgrade,
+	} {
+		if script != "" {
+			scripts[name] = script
+		}
+	}
+	return scripts
+}
+
 type PackageOption struct {
 	// Optional: Signify this package as a virtual package which does not provide
 	// any files, executables, libraries, etc... and is otherwise empty
@@ -82,11 +108,57 @@ type PackageOption struct {
 	NoDepends bool `json:"no-depends" yaml:"no-depends"`
 	// Optional: Mark this package as not providing any executables
 	NoCommands bool `json:"no-commands" yaml:"no-commands"`
+	// Optional: Don't add the -static, -dev, -doc and -lang subpackages that
+	// melange is configured to split from every package
+	NoDefaultSplits bool `json:"no-default-splits" yaml:"no-default-splits"`
+	// Optional: Only scan these directories of the package, such as usr/lib,
+	// for the dependencies and provides that are generated for it
+	ScanPaths []string `json:"scan-paths,omitempty" yaml:"scan-paths,omitempty"`
+	// Optional: Generate rubyX.Y-gem: runtime dependencies on the gems that
+	// the package's gems need
+	RubyGemDepends bool `json:"ruby-gem-depends,omitempty" yaml:"ruby-gem-depends,omitempty"`
 }
 
 type Checks struct {
 	// Optional: disable these linters that are not enabled by default.
 	Disabled []string `json:"disabled,omitempty" yaml:"disabled,omitempty"`
+	// Optional: override the severity (error, warn or info) of these linters.
+	// Linters listed here are enabled even if they are not enabled by default.
+	Severity map[string]string `json:"severity,omitempty" yaml:"severity,omitempty"`
+	// Optional: paths (globs, where "**" matches any number of directories)
+	// that individual linters should not check, keyed by linter name.
+	Ignore map[string][]string `json:"ignore,omitempty" yaml:"ignore,omitempty"`
+	// Optional: non-standard RPATH/RUNPATH directories (globs) that the rpath
+	// linter should accept, such as a private library directory.
+	RPaths []string `json:"rpaths,omitempty" yaml:"rpaths,omitempty"`
+	// Optional: non-standard top-level directories, such as "nix", that the
+	// toplevel linter should accept.
+	Roots []string `json:"roots,omitempty" yaml:"roots,omitempty"`
+	// Optional: the largest file (default 100MB) and the largest total size
+	// of the files (default 1GB) that the size linter accepts, such as
+	// "250MB".
+	MaxFileSize    string `json:"max-file-size,omitempty" yaml:"max-file-size,omitempty"`
+	MaxPackageSize string `json:"max-package-size,omitempty" yaml:"max-package-size,omitempty"`
+}
+
+// Linter is a check of the packages produced by a build that is defined in
+// its configuration. It flags every file that matches all of its path, path
+// regex and content patterns that are set.
+type Linter struct {
+	// The name of the linter, which checks refer to it by
+	Name string `json:"name" yaml:"name"`
+	// Optional: a glob (where "**" matches any number of directories) that
+	// paths must match
+	Path string `json:"path,omitempty" yaml:"path,omitempty"`
+	// Optional: a regular expression that paths must match
+	PathRegex string `json:"path-regex,omitempty" yaml:"path-regex,omitempty"`
+	// Optional: a regular expression that file contents must match
+	Content string `json:"content,omitempty" yaml:"content,omitempty"`
+	// The message to report for each matching file
+	Message string `json:"message" yaml:"message"`
+	// Optional: the severity (error, warn or info) to report matches at.
+	// Defaults to warn.
+	Severity string `json:"severity,omitempty" yaml:"severity,omitempty"`
 }
 
 type Package struct {
@@ -120,13 +192,18 @@ type Package struct {
 	Timeout time.Duration `json:"timeout,omitempty" yaml:"timeout,omitempty"`
 	// Optional: Resources to allocate to the build.
 	Resources *Resources `json:"resources,omitempty" yaml:"resources,omitempty"`
+	// Optional: Whether to split the debug info out of the ELF files of the
+	// package and its subpackages into a <name>-dbg subpackage
+	SplitDebug bool `json:"split-debug,omitempty" yaml:"split-debug,omitempty"`
 }
 
 type Resources struct {
+	// Optional: The number of CPUs the build can use, such as 4, 1.5 or 500m
 	CPU      string `json:"cpu,omitempty" yaml:"cpu,omitempty"`
 	CPUModel string `json:"cpumodel,omitempty" yaml:"cpumodel,omitempty"`
-	Memory   string `json:"memory,omitempty" yaml:"memory,omitempty"`
-	Disk     string `json:"disk,omitempty" yaml:"disk,omitempty"`
+	// Optional: The memory the build can use, such as 8Gi
+	Memory string `json:"memory,omitempty" yaml:"memory,omitempty"`
+	Disk   string `json:"disk,omitempty" yaml:"disk,omitempty"`
 }
 
 // PackageURL returns the package URL ("purl") for the APK (origin) package.
@@ -257,6 +334,27 @@ func (cfg *Configuration) applySubstitutionsForReplaces() error {
 	return nil
 }
 
+func (cfg *Configuration) applySubstitutionsForInstallIf() error {
+	nw := buildConfigMap(cfg)
+	for i, dep := range cfg.Package.Dependencies.InstallIf {
+		var err error
+		cfg.Package.Dependencies.InstallIf[i], err = util.MutateStringFromMap(nw, dep)
+		if err != nil {
+			return fmt.Errorf("failed to apply replacement to install-if %q: %w", dep, err)
+		}
+	}
+	for _, sp := range cfg.Subpackages {
+		for i, dep := range sp.Dependencies.InstallIf {
+			var err error
+			sp.Dependencies.InstallIf[i], err = util.MutateStringFromMap(nw, dep)
+			if err != nil {
+				return fmt.Errorf("failed to apply replacement to %q install-if %q: %w", sp.Name, dep, err)
+			}
+		}
+	}
+	return nil
+}
+
 func (cfg *Configuration) applySubstitutionsForPackages() error {
 	nw := buildConfigMap(cfg)
 	if err := cfg.PerformVarSubstitutions(nw); err != nil {
@@ -349,9 +447,36 @@ func (p Package) FullCopyright() string {
 	return copyright
 }
 
+// EffectiveCopyright returns the copyright of the subpackage, which is that
+// of its package pkg unless the subpackage has its own.
+func (sp Subpackage) EffectiveCopyright(pkg Package) []Copyright {
+	if len(sp.Copyright) > 0 {
+		return sp.Copyright
+	}
+	return pkg.Copyright
+}
+
+// LicenseExpression returns the SPDX license expression of the subpackage of
+// the package pkg, as Package.LicenseExpression does.
+func (sp Subpackage) LicenseExpression(pkg Package) string {
+	return Package{Copyright: sp.EffectiveCopyright(pkg)}.LicenseExpression()
+}
+
+// LicensingInfos returns the text of the custom licenses of the subpackage
+// of the package pkg, as Package.LicensingInfos does.
+func (sp Subpackage) LicensingInfos(pkg Package, WorkspaceDir string) (map[string]string, error) {
+	return Package{Copyright: sp.EffectiveCopyright(pkg)}.LicensingInfos(WorkspaceDir)
+}
+
+// FullCopyright returns the concatenated copyright expressions of the
+// subpackage of the package pkg, as Package.FullCopyright does.
+func (sp Subpackage) FullCopyright(pkg Package) string {
+	return Package{Copyright: sp.EffectiveCopyright(pkg)}.FullCopyright()
+}
+
 type Needs struct {
 	// A list of packages needed by this pipeline
-	Packages []string
+	Packages []string `json:"packages,omitempty" yaml:"packages,omitempty"`
 }
 
 type PipelineAssertions struct {
@@ -363,6 +488,9 @@ type PipelineAssertions struct {
 type Pipeline struct {
 	// Optional: A user defined name for the pipeline
 	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+	// Optional: An identifier for the pipeline, which later pipelines use its
+	// outputs by, as ${{steps.<id>.outputs.<name>}}
+	ID string `json:"id,omitempty" yaml:"id,omitempty"`
 	// Optional: A named reusable pipeline to run
 	//
 	// This can be either a pipeline builtin to melange, or a user defined named pipeline.
@@ -396,6 +524,82 @@ type Pipeline struct {
 	WorkDir string `json:"working-directory,omitempty" yaml:"working-directory,omitempty"`
 	// Optional: environment variables to override the apko environment
 	Environment map[string]string `json:"environment,omitempty" yaml:"environment,omitempty"`
+	// Optional: How to retry the pipeline if it fails
+	//
+	// This is inherited by nested pipelines unless they set their own.
+	Retries *Retries `json:"retries,omitempty" yaml:"retries,omitempty"`
+	// Optional: The amount of time to allow the pipeline, including its
+	// nested pipelines and retries, to take before timing out
+	Timeout time.Duration `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	// Optional: Whether to pause the build before running the pipeline, with
+	// a shell in the guest, when building with --interactive
+	Breakpoint bool `json:"breakpoint,omitempty" yaml:"breakpoint,omitempty"`
+}
+
+type Retries struct {
+	// Required: The number of times to retry a failed pipeline
+	Count int `json:"count" yaml:"count"`
+	// Optional: How long to wait before the first retry, which doubles after
+	// each retry (defaults to 5s)
+	Backoff time.Duration `json:"backoff,omitempty" yaml:"backoff,omitempty"`
+}
+
+// fetchSourceInfo describes how a fetch step with the given inputs checked
+// the signature of what it fetched, or returns "" if it didn't.
+func fetchSourceInfo(with map[string]string) string {
+	if with["signature-uri"] == "" {
+		return ""
+	}
+	typ := with["signature-type"]
+	if typ == "" {
+		typ = "gpg"
+	}
+	var keys []string
+	for _, line := range strings.Split(with["trusted-keys"], "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		if key := strings.Join(strings.Fields(line), ""); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return fmt.Sprintf("%s signature %s verified against trusted keys %s", typ, with["signature-uri"], strings.Join(keys, ", "))
+}
+
+// gitCheckoutSourceInfo describes the parts of a repository that a
+// git-checkout step with the given inputs checked out, beyond the commit
+// itself, or returns "" if it checked out nothing else.
+func gitCheckoutSourceInfo(with map[string]string) string {
+	var info []string
+	if paths := strings.Fields(with["sparse-paths"]); len(paths) != 0 {
+		info = append(info, fmt.Sprintf("sparse checkout of %s", strings.Join(paths, ", ")))
+	}
+	if filter := with["filter"]; filter != "" {
+		info = append(info, fmt.Sprintf("partial clone with filter %s", filter))
+	}
+	if with["recurse-submodules"] == "true" {
+		submodules := "with submodules"
+		var pins []string
+		for _, line := range strings.Split(with["submodule-commits"], "\n") {
+			line, _, _ = strings.Cut(line, "#")
+			if path, commit, ok := strings.Cut(line, ":"); ok {
+				pins = append(pins, fmt.Sprintf("%s at %s", strings.TrimSpace(path), strings.TrimSpace(commit)))
+			}
+		}
+		if len(pins) != 0 {
+			submodules += fmt.Sprintf(" (%s)", strings.Join(pins, ", "))
+		}
+		info = append(info, submodules)
+	}
+	if with["lfs"] == "true" {
+		info = append(info, "with git-lfs objects")
+	}
+	return strings.Join(info, "; ")
+}
+
+// UsesName returns the name of the pipeline that uses refers to, without the
+// version it selects, if any.
+func UsesName(uses string) string {
+	name, _, _ := strings.Cut(uses, "@")
+	return name
 }
 
 // SBOMPackageForUpstreamSource returns an SBOM package for the upstream source
@@ -408,7 +612,7 @@ func (p Pipeline) SBOMPackageForUpstreamSource(licenseDeclared, supplier string,
 	//  feature could even eliminate the need for the package's license field in the
 	//  build configuration.
 
-	uses, with := p.Uses, p.With
+	uses, with := UsesName(p.Uses), p.With
 
 	switch uses {
 	case "fetch":
@@ -450,6 +654,7 @@ func (p Pipeline) SBOMPackageForUpstreamSource(licenseDeclared, supplier string,
 			Version:      pkgVersion,
 			Namespace:    supplier,
 			PURL:         pu,
+			SourceInfo:   fetchSourceInfo(with),
 		}, nil
 
 	case "git-checkout":
@@ -507,6 +712,7 @@ func (p Pipeline) SBOMPackageForUpstreamSource(licenseDeclared, supplier string,
 					LicenseDeclared: licenseDeclared,
 					Namespace:       namespace,
 					PURL:            pu,
+					SourceInfo:      gitCheckoutSourceInfo(with),
 				}, nil
 			}
 
@@ -551,6 +757,7 @@ func (p Pipeline) SBOMPackageForUpstreamSource(licenseDeclared, supplier string,
 			LicenseDeclared: licenseDeclared,
 			Namespace:       supplier,
 			PURL:            &pu,
+			SourceInfo:      gitCheckoutSourceInfo(with),
 		}, nil
 	}
 
@@ -562,12 +769,26 @@ func (p Pipeline) SBOMPackageForUpstreamSource(licenseDeclared, supplier string,
 type Subpackage struct {
 	// Optional: A conditional statement to evaluate for the subpackage
 	If string `json:"if,omitempty" yaml:"if,omitempty"`
-	// Optional: The iterable used to generate multiple subpackages
+	// Optional: The iterable used to generate multiple subpackages, or a
+	// comma-separated list of them to generate one for each combination of
+	// their items
 	Range string `json:"range,omitempty" yaml:"range,omitempty"`
+	// Optional: The architectures, such as x86_64 or aarch64, that the
+	// subpackage is built for, out of those the package is built for. It's
+	// built for all of them if this is empty
+	TargetArchitecture []string `json:"target-architecture,omitempty" yaml:"target-architecture,omitempty"`
 	// Required: Name of the subpackage
 	Name string `json:"name" yaml:"name"`
 	// Optional: The list of pipelines that produce subpackage.
 	Pipeline []Pipeline `json:"pipeline,omitempty" yaml:"pipeline,omitempty"`
+	// Optional: Packages that only the subpackage's pipeline needs, which
+	// are installed with the rest of the environment if the subpackage is
+	// built
+	Needs *Needs `json:"needs,omitempty" yaml:"needs,omitempty"`
+	// Optional: Globs of the paths to move from the main package into the
+	// subpackage once all the pipelines have run, such as usr/include or
+	// usr/lib/*.a, where ** matches any number of directories
+	Contents []string `json:"contents,omitempty" yaml:"contents,omitempty"`
 	// Optional: List of packages to depend on
 	Dependencies Dependencies `json:"dependencies,omitempty" yaml:"dependencies,omitempty"`
 	// Optional: Options that alter the packages behavior
@@ -579,10 +800,38 @@ type Subpackage struct {
 	URL string `json:"url,omitempty" yaml:"url,omitempty"`
 	// Optional: The git commit of the subpackage build configuration
 	Commit string `json:"commit,omitempty" yaml:"commit,omitempty"`
+	// Optional: The copyright and license of the subpackage, if they aren't
+	// those of the package, such as for documentation or a bundled component
+	Copyright []Copyright `json:"copyright,omitempty" yaml:"copyright,omitempty"`
 	// Optional: enabling, disabling, and configuration of build checks
 	Checks Checks `json:"checks,omitempty" yaml:"checks,omitempty"`
 	// Test section for the subpackage.
 	Test *Test `json:"test,omitempty" yaml:"test,omitempty"`
+	// Optional: Whether the subpackage's pipeline is independent of the
+	// pipelines of its neighbouring subpackages, so that consecutive
+	// independent subpackages can run their pipelines concurrently.
+	//
+	// Pipelines that move the same paths, or that need another subpackage's
+	// pipeline to have run first, aren't independent.
+	Independent bool `json:"independent,omitempty" yaml:"independent,omitempty"`
+
+	// Whether the subpackage is left out of the build if nothing ends up in
+	// it, as the subpackages added by WithDefaultSplits are.
+	OmitIfEmpty bool `json:"-" yaml:"-"`
+}
+
+// BuiltFor returns whether the subpackage is built for the architecture
+// arch.
+func (sp Subpackage) BuiltFor(arch apko_types.Architecture) bool {
+	if len(sp.TargetArchitecture) == 0 {
+		return true
+	}
+	for _, ta := range sp.TargetArchitecture {
+		if ta == "all" || apko_types.ParseArchitecture(ta) == arch {
+			return true
+		}
+	}
+	return false
 }
 
 type Input struct {
@@ -592,10 +841,24 @@ type Input struct {
 	Default string `json:"default,omitempty"`
 	// Optional: A toggle denoting whether the input is required or not
 	Required bool `json:"required,omitempty"`
+	// Optional: The type of the input, which its value is checked against:
+	// string (the default), bool, int or enum.
+	Type string `json:"type,omitempty"`
+	// Optional: The values that an enum input can take.
+	Values []string `json:"values,omitempty"`
 }
 
 // The root melange configuration
 type Configuration struct {
+	// Optional: Files with fragments of the configuration, such as shared
+	// environments, tests or vars, to merge into it, relative to its
+	// directory. The configuration overrides what they set, and lists are
+	// appended to theirs.
+	Include []string `json:"include,omitempty" yaml:"include,omitempty"`
+	// Optional: A template configuration that this one is based on, relative
+	// to its directory. The configuration overrides what the template sets,
+	// field by field, and lists replace the template's.
+	Extends string `json:"extends,omitempty" yaml:"extends,omitempty"`
 	// Package metadata
 	Package Package `json:"package" yaml:"package"`
 	// The specification for the packages build environment
@@ -617,12 +880,36 @@ type Configuration struct {
 	VarTransforms []VarTransforms `json:"var-transforms,omitempty" yaml:"var-transforms,omitempty"`
 	// Optional: Deviations to the build
 	Options map[string]BuildOption `json:"options,omitempty" yaml:"options,omitempty"`
+	// Optional: Additional linters to run on the package and its subpackages
+	Linters []Linter `json:"linters,omitempty" yaml:"linters,omitempty"`
 
 	// Test section for the main package.
 	Test *Test `json:"test,omitempty" yaml:"test,omitempty"`
 
+	// Optional: Variables to build the package for every combination of, which
+	// can be used as ${{matrix.<name>}} anywhere in the configuration. The
+	// package names should use them, so that each combination produces
+	// different packages.
+	Matrix map[string][]string `json:"matrix,omitempty" yaml:"matrix,omitempty"`
+
+	// Optional: The hosts that the build can connect to. If set, steps can
+	// only reach the network through a proxy that only allows these hosts.
+	Egress *Egress `json:"egress,omitempty" yaml:"egress,omitempty"`
+
+	// Optional: How the build differs on particular architectures, by the
+	// name of the architecture.
+	ArchOverrides map[string]ArchOverride `json:"arch-overrides,omitempty" yaml:"arch-overrides,omitempty"`
+
+	// Optional: Directories that are kept on the host between builds of the
+	// package, and mounted into the guest, such as for the caches of build
+	// tools.
+	Caches []Cache `json:"caches,omitempty" yaml:"caches,omitempty"`
+
 	// Parsed AST for this configuration
 	root *yaml.Node
+
+	// The variables that vars declares, rather than only setting them.
+	varDeclarations map[string]VarDeclaration
 }
 
 // AllPackageNames returns a sequence of all package names in the configuration,
@@ -641,6 +928,23 @@ func (cfg Configuration) AllPackageNames() iter.Seq[string] {
 	}
 }
 
+type Egress struct {
+	// The hosts that the build can connect to: domain names, such as
+	// github.com, wildcards for the subdomains of a domain, such as
+	// *.githubusercontent.com, IP addresses, or CIDR blocks.
+	Allow []string `json:"allow,omitempty" yaml:"allow,omitempty"`
+}
+
+// Cache is a directory that's kept on the host between builds of the
+// package, and mounted into the guest.
+type Cache struct {
+	// Required: The name of the cache, which it's kept under on the host
+	Name string `json:"name" yaml:"name"`
+	// Required: The absolute path in the guest to mount the cache at, such
+	// as /root/.cache/bazel
+	Target string `json:"target" yaml:"target"`
+}
+
 type Test struct {
 	// Additional Environment necessary for test.
 	// Environment.Contents.Packages automatically get
@@ -664,10 +968,18 @@ type VarTransforms struct {
 	//
 	// Example: ${{package.version}}
 	From string `json:"from" yaml:"from"`
-	// Required: The regular expression to match against the `from` variable
-	Match string `json:"match" yaml:"match"`
-	// Required: The repl to replace on all `match` matches
-	Replace string `json:"replace" yaml:"replace"`
+	// Optional: The regular expression to match against the `from` variable.
+	// Required unless a function is given.
+	Match string `json:"match,omitempty" yaml:"match,omitempty"`
+	// Optional: The repl to replace on all `match` matches
+	Replace string `json:"replace,omitempty" yaml:"replace,omitempty"`
+	// Optional: A function to apply to the `from` variable instead of
+	// matching a regular expression: major, minor, patch, upper, lower,
+	// url-encode, trim-prefix, trim-suffix, add or subtract.
+	Function string `json:"function,omitempty" yaml:"function,omitempty"`
+	// Optional: The argument of the function, such as the prefix for
+	// trim-prefix, or the number for add.
+	Arg string `json:"arg,omitempty" yaml:"arg,omitempty"`
 	// Required: The name of the new variable to create
 	//
 	// Example: mangeled-package-version
@@ -868,6 +1180,10 @@ type Dependencies struct {
 	Provides []string `json:"provides,omitempty" yaml:"provides,omitempty"`
 	// Optional: List of replace objectives
 	Replaces []string `json:"replaces,omitempty" yaml:"replaces,omitempty"`
+	// Optional: Packages that, once they are all installed, make apk install
+	// this package too, such as bash and a package for the completions of a
+	// command that bash-completion uses
+	InstallIf []string `json:"install-if,omitempty" yaml:"install-if,omitempty"`
 	// Optional: An integer string compared against other equal package provides used to
 	// determine priority of provides
 	ProviderPriority string `json:"provider-priority,omitempty" yaml:"provider-priority,omitempty"`
@@ -888,6 +1204,11 @@ type configOptions struct {
 	cpu, cpumodel, memory, disk string
 	timeout                     time.Duration
 	commit                      string
+	matrix                      map[string]string
+	arch                        apko_types.Architecture
+	defaultSplits               []string
+	strict                      bool
+	allowedEnv                  []string
 
 	varsFilePath string
 }
@@ -952,6 +1273,47 @@ func WithEnvFileForParsing(path string) ConfigurationParsingOption {
 	}
 }
 
+// WithMatrix sets the combination of matrix values to parse the configuration
+// for. If it isn't set, the first combination is used.
+func WithMatrix(combo map[string]string) ConfigurationParsingOption {
+	return func(options *configOptions) {
+		options.matrix = combo
+	}
+}
+
+// WithArch sets the architecture to parse the configuration for, whose
+// arch-overrides are applied. If it isn't set, none are.
+func WithArch(arch apko_types.Architecture) ConfigurationParsingOption {
+	return func(options *configOptions) {
+		options.arch = arch
+	}
+}
+
+// WithDefaultSplits sets the kinds of subpackages, from DefaultSplitKinds,
+// to split from the package by default, as abuild does.
+func WithDefaultSplits(kinds []string) ConfigurationParsingOption {
+	return func(options *configOptions) {
+		options.defaultSplits = kinds
+	}
+}
+
+// WithAllowedEnv sets the environment variables that ${{env.*}} can
+// substitute the values of. Referring to any other is an error.
+func WithAllowedEnv(names []string) ConfigurationParsingOption {
+	return func(options *configOptions) {
+		options.allowedEnv = names
+	}
+}
+
+// WithStrict sets whether to reject keys of the configuration that have no
+// value, but are followed by other keys, as they usually come from
+// misindenting what follows them. Unknown fields are rejected either way.
+func WithStrict(strict bool) ConfigurationParsingOption {
+	return func(options *configOptions) {
+		options.strict = strict
+	}
+}
+
 // WithVarsFileForParsing sets the path to the vars file to use if the user wishes to
 // populate the variables block from an external file.
 func WithVarsFileForParsing(path string) ConfigurationParsingOption {
@@ -1058,6 +1420,7 @@ func replaceImageConfig(r *strings.Replacer, in apko_types.ImageConfiguration) a
 func replacePipeline(r *strings.Replacer, in Pipeline) Pipeline {
 	return Pipeline{
 		Name:        r.Replace(in.Name),
+		ID:          in.ID,
 		Uses:        in.Uses,
 		With:        replaceMap(r, in.With),
 		Runs:        r.Replace(in.Runs),
@@ -1069,6 +1432,9 @@ func replacePipeline(r *strings.Replacer, in Pipeline) Pipeline {
 		Assertions:  in.Assertions,
 		WorkDir:     r.Replace(in.WorkDir),
 		Environment: replaceMap(r, in.Environment),
+		Retries:     in.Retries,
+		Timeout:     in.Timeout,
+		Breakpoint:  in.Breakpoint,
 	}
 }
 
@@ -1126,6 +1492,7 @@ func replaceDependencies(r *strings.Replacer, in Dependencies) Dependencies {
 		Runtime:          replaceAll(r, in.Runtime),
 		Provides:         replaceAll(r, in.Provides),
 		Replaces:         replaceAll(r, in.Replaces),
+		InstallIf:        replaceAll(r, in.InstallIf),
 		ProviderPriority: r.Replace(in.ProviderPriority),
 		ReplacesPriority: r.Replace(in.ReplacesPriority),
 	}
@@ -1147,25 +1514,75 @@ func replacePackage(r *strings.Replacer, commit string, in Package) Package {
 		Checks:             in.Checks,
 		Timeout:            in.Timeout,
 		Resources:          in.Resources,
+		SplitDebug:         in.SplitDebug,
+	}
+}
+
+func replaceCopyright(r *strings.Replacer, in []Copyright) []Copyright {
+	if in == nil {
+		return nil
 	}
+
+	out := make([]Copyright, 0, len(in))
+	for _, cp := range in {
+		out = append(out, Copyright{
+			Paths:       replaceAll(r, cp.Paths),
+			Attestation: r.Replace(cp.Attestation),
+			License:     r.Replace(cp.License),
+			LicensePath: r.Replace(cp.LicensePath),
+		})
+	}
+	return out
 }
 
 func replaceSubpackage(r *strings.Replacer, detectedCommit string, in Subpackage) Subpackage {
 	return Subpackage{
-		If:           r.Replace(in.If),
-		Name:         r.Replace(in.Name),
-		Pipeline:     replacePipelines(r, in.Pipeline),
-		Dependencies: replaceDependencies(r, in.Dependencies),
-		Options:      in.Options,
-		Scriptlets:   replaceScriptlets(r, in.Scriptlets),
-		Description:  r.Replace(in.Description),
-		URL:          r.Replace(in.URL),
-		Commit:       replaceCommit(detectedCommit, in.Commit),
-		Checks:       in.Checks,
-		Test:         replaceTest(r, in.Test),
+		If:                 r.Replace(in.If),
+		Name:               r.Replace(in.Name),
+		TargetArchitecture: replaceAll(r, in.TargetArchitecture),
+		Pipeline:           replacePipelines(r, in.Pipeline),
+		Needs:              replaceNeeds(r, in.Needs),
+		Contents:           replaceAll(r, in.Contents),
+		Dependencies:       replaceDependencies(r, in.Dependencies),
+		Options:            in.Options,
+		Scriptlets:         replaceScriptlets(r, in.Scriptlets),
+		Description:        r.Replace(in.Description),
+		URL:                r.Replace(in.URL),
+		Commit:             replaceCommit(detectedCommit, in.Commit),
+		Copyright:          replaceCopyright(r, in.Copyright),
+		Checks:             in.Checks,
+		Test:               replaceTest(r, in.Test),
+		Independent:        in.Independent,
 	}
 }
 
+// rangeLists returns the names of the data lists that the range of a
+// subpackage, such as "py-versions, extras", iterates over, and the keys of
+// each, sorted so that iterating over them is deterministic.
+func rangeLists(datas map[string]DataItems, rng string) ([]string, [][]string, error) {
+	var names []string
+	var lists [][]string
+	for _, name := range strings.Split(rng, ",") {
+		name = strings.TrimSpace(name)
+		items, ok := datas[name]
+		if !ok {
+			return nil, nil, fmt.Errorf("specified undefined range: %q", name)
+		}
+		if slices.Contains(names, name) {
+			return nil, nil, fmt.Errorf("specified range %q more than once", name)
+		}
+
+		keys := make([]string, 0, len(items))
+		for k := range items {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		names = append(names, name)
+		lists = append(lists, keys)
+	}
+	return names, lists, nil
+}
+
 func replaceSubpackages(r *strings.Replacer, datas map[string]DataItems, cfg Configuration, in []Subpackage) ([]Subpackage, error) {
 	out := make([]Subpackage, 0, len(in))
 
@@ -1179,32 +1596,48 @@ func replaceSubpackages(r *strings.Replacer, datas map[string]DataItems, cfg Con
 			continue
 		}
 
-		items, ok := datas[sp.Range]
-		if !ok {
-			return nil, fmt.Errorf("subpackages[%d] (%q) specified undefined range: %q", i, sp.Name, sp.Range)
+		names, lists, err := rangeLists(datas, sp.Range)
+		if err != nil {
+			return nil, fmt.Errorf("subpackages[%d] (%q) %w", i, sp.Name, err)
 		}
-
-		// Ensure iterating over items is deterministic by sorting keys alphabetically
-		keys := make([]string, 0, len(items))
-		for k := range items {
-			keys = append(keys, k)
+		if slices.ContainsFunc(lists, func(keys []string) bool { return len(keys) == 0 }) {
+			// There are no combinations.
+			continue
 		}
-		sort.Strings(keys)
 
 		configMap := buildConfigMap(&cfg)
 		if err := cfg.PerformVarSubstitutions(configMap); err != nil {
 			return nil, fmt.Errorf("applying variable substitutions: %w", err)
 		}
 
-		for _, k := range keys {
-			v := items[k]
-			configMap["${{range.key}}"] = k
-			configMap["${{range.value}}"] = v
+		// A subpackage for each combination of the items of the lists, in
+		// which the items of the last list vary fastest.
+		combination := make([]int, len(lists))
+		for {
+			for j, name := range names {
+				k := lists[j][combination[j]]
+				configMap["${{range."+name+".key}}"] = k
+				configMap["${{range."+name+".value}}"] = datas[name][k]
+			}
+			// range.key and range.value are those of the first list.
+			configMap["${{range.key}}"] = lists[0][combination[0]]
+			configMap["${{range.value}}"] = datas[names[0]][lists[0][combination[0]]]
 			r := replacerFromMap(configMap)
 
 			thingToAdd := replaceSubpackage(r, cfg.Package.Commit, sp)
 
 			out = append(out, thingToAdd)
+
+			j := len(lists) - 1
+			for ; j >= 0; j-- {
+				if combination[j]++; combination[j] < len(lists[j]) {
+					break
+				}
+				combination[j] = 0
+			}
+			if j < 0 {
+				break
+			}
 		}
 	}
 
@@ -1244,12 +1677,16 @@ func ParseConfiguration(_ context.Context, configurationFilePath string, opts ..
 	configurationDirPath := filepath.Dir(configurationFilePath)
 	options.include(opts...)
 
+	// Templates can be outside of the directory of the configuration, if
+	// it's on the host.
+	hostDirPath := ""
 	if options.filesystem == nil {
 		// TODO: this is an abstraction leak, and we can remove this `if statement` once
 		//  ParseConfiguration relies solely on an abstract fs.FS.
 
 		options.filesystem = os.DirFS(configurationDirPath)
 		configurationFilePath = filepath.Base(configurationFilePath)
+		hostDirPath = configurationDirPath
 	}
 
 	if configurationFilePath == "" {
@@ -1272,6 +1709,11 @@ func ParseConfiguration(_ context.Context, configurationFilePath string, opts ..
 	if err != nil {
 		return nil, fmt.Errorf("unable to decode configuration file %q: %w", configurationFilePath, err)
 	}
+	if options.strict {
+		if err := checkStrict(&root); err != nil {
+			return nil, fmt.Errorf("configuration file %q is not strictly valid: %w", configurationFilePath, err)
+		}
+	}
 
 	// XXX(Elizafox) - Node.Decode doesn't allow setting of KnownFields, so we do this cheesy hack below
 	data, err := yaml.Marshal(&root)
@@ -1279,6 +1721,33 @@ func ParseConfiguration(_ context.Context, configurationFilePath string, opts ..
 		return nil, fmt.Errorf("unable to decode configuration file %q: %w", configurationFilePath, err)
 	}
 
+	// Merge the template and the included fragments, leaving the AST as
+	// written.
+	data, err = applyIncludes(fsReadFile(options.filesystem, hostDirPath), configurationFilePath, data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to apply includes to configuration file %q: %w", configurationFilePath, err)
+	}
+
+	// Substitute the allowed environment variables, leaving the AST as
+	// written.
+	data, err = applyEnv(data, options.allowedEnv)
+	if err != nil {
+		return nil, fmt.Errorf("unable to apply environment variables to configuration file %q: %w", configurationFilePath, err)
+	}
+
+	// Set the declared variables to their defaults, leaving the AST as
+	// written.
+	data, varDeclarations, err := applyVarDeclarations(data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to apply variable declarations in configuration file %q: %w", configurationFilePath, err)
+	}
+
+	// Substitute the matrix values, leaving the AST as written.
+	data, err = applyMatrix(data, options.matrix)
+	if err != nil {
+		return nil, fmt.Errorf("unable to apply matrix to configuration file %q: %w", configurationFilePath, err)
+	}
+
 	// Now unmarshal it into the struct, part of said cheesy hack
 	reader := bytes.NewReader(data)
 	decoder := yaml.NewDecoder(reader)
@@ -1286,6 +1755,7 @@ func ParseConfiguration(_ context.Context, configurationFilePath string, opts ..
 	if err := decoder.Decode(&cfg); err != nil {
 		return nil, fmt.Errorf("unable to decode configuration file %q: %w", configurationFilePath, err)
 	}
+	cfg.varDeclarations = varDeclarations
 
 	// If a variables file was defined, merge it into the variables block.
 	if varsFile := options.varsFilePath; varsFile != "" {
@@ -1306,6 +1776,12 @@ func ParseConfiguration(_ context.Context, configurationFilePath string, opts ..
 		}
 	}
 
+	// Apply the overrides for the architecture before substitutions, so that
+	// the variables they set are substituted like the others.
+	if err := cfg.applyArchOverrides(options.arch); err != nil {
+		return nil, fmt.Errorf("unable to apply arch-overrides in configuration file %q: %w", configurationFilePath, err)
+	}
+
 	// Mutate config properties with substitutions.
 	configMap := buildConfigMap(&cfg)
 	if err := cfg.PerformVarSubstitutions(configMap); err != nil {
@@ -1328,6 +1804,14 @@ func ParseConfiguration(_ context.Context, configurationFilePath string, opts ..
 		return nil, fmt.Errorf("unable to decode configuration file %q: %w", configurationFilePath, err)
 	}
 
+	if err := cfg.addDefaultSplits(options.defaultSplits); err != nil {
+		return nil, fmt.Errorf("unable to decode configuration file %q: %w", configurationFilePath, err)
+	}
+
+	if err := cfg.addDebugSubpackage(); err != nil {
+		return nil, fmt.Errorf("unable to decode configuration file %q: %w", configurationFilePath, err)
+	}
+
 	cfg.Environment = replaceImageConfig(replacer, cfg.Environment)
 
 	cfg.Test = replaceTest(replacer, cfg.Test)
@@ -1396,6 +1880,9 @@ func ParseConfiguration(_ context.Context, configurationFilePath string, opts ..
 	if err := cfg.applySubstitutionsForReplaces(); err != nil {
 		return nil, err
 	}
+	if err := cfg.applySubstitutionsForInstallIf(); err != nil {
+		return nil, err
+	}
 	if err := cfg.applySubstitutionsForPackages(); err != nil {
 		return nil, err
 	}
@@ -1450,6 +1937,8 @@ func (e ErrInvalidConfiguration) Unwrap() error {
 }
 
 var packageNameRegex = regexp.MustCompile(`^[a-zA-Z\d][a-zA-Z\d+_.-]*$`)
+var stepIDRegex = regexp.MustCompile(`^[a-zA-Z0-9_]+$`)
+var cacheNameRegex = regexp.MustCompile(`^[a-zA-Z\d][a-zA-Z\d_.-]*$`)
 
 func (cfg Configuration) validate() error {
 	if !packageNameRegex.MatchString(cfg.Package.Name) {
@@ -1463,11 +1952,28 @@ func (cfg Configuration) validate() error {
 	// TODO: try to validate value of .package.version
 
 	if err := validateDependenciesPriorities(cfg.Package.Dependencies); err != nil {
-		return ErrInvalidConfiguration{Problem: errors.New("priority must convert to integer")}
+		return ErrInvalidConfiguration{Problem: err}
+	}
+	if err := validateInstallIf(cfg.Package.Dependencies); err != nil {
+		return ErrInvalidConfiguration{Problem: err}
 	}
 	if err := validatePipelines(cfg.Pipeline); err != nil {
 		return ErrInvalidConfiguration{Problem: err}
 	}
+	if err := validateLinters(cfg.Linters); err != nil {
+		return ErrInvalidConfiguration{Problem: err}
+	}
+	if err := validateScriptlets(cfg.Package.Scriptlets); err != nil {
+		return ErrInvalidConfiguration{Problem: fmt.Errorf("package scriptlets: %w", err)}
+	}
+	if cfg.Egress != nil {
+		if _, err := egress.NewPolicy(cfg.Egress.Allow); err != nil {
+			return ErrInvalidConfiguration{Problem: err}
+		}
+	}
+	if err := validateCaches(cfg.Caches); err != nil {
+		return ErrInvalidConfiguration{Problem: err}
+	}
 
 	saw := map[string]int{cfg.Package.Name: -1}
 	for i, sp := range cfg.Subpackages {
@@ -1489,11 +1995,22 @@ func (cfg Configuration) validate() error {
 			return ErrInvalidConfiguration{Problem: fmt.Errorf("subpackage name %q (subpackages index: %d) must match regex %q", sp.Name, i, packageNameRegex)}
 		}
 		if err := validateDependenciesPriorities(sp.Dependencies); err != nil {
-			return ErrInvalidConfiguration{Problem: errors.New("priority must convert to integer")}
+			return ErrInvalidConfiguration{Problem: fmt.Errorf("subpackage %q: %w", sp.Name, err)}
+		}
+		if err := validateInstallIf(sp.Dependencies); err != nil {
+			return ErrInvalidConfiguration{Problem: fmt.Errorf("subpackage %q: %w", sp.Name, err)}
 		}
 		if err := validatePipelines(sp.Pipeline); err != nil {
 			return ErrInvalidConfiguration{Problem: err}
 		}
+		if err := validateScriptlets(sp.Scriptlets); err != nil {
+			return ErrInvalidConfiguration{Problem: fmt.Errorf("subpackage %q scriptlets: %w", sp.Name, err)}
+		}
+		for _, glob := range sp.Contents {
+			if err := util.ValidateGlob(glob); err != nil {
+				return ErrInvalidConfiguration{Problem: fmt.Errorf("subpackage %q contents: %w", sp.Name, err)}
+			}
+		}
 	}
 
 	return nil
@@ -1513,6 +2030,18 @@ func validatePipelines(ps []Pipeline) error {
 			return fmt.Errorf("pipeline cannot contain both with and runs")
 		}
 
+		if r := p.Retries; r != nil && (r.Count < 0 || r.Backoff < 0) {
+			return fmt.Errorf("pipeline retries count and backoff must not be negative")
+		}
+
+		if p.Timeout < 0 {
+			return fmt.Errorf("pipeline timeout must not be negative")
+		}
+
+		if p.ID != "" && !stepIDRegex.MatchString(p.ID) {
+			return fmt.Errorf("pipeline id %q must match regex %q", p.ID, stepIDRegex)
+		}
+
 		if err := validatePipelines(p.Pipeline); err != nil {
 			return err
 		}
@@ -1520,15 +2049,121 @@ func validatePipelines(ps []Pipeline) error {
 	return nil
 }
 
+func validateLinters(ls []Linter) error {
+	saw := map[string]bool{}
+	for i, l := range ls {
+		if l.Name == "" {
+			return fmt.Errorf("linters[%d] has no name", i)
+		}
+		if saw[l.Name] {
+			return fmt.Errorf("saw duplicate linter name %q", l.Name)
+		}
+		saw[l.Name] = true
+
+		if l.Path == "" && l.PathRegex == "" && l.Content == "" {
+			return fmt.Errorf("linter %q must set at least one of path, path-regex or content", l.Name)
+		}
+		if l.Message == "" {
+			return fmt.Errorf("linter %q has no message", l.Name)
+		}
+		if l.Path != "" {
+			if err := util.ValidateGlob(l.Path); err != nil {
+				return fmt.Errorf("linter %q: %w", l.Name, err)
+			}
+		}
+		if _, err := regexp.Compile(l.PathRegex); err != nil {
+			return fmt.Errorf("linter %q: invalid path-regex: %w", l.Name, err)
+		}
+		if _, err := regexp.Compile(l.Content); err != nil {
+			return fmt.Errorf("linter %q: invalid content: %w", l.Name, err)
+		}
+		switch l.Severity {
+		case "", "error", "warn", "info":
+		default:
+			return fmt.Errorf("linter %q: unknown severity %q (must be one of error, warn or info)", l.Name, l.Severity)
+		}
+	}
+	return nil
+}
+
+// validateScriptlets checks that a trigger has both a script and the paths
+// that run it, which apk lists separated by spaces.
+func validateScriptlets(s *Scriptlets) error {
+	if s == nil {
+		return nil
+	}
+	switch t := s.Trigger; {
+	case t.Script != "" && len(t.Paths) == 0:
+		return errors.New("trigger has a script but no paths")
+	case t.Script == "" && len(t.Paths) > 0:
+		return errors.New("trigger has paths but no script")
+	}
+	for i, p := range s.Trigger.Paths {
+		if !strings.HasPrefix(p, "/") {
+			return fmt.Errorf("trigger paths[%d] %q must be absolute", i, p)
+		}
+		if strings.ContainsAny(p, " \t\n") {
+			return fmt.Errorf("trigger paths[%d] %q must not contain whitespace", i, p)
+		}
+	}
+	return nil
+}
+
+// The directories in the guest that caches can't be mounted at, or in, as
+// melange mounts its own directories there.
+var reservedCacheTargets = []string{"/home/build", "/var/cache/melange"}
+
+func validateCaches(cs []Cache) error {
+	names, targets := map[string]bool{}, map[string]bool{}
+	for i, c := range cs {
+		if !cacheNameRegex.MatchString(c.Name) {
+			return fmt.Errorf("caches[%d] name %q must match regex %q", i, c.Name, cacheNameRegex)
+		}
+		if names[c.Name] {
+			return fmt.Errorf("saw duplicate cache name %q", c.Name)
+		}
+		names[c.Name] = true
+
+		if !path.IsAbs(c.Target) || path.Clean(c.Target) != c.Target || c.Target == "/" {
+			return fmt.Errorf("cache %q target %q must be a clean absolute path other than /", c.Name, c.Target)
+		}
+		for _, r := range reservedCacheTargets {
+			if c.Target == r || strings.HasPrefix(c.Target, r+"/") || strings.HasPrefix(r, c.Target+"/") {
+				return fmt.Errorf("cache %q target %q can't be %s, be in it or contain it", c.Name, c.Target, r)
+			}
+		}
+		if targets[c.Target] {
+			return fmt.Errorf("saw duplicate cache target %q", c.Target)
+		}
+		targets[c.Target] = true
+	}
+	return nil
+}
+
+// validateInstallIf checks that each of the install-if dependencies is one
+// package, as apk lists them separated by spaces.
+func validateInstallIf(deps Dependencies) error {
+	for i, dep := range deps.InstallIf {
+		if dep == "" || strings.ContainsAny(dep, " \t\n") {
+			return fmt.Errorf("install-if[%d] %q must be a single package", i, dep)
+		}
+	}
+	return nil
+}
+
 func validateDependenciesPriorities(deps Dependencies) error {
-	priorities := []string{deps.ProviderPriority, deps.ProviderPriority}
-	for _, priority := range priorities {
-		if priority == "" {
+	for _, p := range []struct {
+		name, value string
+	}{
+		{"provider-priority", deps.ProviderPriority},
+		{"replaces-priority", deps.ReplacesPriority},
+	} {
+		if p.value == "" {
 			continue
 		}
-		_, err := strconv.Atoi(priority)
-		if err != nil {
-			return err
+		// apk reads them as unsigned integers.
+		if _, err := strconv.ParseUint(p.value, 10, 64); err != nil {
+			return fmt.Errorf("%s %q must be a non-negative integer", p.name, p.value)
 		}
 	}
 	return nil
@@ -3,8 +3,12 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	apko_types "chainguard.dev/apko/pkg/build/types"
 	"github.com/chainguard-dev/clog/slogtest"
 	"github.com/stretchr/testify/require"
 )
@@ -59,6 +63,9 @@ subpackages:
         - subpackage-bar=${{vars.bar}}
       replaces:
         - james=${{package.name}}
+      install-if:
+        - ${{package.name}}=${{package.full-version}}
+        - ${{vars.foo}}
 
 test:
   environment:
@@ -105,6 +112,11 @@ test:
 		"james=replacement-provides",
 	}, cfg.Subpackages[0].Dependencies.Replaces)
 
+	require.Equal(t, []string{
+		"replacement-provides=0.0.1-r7",
+		"FOO",
+	}, cfg.Subpackages[0].Dependencies.InstallIf)
+
 	require.Equal(t, []string{
 		"dep~0.0.1",
 	}, cfg.Environment.Contents.Packages)
@@ -219,6 +231,88 @@ subpackages:
 	require.Equal(t, cfg.Subpackages[1].Pipeline[0].Pipeline[0].Runs, "exit 1")
 }
 
+func Test_rangeCrossProduct(t *testing.T) {
+	ctx := slogtest.Context(t)
+
+	fp := filepath.Join(t.TempDir(), "melange.yaml")
+	if err := os.WriteFile(fp, []byte(`
+package:
+  name: attrs
+  version: 0.0.1
+  epoch: 0
+  description: example using two ranges in subpackages
+
+data:
+  - name: py-versions
+    items:
+      3.11: "311"
+      3.12: "312"
+  - name: extras
+    items:
+      tests: Test dependencies
+      docs: Documentation dependencies
+
+subpackages:
+  - range: py-versions, extras
+    name: py${{range.py-versions.key}}-${{package.name}}-${{range.extras.key}}
+    description: ${{range.extras.value}} for Python ${{range.key}}
+    pipeline:
+      - uses: py/pip-install
+        with:
+          python: python${{range.py-versions.key}}
+          package: ${{package.name}}[${{range.extras.key}}]
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := ParseConfiguration(ctx, fp)
+	if err != nil {
+		t.Fatalf("failed to parse configuration: %s", err)
+	}
+
+	var names, descriptions, packages []string
+	for _, sp := range cfg.Subpackages {
+		names = append(names, sp.Name)
+		descriptions = append(descriptions, sp.Description)
+		packages = append(packages, sp.Pipeline[0].With["python"]+" "+sp.Pipeline[0].With["package"])
+	}
+	require.Equal(t, []string{"py3.11-attrs-docs", "py3.11-attrs-tests", "py3.12-attrs-docs", "py3.12-attrs-tests"}, names)
+	require.Equal(t, []string{
+		"Documentation dependencies for Python 3.11",
+		"Test dependencies for Python 3.11",
+		"Documentation dependencies for Python 3.12",
+		"Test dependencies for Python 3.12",
+	}, descriptions)
+	require.Equal(t, []string{
+		"python3.11 attrs[docs]",
+		"python3.11 attrs[tests]",
+		"python3.12 attrs[docs]",
+		"python3.12 attrs[tests]",
+	}, packages)
+
+	for _, rng := range []string{"py-versions, missing", "extras, extras"} {
+		if err := os.WriteFile(fp, []byte(`
+package:
+  name: attrs
+  version: 0.0.1
+  epoch: 0
+
+data:
+  - name: extras
+    items:
+      tests: Test dependencies
+
+subpackages:
+  - range: `+rng+`
+    name: attrs-${{range.key}}
+`), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ParseConfiguration(ctx, fp); err == nil {
+			t.Errorf("expected an error for the range %q", rng)
+		}
+	}
+}
+
 func Test_propagatePipelines(t *testing.T) {
 	ctx := slogtest.Context(t)
 
@@ -300,6 +394,127 @@ pipeline:
 	require.Equal(t, "/home/build/baz", cfg.Pipeline[1].Pipeline[0].Pipeline[1].WorkDir)
 }
 
+func TestPipelineRetriesAndTimeout(t *testing.T) {
+	ctx := slogtest.Context(t)
+	fp := filepath.Join(t.TempDir(), "melange.yaml")
+	if err := os.WriteFile(fp, []byte(`
+package:
+  name: retries-and-timeout
+  version: 0.0.1
+  epoch: 0
+  description: example testing pipeline retries and timeouts
+
+pipeline:
+  - runs: ./download.sh
+    retries:
+      count: 3
+      backoff: 30s
+    timeout: 10m
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := ParseConfiguration(ctx, fp)
+	if err != nil {
+		t.Fatalf("failed to parse configuration: %s", err)
+	}
+
+	require.Equal(t, &Retries{Count: 3, Backoff: 30 * time.Second}, cfg.Pipeline[0].Retries)
+	require.Equal(t, 10*time.Minute, cfg.Pipeline[0].Timeout)
+}
+
+func TestEgress(t *testing.T) {
+	ctx := slogtest.Context(t)
+	parse := func(allow string) (*Configuration, error) {
+		fp := filepath.Join(t.TempDir(), "melange.yaml")
+		if err := os.WriteFile(fp, []byte(`
+package:
+  name: foo
+  version: 1.2.3
+  epoch: 0
+
+egress:
+  allow: `+allow+`
+`), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return ParseConfiguration(ctx, fp)
+	}
+
+	cfg, err := parse(`[github.com, "*.pypi.org", 10.0.0.0/8]`)
+	require.NoError(t, err)
+	require.Equal(t, []string{"github.com", "*.pypi.org", "10.0.0.0/8"}, cfg.Egress.Allow)
+
+	cfg, err = parse(`[]`)
+	require.NoError(t, err)
+	require.NotNil(t, cfg.Egress)
+	require.Empty(t, cfg.Egress.Allow)
+
+	_, err = parse(`[10.0.0.0/33]`)
+	require.ErrorContains(t, err, "invalid egress allowlist entry")
+}
+
+func TestMatrix(t *testing.T) {
+	ctx := slogtest.Context(t)
+	fp := filepath.Join(t.TempDir(), "melange.yaml")
+	if err := os.WriteFile(fp, []byte(`
+package:
+  name: py${{matrix.python}}-foo-${{matrix.tls}}
+  version: 1.2.3
+  epoch: 0
+  description: example testing matrix builds
+
+matrix:
+  python: ["3.11", "3.12"]
+  tls: [openssl, boringssl]
+
+environment:
+  contents:
+    packages:
+      - python-${{matrix.python}}
+      - ${{matrix.tls}}-dev
+
+pipeline:
+  - runs: python${{matrix.python}} -m build --tls=${{matrix.tls}}
+
+subpackages:
+  - name: py${{matrix.python}}-foo-${{matrix.tls}}-doc
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := ParseConfiguration(ctx, fp, WithMatrix(map[string]string{"python": "3.12", "tls": "boringssl"}))
+	if err != nil {
+		t.Fatalf("failed to parse configuration: %s", err)
+	}
+	require.Equal(t, "py3.12-foo-boringssl", cfg.Package.Name)
+	require.Equal(t, []string{"python-3.12", "boringssl-dev"}, cfg.Environment.Contents.Packages)
+	require.Equal(t, "python3.12 -m build --tls=boringssl", cfg.Pipeline[0].Runs)
+	require.Equal(t, "py3.12-foo-boringssl-doc", cfg.Subpackages[0].Name)
+	require.Equal(t, []map[string]string{
+		{"python": "3.11", "tls": "openssl"},
+		{"python": "3.11", "tls": "boringssl"},
+		{"python": "3.12", "tls": "openssl"},
+		{"python": "3.12", "tls": "boringssl"},
+	}, cfg.MatrixCombinations())
+
+	// Without values, the first combination is used.
+	cfg, err = ParseConfiguration(ctx, fp)
+	if err != nil {
+		t.Fatalf("failed to parse configuration: %s", err)
+	}
+	require.Equal(t, "py3.11-foo-openssl", cfg.Package.Name)
+
+	for _, combo := range []map[string]string{
+		{"python": "3.13", "tls": "openssl"},
+		{"python": "3.11"},
+		{"python": "3.11", "tls": "openssl", "arch": "x86_64"},
+	} {
+		if _, err := ParseConfiguration(ctx, fp, WithMatrix(combo)); err == nil {
+			t.Errorf("expected error parsing with matrix values %v", combo)
+		}
+	}
+}
+
 func Test_propagateWorkingDirectoryToUsesNodes(t *testing.T) {
 	ctx := slogtest.Context(t)
 	fp := filepath.Join(os.TempDir(), "melange-test-propagateWorkingDirectory")
@@ -407,6 +622,41 @@ func TestValidatePipelines(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "valid pipeline with retries",
+			p: []Pipeline{
+				{Runs: "somescript.sh", Retries: &Retries{Count: 3, Backoff: time.Second}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid pipeline with negative retries",
+			p: []Pipeline{
+				{Runs: "somescript.sh", Retries: &Retries{Count: -1}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid pipeline with negative timeout",
+			p: []Pipeline{
+				{Runs: "somescript.sh", Timeout: -time.Second},
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid pipeline with id",
+			p: []Pipeline{
+				{Runs: "somescript.sh", ID: "resolve_version"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid pipeline with id that isn't a variable name",
+			p: []Pipeline{
+				{Runs: "somescript.sh", ID: "resolve-version"},
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -419,6 +669,132 @@ func TestValidatePipelines(t *testing.T) {
 	}
 }
 
+func TestValidateLinters(t *testing.T) {
+	tests := []struct {
+		name    string
+		l       []Linter
+		wantErr bool
+	}{
+		{
+			name: "valid linters",
+			l: []Linter{
+				{Name: "no-jars", Path: "**/*.jar", Message: "jars must be built from source"},
+				{Name: "no-internal-hosts", Content: `\.corp\.example\.com`, Message: "internal hostname", Severity: "error"},
+			},
+			wantErr: false,
+		},
+		{
+			name:    "linter without a name",
+			l:       []Linter{{Path: "**/*.jar", Message: "jar"}},
+			wantErr: true,
+		},
+		{
+			name: "duplicate linters",
+			l: []Linter{
+				{Name: "no-jars", Path: "**/*.jar", Message: "jar"},
+				{Name: "no-jars", PathRegex: `\.jar$`, Message: "jar"},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "linter without patterns",
+			l:       []Linter{{Name: "no-jars", Message: "jar"}},
+			wantErr: true,
+		},
+		{
+			name:    "linter without a message",
+			l:       []Linter{{Name: "no-jars", Path: "**/*.jar"}},
+			wantErr: true,
+		},
+		{
+			name:    "linter with a bad regex",
+			l:       []Linter{{Name: "no-jars", PathRegex: "(", Message: "jar"}},
+			wantErr: true,
+		},
+		{
+			name:    "linter with a bad severity",
+			l:       []Linter{{Name: "no-jars", Path: "**/*.jar", Message: "jar", Severity: "fatal"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateLinters(tt.l)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateLinters() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateCaches(t *testing.T) {
+	tests := []struct {
+		name    string
+		c       []Cache
+		wantErr bool
+	}{
+		{
+			name: "valid caches",
+			c: []Cache{
+				{Name: "bazel", Target: "/root/.cache/bazel"},
+				{Name: "gradle", Target: "/root/.gradle"},
+			},
+			wantErr: false,
+		},
+		{
+			name:    "cache without a name",
+			c:       []Cache{{Target: "/root/.cache/bazel"}},
+			wantErr: true,
+		},
+		{
+			name:    "cache with a path for a name",
+			c:       []Cache{{Name: "../bazel", Target: "/root/.cache/bazel"}},
+			wantErr: true,
+		},
+		{
+			name: "duplicate caches",
+			c: []Cache{
+				{Name: "bazel", Target: "/root/.cache/bazel"},
+				{Name: "bazel", Target: "/root/.bazel"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "duplicate targets",
+			c: []Cache{
+				{Name: "bazel", Target: "/root/.cache/bazel"},
+				{Name: "other", Target: "/root/.cache/bazel"},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "relative target",
+			c:       []Cache{{Name: "bazel", Target: ".cache/bazel"}},
+			wantErr: true,
+		},
+		{
+			name:    "target in the workspace",
+			c:       []Cache{{Name: "gradle", Target: "/home/build/.gradle"}},
+			wantErr: true,
+		},
+		{
+			name:    "target containing the cache directory",
+			c:       []Cache{{Name: "var", Target: "/var/cache"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateCaches(tt.c)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateCaches() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func TestGetScheduleMessage(t *testing.T) {
 	tests := []struct {
 		schedule Schedule
@@ -441,3 +817,819 @@ func TestGetScheduleMessage(t *testing.T) {
 		}
 	}
 }
+
+func TestGitCheckoutSourceInfo(t *testing.T) {
+	p := Pipeline{
+		Uses: "git-checkout",
+		With: map[string]string{
+			"repository":         "https://github.com/example/monorepo",
+			"tag":                "v1.2.3",
+			"sparse-paths":       "libs/foo\nlibs/bar",
+			"filter":             "blob:none",
+			"recurse-submodules": "true",
+			"submodule-commits":  "# pinned for CVE-2024-1234\nvendor/zlib: 51b7f2abdade71cd9bb0e7a373ef2610ec6f9daf\n",
+			"lfs":                "true",
+		},
+	}
+	pkg, err := p.SBOMPackageForUpstreamSource("MIT", "wolfi", "0")
+	require.NoError(t, err)
+	require.Equal(t, "sparse checkout of libs/foo, libs/bar; partial clone with filter blob:none; "+
+		"with submodules (vendor/zlib at 51b7f2abdade71cd9bb0e7a373ef2610ec6f9daf); with git-lfs objects", pkg.SourceInfo)
+
+	p.With = map[string]string{"repository": "https://github.com/example/monorepo", "tag": "v1.2.3"}
+	pkg, err = p.SBOMPackageForUpstreamSource("MIT", "wolfi", "0")
+	require.NoError(t, err)
+	require.Empty(t, pkg.SourceInfo)
+}
+
+func TestFetchSourceInfo(t *testing.T) {
+	p := Pipeline{
+		Uses: "fetch",
+		With: map[string]string{
+			"uri":             "https://example.com/foo-1.2.3.tar.gz",
+			"expected-sha256": "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			"purl-name":       "foo",
+			"purl-version":    "1.2.3",
+			"signature-uri":   "https://example.com/foo-1.2.3.tar.gz.asc",
+			"trusted-keys":    "# release key\n072A F2CF 1FDE E5F0 6A36  B338 70C6 7E61 F873 7BFB\n",
+		},
+	}
+	pkg, err := p.SBOMPackageForUpstreamSource("MIT", "wolfi", "0")
+	require.NoError(t, err)
+	require.Equal(t, "gpg signature https://example.com/foo-1.2.3.tar.gz.asc verified against trusted keys "+
+		"072AF2CF1FDEE5F06A36B33870C67E61F8737BFB", pkg.SourceInfo)
+
+	delete(p.With, "signature-uri")
+	pkg, err = p.SBOMPackageForUpstreamSource("MIT", "wolfi", "0")
+	require.NoError(t, err)
+	require.Empty(t, pkg.SourceInfo)
+}
+
+func TestArchOverrides(t *testing.T) {
+	ctx := slogtest.Context(t)
+	fp := filepath.Join(t.TempDir(), "melange.yaml")
+	if err := os.WriteFile(fp, []byte(`
+package:
+  name: foo
+  version: 1.2.3
+  epoch: 0
+  description: example testing arch overrides
+
+vars:
+  simd: none
+
+environment:
+  contents:
+    packages:
+      - build-base
+      - valgrind
+
+pipeline:
+  - id: configure
+    uses: autoconf/configure
+    with:
+      opts: --with-simd=${{vars.simd}}
+  - uses: autoconf/make
+  - id: test
+    runs: make check
+
+subpackages:
+  - name: foo-doc
+    pipeline:
+      - id: docs
+        runs: make docs
+
+arch-overrides:
+  arm64:
+    vars:
+      simd: neon
+    environment:
+      contents:
+        packages:
+          add:
+            - neon-dev
+          remove:
+            - valgrind
+      environment:
+        CFLAGS: -march=armv8-a
+  s390x:
+    pipeline:
+      test:
+        skip: true
+      docs:
+        runs: make docs-lite
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := ParseConfiguration(ctx, fp, WithArch(apko_types.ParseArchitecture("aarch64")))
+	require.NoError(t, err)
+	require.Equal(t, []string{"build-base", "neon-dev"}, cfg.Environment.Contents.Packages)
+	require.Equal(t, "-march=armv8-a", cfg.Environment.Environment["CFLAGS"])
+	require.Equal(t, "--with-simd=neon", cfg.Pipeline[0].With["opts"])
+	require.Len(t, cfg.Pipeline, 3)
+
+	cfg, err = ParseConfiguration(ctx, fp, WithArch(apko_types.ParseArchitecture("s390x")))
+	require.NoError(t, err)
+	require.Equal(t, []string{"build-base", "valgrind"}, cfg.Environment.Contents.Packages)
+	require.Equal(t, "--with-simd=none", cfg.Pipeline[0].With["opts"])
+	require.Len(t, cfg.Pipeline, 2)
+	require.Equal(t, "autoconf/make", cfg.Pipeline[1].Uses)
+	require.Equal(t, "make docs-lite", cfg.Subpackages[0].Pipeline[0].Runs)
+
+	// Without an architecture, none of the overrides are applied.
+	cfg, err = ParseConfiguration(ctx, fp)
+	require.NoError(t, err)
+	require.Len(t, cfg.Pipeline, 3)
+	require.Equal(t, "make docs", cfg.Subpackages[0].Pipeline[0].Runs)
+
+	for _, overrides := range []string{`
+arch-overrides:
+  sparc:
+    vars:
+      simd: vis
+`, `
+arch-overrides:
+  s390x:
+    pipeline:
+      tests:
+        skip: true
+`} {
+		fp := filepath.Join(t.TempDir(), "melange.yaml")
+		require.NoError(t, os.WriteFile(fp, []byte(`
+package:
+  name: foo
+  version: 1.2.3
+pipeline:
+  - id: test
+    runs: make check
+`+overrides), 0644))
+		_, err := ParseConfiguration(ctx, fp)
+		require.ErrorContains(t, err, "arch-overrides")
+	}
+}
+
+func TestSplitDebug(t *testing.T) {
+	ctx := slogtest.Context(t)
+	fp := filepath.Join(t.TempDir(), "melange.yaml")
+	if err := os.WriteFile(fp, []byte(`
+package:
+  name: foo
+  version: 1.2.3
+  epoch: 0
+  description: example testing split-debug
+  split-debug: true
+
+pipeline:
+  - uses: autoconf/make
+
+subpackages:
+  - name: ${{package.name}}-libs
+    pipeline:
+      - runs: mv ${{targets.destdir}}/usr/lib ${{targets.contextdir}}/usr/
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := ParseConfiguration(ctx, fp)
+	require.NoError(t, err)
+	require.Len(t, cfg.Subpackages, 2)
+	dbg := cfg.Subpackages[1]
+	require.Equal(t, "foo-dbg", dbg.Name)
+	require.Equal(t, []Pipeline{
+		{Uses: "split/debug", With: map[string]string{"package": "foo"}},
+		{Uses: "split/debug", With: map[string]string{"package": "foo-libs"}},
+	}, dbg.Pipeline)
+
+	// The subpackage can't be defined along with split-debug.
+	if err := os.WriteFile(fp, []byte(`
+package:
+  name: foo
+  version: 1.2.3
+  epoch: 0
+  split-debug: true
+
+pipeline:
+  - uses: autoconf/make
+
+subpackages:
+  - name: foo-dbg
+    pipeline:
+      - uses: split/debug
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = ParseConfiguration(ctx, fp)
+	require.ErrorContains(t, err, `split-debug adds the subpackage "foo-dbg"`)
+}
+
+func TestDefaultSplits(t *testing.T) {
+	ctx := slogtest.Context(t)
+	fp := filepath.Join(t.TempDir(), "melange.yaml")
+	if err := os.WriteFile(fp, []byte(`
+package:
+  name: foo
+  version: 1.2.3
+  epoch: 0
+  description: example testing default splits
+  split-debug: true
+
+pipeline:
+  - uses: autoconf/make
+
+subpackages:
+  - name: ${{package.name}}-doc
+    pipeline:
+      - uses: split/manpages
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := ParseConfiguration(ctx, fp, WithDefaultSplits([]string{"lang", "dev", "doc"}))
+	require.NoError(t, err)
+	var names []string
+	for _, sp := range cfg.Subpackages {
+		names = append(names, sp.Name)
+	}
+	// The package's own foo-doc is kept, and the debug info is split from
+	// the default splits too.
+	require.Equal(t, []string{"foo-doc", "foo-dev", "foo-lang", "foo-dbg"}, names)
+	require.False(t, cfg.Subpackages[0].OmitIfEmpty)
+	require.Equal(t, Subpackage{
+		Name:        "foo-dev",
+		Description: "foo development files",
+		Pipeline:    []Pipeline{{Uses: "split/dev"}},
+		OmitIfEmpty: true,
+	}, cfg.Subpackages[1])
+	require.Equal(t, []Pipeline{{Uses: "split/locales"}}, cfg.Subpackages[2].Pipeline)
+	require.Len(t, cfg.Subpackages[3].Pipeline, 4)
+
+	_, err = ParseConfiguration(ctx, fp, WithDefaultSplits([]string{"man"}))
+	require.ErrorContains(t, err, `unknown default split "man"`)
+
+	// Packages can opt out of them.
+	if err := os.WriteFile(fp, []byte(`
+package:
+  name: foo
+  version: 1.2.3
+  epoch: 0
+  options:
+    no-default-splits: true
+
+pipeline:
+  - uses: autoconf/make
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err = ParseConfiguration(ctx, fp, WithDefaultSplits(DefaultSplitKinds()))
+	require.NoError(t, err)
+	require.Empty(t, cfg.Subpackages)
+}
+
+func TestInclude(t *testing.T) {
+	ctx := slogtest.Context(t)
+	dir := t.TempDir()
+	write := func(name, content string) {
+		p := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0o755))
+		require.NoError(t, os.WriteFile(p, []byte(content), 0o644))
+	}
+	write("common/environment.yaml", `
+include:
+  - vars.yaml
+
+environment:
+  contents:
+    repositories:
+      - https://packages.wolfi.dev/os
+    packages:
+      - busybox
+      - build-base
+  environment:
+    CFLAGS: -O2
+`)
+	write("common/vars.yaml", `
+vars:
+  mangled: foo-common
+  prefix: /usr
+`)
+	write("common/test.yaml", `
+test:
+  pipeline:
+    - runs: ${{package.name}} --version
+`)
+	write("melange.yaml", `
+include:
+  - common/environment.yaml
+  - common/test.yaml
+
+package:
+  name: foo
+  version: 1.2.3
+  epoch: 0
+
+vars:
+  prefix: /opt
+
+environment:
+  contents:
+    packages:
+      - busybox
+      - openssl-dev
+  environment:
+    CFLAGS: -O3
+
+pipeline:
+  - runs: make PREFIX=${{vars.prefix}} NAME=${{vars.mangled}}
+
+test:
+  pipeline:
+    - runs: ${{package.name}} --help
+`)
+
+	cfg, err := ParseConfiguration(ctx, filepath.Join(dir, "melange.yaml"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"common/environment.yaml", "common/test.yaml"}, cfg.Include)
+	require.Equal(t, []string{"https://packages.wolfi.dev/os"}, cfg.Environment.Contents.RuntimeRepositories)
+	require.Equal(t, []string{"busybox", "build-base", "openssl-dev"}, cfg.Environment.Contents.Packages)
+	require.Equal(t, "-O3", cfg.Environment.Environment["CFLAGS"])
+	require.Equal(t, "make PREFIX=/opt NAME=foo-common", cfg.Pipeline[0].Runs)
+	require.Len(t, cfg.Test.Pipeline, 2)
+	require.Equal(t, "foo --version", cfg.Test.Pipeline[0].Runs)
+	require.Equal(t, "foo --help", cfg.Test.Pipeline[1].Runs)
+
+	// Includes can't go around in circles, or outside of a filesystem that
+	// the configuration is read from.
+	write("common/vars.yaml", `
+include:
+  - environment.yaml
+`)
+	_, err = ParseConfiguration(ctx, filepath.Join(dir, "melange.yaml"))
+	require.ErrorContains(t, err, "melange.yaml -> common/environment.yaml -> common/vars.yaml -> common/environment.yaml")
+
+	write("melange.yaml", `
+include:
+  - ../outside.yaml
+
+package:
+  name: foo
+  version: 1.2.3
+  epoch: 0
+`)
+	_, err = ParseConfiguration(ctx, "melange.yaml", WithFS(os.DirFS(dir)))
+	require.ErrorContains(t, err, "is not in the directory of the configuration")
+}
+
+func TestExtends(t *testing.T) {
+	ctx := slogtest.Context(t)
+	dir := t.TempDir()
+	write := func(name, content string) {
+		p := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0o755))
+		require.NoError(t, os.WriteFile(p, []byte(content), 0o644))
+	}
+	write("templates/base.yaml", `
+package:
+  epoch: 0
+  copyright:
+    - license: Apache-2.0
+
+environment:
+  contents:
+    packages:
+      - busybox
+`)
+	write("templates/python-lib.yaml", `
+extends: base.yaml
+include:
+  - test.yaml
+
+package:
+  name: py3-${{vars.module}}
+  description: The ${{vars.module}} Python library
+
+environment:
+  contents:
+    packages:
+      - py3-setuptools
+
+pipeline:
+  - uses: python/build@v2
+  - uses: strip
+`)
+	write("templates/test.yaml", `
+test:
+  pipeline:
+    - runs: python3 -c "import ${{vars.module}}"
+`)
+	write("packages/melange.yaml", `
+extends: ../templates/python-lib.yaml
+
+package:
+  version: 1.2.3
+  copyright:
+    - license: MIT
+
+vars:
+  module: foo
+
+environment:
+  contents:
+    packages:
+      - py3-setuptools
+      - py3-wheel
+`)
+
+	cfg, err := ParseConfiguration(ctx, filepath.Join(dir, "packages", "melange.yaml"))
+	require.NoError(t, err)
+	require.Equal(t, "../templates/python-lib.yaml", cfg.Extends)
+	require.Equal(t, "py3-foo", cfg.Package.Name)
+	require.Equal(t, "1.2.3", cfg.Package.Version)
+	require.Equal(t, "The foo Python library", cfg.Package.Description)
+	require.Equal(t, "MIT", cfg.Package.LicenseExpression())
+	// Lists replace those of the template, rather than being appended.
+	require.Equal(t, []string{"py3-setuptools", "py3-wheel"}, cfg.Environment.Contents.Packages)
+	require.Len(t, cfg.Pipeline, 2)
+	require.Equal(t, `python3 -c "import foo"`, cfg.Test.Pipeline[0].Runs)
+
+	write("templates/base.yaml", `
+extends: python-lib.yaml
+`)
+	_, err = ParseConfiguration(ctx, filepath.Join(dir, "packages", "melange.yaml"))
+	require.ErrorContains(t, err, "melange.yaml -> ../templates/python-lib.yaml -> ../templates/base.yaml -> ../templates/python-lib.yaml")
+}
+
+func TestVarDeclarations(t *testing.T) {
+	ctx := slogtest.Context(t)
+	dir := t.TempDir()
+	fp := filepath.Join(dir, "melange.yaml")
+	if err := os.WriteFile(fp, []byte(`
+package:
+  name: foo
+  version: 1.2.3
+  epoch: 0
+
+vars:
+  plain: value
+  prefix:
+    default: /usr
+    pattern: /.*
+  flavor:
+    required: true
+    pattern: openssl|boringssl
+    description: The TLS library to build with
+
+pipeline:
+  - runs: ./configure --prefix=${{vars.prefix}} --with-tls=${{vars.flavor}}
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := ParseConfiguration(ctx, fp)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"plain": "value", "prefix": "/usr", "flavor": ""}, cfg.Vars)
+	_, err = cfg.GetVarsFromConfig()
+	require.ErrorContains(t, err, `variable "flavor" is required, but not set: The TLS library to build with`)
+
+	varsFile := filepath.Join(dir, "vars.yaml")
+	require.NoError(t, os.WriteFile(varsFile, []byte("flavor: libressl\n"), 0644))
+	cfg, err = ParseConfiguration(ctx, fp, WithVarsFileForParsing(varsFile))
+	require.NoError(t, err)
+	_, err = cfg.GetVarsFromConfig()
+	require.ErrorContains(t, err, `variable "flavor" is "libressl", which does not match pattern "openssl|boringssl"`)
+
+	require.NoError(t, os.WriteFile(varsFile, []byte("flavor: boringssl\n"), 0644))
+	cfg, err = ParseConfiguration(ctx, fp, WithVarsFileForParsing(varsFile))
+	require.NoError(t, err)
+	vars, err := cfg.GetVarsFromConfig()
+	require.NoError(t, err)
+	require.Equal(t, "boringssl", vars["${{vars.flavor}}"])
+
+	// Declarations are checked as they are parsed.
+	for _, decl := range []string{
+		"default: usr\n    pattern: /.*",
+		"pattern: '('",
+		"requried: true",
+	} {
+		if err := os.WriteFile(fp, []byte(`
+package:
+  name: foo
+  version: 1.2.3
+  epoch: 0
+
+vars:
+  prefix:
+    `+decl+`
+`), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err = ParseConfiguration(ctx, fp)
+		require.Error(t, err, decl)
+	}
+}
+
+func TestStrict(t *testing.T) {
+	ctx := slogtest.Context(t)
+	fp := filepath.Join(t.TempDir(), "melange.yaml")
+	if err := os.WriteFile(fp, []byte(`
+package:
+  name: foo
+  version: 1.2.3
+  epoch: 0
+
+  dependencies:
+    runtime:
+
+subpackages:
+  - name: foo-dev
+    test:
+    pipeline:
+      - runs: test -f /usr/include/foo.h
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The test's pipeline silently becomes the subpackage's.
+	cfg, err := ParseConfiguration(ctx, fp)
+	require.NoError(t, err)
+	require.Nil(t, cfg.Subpackages[0].Test)
+	require.Len(t, cfg.Subpackages[0].Pipeline, 1)
+
+	_, err = ParseConfiguration(ctx, fp, WithStrict(true))
+	require.ErrorContains(t, err, `line 12: "test" has no value; is what follows it meant to be indented under it?`)
+	require.NotContains(t, err.Error(), `"runtime"`)
+}
+
+func TestEnv(t *testing.T) {
+	ctx := slogtest.Context(t)
+	t.Setenv("BUILD_NUMBER", "7")
+	t.Setenv("MIRROR", "https://mirror.example.com/wolfi")
+	t.Setenv("SECRET", "hunter2")
+
+	fp := filepath.Join(t.TempDir(), "melange.yaml")
+	write := func(s string) {
+		if err := os.WriteFile(fp, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`
+package:
+  name: foo
+  version: 1.2.3
+  epoch: ${{env.BUILD_NUMBER}}
+
+environment:
+  contents:
+    repositories:
+      - ${{env.MIRROR}}
+
+pipeline:
+  - runs: echo build ${{env.BUILD_NUMBER}}
+`)
+
+	cfg, err := ParseConfiguration(ctx, fp, WithAllowedEnv([]string{"BUILD_NUMBER", "MIRROR"}))
+	require.NoError(t, err)
+	require.Equal(t, uint64(7), cfg.Package.Epoch)
+	require.Equal(t, []string{"https://mirror.example.com/wolfi"}, cfg.Environment.Contents.RuntimeRepositories)
+	require.Equal(t, "echo build 7", cfg.Pipeline[0].Runs)
+
+	_, err = ParseConfiguration(ctx, fp, WithAllowedEnv([]string{"BUILD_NUMBER"}))
+	require.ErrorContains(t, err, `environment variable "MIRROR" is not allowed`)
+
+	_, err = ParseConfiguration(ctx, fp)
+	require.ErrorContains(t, err, `environment variable "BUILD_NUMBER" is not allowed`)
+
+	write(`
+package:
+  name: foo
+  version: 1.2.3
+  epoch: 0
+
+pipeline:
+  - runs: echo ${{env.UNSET_FOR_TEST}}
+`)
+	_, err = ParseConfiguration(ctx, fp, WithAllowedEnv([]string{"UNSET_FOR_TEST"}))
+	require.ErrorContains(t, err, `environment variable "UNSET_FOR_TEST" is not set`)
+}
+
+func TestSubpackageNeeds(t *testing.T) {
+	ctx := slogtest.Context(t)
+	fp := filepath.Join(t.TempDir(), "melange.yaml")
+	if err := os.WriteFile(fp, []byte(`
+package:
+  name: foo
+  version: 1.2.3
+  epoch: 0
+
+data:
+  - name: docs
+    items:
+      html: gtk-doc
+      man: scdoc
+
+subpackages:
+  - range: docs
+    name: ${{package.name}}-${{range.key}}
+    needs:
+      packages:
+        - ${{range.value}}
+    pipeline:
+      - runs: make ${{range.key}}
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := ParseConfiguration(ctx, fp)
+	require.NoError(t, err)
+	require.Len(t, cfg.Subpackages, 2)
+	require.Equal(t, &Needs{Packages: []string{"gtk-doc"}}, cfg.Subpackages[0].Needs)
+	require.Equal(t, &Needs{Packages: []string{"scdoc"}}, cfg.Subpackages[1].Needs)
+}
+
+func TestValidateScriptlets(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       *Scriptlets
+		wantErr bool
+	}{
+		{
+			name:    "no scriptlets",
+			wantErr: false,
+		},
+		{
+			name: "trigger with several paths",
+			s: &Scriptlets{
+				PreUpgrade: "#!/bin/sh\n",
+				Trigger:    Trigger{Script: "#!/bin/sh\n", Paths: []string{"/usr/lib/foo", "/usr/share/foo/*"}},
+			},
+			wantErr: false,
+		},
+		{
+			name:    "trigger without paths",
+			s:       &Scriptlets{Trigger: Trigger{Script: "#!/bin/sh\n"}},
+			wantErr: true,
+		},
+		{
+			name:    "trigger without a script",
+			s:       &Scriptlets{Trigger: Trigger{Paths: []string{"/usr/lib/foo"}}},
+			wantErr: true,
+		},
+		{
+			name:    "relative trigger path",
+			s:       &Scriptlets{Trigger: Trigger{Script: "#!/bin/sh\n", Paths: []string{"usr/lib/foo"}}},
+			wantErr: true,
+		},
+		{
+			name:    "trigger path with a space",
+			s:       &Scriptlets{Trigger: Trigger{Script: "#!/bin/sh\n", Paths: []string{"/usr/lib/foo bar"}}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateScriptlets(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateScriptlets() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSubpackageBuiltFor(t *testing.T) {
+	tests := []struct {
+		name  string
+		archs []string
+		arch  string
+		want  bool
+	}{
+		{name: "all architectures", arch: "x86_64", want: true},
+		{name: "listed", archs: []string{"x86_64", "aarch64"}, arch: "aarch64", want: true},
+		{name: "listed by another name", archs: []string{"amd64"}, arch: "x86_64", want: true},
+		{name: "not listed", archs: []string{"x86_64", "aarch64"}, arch: "riscv64", want: false},
+		{name: "all", archs: []string{"all"}, arch: "riscv64", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sp := Subpackage{Name: "foo-efi", TargetArchitecture: tt.archs}
+			if got := sp.BuiltFor(apko_types.ParseArchitecture(tt.arch)); got != tt.want {
+				t.Errorf("BuiltFor(%s) = %v, want %v", tt.arch, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateDependenciesPriorities(t *testing.T) {
+	tests := []struct {
+		name    string
+		deps    Dependencies
+		wantErr bool
+	}{
+		{name: "no priorities", wantErr: false},
+		{name: "valid priorities", deps: Dependencies{ProviderPriority: "17", ReplacesPriority: "0"}, wantErr: false},
+		{name: "invalid provider priority", deps: Dependencies{ProviderPriority: "high"}, wantErr: true},
+		{name: "invalid replaces priority", deps: Dependencies{ProviderPriority: "10", ReplacesPriority: "1.5"}, wantErr: true},
+		{name: "negative priority", deps: Dependencies{ReplacesPriority: "-1"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateDependenciesPriorities(tt.deps)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateDependenciesPriorities() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSubpackageCopyright(t *testing.T) {
+	ctx := slogtest.Context(t)
+
+	fp := filepath.Join(t.TempDir(), "subpackage-copyright.yaml")
+	if err := os.WriteFile(fp, []byte(`
+package:
+  name: foo
+  version: 1.2.3
+  epoch: 0
+  copyright:
+    - license: Apache-2.0
+      attestation: Copyright Foo
+
+data:
+  - name: components
+    items:
+      bar: MIT
+      baz: BSD-3-Clause
+
+subpackages:
+  - name: foo-doc
+    copyright:
+      - license: CC-BY-4.0
+  - name: foo-dev
+  - range: components
+    name: foo-${{range.key}}
+    copyright:
+      - license: ${{range.value}}
+        license-path: LICENSE.${{range.key}}
+`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := ParseConfiguration(ctx, fp)
+	require.NoError(t, err)
+
+	got := map[string]string{}
+	for _, sp := range cfg.Subpackages {
+		got[sp.Name] = sp.LicenseExpression(cfg.Package)
+	}
+	require.Equal(t, map[string]string{
+		"foo-doc": "CC-BY-4.0",
+		"foo-dev": "Apache-2.0",
+		"foo-bar": "MIT",
+		"foo-baz": "BSD-3-Clause",
+	}, got)
+
+	require.Equal(t, "Copyright Foo\n", cfg.Subpackages[1].FullCopyright(cfg.Package))
+	require.Equal(t, "\n", cfg.Subpackages[0].FullCopyright(cfg.Package))
+	require.Equal(t, "LICENSE.bar", cfg.Subpackages[2].Copyright[0].LicensePath)
+}
+
+// melange query selects fields by their JSON names, so they must be the ones
+// in the YAML file.
+func TestJSONNamesMatchYAML(t *testing.T) {
+	seen := map[reflect.Type]bool{}
+	var check func(typ reflect.Type)
+	check = func(typ reflect.Type) {
+		switch typ.Kind() {
+		case reflect.Pointer, reflect.Slice, reflect.Array, reflect.Map:
+			check(typ.Elem())
+			return
+		case reflect.Struct:
+		default:
+			return
+		}
+		if seen[typ] || typ.PkgPath() != reflect.TypeOf(Configuration{}).PkgPath() {
+			return
+		}
+		seen[typ] = true
+
+		for i := range typ.NumField() {
+			f := typ.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			jsonName, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if jsonName == "" {
+				jsonName = f.Name
+			}
+			yamlName, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+			if yamlName == "" {
+				yamlName = strings.ToLower(f.Name)
+			}
+			require.Equal(t, yamlName, jsonName, "JSON name of %s.%s", typ.Name(), f.Name)
+			check(f.Type)
+		}
+	}
+	check(reflect.TypeOf(Configuration{}))
+}
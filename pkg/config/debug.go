@@ -0,0 +1,53 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import "fmt"
+
+// debugSubpackageSuffix is what the name of the subpackage that split-debug
+// adds ends in.
+const debugSubpackageSuffix = "-dbg"
+
+// addDebugSubpackage adds the subpackage that the debug info of the package
+// and its subpackages is split into, if the package asks for it. It comes
+// after the other subpackages, so that it splits what they have once their
+// pipelines have run.
+func (cfg *Configuration) addDebugSubpackage() error {
+	if !cfg.Package.SplitDebug {
+		return nil
+	}
+
+	name := cfg.Package.Name + debugSubpackageSuffix
+	pipeline := []Pipeline{{
+		Uses: "split/debug",
+		With: map[string]string{"package": cfg.Package.Name},
+	}}
+	for _, sp := range cfg.Subpackages {
+		if sp.Name == name {
+			return fmt.Errorf("split-debug adds the subpackage %q, which is already defined", name)
+		}
+		pipeline = append(pipeline, Pipeline{
+			Uses: "split/debug",
+			With: map[string]string{"package": sp.Name},
+		})
+	}
+
+	cfg.Subpackages = append(cfg.Subpackages, Subpackage{
+		Name:        name,
+		Description: cfg.Package.Name + " debug symbols",
+		Pipeline:    pipeline,
+	})
+	return nil
+}
@@ -0,0 +1,74 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+var envRegex = regexp.MustCompile(`\$\{\{env\.([A-Za-z_][A-Za-z0-9_]*)\}\}`)
+
+// applyEnv substitutes the values of the environment variables for
+// ${{env.*}} in every scalar of the configuration in data. Only the
+// variables in allowed can be substituted, so that a configuration can't
+// read anything else from the environment of whoever builds it.
+func applyEnv(data []byte, allowed []string) ([]byte, error) {
+	if !envRegex.Match(data) {
+		return data, nil
+	}
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+
+	var err error
+	var replace func(n *yaml.Node)
+	replace = func(n *yaml.Node) {
+		if n.Kind == yaml.ScalarNode && envRegex.MatchString(n.Value) {
+			n.Value = envRegex.ReplaceAllStringFunc(n.Value, func(ref string) string {
+				name := envRegex.FindStringSubmatch(ref)[1]
+				if !slices.Contains(allowed, name) {
+					err = fmt.Errorf("environment variable %q is not allowed", name)
+					return ref
+				}
+				v, ok := os.LookupEnv(name)
+				if !ok {
+					err = fmt.Errorf("environment variable %q is not set", name)
+					return ref
+				}
+				return v
+			})
+			// Let plain scalars resolve to what they hold now, such as an
+			// epoch.
+			if n.Style == 0 {
+				n.Tag = ""
+			}
+		}
+		for _, c := range n.Content {
+			replace(c)
+		}
+	}
+	replace(&root)
+	if err != nil {
+		return nil, err
+	}
+
+	return yaml.Marshal(&root)
+}
@@ -0,0 +1,259 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// topLevelOrder is the canonical order of the top-level keys of a
+// configuration. It's the order they're usually written in, rather than that
+// of the fields of Configuration.
+var topLevelOrder = []string{
+	"include",
+	"extends",
+	"package",
+	"environment",
+	"vars",
+	"var-transforms",
+	"data",
+	"options",
+	"matrix",
+	"arch-overrides",
+	"caches",
+	"egress",
+	"linters",
+	"pipeline",
+	"subpackages",
+	"update",
+	"test",
+}
+
+// Format rewrites the YAML document of a configuration, as it's written
+// rather than parsed, into its canonical form: the keys of each mapping are
+// in the order of the fields they're decoded into, with those melange
+// doesn't know last, and the scripts that pipelines run are literal blocks.
+// Lists and mappings are written in block style. Comments move along with the
+// keys they're on. The configuration that the document is parsed into stays
+// the same.
+func Format(doc *yaml.Node) error {
+	root := doc
+	if root.Kind == yaml.DocumentNode {
+		if len(root.Content) == 0 {
+			return nil
+		}
+		root = root.Content[0]
+	}
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("expected a mapping at the top of the configuration, got %s", root.Tag)
+	}
+
+	blockStyle(root)
+	fields := yamlFields(reflect.TypeOf(Configuration{}))
+	sortMapping(root, topLevelOrder)
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if f, ok := fields[root.Content[i].Value]; ok {
+			formatNode(root.Content[i+1], f.typ)
+		}
+	}
+	return nil
+}
+
+// yamlField is a field of a struct, by the key it's decoded from.
+type yamlField struct {
+	index int
+	typ   reflect.Type
+}
+
+// yamlFields returns the fields of the struct t by their keys, in the order
+// that they're declared in.
+func yamlFields(t reflect.Type) map[string]yamlField {
+	fields := map[string]yamlField{}
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+		if strings.Contains(opts, "inline") {
+			for k, inner := range yamlFields(indirect(f.Type)) {
+				fields[k] = yamlField{index: len(fields), typ: inner.typ}
+			}
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		fields[name] = yamlField{index: len(fields), typ: f.Type}
+	}
+	return fields
+}
+
+// indirect returns the type that t points to, if it's a pointer.
+func indirect(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t
+}
+
+var pipelineType = reflect.TypeOf(Pipeline{})
+
+// keyOrders are the canonical orders of the keys of the types whose fields
+// aren't declared in the order they're usually written in. The keys of the
+// other types are in the order of their fields.
+var keyOrders = map[reflect.Type][]string{
+	pipelineType: {
+		"name",
+		"id",
+		"label",
+		"if",
+		"needs",
+		"assertions",
+		"uses",
+		"working-directory",
+		"environment",
+		"with",
+		"inputs",
+		"retries",
+		"timeout",
+		"breakpoint",
+		"runs",
+		"pipeline",
+	},
+	reflect.TypeOf(Subpackage{}): {
+		"name",
+		"if",
+		"range",
+		"target-architecture",
+		"description",
+		"url",
+		"commit",
+		"copyright",
+		"independent",
+		"dependencies",
+		"options",
+		"scriptlets",
+		"checks",
+		"needs",
+		"pipeline",
+		"contents",
+		"test",
+	},
+}
+
+// formatNode formats the node n, which is decoded into a value of type t.
+func formatNode(n *yaml.Node, t reflect.Type) {
+	t = indirect(t)
+	switch t.Kind() {
+	case reflect.Struct:
+		if n.Kind != yaml.MappingNode {
+			return
+		}
+		fields := yamlFields(t)
+		order, ok := keyOrders[t]
+		if !ok {
+			order = make([]string, len(fields))
+			for k, f := range fields {
+				order[f.index] = k
+			}
+		}
+		sortMapping(n, order)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, value := n.Content[i].Value, n.Content[i+1]
+			if f, ok := fields[key]; ok {
+				formatNode(value, f.typ)
+			}
+			if t == pipelineType && key == "runs" {
+				formatScript(value)
+			}
+		}
+
+	case reflect.Slice, reflect.Array:
+		if n.Kind != yaml.SequenceNode {
+			return
+		}
+		for _, item := range n.Content {
+			formatNode(item, t.Elem())
+		}
+
+	case reflect.Map:
+		// The keys of maps, such as the inputs of a pipeline, are left in the
+		// order they're written in.
+		if n.Kind != yaml.MappingNode {
+			return
+		}
+		for i := 1; i < len(n.Content); i += 2 {
+			formatNode(n.Content[i], t.Elem())
+		}
+	}
+}
+
+// blockStyle writes n and all the nodes in it in block style, rather than
+// flow style, such as [a, b].
+func blockStyle(n *yaml.Node) {
+	n.Style &^= yaml.FlowStyle
+	for _, c := range n.Content {
+		blockStyle(c)
+	}
+}
+
+// sortMapping sorts the keys of the mapping n in the order of the keys in
+// order, with those that aren't in it last, in the order they're in.
+func sortMapping(n *yaml.Node, order []string) {
+	type pair struct {
+		key, value *yaml.Node
+	}
+	pairs := make([]pair, 0, len(n.Content)/2)
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		pairs = append(pairs, pair{n.Content[i], n.Content[i+1]})
+	}
+
+	rank := func(p pair) int {
+		if i := slices.Index(order, p.key.Value); i >= 0 {
+			return i
+		}
+		return len(order)
+	}
+	slices.SortStableFunc(pairs, func(a, b pair) int {
+		return rank(a) - rank(b)
+	})
+
+	n.Content = n.Content[:0]
+	for _, p := range pairs {
+		n.Content = append(n.Content, p.key, p.value)
+	}
+}
+
+// formatScript makes the script in n, if it has more than one line, a
+// literal block. The script itself is left as it is, so if it has trailing
+// whitespace that a literal block can't hold, it's still written quoted.
+func formatScript(n *yaml.Node) {
+	if n.Kind != yaml.ScalarNode || n.Tag != "!!str" {
+		return
+	}
+	if !strings.Contains(strings.TrimRight(n.Value, "\n"), "\n") {
+		return
+	}
+	n.Style = yaml.LiteralStyle
+}
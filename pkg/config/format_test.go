@@ -0,0 +1,113 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestFormat(t *testing.T) {
+	for _, tt := range []struct {
+		name, in, want string
+	}{{
+		name: "top-level keys",
+		in: `pipeline:
+- uses: fetch
+package:
+  version: 1.2.3
+  # The name of the package.
+  name: hello
+x-custom: true
+environment: {}
+`,
+		want: `package:
+  # The name of the package.
+  name: hello
+  version: 1.2.3
+environment: {}
+pipeline:
+  - uses: fetch
+x-custom: true
+`,
+	}, {
+		name: "pipelines",
+		in: `pipeline:
+- with:
+    repository: https://github.com/example/hello
+    tag: v${{package.version}}
+  uses: git-checkout
+- runs: "make\nmake install\n"
+  if: ${{build.arch}} == 'x86_64'
+  name: build
+- runs: make check
+`,
+		want: `pipeline:
+  - uses: git-checkout
+    with:
+      repository: https://github.com/example/hello
+      tag: v${{package.version}}
+  - name: build
+    if: ${{build.arch}} == 'x86_64'
+    runs: |
+      make
+      make install
+  - runs: make check
+`,
+	}, {
+		name: "scripts",
+		in: `pipeline:
+- runs: "make  \nmake install\t\n"
+- runs: "cat <<EOF\n  indented\n\nEOF\n\n"
+- runs: "make\nmake install"
+`,
+		want: `pipeline:
+  - runs: "make  \nmake install\t\n"
+  - runs: |+
+      cat <<EOF
+        indented
+
+      EOF
+
+  - runs: |-
+      make
+      make install
+`,
+	}, {
+		name: "subpackages",
+		in: `subpackages:
+- pipeline:
+  - uses: split/dev
+  description: headers
+  name: hello-dev
+  x-custom: [a, b]
+`,
+		want: `subpackages:
+  - name: hello-dev
+    description: headers
+    pipeline:
+      - uses: split/dev
+    x-custom:
+      - a
+      - b
+`,
+	}} {
+		t.Run(tt.name, func(t *testing.T) {
+			var doc yaml.Node
+			require.NoError(t, yaml.Unmarshal([]byte(tt.in), &doc))
+			require.NoError(t, Format(&doc))
+			var out strings.Builder
+			enc := yaml.NewEncoder(&out)
+			enc.SetIndent(2)
+			require.NoError(t, enc.Encode(&doc))
+			require.Equal(t, tt.want, out.String())
+
+			// Formatting must not change what the configuration means.
+			var before, after Configuration
+			require.NoError(t, yaml.Unmarshal([]byte(tt.in), &before))
+			require.NoError(t, yaml.Unmarshal([]byte(out.String()), &after))
+			require.Equal(t, before, after)
+		})
+	}
+}
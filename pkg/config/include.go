@@ -0,0 +1,264 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// readFileFunc reads a file of the configuration, by its slash-separated
+// path relative to the filesystem that the configuration is read from.
+type readFileFunc func(name string) ([]byte, error)
+
+// fsReadFile returns a readFileFunc that reads files from fsys, and from
+// dir on the host for paths that go outside of fsys, if dir isn't empty.
+func fsReadFile(fsys fs.FS, dir string) readFileFunc {
+	return func(name string) ([]byte, error) {
+		if fs.ValidPath(name) {
+			return fs.ReadFile(fsys, name)
+		}
+		if dir == "" || path.IsAbs(name) {
+			return nil, fmt.Errorf("%q is not in the directory of the configuration", name)
+		}
+		return os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+	}
+}
+
+// applyIncludes merges the template that the configuration in data, read
+// from file, extends, and the fragments that it includes, into it, and
+// returns the result.
+//
+// Fragments are merged in the order they are included, each one over the
+// ones before it, and the configuration itself over all of them:
+//
+//   - mappings are merged key by key,
+//   - sequences are concatenated, with the items of the fragments first, and
+//     scalars that are already in the sequence left out,
+//   - anything else is taken from the configuration.
+//
+// The configuration, with its fragments, is then merged over the template
+// it extends, which overrides whatever it sets: mappings are merged key by
+// key, and anything else, sequences included, is replaced.
+//
+// Fragments and templates can include fragments, and templates can extend
+// templates, by paths relative to their own.
+func applyIncludes(read readFileFunc, file string, data []byte) ([]byte, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	if len(root.Content) == 0 {
+		return data, nil
+	}
+	doc := root.Content[0]
+	includes, extends := mappingValue(doc, "include"), mappingValue(doc, "extends")
+	if includes == nil && extends == nil {
+		return data, nil
+	}
+
+	merged, err := resolveExtends(read, file, doc, []string{path.Clean(file)})
+	if err != nil {
+		return nil, err
+	}
+	// Keep what the configuration itself includes and extends, rather than
+	// what its fragments and templates did.
+	if includes != nil {
+		merged.Content = append(merged.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "include"}, includes)
+	}
+	if extends != nil {
+		merged.Content = append(merged.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "extends"}, extends)
+	}
+	root.Content[0] = merged
+	return yaml.Marshal(&root)
+}
+
+// resolveExtends returns doc, read from file, with the fragments it
+// includes, merged over the template it extends. stack is the files being
+// resolved, to detect cycles.
+func resolveExtends(read readFileFunc, file string, doc *yaml.Node, stack []string) (*yaml.Node, error) {
+	extends := mappingValue(doc, "extends")
+	deleteMappingKey(doc, "extends")
+
+	doc, err := resolveIncludes(read, file, doc, stack)
+	if err != nil {
+		return nil, err
+	}
+	if extends == nil {
+		return doc, nil
+	}
+
+	var template string
+	if err := extends.Decode(&template); err != nil {
+		return nil, fmt.Errorf("%s: decoding extends: %w", file, err)
+	}
+	name, tdoc, err := readFragment(read, file, template, stack)
+	if err != nil {
+		return nil, err
+	}
+	if tdoc == nil {
+		return doc, nil
+	}
+	tdoc, err = resolveExtends(read, name, tdoc, append(stack, name))
+	if err != nil {
+		return nil, err
+	}
+	return overrideNodes(tdoc, doc), nil
+}
+
+// resolveIncludes returns doc, read from file, merged over the fragments it
+// includes. stack is the files being resolved, to detect cycles.
+func resolveIncludes(read readFileFunc, file string, doc *yaml.Node, stack []string) (*yaml.Node, error) {
+	includes := mappingValue(doc, "include")
+	if includes == nil {
+		return doc, nil
+	}
+	var paths []string
+	if err := includes.Decode(&paths); err != nil {
+		return nil, fmt.Errorf("%s: decoding include: %w", file, err)
+	}
+	deleteMappingKey(doc, "include")
+
+	var base *yaml.Node
+	for _, p := range paths {
+		name, fragment, err := readFragment(read, file, p, stack)
+		if err != nil {
+			return nil, err
+		}
+		if fragment == nil {
+			continue
+		}
+		fragment, err = resolveIncludes(read, name, fragment, append(stack, name))
+		if err != nil {
+			return nil, err
+		}
+		base = mergeNodes(base, fragment)
+	}
+
+	return mergeNodes(base, doc), nil
+}
+
+// readFragment reads the file at p, relative to file, and returns its path
+// and its document, or nil if it's empty.
+func readFragment(read readFileFunc, file, p string, stack []string) (string, *yaml.Node, error) {
+	name := path.Join(path.Dir(file), p)
+	if slices.Contains(stack, name) {
+		return "", nil, fmt.Errorf("%s: including %q again: %s", file, p, strings.Join(append(stack, name), " -> "))
+	}
+
+	b, err := read(name)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: reading %q: %w", file, p, err)
+	}
+	var root yaml.Node
+	if err := yaml.Unmarshal(b, &root); err != nil {
+		return "", nil, fmt.Errorf("decoding %q: %w", name, err)
+	}
+	if len(root.Content) == 0 {
+		return name, nil, nil
+	}
+	if root.Content[0].Kind != yaml.MappingNode {
+		return "", nil, fmt.Errorf("%q is not a mapping", name)
+	}
+	return name, root.Content[0], nil
+}
+
+// overrideNodes merges over into base, as a configuration overrides the
+// template it extends, and returns the result.
+func overrideNodes(base, over *yaml.Node) *yaml.Node {
+	if base.Kind != yaml.MappingNode || over.Kind != yaml.MappingNode {
+		return over
+	}
+	for i := 0; i+1 < len(over.Content); i += 2 {
+		key, value := over.Content[i], over.Content[i+1]
+		if j := mappingIndex(base, key.Value); j >= 0 {
+			base.Content[j+1] = overrideNodes(base.Content[j+1], value)
+		} else {
+			base.Content = append(base.Content, key, value)
+		}
+	}
+	return base
+}
+
+// mergeNodes merges over into base, as applyIncludes describes, and
+// returns the result. base may be nil.
+func mergeNodes(base, over *yaml.Node) *yaml.Node {
+	if base == nil || base.Kind != over.Kind {
+		return over
+	}
+
+	switch over.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(over.Content); i += 2 {
+			key, value := over.Content[i], over.Content[i+1]
+			if j := mappingIndex(base, key.Value); j >= 0 {
+				base.Content[j+1] = mergeNodes(base.Content[j+1], value)
+			} else {
+				base.Content = append(base.Content, key, value)
+			}
+		}
+		return base
+
+	case yaml.SequenceNode:
+		for _, item := range over.Content {
+			if item.Kind == yaml.ScalarNode && slices.ContainsFunc(base.Content, func(n *yaml.Node) bool {
+				return n.Kind == yaml.ScalarNode && n.Value == item.Value
+			}) {
+				continue
+			}
+			base.Content = append(base.Content, item)
+		}
+		return base
+
+	default:
+		return over
+	}
+}
+
+// mappingIndex returns the index of key in the content of the mapping node,
+// or -1 if it isn't in it.
+func mappingIndex(node *yaml.Node, key string) int {
+	if node.Kind != yaml.MappingNode {
+		return -1
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// mappingValue returns the value of key in the mapping node, or nil.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if i := mappingIndex(node, key); i >= 0 {
+		return node.Content[i+1]
+	}
+	return nil
+}
+
+// deleteMappingKey removes key, and its value, from the mapping node.
+func deleteMappingKey(node *yaml.Node, key string) {
+	if i := mappingIndex(node, key); i >= 0 {
+		node.Content = slices.Delete(node.Content, i, i+2)
+	}
+}
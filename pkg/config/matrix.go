@@ -0,0 +1,129 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var matrixKeyRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// MatrixCombinations returns every combination of the values in the
+// configuration's matrix, in a stable order, or nil if it doesn't have one.
+func (cfg Configuration) MatrixCombinations() []map[string]string {
+	if len(cfg.Matrix) == 0 {
+		return nil
+	}
+
+	combos := []map[string]string{{}}
+	for _, k := range slices.Sorted(maps.Keys(cfg.Matrix)) {
+		next := make([]map[string]string, 0, len(combos)*len(cfg.Matrix[k]))
+		for _, combo := range combos {
+			for _, v := range cfg.Matrix[k] {
+				c := maps.Clone(combo)
+				c[k] = v
+				next = append(next, c)
+			}
+		}
+		combos = next
+	}
+	return combos
+}
+
+// MatrixName returns a short name for a combination of matrix values, made
+// of its values in the order of their keys.
+func MatrixName(combo map[string]string) string {
+	values := make([]string, 0, len(combo))
+	for _, k := range slices.Sorted(maps.Keys(combo)) {
+		values = append(values, combo[k])
+	}
+	return strings.Join(values, "-")
+}
+
+// applyMatrix substitutes the values of combo for ${{matrix.*}} in every
+// scalar of the configuration in data, other than the matrix itself. If
+// combo is nil, the first combination is used.
+func applyMatrix(data []byte, combo map[string]string) ([]byte, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	if len(root.Content) == 0 {
+		return data, nil
+	}
+	doc := root.Content[0]
+
+	var matrix map[string][]string
+	for i := 0; i+1 < len(doc.Content); i += 2 {
+		if doc.Content[i].Value == "matrix" {
+			if err := doc.Content[i+1].Decode(&matrix); err != nil {
+				return nil, fmt.Errorf("decoding matrix: %w", err)
+			}
+		}
+	}
+	if len(matrix) == 0 {
+		if len(combo) != 0 {
+			return nil, fmt.Errorf("matrix values given, but the configuration has no matrix")
+		}
+		return data, nil
+	}
+
+	for k, vs := range matrix {
+		if !matrixKeyRegex.MatchString(k) {
+			return nil, fmt.Errorf("matrix variable %q must match regex %q", k, matrixKeyRegex)
+		}
+		if len(vs) == 0 {
+			return nil, fmt.Errorf("matrix variable %q has no values", k)
+		}
+	}
+
+	if combo == nil {
+		combo = Configuration{Matrix: matrix}.MatrixCombinations()[0]
+	}
+	replacements := make([]string, 0, 2*len(combo))
+	for k, v := range combo {
+		if !slices.Contains(matrix[k], v) {
+			return nil, fmt.Errorf("matrix variable %q has no value %q", k, v)
+		}
+		replacements = append(replacements, fmt.Sprintf("${{matrix.%s}}", k), v)
+	}
+	if len(combo) != len(matrix) {
+		return nil, fmt.Errorf("matrix values %v don't set every matrix variable", combo)
+	}
+	r := strings.NewReplacer(replacements...)
+
+	var replace func(n *yaml.Node)
+	replace = func(n *yaml.Node) {
+		if n.Kind == yaml.ScalarNode {
+			n.Value = r.Replace(n.Value)
+		}
+		for _, c := range n.Content {
+			replace(c)
+		}
+	}
+	for i := 0; i+1 < len(doc.Content); i += 2 {
+		if doc.Content[i].Value != "matrix" {
+			replace(doc.Content[i+1])
+		}
+	}
+
+	return yaml.Marshal(&root)
+}
@@ -0,0 +1,57 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed schema.json
+var schema []byte
+
+// Schema returns the JSON Schema of the configuration format, which
+// internal/gen-jsonschema generates from Configuration.
+func Schema() []byte {
+	return schema
+}
+
+// checkStrict returns an error for each key in the configuration in node
+// that has no value, but is followed by another key of the same mapping.
+// Such keys are usually meant to have what follows them indented under them,
+// which otherwise silently ends up somewhere else, such as a test's pipeline
+// in the pipeline of its subpackage.
+func checkStrict(node *yaml.Node) error {
+	var errs []error
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, n := range node.Content {
+			errs = append(errs, checkStrict(n))
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if value.Kind == yaml.ScalarNode && value.Tag == "!!null" && value.Value == "" && i+2 < len(node.Content) {
+				errs = append(errs, fmt.Errorf("line %d: %q has no value; is what follows it meant to be indented under it?", key.Line, key.Value))
+				continue
+			}
+			errs = append(errs, checkStrict(value))
+		}
+	}
+	return errors.Join(errs...)
+}
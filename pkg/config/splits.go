@@ -0,0 +1,80 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"slices"
+)
+
+// defaultSplit is a kind of subpackage that WithDefaultSplits can add to
+// every package: the suffix of its name, the pipeline that splits it from
+// the package, and what it's described as.
+type defaultSplit struct {
+	kind, pipeline, description string
+}
+
+// The kinds of default splits, in the order they're split from the package,
+// which is the order abuild splits them in: static libraries before the rest
+// of the development files, so that they don't end up in -dev.
+var defaultSplits = []defaultSplit{
+	{"static", "split/static", "static libraries"},
+	{"dev", "split/dev", "development files"},
+	{"doc", "split/doc", "documentation"},
+	{"lang", "split/locales", "translations"},
+}
+
+// DefaultSplitKinds returns the kinds of subpackages that WithDefaultSplits
+// can add.
+func DefaultSplitKinds() []string {
+	kinds := make([]string, 0, len(defaultSplits))
+	for _, s := range defaultSplits {
+		kinds = append(kinds, s.kind)
+	}
+	return kinds
+}
+
+// addDefaultSplits adds a subpackage for each of the kinds of default splits,
+// unless the package opts out of them or already defines that subpackage
+// itself. They come after the package's own subpackages, so that those take
+// what they want first, and are left out of the build if nothing is split
+// into them.
+func (cfg *Configuration) addDefaultSplits(kinds []string) error {
+	for _, kind := range kinds {
+		if !slices.Contains(DefaultSplitKinds(), kind) {
+			return fmt.Errorf("unknown default split %q, must be one of %q", kind, DefaultSplitKinds())
+		}
+	}
+	if len(kinds) == 0 || (cfg.Package.Options != nil && cfg.Package.Options.NoDefaultSplits) {
+		return nil
+	}
+
+	for _, s := range defaultSplits {
+		if !slices.Contains(kinds, s.kind) {
+			continue
+		}
+		name := cfg.Package.Name + "-" + s.kind
+		if slices.ContainsFunc(cfg.Subpackages, func(sp Subpackage) bool { return sp.Name == name }) {
+			continue
+		}
+		cfg.Subpackages = append(cfg.Subpackages, Subpackage{
+			Name:        name,
+			Description: cfg.Package.Name + " " + s.description,
+			Pipeline:    []Pipeline{{Uses: s.pipeline}},
+			OmitIfEmpty: true,
+		})
+	}
+	return nil
+}
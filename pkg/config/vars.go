@@ -15,8 +15,17 @@
 package config
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"maps"
+	"net/url"
 	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
 
 	"chainguard.dev/melange/pkg/util"
 )
@@ -41,8 +50,110 @@ const (
 	SubstitutionBuildGoArch           = "${{build.goarch}}"
 )
 
+// VarDeclaration is the long form of a variable in vars, which declares
+// what values it can have, rather than only setting it.
+type VarDeclaration struct {
+	// Optional: The value of the variable, unless a variables file or a build
+	// option sets it
+	Default string `json:"default,omitempty" yaml:"default,omitempty"`
+	// Optional: Whether the variable has to be set, to something other than
+	// an empty string
+	Required bool `json:"required,omitempty" yaml:"required,omitempty"`
+	// Optional: A regular expression that the whole value of the variable
+	// has to match, if it's set
+	Pattern string `json:"pattern,omitempty" yaml:"pattern,omitempty"`
+	// Optional: The human-readable description of the variable
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+}
+
+// applyVarDeclarations replaces the declarations of variables in the vars
+// of the configuration in data with their defaults, and returns the result,
+// along with the declarations.
+func applyVarDeclarations(data []byte) ([]byte, map[string]VarDeclaration, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, nil, err
+	}
+	if len(root.Content) == 0 {
+		return data, nil, nil
+	}
+	vars := mappingValue(root.Content[0], "vars")
+	if vars == nil || vars.Kind != yaml.MappingNode {
+		return data, nil, nil
+	}
+
+	decls := map[string]VarDeclaration{}
+	for i := 0; i+1 < len(vars.Content); i += 2 {
+		key, value := vars.Content[i], vars.Content[i+1]
+		if value.Kind != yaml.MappingNode {
+			continue
+		}
+		// Node.Decode doesn't allow setting KnownFields, so the declaration is
+		// marshalled again to decode it.
+		b, err := yaml.Marshal(value)
+		if err != nil {
+			return nil, nil, err
+		}
+		var decl VarDeclaration
+		dec := yaml.NewDecoder(bytes.NewReader(b))
+		dec.KnownFields(true)
+		if err := dec.Decode(&decl); err != nil {
+			return nil, nil, fmt.Errorf("decoding declaration of variable %q: %w", key.Value, err)
+		}
+		if decl.Pattern != "" {
+			if _, err := regexp.Compile(decl.Pattern); err != nil {
+				return nil, nil, fmt.Errorf("variable %q: pattern %q does not compile into a regex: %w", key.Value, decl.Pattern, err)
+			}
+			if decl.Default != "" && !matchesWhole(decl.Pattern, decl.Default) {
+				return nil, nil, fmt.Errorf("variable %q: default %q does not match pattern %q", key.Value, decl.Default, decl.Pattern)
+			}
+		}
+		decls[key.Value] = decl
+		vars.Content[i+1] = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: decl.Default}
+	}
+	if len(decls) == 0 {
+		return data, nil, nil
+	}
+
+	out, err := yaml.Marshal(&root)
+	if err != nil {
+		return nil, nil, err
+	}
+	return out, decls, nil
+}
+
+// matchesWhole returns whether the whole of s matches the pattern, which
+// has been compiled already.
+func matchesWhole(pattern, s string) bool {
+	return regexp.MustCompile(`^(?:` + pattern + `)$`).MatchString(s)
+}
+
+// validateVars checks the variables of the configuration against their
+// declarations.
+func (cfg Configuration) validateVars() error {
+	var errs []error
+	for _, k := range slices.Sorted(maps.Keys(cfg.varDeclarations)) {
+		decl, v := cfg.varDeclarations[k], cfg.Vars[k]
+		switch {
+		case v == "" && decl.Required:
+			msg := fmt.Sprintf("variable %q is required, but not set", k)
+			if decl.Description != "" {
+				msg += ": " + decl.Description
+			}
+			errs = append(errs, errors.New(msg))
+		case v != "" && decl.Pattern != "" && !matchesWhole(decl.Pattern, v):
+			errs = append(errs, fmt.Errorf("variable %q is %q, which does not match pattern %q", k, v, decl.Pattern))
+		}
+	}
+	return errors.Join(errs...)
+}
+
 // Get variables from configuration and return them in a map
 func (cfg Configuration) GetVarsFromConfig() (map[string]string, error) {
+	if err := cfg.validateVars(); err != nil {
+		return nil, err
+	}
+
 	nw := map[string]string{}
 
 	for k, v := range cfg.Vars {
@@ -68,6 +179,19 @@ func (cfg Configuration) PerformVarSubstitutions(nw map[string]string) error {
 			return err
 		}
 
+		if v.Function != "" {
+			fn, ok := varTransformFunctions[v.Function]
+			if !ok {
+				return fmt.Errorf("var-transform %q: unknown function %q", v.To, v.Function)
+			}
+			output, err := fn(from, v.Arg)
+			if err != nil {
+				return fmt.Errorf("var-transform %q: %s %q: %w", v.To, v.Function, from, err)
+			}
+			nw[nk] = output
+			continue
+		}
+
 		re, err := regexp.Compile(v.Match)
 		if err != nil {
 			return fmt.Errorf("match value: %s string does not compile into a regex: %w", v.Match, err)
@@ -79,3 +203,57 @@ func (cfg Configuration) PerformVarSubstitutions(nw map[string]string) error {
 
 	return nil
 }
+
+// varTransformFunctions are the functions that var-transforms can apply to
+// a value, by name, with the argument of the transform.
+var varTransformFunctions = map[string]func(from, arg string) (string, error){
+	"major": func(from, _ string) (string, error) { return versionComponent(from, 0) },
+	"minor": func(from, _ string) (string, error) { return versionComponent(from, 1) },
+	"patch": func(from, _ string) (string, error) { return versionComponent(from, 2) },
+	"upper": func(from, _ string) (string, error) { return strings.ToUpper(from), nil },
+	"lower": func(from, _ string) (string, error) { return strings.ToLower(from), nil },
+	"url-encode": func(from, _ string) (string, error) {
+		return url.PathEscape(from), nil
+	},
+	"trim-prefix": func(from, arg string) (string, error) { return strings.TrimPrefix(from, arg), nil },
+	"trim-suffix": func(from, arg string) (string, error) { return strings.TrimSuffix(from, arg), nil },
+	"add":         func(from, arg string) (string, error) { return arithmetic(from, arg, 1) },
+	"subtract":    func(from, arg string) (string, error) { return arithmetic(from, arg, -1) },
+}
+
+// versionComponent returns the nth numeric component of a version such as
+// 1.2.3, v1.2.3 or 1.2.3-rc1, or 0 if the version has fewer components.
+func versionComponent(version string, n int) (string, error) {
+	isVersionRune := func(r rune) bool {
+		return r == '.' || (r >= '0' && r <= '9')
+	}
+	version = strings.TrimLeftFunc(version, func(r rune) bool { return !isVersionRune(r) })
+	if end := strings.IndexFunc(version, func(r rune) bool { return !isVersionRune(r) }); end >= 0 {
+		version = version[:end]
+	}
+
+	components := strings.Split(version, ".")
+	if components[0] == "" {
+		return "", errors.New("not a version")
+	}
+	if n >= len(components) {
+		return "0", nil
+	}
+	if components[n] == "" {
+		return "", fmt.Errorf("component %d is empty", n+1)
+	}
+	return components[n], nil
+}
+
+// arithmetic adds arg to from, sign times, as integers.
+func arithmetic(from, arg string, sign int) (string, error) {
+	a, err := strconv.Atoi(from)
+	if err != nil {
+		return "", errors.New("not an integer")
+	}
+	b, err := strconv.Atoi(arg)
+	if err != nil {
+		return "", fmt.Errorf("argument %q is not an integer", arg)
+	}
+	return strconv.Itoa(a + sign*b), nil
+}
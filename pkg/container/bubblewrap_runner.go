@@ -19,10 +19,12 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	apko_build "chainguard.dev/apko/pkg/build"
 	apko_types "chainguard.dev/apko/pkg/build/types"
@@ -41,6 +43,11 @@ const (
 
 type bubblewrap struct {
 	remove bool // if true, clean up temp dirs on close.
+
+	// Whether commands can be run in systemd scopes with CPU and memory
+	// limits, which is found out the first time a build has limits.
+	scopeOnce sync.Once
+	canScope  bool
 }
 
 // BubblewrapRunner returns a Bubblewrap Runner implementation.
@@ -57,6 +64,13 @@ func (bw *bubblewrap) Name() string {
 	return BubblewrapName
 }
 
+// DisableNetwork implements NetworkDisabler. Every command runs in its own
+// sandbox, so later ones just don't get the host's network.
+func (bw *bubblewrap) DisableNetwork(ctx context.Context, cfg *Config) error {
+	cfg.Capabilities.Networking = false
+	return nil
+}
+
 // Run runs a Bubblewrap task given a Config and command string.
 func (bw *bubblewrap) Run(ctx context.Context, cfg *Config, envOverride map[string]string, args ...string) error {
 	execCmd := bw.cmd(ctx, cfg, false, envOverride, args...)
@@ -66,8 +80,7 @@ func (bw *bubblewrap) Run(ctx context.Context, cfg *Config, envOverride map[stri
 	defer stdout.Close()
 	defer stderr.Close()
 
-	execCmd.Stdout = stdout
-	execCmd.Stderr = stderr
+	execCmd.Stdout, execCmd.Stderr = TeeOutput(ctx, stdout, stderr)
 
 	return execCmd.Run()
 }
@@ -79,7 +92,14 @@ func (bw *bubblewrap) cmd(ctx context.Context, cfg *Config, debug bool, envOverr
 	baseargs = append(baseargs, "--bind", cfg.ImgRef, "/")
 
 	for _, bind := range cfg.Mounts {
-		baseargs = append(baseargs, "--bind", bind.Source, bind.Destination)
+		flag := "--bind"
+		if bind.ReadOnly {
+			flag = "--ro-bind"
+		}
+		baseargs = append(baseargs, flag, bind.Source, bind.Destination)
+	}
+	if cfg.SecretsDir != "" {
+		baseargs = append(baseargs, "--ro-bind", cfg.SecretsDir, DefaultSecretsDir)
 	}
 	// add the ref of the directory
 
@@ -123,12 +143,50 @@ func (bw *bubblewrap) cmd(ctx context.Context, cfg *Config, debug bool, envOverr
 
 	args = append(baseargs, args...)
 	execCmd := exec.CommandContext(ctx, "bwrap", args...)
+	if scope := bw.scope(ctx, cfg); scope != nil {
+		execCmd = exec.CommandContext(ctx, scope[0], append(append(scope[1:], "bwrap"), args...)...)
+	}
 
 	clog.FromContext(ctx).Debugf("executing: %s", strings.Join(execCmd.Args, " "))
 
 	return execCmd
 }
 
+// scope returns the systemd-run command that runs a command in a scope
+// with the CPU and memory limits in cfg, or nil if cfg has none, or the
+// host can't apply them.
+func (bw *bubblewrap) scope(ctx context.Context, cfg *Config) []string {
+	var props []string
+	if n, err := ParseCPU(cfg.CPU); err == nil {
+		props = append(props, "-p", fmt.Sprintf("CPUQuota=%d%%", int(math.Ceil(n*100))))
+	}
+	if n, err := ParseMemory(cfg.Memory); err == nil {
+		props = append(props, "-p", fmt.Sprintf("MemoryMax=%d", n), "-p", "MemorySwapMax=0")
+	}
+	if len(props) == 0 {
+		return nil
+	}
+
+	args := []string{"systemd-run", "--scope", "--quiet", "--collect"}
+	if os.Getuid() != 0 {
+		args = append(args, "--user")
+	}
+	args = append(args, props...)
+
+	bw.scopeOnce.Do(func() {
+		out, err := exec.CommandContext(ctx, args[0], append(args[1:], "true")...).CombinedOutput()
+		if err != nil {
+			clog.FromContext(ctx).Warnf("bubblewrap: unable to limit CPU and memory with systemd-run, so they aren't: %v: %s", err, strings.TrimSpace(string(out)))
+			return
+		}
+		bw.canScope = true
+	})
+	if !bw.canScope {
+		return nil
+	}
+	return append(args, "--")
+}
+
 func (bw *bubblewrap) Debug(ctx context.Context, cfg *Config, envOverride map[string]string, args ...string) error {
 	execCmd := bw.cmd(ctx, cfg, true, envOverride, args...)
 
@@ -167,6 +225,10 @@ func (bw *bubblewrap) StartPod(ctx context.Context, cfg *Config) error {
 	ctx, span := otel.Tracer("melange").Start(ctx, "bubblewrap.StartPod")
 	defer span.End()
 
+	if err := checkResources(cfg); err != nil {
+		return err
+	}
+
 	script := "[ -x /sbin/ldconfig ] && /sbin/ldconfig /lib || true"
 	return bw.Run(ctx, cfg, nil, "/bin/sh", "-c", script)
 }
@@ -183,6 +245,11 @@ func (bw *bubblewrap) WorkspaceTar(ctx context.Context, cfg *Config) (io.ReadClo
 	return nil, nil
 }
 
+// MountsWorkspace implements WorkspaceMounter.
+func (bw *bubblewrap) MountsWorkspace() bool {
+	return true
+}
+
 type bubblewrapOCILoader struct {
 	remove   bool
 	guestDir string
@@ -54,3 +54,48 @@ func TestBubblewrapCmd(t *testing.T) {
 		})
 	}
 }
+
+func TestBubblewrapScope(t *testing.T) {
+	ctx := slogtest.Context(t)
+	cfg := &Config{CPU: "1.5", Memory: "512Mi"}
+
+	// Without systemd-run, commands aren't limited.
+	bw := new(bubblewrap)
+	bw.scopeOnce.Do(func() {})
+	if cmd := bw.cmd(ctx, cfg, false, nil, "true"); cmd.Args[0] != "bwrap" {
+		t.Fatalf("expected bwrap to be run directly, found %v", cmd.Args)
+	}
+
+	bw = new(bubblewrap)
+	bw.scopeOnce.Do(func() {})
+	bw.canScope = true
+	cmd := bw.cmd(ctx, cfg, false, nil, "true")
+	args := strings.Join(cmd.Args, " ")
+	for _, want := range []string{"systemd-run --scope", "-p CPUQuota=150%", "-p MemoryMax=536870912", "-p MemorySwapMax=0", "-- bwrap --bind"} {
+		if !strings.Contains(args, want) {
+			t.Errorf("expected %q in %v", want, args)
+		}
+	}
+
+	// Without limits, there's no scope.
+	if cmd := bw.cmd(ctx, new(Config), false, nil, "true"); cmd.Args[0] != "bwrap" {
+		t.Fatalf("expected bwrap to be run directly, found %v", cmd.Args)
+	}
+}
+
+func TestBubblewrapMounts(t *testing.T) {
+	ctx := slogtest.Context(t)
+	cfg := &Config{Mounts: []BindMount{
+		{Source: "/srv/src", Destination: "/work/src"},
+		{Source: "/srv/ca.pem", Destination: "/etc/ssl/ca.pem", ReadOnly: true},
+	}}
+
+	bw := new(bubblewrap)
+	bw.scopeOnce.Do(func() {})
+	args := strings.Join(bw.cmd(ctx, cfg, false, nil, "true").Args, " ")
+	for _, want := range []string{"--bind /srv/src /work/src", "--ro-bind /srv/ca.pem /etc/ssl/ca.pem"} {
+		if !strings.Contains(args, want) {
+			t.Errorf("expected %q in %v", want, args)
+		}
+	}
+}
@@ -31,11 +31,15 @@ const (
 	DefaultCacheDir = "/var/cache/melange"
 	// DefaultResolvConfPath is the default path to the resolv.conf file in the runner's environment.
 	DefaultResolvConfPath = "/etc/resolv.conf"
+	// DefaultSecretsDir is the default path to the secrets directory in the runner's environment.
+	DefaultSecretsDir = "/run/secrets"
 )
 
 type BindMount struct {
 	Source      string
 	Destination string
+	// Whether the guest can only read what's mounted.
+	ReadOnly bool
 }
 
 type Capabilities struct {
@@ -58,4 +62,8 @@ type Config struct {
 	SSHHostKey            string
 	Disk                  string
 	Timeout               time.Duration
+
+	// A directory on the host whose files are made available, read-only, in
+	// DefaultSecretsDir. It's never part of the workspace.
+	SecretsDir string
 }
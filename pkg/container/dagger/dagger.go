@@ -19,6 +19,7 @@ import (
 	"errors"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
 
 	apko_build "chainguard.dev/apko/pkg/build"
@@ -152,6 +153,22 @@ func (d *daggerRunner) StartPod(ctx context.Context, cfg *container.Config) erro
 		}
 	}
 
+	if cfg.SecretsDir != "" {
+		entries, err := os.ReadDir(cfg.SecretsDir)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			data, err := os.ReadFile(filepath.Join(cfg.SecretsDir, e.Name()))
+			if err != nil {
+				return err
+			}
+			secret := d.client.SetSecret(e.Name(), string(data))
+			d.container = d.container.WithMountedSecret(path.Join(container.DefaultSecretsDir, e.Name()), secret,
+				dagger.ContainerWithMountedSecretOpts{Owner: "0:0", Mode: 0o444})
+		}
+	}
+
 	script := "[ -x /sbin/ldconfig ] && /sbin/ldconfig /lib || true"
 	d.container = d.container.WithExec([]string{"/bin/sh", "-c", script})
 	return nil
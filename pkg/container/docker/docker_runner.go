@@ -74,6 +74,24 @@ func (dk *docker) Close() error {
 	return dk.cli.Close()
 }
 
+// DisableNetwork implements NetworkDisabler by disconnecting the pod from
+// all of its networks.
+func (dk *docker) DisableNetwork(ctx context.Context, cfg *mcontainer.Config) error {
+	info, err := dk.cli.ContainerInspect(ctx, cfg.PodID)
+	if err != nil {
+		return err
+	}
+	if info.NetworkSettings != nil {
+		for name := range info.NetworkSettings.Networks {
+			if err := dk.cli.NetworkDisconnect(ctx, name, cfg.PodID, true); err != nil {
+				return fmt.Errorf("disconnecting pod %s from network %s: %w", cfg.PodID, name, err)
+			}
+		}
+	}
+	cfg.Capabilities.Networking = false
+	return nil
+}
+
 // StartPod starts a pod for supporting a Docker task, if
 // necessary.
 func (dk *docker) StartPod(ctx context.Context, cfg *mcontainer.Config) error {
@@ -90,15 +108,40 @@ func (dk *docker) StartPod(ctx context.Context, cfg *mcontainer.Config) error {
 		}
 
 		mounts = append(mounts, mount.Mount{
-			Type:   mount.TypeBind,
-			Source: bind.Source,
-			Target: bind.Destination,
+			Type:     mount.TypeBind,
+			Source:   bind.Source,
+			Target:   bind.Destination,
+			ReadOnly: bind.ReadOnly,
+		})
+	}
+
+	if cfg.SecretsDir != "" {
+		mounts = append(mounts, mount.Mount{
+			Type:     mount.TypeBind,
+			Source:   cfg.SecretsDir,
+			Target:   mcontainer.DefaultSecretsDir,
+			ReadOnly: true,
 		})
 	}
 
 	hostConfig := &container.HostConfig{
 		Mounts: mounts,
 	}
+	if cfg.CPU != "" {
+		cpus, err := mcontainer.ParseCPU(cfg.CPU)
+		if err != nil {
+			return err
+		}
+		hostConfig.NanoCPUs = int64(cpus * 1e9)
+	}
+	if cfg.Memory != "" {
+		memory, err := mcontainer.ParseMemory(cfg.Memory)
+		if err != nil {
+			return err
+		}
+		// Without swap, so that the limit is on all the memory the build uses.
+		hostConfig.Memory, hostConfig.MemorySwap = memory, memory
+	}
 
 	platform := &image_spec.Platform{
 		Architecture: cfg.Arch.String(),
@@ -192,7 +235,8 @@ func (dk *docker) waitForCommand(ctx context.Context, r io.Reader) error {
 	defer cancel()
 	ctxr := contextreader.New(ctx, r)
 
-	_, err := stdcopy.StdCopy(stdout, stderr, ctxr)
+	outw, errw := mcontainer.TeeOutput(ctx, stdout, stderr)
+	_, err := stdcopy.StdCopy(outw, errw, ctxr)
 	return err
 }
 
@@ -245,7 +289,7 @@ func (dk *docker) Run(ctx context.Context, cfg *mcontainer.Config, envOverride m
 	case 0:
 		return nil
 	default:
-		return fmt.Errorf("task exited with code %d", inspectResp.ExitCode)
+		return &mcontainer.ExitError{Code: inspectResp.ExitCode}
 	}
 }
 
@@ -344,7 +388,7 @@ func (dk *docker) Debug(ctx context.Context, cfg *mcontainer.Config, envOverride
 	case 0:
 		return nil
 	default:
-		return fmt.Errorf("task exited with code %d", inspectResp.ExitCode)
+		return &mcontainer.ExitError{Code: inspectResp.ExitCode}
 	}
 }
 
@@ -354,6 +398,11 @@ func (dk *docker) WorkspaceTar(ctx context.Context, cfg *mcontainer.Config) (io.
 	return nil, nil
 }
 
+// MountsWorkspace implements WorkspaceMounter.
+func (dk *docker) MountsWorkspace() bool {
+	return true
+}
+
 type dockerLoader struct {
 	cli *client.Client
 }
@@ -0,0 +1,58 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package container
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+type outputKey struct{}
+
+type output struct {
+	stdout, stderr io.Writer
+}
+
+// WithOutput returns a context that commands run with also write their
+// stdout and stderr to, as well as to the log.
+func WithOutput(ctx context.Context, stdout, stderr io.Writer) context.Context {
+	return context.WithValue(ctx, outputKey{}, output{stdout: stdout, stderr: stderr})
+}
+
+// TeeOutput returns writers for a command's stdout and stderr that write to
+// stdout and stderr, and to those that ctx has from WithOutput, if any.
+func TeeOutput(ctx context.Context, stdout, stderr io.Writer) (io.Writer, io.Writer) {
+	o, ok := ctx.Value(outputKey{}).(output)
+	if !ok {
+		return stdout, stderr
+	}
+	return io.MultiWriter(stdout, o.stdout), io.MultiWriter(stderr, o.stderr)
+}
+
+// ExitError is returned by runners when a command exits with a non-zero
+// status that they don't have a more specific error for.
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("task exited with code %d", e.Code)
+}
+
+// ExitCode returns the exit status of the command.
+func (e *ExitError) ExitCode() int {
+	return e.Code
+}
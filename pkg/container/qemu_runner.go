@@ -28,6 +28,7 @@ import (
 	"encoding/pem"
 	"fmt"
 	"io"
+	"math"
 	"net"
 	"os"
 	"os/exec"
@@ -82,6 +83,7 @@ func (bw *qemu) Run(ctx context.Context, cfg *Config, envOverride map[string]str
 	stdout, stderr := logwriter.New(log.Info), logwriter.New(log.Warn)
 	defer stdout.Close()
 	defer stderr.Close()
+	outw, errw := TeeOutput(ctx, stdout, stderr)
 
 	// default to root user but if a different user is specified
 	// we will use the embedded build:1000:1000 user
@@ -96,8 +98,8 @@ func (bw *qemu) Run(ctx context.Context, cfg *Config, envOverride map[string]str
 		cfg,
 		envOverride,
 		nil,
-		stderr,
-		stdout,
+		errw,
+		outw,
 		false,
 		args,
 	)
@@ -172,7 +174,79 @@ func (bw *qemu) StartPod(ctx context.Context, cfg *Config) error {
 
 	cfg.SSHAddress = "127.0.0.1:" + strconv.Itoa(port)
 
-	return createMicroVM(ctx, cfg)
+	if err := createMicroVM(ctx, cfg); err != nil {
+		return err
+	}
+	return copySecrets(ctx, cfg)
+}
+
+// copySecrets copies the files in cfg.SecretsDir into the VM, as the
+// workspace is the only directory it shares with the host.
+func copySecrets(ctx context.Context, cfg *Config) error {
+	if cfg.SecretsDir == "" {
+		return nil
+	}
+	entries, err := os.ReadDir(cfg.SecretsDir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		f, err := os.Open(filepath.Join(cfg.SecretsDir, e.Name()))
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(DefaultSecretsDir, e.Name())
+		err = sendSSHCommand(ctx,
+			"root",
+			cfg.SSHAddress,
+			cfg,
+			nil,
+			f,
+			nil,
+			nil,
+			false,
+			[]string{"sh", "-c", fmt.Sprintf("mkdir -p '%s' && cat > '%s' && chmod 0444 '%s'", DefaultSecretsDir, dst, dst)},
+		)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("qemu: copying secret %s: %w", e.Name(), err)
+		}
+	}
+	return nil
+}
+
+// HostLoopbackAddress implements EgressProxier. QEMU's user networking
+// forwards this address to the host's loopback interface.
+func (bw *qemu) HostLoopbackAddress() string {
+	return "10.0.2.2"
+}
+
+// RestrictToHost implements EgressProxier by removing the VM's default
+// routes, which leaves it only its local network, where the host is.
+func (bw *qemu) RestrictToHost(ctx context.Context, cfg *Config) error {
+	return bw.DisableNetwork(ctx, cfg)
+}
+
+// DisableNetwork implements NetworkDisabler. The VM is reached over ssh on
+// its local network, so rather than taking its network down, its default
+// routes are removed, leaving it no way to anywhere else.
+func (bw *qemu) DisableNetwork(ctx context.Context, cfg *Config) error {
+	err := sendSSHCommand(ctx,
+		"root",
+		cfg.SSHAddress,
+		cfg,
+		nil,
+		nil,
+		nil,
+		nil,
+		false,
+		[]string{"sh", "-c", `ip route del default 2>/dev/null; ip -6 route del default 2>/dev/null; [ -z "$(ip route show default)" ]`},
+	)
+	if err != nil {
+		return fmt.Errorf("qemu: removing default routes: %w", err)
+	}
+	cfg.Capabilities.Networking = false
+	return nil
 }
 
 // TerminatePod terminates a pod if necessary.  Not implemented
@@ -350,7 +424,12 @@ func createMicroVM(ctx context.Context, cfg *Config) error {
 	baseargs = append(baseargs, "-m", cfg.Memory)
 
 	if cfg.CPU != "" {
-		baseargs = append(baseargs, "-smp", cfg.CPU)
+		cpus, err := ParseCPU(cfg.CPU)
+		if err != nil {
+			return err
+		}
+		// A VM can only have whole CPUs.
+		baseargs = append(baseargs, "-smp", fmt.Sprintf("%d", int(math.Ceil(cpus))))
 	} else {
 		baseargs = append(baseargs, "-smp", fmt.Sprintf("%d", runtime.NumCPU()))
 	}
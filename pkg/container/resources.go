@@ -0,0 +1,66 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package container
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseCPU parses a number of CPUs, which can be a fraction, such as 1.5,
+// or in thousandths of a CPU, such as 500m, as Kubernetes has them.
+func ParseCPU(cpu string) (float64, error) {
+	var n float64
+	var err error
+	if milli, ok := strings.CutSuffix(cpu, "m"); ok {
+		n, err = strconv.ParseFloat(milli, 64)
+		n /= 1000
+	} else {
+		n, err = strconv.ParseFloat(cpu, 64)
+	}
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid number of CPUs %q", cpu)
+	}
+	return n, nil
+}
+
+// ParseMemory parses an amount of memory, such as 512Mi or 4G, into bytes.
+func ParseMemory(memory string) (int64, error) {
+	kb, err := convertHumanToKB(memory)
+	if err != nil {
+		return 0, err
+	}
+	if kb <= 0 {
+		return 0, fmt.Errorf("invalid memory size %q", memory)
+	}
+	return kb * 1024, nil
+}
+
+// checkResources checks that the CPU and memory limits in cfg, if it has
+// any, can be parsed.
+func checkResources(cfg *Config) error {
+	if cfg.CPU != "" {
+		if _, err := ParseCPU(cfg.CPU); err != nil {
+			return err
+		}
+	}
+	if cfg.Memory != "" {
+		if _, err := ParseMemory(cfg.Memory); err != nil {
+			return err
+		}
+	}
+	return nil
+}
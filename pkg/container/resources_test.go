@@ -0,0 +1,59 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package container
+
+import (
+	"testing"
+)
+
+func TestParseCPU(t *testing.T) {
+	for in, want := range map[string]float64{
+		"4":    4,
+		"1.5":  1.5,
+		"500m": 0.5,
+	} {
+		got, err := ParseCPU(in)
+		if err != nil {
+			t.Errorf("ParseCPU(%q): %v", in, err)
+		} else if got != want {
+			t.Errorf("ParseCPU(%q) = %v, want %v", in, got, want)
+		}
+	}
+	for _, in := range []string{"", "four", "0", "-1", "m"} {
+		if _, err := ParseCPU(in); err == nil {
+			t.Errorf("ParseCPU(%q): want error", in)
+		}
+	}
+}
+
+func TestParseMemory(t *testing.T) {
+	for in, want := range map[string]int64{
+		"512Mi": 512 << 20,
+		"8Gi":   8 << 30,
+		"2G":    2 << 30,
+	} {
+		got, err := ParseMemory(in)
+		if err != nil {
+			t.Errorf("ParseMemory(%q): %v", in, err)
+		} else if got != want {
+			t.Errorf("ParseMemory(%q) = %v, want %v", in, got, want)
+		}
+	}
+	for _, in := range []string{"", "8", "lots", "0Gi"} {
+		if _, err := ParseMemory(in); err == nil {
+			t.Errorf("ParseMemory(%q): want error", in)
+		}
+	}
+}
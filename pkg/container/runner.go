@@ -46,6 +46,34 @@ type Runner interface {
 	WorkspaceTar(ctx context.Context, cfg *Config) (io.ReadCloser, error)
 }
 
+// WorkspaceMounter is implemented by runners that mount the workspace from
+// the host, so that what steps write to it is on the host as soon as they
+// finish, rather than when the workspace is retrieved.
+type WorkspaceMounter interface {
+	// MountsWorkspace reports whether the workspace is mounted from the
+	// host.
+	MountsWorkspace() bool
+}
+
+// NetworkDisabler is implemented by runners that can cut a running pod off
+// from the network, for hermetic builds.
+type NetworkDisabler interface {
+	// DisableNetwork cuts the pod off from the network for every command
+	// run after it.
+	DisableNetwork(ctx context.Context, cfg *Config) error
+}
+
+// EgressProxier is implemented by runners whose pods can reach a proxy on the
+// host's loopback interface, for builds with an egress allowlist.
+type EgressProxier interface {
+	// HostLoopbackAddress returns the address that pods reach the host's
+	// loopback interface at.
+	HostLoopbackAddress() string
+	// RestrictToHost cuts a running pod off from the network, other than
+	// the host's loopback interface, if the runner can.
+	RestrictToHost(ctx context.Context, cfg *Config) error
+}
+
 type Loader interface {
 	LoadImage(ctx context.Context, layer v1.Layer, arch apko_types.Architecture, bc *apko_build.Context) (ref string, err error)
 	RemoveImage(ctx context.Context, ref string) error
@@ -1,6 +1,7 @@
 package apkbuild
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha256"
 	"crypto/sha512"
@@ -112,6 +113,9 @@ func (c Context) Generate(ctx context.Context, apkBuildURI, pkgName string) erro
 		// maps the APKBUILD values to convert config
 		apkConverter.mapconvert()
 
+		// carries over the install scripts and triggers as scriptlets
+		c.buildScriptlets(ctx, apkConverter)
+
 		// builds the convert environment configuration
 		apkConverter.buildEnvironment(c.AdditionalRepositories, c.AdditionalKeyrings)
 
@@ -119,6 +123,12 @@ func (c Context) Generate(ctx context.Context, apkBuildURI, pkgName string) erro
 		if err != nil {
 			return fmt.Errorf("writing convert config file: %w", err)
 		}
+
+		// maps the secfixes of the APKBUILD into advisories
+		err = apkConverter.writeAdvisories(ctx, c.OutDir, time.Now())
+		if err != nil {
+			return fmt.Errorf("writing advisories: %w", err)
+		}
 	}
 
 	return nil
@@ -136,7 +146,11 @@ func (c Context) getApkBuildFile(ctx context.Context, apkbuildURL, packageName s
 	if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("non ok http response code: %v", resp.StatusCode)
 	}
-	apkbuildFile := apkbuild.NewApkbuildFile(packageName, resp.Body)
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", apkbuildURL, err)
+	}
+	apkbuildFile := apkbuild.NewApkbuildFile(packageName, bytes.NewReader(raw))
 
 	parsedApkBuild, err := apkbuild.Parse(apkbuildFile, nil)
 
@@ -145,7 +159,8 @@ func (c Context) getApkBuildFile(ctx context.Context, apkbuildURL, packageName s
 	}
 
 	c.ApkConvertors[packageName] = ApkConvertor{
-		Apkbuild: &parsedApkBuild,
+		Apkbuild:    &parsedApkBuild,
+		ApkBuildRaw: raw,
 		GeneratedMelangeConfig: &manifest.GeneratedMelangeConfig{
 			GeneratedFromComment: apkbuildURL,
 		},
@@ -384,82 +399,107 @@ func (c *Context) buildFetchStep(ctx context.Context, converter ApkConvertor) er
 	}
 
 	// Loop over sources and add fetch steps for tarball
+	var patches []string
 	for _, source := range apkBuild.Source {
 		location := source.Location
 
-		_, err := url.ParseRequestURI(location)
-		if err != nil {
-			return fmt.Errorf("parsing URI %s: %w", location, err)
-		}
-
-		// Create a request using standard http.NewRequestWithContext
-		req, err := http.NewRequestWithContext(ctx, "GET", location, nil)
-		if err != nil {
-			return fmt.Errorf("creating request for URI %s: %w", location, err)
+		// Sources without a URL, such as patches, are next to the APKBUILD.
+		if !strings.Contains(location, "://") {
+			if strings.HasSuffix(source.Filename, ".patch") {
+				patches = append(patches, source.Filename)
+			}
+			log.Infof("source %s is next to the APKBUILD, copy it next to the convert config", source.Filename)
+			continue
 		}
 
-		// Use RLHTTPClient to send the request with rate limiting
-		resp, err := c.Client.Do(req)
+		_, err := url.ParseRequestURI(location)
 		if err != nil {
-			return fmt.Errorf("failed getting URI %s: %w", location, err)
+			return fmt.Errorf("parsing URI %s: %w", location, err)
 		}
-		defer resp.Body.Close()
 
-		failed := false
-		if resp.StatusCode != http.StatusOK {
-			log.Infof("non ok http response for URI %s code: %v", location, resp.StatusCode)
-			failed = true
+		// The sha512 that abuild checks the source against.
+		var sha512sum string
+		for _, shas := range apkBuild.Sha512sums {
+			if shas.Source == source.Filename {
+				sha512sum = shas.Hash
+			}
 		}
 
-		b, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return fmt.Errorf("failed getting URI %s: %w", location, err)
+		with := map[string]string{
+			"uri": strings.ReplaceAll(location, apkBuild.Pkgver, "${{package.version}}"),
 		}
 
-		var expectedSha string
-		if !failed {
-			// Validate the source matches the sha512 in the APKBUILD
-			validated := false
-			for _, shas := range apkBuild.Sha512sums {
-				if shas.Source == source.Filename {
-					h512 := sha512.New()
-					h512.Write(b)
-
-					if shas.Hash == fmt.Sprintf("%x", h512.Sum(nil)) {
-						validated = true
-					}
-				}
-			}
-
-			// Now generate the 256 sha for the convert config
-			if !validated {
-				expectedSha = "SHA512 DOES NOT MATCH SOURCE - VALIDATE MANUALLY"
-				log.Infof("source %s expected sha512 do not match!", source.Filename)
-			} else {
+		b, err := c.get(ctx, location)
+		switch {
+		case err == nil:
+			// Validate the source matches the sha512 in the APKBUILD, and
+			// generate the sha256 for the convert config
+			h512 := sha512.New()
+			h512.Write(b)
+			if sha512sum != "" && sha512sum == fmt.Sprintf("%x", h512.Sum(nil)) {
 				h256 := sha256.New()
 				h256.Write(b)
-
-				expectedSha = fmt.Sprintf("%x", h256.Sum(nil))
+				with["expected-sha256"] = fmt.Sprintf("%x", h256.Sum(nil))
+			} else {
+				with["expected-sha256"] = "SHA512 DOES NOT MATCH SOURCE - VALIDATE MANUALLY"
+				log.Infof("source %s expected sha512 do not match!", source.Filename)
 			}
-		} else {
-			expectedSha = "FIXME - SOURCE URL NOT VALID"
+
+		case sha512sum != "":
+			// fetch can check the source against the sha512 in the
+			// APKBUILD just as well.
+			log.Infof("using the sha512 of the APKBUILD for source %s: %v", source.Filename, err)
+			with["expected-sha512"] = sha512sum
+
+		default:
+			log.Infof("%v", err)
+			with["expected-sha256"] = "FIXME - SOURCE URL NOT VALID"
 		}
 
 		// Fallback to using the fetch pipeline with tarball location
 		pipeline := config.Pipeline{
 			Uses: "fetch",
-			With: map[string]string{
-				"uri":             strings.ReplaceAll(location, apkBuild.Pkgver, "${{package.version}}"),
-				"expected-sha256": expectedSha,
-			},
+			With: with,
 		}
 		converter.GeneratedMelangeConfig.Pipeline = append(converter.GeneratedMelangeConfig.Pipeline, pipeline)
+	}
 
+	if len(patches) != 0 {
+		converter.GeneratedMelangeConfig.Pipeline = append(converter.GeneratedMelangeConfig.Pipeline, config.Pipeline{
+			Uses: "patch",
+			With: map[string]string{"patches": strings.Join(patches, " ")},
+		})
 	}
 
 	return nil
 }
 
+// get returns the body of the file at uri.
+func (c *Context) get(ctx context.Context, uri string) ([]byte, error) {
+	// Create a request using standard http.NewRequestWithContext
+	req, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request for URI %s: %w", uri, err)
+	}
+
+	// Use RLHTTPClient to send the request with rate limiting
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting URI %s: %w", uri, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("non ok http response for URI %s code: %v", uri, resp.StatusCode)
+	}
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting URI %s: %w", uri, err)
+	}
+	return b, nil
+}
+
 // maps APKBUILD values to mconvert
 func (c ApkConvertor) mapconvert() {
 	c.GeneratedMelangeConfig.Package.Name = c.Apkbuild.Pkgname
@@ -488,17 +528,6 @@ func (c ApkConvertor) mapconvert() {
 	}
 	c.GeneratedMelangeConfig.Package.Copyright = append(c.GeneratedMelangeConfig.Package.Copyright, copyright)
 
-	// triggers
-	if c.Apkbuild.Triggers != nil {
-		scriptlets := config.Scriptlets{
-			Trigger: config.Trigger{
-				Paths:  []string{"FIXME"},
-				Script: "FIXME",
-			},
-		}
-		c.GeneratedMelangeConfig.Package.Scriptlets = &scriptlets
-	}
-
 	// if c.Apkbuild.Funcs["build"] != nil {
 	//	// todo lets check the command and add the correct cmake | make | meson mconvert pipelines
 	//	//build := c.Apkbuild.Funcs["build"]
@@ -532,6 +561,19 @@ func (c ApkConvertor) mapconvert() {
 			Name: strings.Replace(subPackage.Subpkgname, "$pkgname", c.Apkbuild.Pkgname, 1),
 		}
 
+		// translate the split function of the subpackage if the APKBUILD defines it, rather than
+		// guessing what it splits from its name
+		fn := splitFuncName(subpackage.Name, subPackage.SplitFunc)
+		if body, ok := shellFunction(c.ApkBuildRaw, fn); ok {
+			subpackage.Description = c.Apkbuild.Pkgname + " " + fn
+			if strings.HasSuffix(subpackage.Name, "-dev") {
+				subpackage.Dependencies.Runtime = c.devDependencies()
+			}
+			translateSplitFunc(c.Apkbuild.Pkgname, body, &subpackage)
+			c.GeneratedMelangeConfig.Subpackages = append(c.GeneratedMelangeConfig.Subpackages, subpackage)
+			continue
+		}
+
 		// generate subpackages based on the subpackages defined in the APKBUILD
 		var ext string
 		// parts := strings.Split(subPackage.Subpkgname, "-")
@@ -548,14 +590,7 @@ func (c ApkConvertor) mapconvert() {
 			case "dev":
 				ext = "dev"
 				subpackage.Dependencies = config.Dependencies{
-					Runtime: []string{c.Apkbuild.Pkgname},
-				}
-				// include dev dependencies in the dev runtime
-				for _, dependsDev := range c.Apkbuild.DependsDev {
-					subpackage.Dependencies.Runtime = append(subpackage.Dependencies.Runtime, dependsDev.Pkgname)
-				}
-				for _, depends := range c.Apkbuild.Depends {
-					subpackage.Dependencies.Runtime = append(subpackage.Dependencies.Runtime, depends.Pkgname)
+					Runtime: c.devDependencies(),
 				}
 			default:
 				// if we don't recognise the extension make it obvious user needs to manually fix the config
@@ -573,6 +608,19 @@ func (c ApkConvertor) mapconvert() {
 	}
 }
 
+// the runtime dependencies of the -dev subpackage, which are the package and the dev dependencies
+func (c ApkConvertor) devDependencies() []string {
+	runtime := []string{c.Apkbuild.Pkgname}
+	// include dev dependencies in the dev runtime
+	for _, dependsDev := range c.Apkbuild.DependsDev {
+		runtime = append(runtime, dependsDev.Pkgname)
+	}
+	for _, depends := range c.Apkbuild.Depends {
+		runtime = append(runtime, depends.Pkgname)
+	}
+	return runtime
+}
+
 // adds a mconvert environment section
 func (c ApkConvertor) buildEnvironment(additionalRepositories, additionalKeyrings []string) {
 	// wolfi-os base environment
@@ -312,6 +312,162 @@ func TestMultilineParsing(t *testing.T) {
 	assert.Equal(t, "de2cd008406d133cc838388f5a109560d29323e0a4c8c6306f712a536b6d90846d44bc5f691514621653f33a2929c0d84fa9c54d61d5ddf4606243df63c7e139", parsedApkBuild.Sha512sums[3].Hash)
 }
 
+func TestContext_getSourceShaFallback(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	c := getTestContext(t, server)
+	c.ApkConvertors["foo"] = ApkConvertor{
+		Apkbuild: &apkbuild.Apkbuild{
+			Source: []apkbuild.Source{
+				{Filename: "foo-1.2.3.tar.xz", Location: server.URL + "/foo-1.2.3.tar.xz"},
+				{Filename: "fix-build.patch", Location: "fix-build.patch"},
+			},
+			Pkgver: "1.2.3",
+			Sha512sums: []apkbuild.SourceHash{
+				{Source: "foo-1.2.3.tar.xz", Hash: "45c3e1ad1cc945ba83cf95e439d9d83520df955e53612efd592f53c173a118a949780c619bb744631c0867474bd770dc0308e0669732ab5d4bffcf417f3e9014"},
+			},
+		},
+		GeneratedMelangeConfig: &manifest.GeneratedMelangeConfig{},
+	}
+
+	assert.NoError(t, c.buildFetchStep(slogtest.Context(t), c.ApkConvertors["foo"]))
+	assert.Equal(t, []config.Pipeline{{
+		Uses: "fetch",
+		With: map[string]string{
+			"uri":             server.URL + "/foo-${{package.version}}.tar.xz",
+			"expected-sha512": "45c3e1ad1cc945ba83cf95e439d9d83520df955e53612efd592f53c173a118a949780c619bb744631c0867474bd770dc0308e0669732ab5d4bffcf417f3e9014",
+		},
+	}, {
+		Uses: "patch",
+		With: map[string]string{"patches": "fix-build.patch"},
+	}}, c.ApkConvertors["foo"].GeneratedMelangeConfig.Pipeline)
+}
+
+func TestTranslateSplitFunc(t *testing.T) {
+	raw := []byte(`pkgname=foo
+subpackages="$pkgname-dev $pkgname-tools $pkgname-bash-completion:bashcomp"
+
+dev() {
+	default_dev
+	amove usr/lib/cmake
+}
+
+tools() {
+	pkgdesc="$pkgname command line tools"
+	depends="$pkgname=$pkgver-r$pkgrel bash"
+	amove usr/bin/foo-*
+	ln -s foo-tool "$subpkgdir"/usr/bin/footool
+}
+
+bashcomp() {
+	install_if="$pkgname=$pkgver-r$pkgrel bash-completion"
+	amove usr/share/bash-completion/completions
+}
+`)
+
+	body, ok := shellFunction(raw, "dev")
+	assert.True(t, ok)
+	dev := config.Subpackage{Name: "foo-dev"}
+	translateSplitFunc("foo", body, &dev)
+	assert.Equal(t, []config.Pipeline{{Uses: "split/dev"}}, dev.Pipeline)
+	assert.Equal(t, []string{"usr/lib/cmake"}, dev.Contents)
+
+	body, ok = shellFunction(raw, splitFuncName("foo-tools", ""))
+	assert.True(t, ok)
+	tools := config.Subpackage{Name: "foo-tools"}
+	translateSplitFunc("foo", body, &tools)
+	assert.Equal(t, "foo command line tools", tools.Description)
+	assert.Equal(t, []string{"foo", "bash"}, tools.Dependencies.Runtime)
+	assert.Empty(t, tools.Contents)
+	assert.Equal(t, []config.Pipeline{{Runs: `mkdir -p "${{targets.subpkgdir}}"/usr/bin
+mv "${{targets.destdir}}"/usr/bin/foo-* "${{targets.subpkgdir}}"/usr/bin/
+ln -s foo-tool "${{targets.subpkgdir}}"/usr/bin/footool
+`}}, tools.Pipeline)
+
+	body, ok = shellFunction(raw, splitFuncName("foo-bash-completion", "bashcomp"))
+	assert.True(t, ok)
+	bashcomp := config.Subpackage{Name: "foo-bash-completion"}
+	translateSplitFunc("foo", body, &bashcomp)
+	assert.Equal(t, []string{"usr/share/bash-completion/completions"}, bashcomp.Contents)
+	assert.Equal(t, []string{"foo=${{package.full-version}}", "bash-completion"}, bashcomp.Dependencies.InstallIf)
+
+	_, ok = shellFunction(raw, "doc")
+	assert.False(t, ok)
+}
+
+func TestParseSecfixes(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "APKBUILD_DATA"))
+	assert.NoError(t, err)
+
+	secfixes := parseSecfixes(data)
+	assert.Equal(t, []Secfix{
+		{Version: "1.7.1-r0", Vulnerabilities: [][]string{{"CVE-2021-31535"}}},
+		{Version: "1.6.12-r0", Vulnerabilities: [][]string{{"CVE-2020-14363"}}},
+		{Version: "1.6.10-r0", Vulnerabilities: [][]string{{"CVE-2020-14344"}}},
+		{Version: "1.6.6-r0", Vulnerabilities: [][]string{{"CVE-2018-14598"}, {"CVE-2018-14599"}, {"CVE-2018-14600"}}},
+	}, secfixes)
+
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	doc := advisories("libx11", []Secfix{
+		{Version: "1.7.1-r0", Vulnerabilities: [][]string{{"CVE-2021-31535", "GHSA-aaaa-bbbb-cccc"}}},
+		{Version: "0", Vulnerabilities: [][]string{{"CVE-2019-0001"}}},
+	}, now)
+	assert.Equal(t, "libx11", doc.Package.Name)
+	assert.Equal(t, []advisory{{
+		ID:      "CVE-2021-31535",
+		Aliases: []string{"GHSA-aaaa-bbbb-cccc"},
+		Events:  []advisoryEvent{{Timestamp: "2024-01-02T03:04:05Z", Type: "fixed", Data: map[string]string{"fixed-version": "1.7.1-r0"}}},
+	}, {
+		ID:      "CVE-2019-0001",
+		Aliases: []string{},
+		Events: []advisoryEvent{{Timestamp: "2024-01-02T03:04:05Z", Type: "false-positive-determination", Data: map[string]string{
+			"type": "FIXME",
+			"note": "The APKBUILD's secfixes list it as never affecting the package",
+		}}},
+	}}, doc.Advisories)
+}
+
+func TestBuildScriptlets(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/main/foo/foo.post-install":
+			_, _ = rw.Write([]byte("#!/bin/sh\necho installed\n"))
+		case "/main/foo/foo-fonts.trigger":
+			_, _ = rw.Write([]byte("#!/bin/sh\nfc-cache\n"))
+		default:
+			http.NotFound(rw, req)
+		}
+	}))
+	defer server.Close()
+
+	c := getTestContext(t, server)
+	converter := ApkConvertor{
+		Apkbuild: &apkbuild.Apkbuild{
+			Pkgname:  "foo",
+			Install:  []string{"$pkgname.post-install", "foo.pre-deinstall", "foo.unknown"},
+			Triggers: []string{"$pkgname-fonts.trigger=/usr/share/fonts/*:/usr/share/X11/fonts/*"},
+		},
+		GeneratedMelangeConfig: &manifest.GeneratedMelangeConfig{
+			GeneratedFromComment: server.URL + "/main/foo/APKBUILD",
+		},
+	}
+	converter.GeneratedMelangeConfig.Subpackages = []config.Subpackage{{Name: "foo-fonts"}}
+
+	c.buildScriptlets(slogtest.Context(t), converter)
+
+	assert.Equal(t, &config.Scriptlets{
+		PostInstall:  "#!/bin/sh\necho installed\n",
+		PreDeinstall: "FIXME: fetch " + server.URL + "/main/foo/foo.pre-deinstall",
+	}, converter.GeneratedMelangeConfig.Package.Scriptlets)
+	assert.Equal(t, &config.Scriptlets{
+		Trigger: config.Trigger{
+			Paths:  []string{"/usr/share/fonts/*", "/usr/share/X11/fonts/*"},
+			Script: "#!/bin/sh\nfc-cache\n",
+		},
+	}, converter.GeneratedMelangeConfig.Subpackages[0].Scriptlets)
+}
+
 func getTestContext(t *testing.T, server *httptest.Server) Context {
 	return Context{
 		NavigationMap: &NavigationMap{
@@ -0,0 +1,108 @@
+package apkbuild
+
+import (
+	"context"
+	"net/url"
+	"strings"
+
+	"chainguard.dev/melange/pkg/config"
+	"github.com/chainguard-dev/clog"
+)
+
+// buildScriptlets fetches the install scripts and triggers of the APKBUILD, which are next to
+// it, and adds them to the scriptlets of the packages they're for
+func (c *Context) buildScriptlets(ctx context.Context, converter ApkConvertor) {
+	log := clog.FromContext(ctx)
+
+	base, err := url.Parse(converter.GeneratedMelangeConfig.GeneratedFromComment)
+	if err != nil {
+		log.Infof("skipping scriptlets, parsing URI %s: %v", converter.GeneratedMelangeConfig.GeneratedFromComment, err)
+		return
+	}
+	expand := strings.NewReplacer("${pkgname}", converter.Apkbuild.Pkgname, "$pkgname", converter.Apkbuild.Pkgname)
+
+	for _, install := range converter.Apkbuild.Install {
+		name := expand.Replace(install)
+		i := strings.LastIndex(name, ".")
+		if i < 0 {
+			log.Infof("skipping install script %s, which isn't named after its package", name)
+			continue
+		}
+		if scriptletFor(&config.Scriptlets{}, name[i+1:]) == nil {
+			log.Infof("skipping install script %s, which isn't a pre or post install, upgrade or deinstall script", name)
+			continue
+		}
+		scriptlets := converter.scriptletsFor(name[:i])
+		if scriptlets == nil {
+			log.Infof("skipping install script %s, which isn't for any of the packages", name)
+			continue
+		}
+		*scriptletFor(scriptlets, name[i+1:]) = c.fetchScript(ctx, base, name)
+	}
+
+	// such as $pkgname.trigger=/usr/share/fonts/*:/usr/share/X11/fonts/*
+	for _, trigger := range converter.Apkbuild.Triggers {
+		name, paths, _ := strings.Cut(expand.Replace(trigger), "=")
+		scriptlets := converter.scriptletsFor(strings.TrimSuffix(name, ".trigger"))
+		if scriptlets == nil {
+			log.Infof("skipping trigger %s, which isn't for any of the packages", name)
+			continue
+		}
+		scriptlets.Trigger = config.Trigger{
+			Paths:  strings.Split(paths, ":"),
+			Script: c.fetchScript(ctx, base, name),
+		}
+	}
+}
+
+// fetchScript returns the script next to the APKBUILD at base, or a FIXME if it can't be fetched
+func (c *Context) fetchScript(ctx context.Context, base *url.URL, name string) string {
+	uri := base.ResolveReference(&url.URL{Path: name}).String()
+	b, err := c.get(ctx, uri)
+	if err != nil {
+		clog.FromContext(ctx).Infof("%v", err)
+		return "FIXME: fetch " + uri
+	}
+	return string(b)
+}
+
+// scriptletsFor returns the scriptlets of the package or subpackage named name, or nil if
+// there's no such package
+func (c ApkConvertor) scriptletsFor(name string) *config.Scriptlets {
+	cfg := c.GeneratedMelangeConfig
+	if name == c.Apkbuild.Pkgname {
+		if cfg.Package.Scriptlets == nil {
+			cfg.Package.Scriptlets = &config.Scriptlets{}
+		}
+		return cfg.Package.Scriptlets
+	}
+	for i := range cfg.Subpackages {
+		if sp := &cfg.Subpackages[i]; sp.Name == name {
+			if sp.Scriptlets == nil {
+				sp.Scriptlets = &config.Scriptlets{}
+			}
+			return sp.Scriptlets
+		}
+	}
+	return nil
+}
+
+// scriptletFor returns the scriptlet that the install script with the suffix, such as
+// post-install, is, or nil if it isn't one
+func scriptletFor(scriptlets *config.Scriptlets, suffix string) *string {
+	switch suffix {
+	case "pre-install":
+		return &scriptlets.PreInstall
+	case "post-install":
+		return &scriptlets.PostInstall
+	case "pre-upgrade":
+		return &scriptlets.PreUpgrade
+	case "post-upgrade":
+		return &scriptlets.PostUpgrade
+	case "pre-deinstall":
+		return &scriptlets.PreDeinstall
+	case "post-deinstall":
+		return &scriptlets.PostDeinstall
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package apkbuild
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/chainguard-dev/clog"
+	"github.com/chainguard-dev/yam/pkg/yam/formatted"
+	"gopkg.in/yaml.v3"
+)
+
+// Secfix is a version of the secfixes of an APKBUILD, with the vulnerabilities that it fixed.
+// Each vulnerability is its identifiers, such as a CVE and its GHSA. Version 0 lists the
+// vulnerabilities that the package was never affected by.
+type Secfix struct {
+	Version         string
+	Vulnerabilities [][]string
+}
+
+// parseSecfixes parses the secfixes comment of the APKBUILD raw:
+//
+//	# secfixes:
+//	#   1.7.1-r0:
+//	#     - CVE-2021-31535
+func parseSecfixes(raw []byte) []Secfix {
+	var secfixes []Secfix
+	in := false
+	for _, line := range strings.Split(string(raw), "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "#") {
+			if in {
+				break
+			}
+			continue
+		}
+		text := strings.TrimSpace(strings.TrimPrefix(line, "#"))
+		switch {
+		case text == "secfixes:":
+			in = true
+		case !in:
+		case strings.HasPrefix(text, "- "):
+			if len(secfixes) > 0 {
+				ids := strings.Fields(strings.TrimPrefix(text, "- "))
+				secfixes[len(secfixes)-1].Vulnerabilities = append(secfixes[len(secfixes)-1].Vulnerabilities, ids)
+			}
+		case strings.HasSuffix(text, ":"):
+			secfixes = append(secfixes, Secfix{Version: strings.Trim(strings.TrimSuffix(text, ":"), `"'`)})
+		default:
+			return secfixes
+		}
+	}
+	return secfixes
+}
+
+// advisoriesDocument is the advisories of a package, as wolfictl keeps them.
+type advisoriesDocument struct {
+	SchemaVersion string `yaml:"schema-version"`
+	Package       struct {
+		Name string `yaml:"name"`
+	} `yaml:"package"`
+	Advisories []advisory `yaml:"advisories"`
+}
+
+type advisory struct {
+	ID      string          `yaml:"id"`
+	Aliases []string        `yaml:"aliases,omitempty"`
+	Events  []advisoryEvent `yaml:"events"`
+}
+
+type advisoryEvent struct {
+	Timestamp string            `yaml:"timestamp"`
+	Type      string            `yaml:"type"`
+	Data      map[string]string `yaml:"data,omitempty"`
+}
+
+// advisories maps the secfixes of the package pkgname into its advisories, with events at
+// now. The vulnerabilities of version 0 are false positives, for whoever finishes the
+// advisories to say why.
+func advisories(pkgname string, secfixes []Secfix, now time.Time) advisoriesDocument {
+	doc := advisoriesDocument{SchemaVersion: "2"}
+	doc.Package.Name = pkgname
+	timestamp := now.UTC().Format(time.RFC3339)
+	for _, secfix := range secfixes {
+		for _, ids := range secfix.Vulnerabilities {
+			if len(ids) == 0 {
+				continue
+			}
+			event := advisoryEvent{
+				Timestamp: timestamp,
+				Type:      "fixed",
+				Data:      map[string]string{"fixed-version": secfix.Version},
+			}
+			if secfix.Version == "0" {
+				event.Type = "false-positive-determination"
+				event.Data = map[string]string{
+					"type": "FIXME",
+					"note": "The APKBUILD's secfixes list it as never affecting the package",
+				}
+			}
+			doc.Advisories = append(doc.Advisories, advisory{
+				ID:      ids[0],
+				Aliases: ids[1:],
+				Events:  []advisoryEvent{event},
+			})
+		}
+	}
+	return doc
+}
+
+// writeAdvisories writes the advisories that the secfixes of the APKBUILD map
+// into next to the converted configuration, if it has any.
+func (c ApkConvertor) writeAdvisories(ctx context.Context, outdir string, now time.Time) error {
+	secfixes := parseSecfixes(c.ApkBuildRaw)
+	if len(secfixes) == 0 {
+		return nil
+	}
+
+	advisoriesFile := filepath.Join(outdir, fmt.Sprintf("%s.advisories.yaml", c.Apkbuild.Pkgname))
+	f, err := os.Create(advisoriesFile)
+	if err != nil {
+		return fmt.Errorf("creating file %s: %w", advisoriesFile, err)
+	}
+	defer f.Close()
+
+	var n yaml.Node
+	if err := n.Encode(advisories(c.Apkbuild.Pkgname, secfixes, now)); err != nil {
+		return fmt.Errorf("encoding YAML to node: %w", err)
+	}
+	if err := formatted.NewEncoder(f).AutomaticConfig().Encode(&n); err != nil {
+		return fmt.Errorf("encoding formatted YAML to file %s: %w", advisoriesFile, err)
+	}
+
+	clog.FromContext(ctx).Infof("Generated advisories from secfixes: %s", advisoriesFile)
+	return nil
+}
@@ -0,0 +1,166 @@
+package apkbuild
+
+import (
+	"path"
+	"regexp"
+	"strings"
+
+	"chainguard.dev/melange/pkg/config"
+)
+
+// defaultSplits maps the default split functions of abuild, which split
+// functions call, to the split pipelines that do the same.
+var defaultSplits = map[string]string{
+	"default_dbg":    "split/debug",
+	"default_dev":    "split/dev",
+	"default_doc":    "split/doc",
+	"default_lang":   "split/locales",
+	"default_static": "split/static",
+}
+
+// shellFunction returns the lines of the body of the shell function name
+// that the APKBUILD raw defines, or false if it doesn't.
+func shellFunction(raw []byte, name string) ([]string, bool) {
+	lines := strings.Split(string(raw), "\n")
+	for i, line := range lines {
+		line = strings.TrimRight(line, " \t")
+		if line != name+"() {" && line != name+"()" {
+			continue
+		}
+		start := i + 1
+		if line == name+"()" {
+			// The brace is on the next line.
+			start++
+		}
+		for j := start; j < len(lines); j++ {
+			if strings.TrimRight(lines[j], " \t") == "}" {
+				return lines[start:j], true
+			}
+		}
+		return nil, false
+	}
+	return nil, false
+}
+
+// splitFuncName returns the name of the split function of the subpackage
+// named name, which abuild names after the last part of its name, unless
+// the subpackage sets it.
+func splitFuncName(name, splitFunc string) string {
+	if splitFunc != "" {
+		return splitFunc
+	}
+	if i := strings.LastIndex(name, "-"); i >= 0 {
+		return name[i+1:]
+	}
+	return name
+}
+
+// translateSplitFunc translates the body of a split function of the
+// package pkgname into the subpackage sp. Files that the function moves
+// with amove are moved into the subpackage by its contents, unless it runs
+// other commands, which the subpackage's pipeline runs in order with them.
+func translateSplitFunc(pkgname string, body []string, sp *config.Subpackage) {
+	replacer := strings.NewReplacer(
+		"${pkgver}-r${pkgrel}", config.SubstitutionPackageFullVersion,
+		"$pkgver-r$pkgrel", config.SubstitutionPackageFullVersion,
+		"${pkgdir}", config.SubstitutionTargetsDestdir,
+		"$pkgdir", config.SubstitutionTargetsDestdir,
+		"${subpkgdir}", config.SubstitutionSubPkgDir,
+		"$subpkgdir", config.SubstitutionSubPkgDir,
+		"${pkgname}", pkgname,
+		"$pkgname", pkgname,
+		"${pkgver}", config.SubstitutionPackageVersion,
+		"$pkgver", config.SubstitutionPackageVersion,
+	)
+	var moves, script []string
+	other := false
+	for _, line := range joinContinuations(body) {
+		trimmed := strings.TrimSpace(line)
+		fields := strings.Fields(strings.ReplaceAll(trimmed, "\\\n", " "))
+		if len(fields) == 0 || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		name, value, isAssignment := strings.Cut(trimmed, "=")
+		switch {
+		case isAssignment && name == "pkgdesc":
+			sp.Description = replacer.Replace(strings.Trim(value, `"'`))
+		case isAssignment && name == "depends":
+			// Without their versions, which are the package's own.
+			for _, dep := range strings.Fields(strings.Trim(value, `"'`)) {
+				if i := strings.IndexAny(dep, "=<>~"); i >= 0 {
+					dep = dep[:i]
+				}
+				dep = replacer.Replace(dep)
+				if dep != "" && !hasShellVariable(dep) && !contains(sp.Dependencies.Runtime, dep) {
+					sp.Dependencies.Runtime = append(sp.Dependencies.Runtime, dep)
+				}
+			}
+		case isAssignment && name == "install_if":
+			// With their versions, which are usually the package's own.
+			for _, dep := range strings.Fields(strings.Trim(value, `"'`)) {
+				dep = replacer.Replace(dep)
+				if !hasShellVariable(dep) && !contains(sp.Dependencies.InstallIf, dep) {
+					sp.Dependencies.InstallIf = append(sp.Dependencies.InstallIf, dep)
+				}
+			}
+		case isAssignment && !strings.ContainsAny(name, " \t"):
+			// Such as provides, which aren't translated.
+		case defaultSplits[fields[0]] != "":
+			sp.Pipeline = append(sp.Pipeline, config.Pipeline{Uses: defaultSplits[fields[0]]})
+		case fields[0] == "amove":
+			for _, p := range fields[1:] {
+				p = strings.TrimPrefix(replacer.Replace(strings.Trim(p, `"'`)), "/")
+				moves = append(moves, p)
+				script = append(script, amoveScript(p)...)
+			}
+		default:
+			script = append(script, replacer.Replace(trimmed))
+			other = true
+		}
+	}
+
+	if !other {
+		sp.Contents = append(sp.Contents, moves...)
+		return
+	}
+	sp.Pipeline = append(sp.Pipeline, config.Pipeline{Runs: strings.Join(script, "\n") + "\n"})
+}
+
+var substitutionRegex = regexp.MustCompile(`\$\{\{[^}]*\}\}`)
+
+// hasShellVariable returns whether s still refers to a shell variable once
+// the melange substitutions in it are left out.
+func hasShellVariable(s string) bool {
+	return strings.Contains(substitutionRegex.ReplaceAllString(s, ""), "$")
+}
+
+// amoveScript returns the commands that move the path p from the package
+// into the subpackage, as amove does.
+func amoveScript(p string) []string {
+	dir := path.Dir(p)
+	dst := `"` + config.SubstitutionSubPkgDir + `"/` + dir
+	if dir == "." {
+		dst = `"` + config.SubstitutionSubPkgDir + `"`
+	}
+	return []string{
+		"mkdir -p " + dst,
+		`mv "` + config.SubstitutionTargetsDestdir + `"/` + p + " " + dst + "/",
+	}
+}
+
+// joinContinuations joins the lines that end with a backslash with the
+// lines that follow them.
+func joinContinuations(lines []string) []string {
+	var out []string
+	continued := false
+	for _, line := range lines {
+		if continued {
+			out[len(out)-1] += "\n" + line
+		} else {
+			out = append(out, line)
+		}
+		continued = strings.HasSuffix(strings.TrimRight(line, " \t"), "\\")
+	}
+	return out
+}
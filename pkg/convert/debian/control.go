@@ -0,0 +1,202 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package debian
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// Paragraph is a paragraph of a deb822 file, such as debian/control, with
+// its field names in lower case.
+type Paragraph map[string]string
+
+// Get returns the value of the field, whatever the case of its name.
+func (p Paragraph) Get(field string) string {
+	return p[strings.ToLower(field)]
+}
+
+// ParseParagraphs parses the paragraphs of a deb822 file. The lines that
+// continue a field are joined to its value with newlines.
+func ParseParagraphs(r io.Reader) ([]Paragraph, error) {
+	var paragraphs []Paragraph
+	var current Paragraph
+	var field string
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "#"):
+			continue
+
+		case strings.TrimSpace(line) == "":
+			if current != nil {
+				paragraphs = append(paragraphs, current)
+			}
+			current, field = nil, ""
+
+		case line[0] == ' ' || line[0] == '\t':
+			if field == "" {
+				return nil, fmt.Errorf("line %d: continuation line without a field", n)
+			}
+			current[field] += "\n" + strings.TrimSpace(line)
+
+		default:
+			name, value, ok := strings.Cut(line, ":")
+			if !ok {
+				return nil, fmt.Errorf("line %d: expected a field, got %q", n, line)
+			}
+			if current == nil {
+				current = Paragraph{}
+			}
+			field = strings.ToLower(strings.TrimSpace(name))
+			current[field] = strings.TrimSpace(value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if current != nil {
+		paragraphs = append(paragraphs, current)
+	}
+	return paragraphs, nil
+}
+
+var relationQualifiersRegex = regexp.MustCompile(`\([^)]*\)|\[[^]]*\]|<[^>]*>`)
+
+// ParseRelations returns the names of the packages in a relationship
+// field, such as Build-Depends, without their versions, architectures
+// and build profiles. Of alternatives, only the first is returned.
+// Substitution variables, such as ${misc:Depends}, are left out.
+func ParseRelations(field string) []string {
+	var names []string
+	for _, rel := range strings.Split(field, ",") {
+		rel, _, _ = strings.Cut(rel, "|")
+		rel = strings.TrimSpace(relationQualifiersRegex.ReplaceAllString(rel, ""))
+		rel, _, _ = strings.Cut(rel, ":")
+		if rel == "" || strings.HasPrefix(rel, "${") {
+			continue
+		}
+		names = append(names, rel)
+	}
+	return names
+}
+
+var changelogRegex = regexp.MustCompile(`^(\S+) \(([^)]+)\)`)
+
+// ParseChangelog returns the source package name and the version of the
+// latest entry of debian/changelog.
+func ParseChangelog(r io.Reader) (string, string, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		m := changelogRegex.FindStringSubmatch(line)
+		if m == nil {
+			return "", "", fmt.Errorf("unexpected changelog entry %q", line)
+		}
+		return m[1], m[2], nil
+	}
+	if err := scanner.Err(); err != nil {
+		return "", "", err
+	}
+	return "", "", fmt.Errorf("empty changelog")
+}
+
+var repackRegex = regexp.MustCompile(`[+~](dfsg|ds|repack)[0-9.]*$`)
+
+// UpstreamVersion returns the upstream version of a Debian version, without
+// its epoch, its Debian revision, and the suffix of a repacked source, such
+// as +dfsg.
+func UpstreamVersion(version string) string {
+	if i := strings.Index(version, ":"); i >= 0 {
+		version = version[i+1:]
+	}
+	if i := strings.LastIndex(version, "-"); i >= 0 {
+		version = version[:i]
+	}
+	return repackRegex.ReplaceAllString(version, "")
+}
+
+// WatchEntry is a line of debian/watch, which finds the upstream releases
+// by the links matching Pattern on the page at URL.
+type WatchEntry struct {
+	URL     string
+	Pattern string
+}
+
+// ParseWatch parses the entries of debian/watch, with their options left
+// out.
+func ParseWatch(r io.Reader, source string) ([]WatchEntry, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	text := strings.ReplaceAll(string(b), "\\\n", "")
+
+	var entries []WatchEntry
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "version=") {
+			continue
+		}
+		line = strings.ReplaceAll(line, "@PACKAGE@", source)
+		line = strings.ReplaceAll(line, "@ANY_VERSION@", `[-_]?(\d[\-+\.:\~\da-zA-Z]*)`)
+		line = strings.ReplaceAll(line, "@ARCHIVE_EXT@", `(?:\.tar\.xz|\.tar\.bz2|\.tar\.gz|\.zip|\.tgz|\.tbz|\.txz)`)
+
+		fields := strings.Fields(line)
+		if len(fields) > 0 && strings.HasPrefix(fields[0], "opts=") {
+			fields = fields[1:]
+			// Quoted options can have spaces in them.
+			if strings.HasPrefix(line, `opts="`) {
+				if _, rest, ok := strings.Cut(line[len(`opts="`):], `"`); ok {
+					fields = strings.Fields(rest)
+				}
+			}
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		entry := WatchEntry{URL: fields[0]}
+		if len(fields) > 1 {
+			entry.Pattern = fields[1]
+		} else if i := strings.LastIndex(entry.URL, "/"); i >= 0 {
+			entry.URL, entry.Pattern = entry.URL[:i+1], entry.URL[i+1:]
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// ParseSeries returns the patches in debian/patches/series, in the order to
+// apply them.
+func ParseSeries(r io.Reader) ([]string, error) {
+	var patches []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		if fields := strings.Fields(line); len(fields) > 0 {
+			patches = append(patches, fields[0])
+		}
+	}
+	return patches, scanner.Err()
+}
@@ -0,0 +1,602 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package debian converts Debian source packages into melange
+// configurations, as best it can. What it can't work out is left as FIXME
+// for whoever finishes the configuration.
+package debian
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	apkotypes "chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/melange/pkg/config"
+	"chainguard.dev/melange/pkg/manifest"
+	"github.com/chainguard-dev/clog"
+)
+
+// Context holds the settings of the conversion.
+type Context struct {
+	OutDir                 string
+	AdditionalRepositories []string
+	AdditionalKeyrings     []string
+}
+
+// Converted is a melange configuration converted from a Debian source
+// package, with the patches that it applies.
+type Converted struct {
+	manifest.GeneratedMelangeConfig
+
+	// The paths of the patches in debian/patches, in the order they're
+	// applied.
+	Patches []string
+}
+
+// Generate converts the Debian source package in dir, the unpacked source
+// or just its debian directory, and writes its configuration to OutDir,
+// with its patches in a directory named after the package next to it.
+func (c Context) Generate(ctx context.Context, dir string) error {
+	debianDir, err := findDebianDir(dir)
+	if err != nil {
+		return err
+	}
+
+	converted, err := c.Convert(ctx, os.DirFS(filepath.Dir(debianDir)))
+	if err != nil {
+		return err
+	}
+	converted.GeneratedFromComment = debianDir
+
+	if err := converted.Write(ctx, c.OutDir); err != nil {
+		return err
+	}
+
+	patchDir := filepath.Join(c.OutDir, converted.Package.Name)
+	for _, p := range converted.Patches {
+		b, err := os.ReadFile(filepath.Join(debianDir, "patches", filepath.FromSlash(p)))
+		if err != nil {
+			return fmt.Errorf("reading patch: %w", err)
+		}
+		dst := filepath.Join(patchDir, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(dst, b, 0o644); err != nil {
+			return fmt.Errorf("writing patch: %w", err)
+		}
+	}
+	if len(converted.Patches) != 0 {
+		clog.FromContext(ctx).Infof("Copied %d patches to %s, build with --source-dir %s", len(converted.Patches), patchDir, patchDir)
+	}
+
+	return nil
+}
+
+// findDebianDir returns the debian directory of the source package in dir,
+// which may be the debian directory itself.
+func findDebianDir(dir string) (string, error) {
+	for _, d := range []string{filepath.Join(dir, "debian"), dir} {
+		if _, err := os.Stat(filepath.Join(d, "control")); err == nil {
+			return d, nil
+		}
+	}
+	return "", fmt.Errorf("no debian/control in %s", dir)
+}
+
+// Convert converts the Debian source package in fsys, which has its debian
+// directory, and may have the rest of its source.
+func (c Context) Convert(ctx context.Context, fsys fs.FS) (*Converted, error) {
+	log := clog.FromContext(ctx)
+
+	b, err := fs.ReadFile(fsys, "debian/control")
+	if err != nil {
+		return nil, fmt.Errorf("reading debian/control: %w", err)
+	}
+	paragraphs, err := ParseParagraphs(bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("parsing debian/control: %w", err)
+	}
+	if len(paragraphs) == 0 || paragraphs[0].Get("Source") == "" {
+		return nil, errors.New("debian/control has no source paragraph")
+	}
+	source, binaries := paragraphs[0], paragraphs[1:]
+	name := source.Get("Source")
+
+	b, err = fs.ReadFile(fsys, "debian/changelog")
+	if err != nil {
+		return nil, fmt.Errorf("reading debian/changelog: %w", err)
+	}
+	_, debVersion, err := ParseChangelog(bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("parsing debian/changelog: %w", err)
+	}
+	version := UpstreamVersion(debVersion)
+
+	converted := &Converted{}
+	cfg := &converted.Configuration
+	cfg.Package = config.Package{
+		Name:    name,
+		Version: version,
+		Epoch:   0,
+		URL:     source.Get("Homepage"),
+	}
+
+	// The binary package named after the source is the main package, and
+	// the others are its subpackages.
+	main := slices.IndexFunc(binaries, func(p Paragraph) bool { return p.Get("Package") == name })
+	if main < 0 && len(binaries) > 0 {
+		main = 0
+	}
+	if main >= 0 {
+		synopsis, _, _ := strings.Cut(binaries[main].Get("Description"), "\n")
+		cfg.Package.Description = synopsis
+		cfg.Package.Dependencies.Runtime = mapPackages(ParseRelations(binaries[main].Get("Depends")))
+	}
+
+	if license := readLicense(fsys); license != "" {
+		cfg.Package.Copyright = []config.Copyright{{License: license}}
+	} else {
+		cfg.Package.Copyright = []config.Copyright{{License: "FIXME"}}
+	}
+
+	cfg.Environment = c.environment(source)
+
+	entries, err := readWatch(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	fetch, update := fetchStep(entries)
+	cfg.Pipeline = append(cfg.Pipeline, fetch)
+	cfg.Update = update
+
+	if b, err := fs.ReadFile(fsys, "debian/patches/series"); err == nil {
+		patches, err := ParseSeries(bytes.NewReader(b))
+		if err != nil {
+			return nil, fmt.Errorf("parsing debian/patches/series: %w", err)
+		}
+		converted.Patches = patches
+	}
+	if len(converted.Patches) != 0 {
+		cfg.Pipeline = append(cfg.Pipeline, config.Pipeline{
+			Uses: "patch",
+			With: map[string]string{"patches": strings.Join(converted.Patches, " ")},
+		})
+	}
+
+	rules, err := fs.ReadFile(fsys, "debian/rules")
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("reading debian/rules: %w", err)
+	}
+	buildSystem := detectBuildSystem(fsys, string(rules), source)
+	log.Infof("building %s with %s", name, cmp.Or(buildSystem, "an unknown build system"))
+	cfg.Pipeline = append(cfg.Pipeline, buildSteps(buildSystem, configureOpts(string(rules)))...)
+
+	for i, bin := range binaries {
+		if i == main {
+			continue
+		}
+		sp, err := subpackage(fsys, name, bin)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Subpackages = append(cfg.Subpackages, sp)
+	}
+
+	return converted, nil
+}
+
+// environment returns the build environment of the source package, with
+// the packages that it build-depends on.
+func (c Context) environment(source Paragraph) apkotypes.ImageConfiguration {
+	env := apkotypes.ImageConfiguration{
+		Contents: apkotypes.ImageContents{
+			BuildRepositories: c.AdditionalRepositories,
+			Keyring:           c.AdditionalKeyrings,
+			Packages: []string{
+				"build-base",
+				"busybox",
+				"ca-certificates-bundle",
+			},
+		},
+	}
+	deps := slices.Concat(
+		ParseRelations(source.Get("Build-Depends")),
+		ParseRelations(source.Get("Build-Depends-Arch")),
+		ParseRelations(source.Get("Build-Depends-Indep")),
+	)
+	for _, p := range mapPackages(deps) {
+		if !slices.Contains(env.Contents.Packages, p) {
+			env.Contents.Packages = append(env.Contents.Packages, p)
+		}
+	}
+	return env
+}
+
+// readLicense returns the SPDX expression of the license of the files that
+// debian/copyright lists for *, if it's machine-readable.
+func readLicense(fsys fs.FS) string {
+	b, err := fs.ReadFile(fsys, "debian/copyright")
+	if err != nil {
+		return ""
+	}
+	paragraphs, err := ParseParagraphs(bytes.NewReader(b))
+	if err != nil {
+		return ""
+	}
+	for _, p := range paragraphs {
+		if strings.TrimSpace(p.Get("Files")) != "*" {
+			continue
+		}
+		short, _, _ := strings.Cut(p.Get("License"), "\n")
+		return spdxExpression(short)
+	}
+	return ""
+}
+
+// spdxLicenses maps the short names of licenses in debian/copyright to
+// their SPDX identifiers.
+var spdxLicenses = map[string]string{
+	"Apache-2":      "Apache-2.0",
+	"Apache-2.0":    "Apache-2.0",
+	"Artistic":      "Artistic-1.0-Perl",
+	"BSD-2-clause":  "BSD-2-Clause",
+	"BSD-3-clause":  "BSD-3-Clause",
+	"Expat":         "MIT",
+	"GPL-1+":        "GPL-1.0-or-later",
+	"GPL-2":         "GPL-2.0-only",
+	"GPL-2+":        "GPL-2.0-or-later",
+	"GPL-3":         "GPL-3.0-only",
+	"GPL-3+":        "GPL-3.0-or-later",
+	"ISC":           "ISC",
+	"LGPL-2":        "LGPL-2.0-only",
+	"LGPL-2+":       "LGPL-2.0-or-later",
+	"LGPL-2.1":      "LGPL-2.1-only",
+	"LGPL-2.1+":     "LGPL-2.1-or-later",
+	"LGPL-3":        "LGPL-3.0-only",
+	"LGPL-3+":       "LGPL-3.0-or-later",
+	"MIT":           "MIT",
+	"MPL-2.0":       "MPL-2.0",
+	"public-domain": "LicenseRef-public-domain",
+	"Zlib":          "Zlib",
+}
+
+// spdxExpression converts a license of debian/copyright, such as
+// "GPL-2+ or Artistic", into an SPDX expression.
+func spdxExpression(license string) string {
+	fields := strings.Fields(license)
+	for i, f := range fields {
+		switch lower := strings.ToLower(f); lower {
+		case "or", "and":
+			fields[i] = strings.ToUpper(lower)
+		default:
+			if id, ok := spdxLicenses[f]; ok {
+				fields[i] = id
+			}
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+// droppedPackages are build dependencies that only Debian's packaging
+// needs.
+var droppedPackages = []string{"debhelper", "debhelper-compat", "dpkg-dev", "quilt", "lsb-release"}
+
+// packageNames maps the names of Debian packages to the names of the
+// packages that provide the same.
+var packageNames = map[string]string{
+	"build-essential":      "build-base",
+	"golang-any":           "go",
+	"golang-go":            "go",
+	"libbz2-dev":           "bzip2-dev",
+	"libcurl4-gnutls-dev":  "curl-dev",
+	"libcurl4-openssl-dev": "curl-dev",
+	"libexpat1-dev":        "expat-dev",
+	"libglib2.0-dev":       "glib-dev",
+	"libgtk-3-dev":         "gtk-3-dev",
+	"liblzma-dev":          "xz-dev",
+	"libncurses-dev":       "ncurses-dev",
+	"libncurses5-dev":      "ncurses-dev",
+	"libncursesw5-dev":     "ncurses-dev",
+	"libpcre2-dev":         "pcre2-dev",
+	"libreadline-dev":      "readline-dev",
+	"libsqlite3-dev":       "sqlite-dev",
+	"libssl-dev":           "openssl-dev",
+	"libsystemd-dev":       "systemd-dev",
+	"libzstd-dev":          "zstd-dev",
+	"ninja-build":          "ninja",
+	"pkg-config":           "pkgconf",
+	"pkgconf":              "pkgconf",
+	"python3-all":          "python3",
+	"python3-all-dev":      "python3-dev",
+	"python3-dev":          "python3-dev",
+	"python3":              "python3",
+	"cargo":                "rust",
+	"rustc":                "rust",
+	"zlib1g-dev":           "zlib-dev",
+}
+
+// mapPackages maps the names of Debian packages to the names of the
+// packages that provide the same, leaving out the ones that only Debian's
+// packaging needs. Names that aren't known are kept as they are.
+func mapPackages(debs []string) []string {
+	var out []string
+	for _, deb := range debs {
+		p, ok := packageNames[deb]
+		switch {
+		case ok:
+		case slices.Contains(droppedPackages, deb) || strings.HasPrefix(deb, "dh-"):
+			p = ""
+		case strings.HasPrefix(deb, "python3-"):
+			p = "py3-" + strings.TrimPrefix(deb, "python3-")
+		default:
+			p = deb
+		}
+		if p != "" && !slices.Contains(out, p) {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// readWatch reads the entries of debian/watch, if there is one.
+func readWatch(fsys fs.FS, source string) ([]WatchEntry, error) {
+	b, err := fs.ReadFile(fsys, "debian/watch")
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading debian/watch: %w", err)
+	}
+	entries, err := ParseWatch(bytes.NewReader(b), source)
+	if err != nil {
+		return nil, fmt.Errorf("parsing debian/watch: %w", err)
+	}
+	return entries, nil
+}
+
+var versionGroupRegex = regexp.MustCompile(`\([^)]*\)`)
+
+// fetchStep returns the step that fetches the upstream source that the
+// first entry of debian/watch finds, and the configuration to update the
+// package by. Sources on GitHub are checked out at their release tags, and
+// others are fetched from the page that debian/watch looks at, if the file
+// they're in can be worked out from its pattern.
+func fetchStep(entries []WatchEntry) (config.Pipeline, config.Update) {
+	fixme := config.Pipeline{
+		Uses: "fetch",
+		With: map[string]string{
+			"uri":             "FIXME",
+			"expected-sha256": "FIXME",
+		},
+	}
+	if len(entries) == 0 {
+		return fixme, config.Update{Enabled: false, ExcludeReason: "FIXME: debian/watch has no entries"}
+	}
+	entry := entries[0]
+
+	u, err := url.Parse(entry.URL)
+	if err != nil {
+		return fixme, config.Update{Enabled: false, ExcludeReason: "FIXME: " + err.Error()}
+	}
+
+	if u.Host == "github.com" {
+		parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+		if len(parts) >= 2 {
+			identifier := path.Join(parts[0], parts[1])
+			prefix := ""
+			if strings.Contains(entry.Pattern, "/v") || strings.Contains(entry.Pattern, "v?") {
+				prefix = "v"
+			}
+			return config.Pipeline{
+				Uses: "git-checkout",
+				With: map[string]string{
+					"repository":      "https://github.com/" + identifier,
+					"tag":             prefix + "${{package.version}}",
+					"expected-commit": "FIXME",
+				},
+			}, config.Update{
+				Enabled: true,
+				GitHubMonitor: &config.GitHubMonitor{
+					Identifier:  identifier,
+					StripPrefix: prefix,
+					UseTags:     true,
+				},
+			}
+		}
+	}
+
+	// The file name is the pattern with the version for its group, if
+	// nothing else in it is a regular expression.
+	file := strings.ReplaceAll(versionGroupRegex.ReplaceAllLiteralString(entry.Pattern, "${{package.version}}"), `\.`, ".")
+	file = strings.TrimPrefix(file, ".*/")
+	if strings.Count(entry.Pattern, "(") != 1 || strings.ContainsAny(strings.ReplaceAll(file, "${{package.version}}", ""), `\()[]*+?|^$`) {
+		fixme.With["uri"] = "FIXME: " + entry.URL + " " + entry.Pattern
+		return fixme, config.Update{Enabled: false, ExcludeReason: "FIXME: find the release-monitoring.org identifier of the package"}
+	}
+	uri := strings.TrimSuffix(entry.URL, "/") + "/" + file
+	return config.Pipeline{
+		Uses: "fetch",
+		With: map[string]string{
+			"uri":             uri,
+			"expected-sha256": "FIXME",
+		},
+	}, config.Update{
+		Enabled:       false,
+		ExcludeReason: "FIXME: find the release-monitoring.org identifier of the package",
+	}
+}
+
+var buildSystemRegex = regexp.MustCompile(`--buildsystem[= ]([a-z_]+)`)
+
+// detectBuildSystem returns the build system that debian/rules builds the
+// package with. When it isn't set, it's guessed from what the package
+// build-depends on, and otherwise picked from the files of the source, as
+// dh does.
+func detectBuildSystem(fsys fs.FS, rules string, source Paragraph) string {
+	if m := buildSystemRegex.FindStringSubmatch(rules); m != nil {
+		return m[1]
+	}
+	deps := ParseRelations(source.Get("Build-Depends"))
+	switch {
+	case strings.Contains(rules, "--with python3") || strings.Contains(rules, "--with=python3") ||
+		slices.Contains(deps, "dh-python") || slices.Contains(deps, "dh-sequence-python3"):
+		return "pybuild"
+	case slices.Contains(deps, "cmake"):
+		return "cmake"
+	case slices.Contains(deps, "meson"):
+		return "meson"
+	case slices.Contains(deps, "dh-golang") || slices.Contains(deps, "dh-sequence-golang"):
+		return "golang"
+	case slices.Contains(deps, "autoconf") || slices.Contains(deps, "dh-autoreconf"):
+		return "autoconf"
+	}
+
+	for _, f := range []struct{ file, buildSystem string }{
+		{"configure", "autoconf"},
+		{"configure.ac", "autoconf"},
+		{"CMakeLists.txt", "cmake"},
+		{"meson.build", "meson"},
+		{"pyproject.toml", "pybuild"},
+		{"setup.py", "pybuild"},
+		{"Makefile", "makefile"},
+	} {
+		if _, err := fs.Stat(fsys, f.file); err == nil {
+			return f.buildSystem
+		}
+	}
+	if strings.Contains(rules, "dh_auto_configure") {
+		return "autoconf"
+	}
+	return ""
+}
+
+// configureOpts returns the options that debian/rules passes to the build
+// system when it overrides dh_auto_configure, such as
+// "dh_auto_configure -- --disable-foo".
+func configureOpts(rules string) string {
+	var opts []string
+	scanner := bufio.NewScanner(strings.NewReader(strings.ReplaceAll(rules, "\\\n", " ")))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		_, rest, ok := strings.Cut(line, "dh_auto_configure")
+		if !ok {
+			continue
+		}
+		if _, args, ok := strings.Cut(rest, " -- "); ok {
+			opts = append(opts, strings.Fields(args)...)
+		}
+	}
+	return strings.Join(opts, " ")
+}
+
+// buildSteps returns the steps that build and install the package with the
+// build system.
+func buildSteps(buildSystem, opts string) []config.Pipeline {
+	with := func(opts string) map[string]string {
+		if opts == "" {
+			return nil
+		}
+		return map[string]string{"opts": opts}
+	}
+
+	var steps []config.Pipeline
+	switch buildSystem {
+	case "autoconf":
+		steps = []config.Pipeline{
+			{Uses: "autoconf/configure", With: with(opts)},
+			{Uses: "autoconf/make"},
+			{Uses: "autoconf/make-install"},
+		}
+	case "makefile":
+		steps = []config.Pipeline{
+			{Uses: "autoconf/make"},
+			{Uses: "autoconf/make-install"},
+		}
+	case "cmake":
+		steps = []config.Pipeline{
+			{Uses: "cmake/configure", With: with(opts)},
+			{Uses: "cmake/build"},
+			{Uses: "cmake/install"},
+		}
+	case "meson":
+		steps = []config.Pipeline{
+			{Uses: "meson/configure", With: with(opts)},
+			{Uses: "meson/compile"},
+			{Uses: "meson/install"},
+		}
+	case "pybuild":
+		return []config.Pipeline{{Uses: "python/build@v2"}}
+	default:
+		return []config.Pipeline{{Runs: "FIXME: build and install the package into ${{targets.contextdir}}"}}
+	}
+	return append(steps, config.Pipeline{Uses: "strip"})
+}
+
+// subpackage returns the subpackage for the binary package bin of source.
+// The files that debian/<package>.install lists are moved into it, and
+// otherwise development files and documentation are split into -dev and
+// -doc packages.
+func subpackage(fsys fs.FS, source string, bin Paragraph) (config.Subpackage, error) {
+	name := bin.Get("Package")
+	synopsis, _, _ := strings.Cut(bin.Get("Description"), "\n")
+	sp := config.Subpackage{
+		Name:        name,
+		Description: synopsis,
+		Dependencies: config.Dependencies{
+			Runtime: mapPackages(ParseRelations(bin.Get("Depends"))),
+		},
+	}
+	if rest, ok := strings.CutPrefix(name, source); ok && rest != "" {
+		sp.Name = "${{package.name}}" + rest
+	}
+
+	b, err := fs.ReadFile(fsys, "debian/"+name+".install")
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return sp, fmt.Errorf("reading debian/%s.install: %w", name, err)
+	}
+	if err == nil {
+		scanner := bufio.NewScanner(bytes.NewReader(b))
+		for scanner.Scan() {
+			line, _, _ := strings.Cut(scanner.Text(), "#")
+			if fields := strings.Fields(line); len(fields) > 0 {
+				p := strings.TrimPrefix(fields[0], "/")
+				sp.Contents = append(sp.Contents, strings.TrimPrefix(p, "debian/tmp/"))
+			}
+		}
+		return sp, nil
+	}
+
+	switch {
+	case strings.HasSuffix(name, "-dev"):
+		sp.Pipeline = []config.Pipeline{{Uses: "split/dev"}}
+	case strings.HasSuffix(name, "-doc"):
+		sp.Pipeline = []config.Pipeline{{Uses: "split/doc"}}
+	default:
+		sp.Pipeline = []config.Pipeline{{Runs: "FIXME: move the files of " + name + " into ${{targets.subpkgdir}}"}}
+	}
+	return sp, nil
+}
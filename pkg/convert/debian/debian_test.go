@@ -0,0 +1,89 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package debian
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"chainguard.dev/melange/pkg/config"
+	"github.com/chainguard-dev/clog/slogtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerate(t *testing.T) {
+	dir := t.TempDir()
+	c := Context{
+		OutDir:                 dir,
+		AdditionalRepositories: []string{"https://packages.wolfi.dev/os"},
+		AdditionalKeyrings:     []string{"https://packages.wolfi.dev/os/wolfi-signing.rsa.pub"},
+	}
+	require.NoError(t, c.Generate(slogtest.Context(t), filepath.Join("testdata", "hello")))
+
+	got, err := os.ReadFile(filepath.Join(dir, "hello.yaml"))
+	require.NoError(t, err)
+	want, err := os.ReadFile(filepath.Join("testdata", "hello.yaml"))
+	require.NoError(t, err)
+	// The comment has the path that it was converted from.
+	_, rest, _ := strings.Cut(string(got), "\n")
+	assert.Equal(t, string(want), rest)
+
+	for _, p := range []string{"fix-greeting.patch", "debian/no-network-tests.patch"} {
+		assert.FileExists(t, filepath.Join(dir, "hello", p))
+	}
+}
+
+func TestUpstreamVersion(t *testing.T) {
+	for version, want := range map[string]string{
+		"2.10-3":            "2.10",
+		"1:2.10-3+deb12u1":  "2.10",
+		"1.2.3":             "1.2.3",
+		"1.2.3+dfsg-1":      "1.2.3",
+		"0.9~ds1-2":         "0.9",
+		"4.1-2-1":           "4.1-2",
+		"3.0.1+really2.9-1": "3.0.1+really2.9",
+	} {
+		assert.Equal(t, want, UpstreamVersion(version), version)
+	}
+}
+
+func TestParseRelations(t *testing.T) {
+	got := ParseRelations("debhelper-compat (= 13), libfoo-dev:native, bar [amd64] | baz, ${misc:Depends},\n python3-sphinx <!nodoc>")
+	assert.Equal(t, []string{"debhelper-compat", "libfoo-dev", "bar", "python3-sphinx"}, got)
+	assert.Equal(t, []string{"openssl-dev", "py3-sphinx", "foo"}, mapPackages([]string{"debhelper-compat", "libssl-dev", "dh-python", "python3-sphinx", "foo"}))
+}
+
+func TestFetchStep(t *testing.T) {
+	entries, err := ParseWatch(strings.NewReader(`version=4
+opts="filenamemangle=s%(?:.*?)?v?(\d[\d.]*)\.tar\.gz%@PACKAGE@-$1.tar.gz%" \
+  https://github.com/example/foo/tags .*/v?(\d\S+)\.tar\.gz
+`), "foo")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	fetch, update := fetchStep(entries)
+	assert.Equal(t, "git-checkout", fetch.Uses)
+	assert.Equal(t, "https://github.com/example/foo", fetch.With["repository"])
+	assert.Equal(t, "v${{package.version}}", fetch.With["tag"])
+	assert.Equal(t, &config.GitHubMonitor{Identifier: "example/foo", StripPrefix: "v", UseTags: true}, update.GitHubMonitor)
+
+	// Patterns with more than the version in them can't be fetched.
+	fetch, update = fetchStep([]WatchEntry{{URL: "https://example.com/releases/", Pattern: `foo-(\d+)\.tar\.(?:gz|xz)`}})
+	assert.True(t, strings.HasPrefix(fetch.With["uri"], "FIXME"))
+	assert.False(t, update.Enabled)
+}
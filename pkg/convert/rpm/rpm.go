@@ -0,0 +1,942 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package rpm converts RPM spec files into melange configurations, as best
+// it can. Values it can't work out are left as FIXME, and what the spec does
+// that it couldn't translate is flagged by TODO comments, for whoever
+// finishes the configuration.
+package rpm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	apkotypes "chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/melange/pkg/config"
+	"chainguard.dev/melange/pkg/manifest"
+	"github.com/chainguard-dev/clog"
+)
+
+// Context holds the settings of the conversion.
+type Context struct {
+	OutDir                 string
+	AdditionalRepositories []string
+	AdditionalKeyrings     []string
+}
+
+// Converted is a melange configuration converted from a spec file, with
+// the patches that it applies.
+type Converted struct {
+	manifest.GeneratedMelangeConfig
+
+	// The file names of the patches, in the order they're applied.
+	Patches []string
+}
+
+// Generate converts the spec file at specPath, and writes its configuration
+// to OutDir, with the patches that are next to the spec in a directory
+// named after the package next to it.
+func (c Context) Generate(ctx context.Context, specPath string) error {
+	log := clog.FromContext(ctx)
+
+	f, err := os.Open(specPath)
+	if err != nil {
+		return fmt.Errorf("opening spec: %w", err)
+	}
+	defer f.Close()
+
+	converted, err := c.Convert(ctx, f)
+	if err != nil {
+		return fmt.Errorf("converting %s: %w", specPath, err)
+	}
+	converted.GeneratedFromComment = specPath
+
+	if err := converted.Write(ctx, c.OutDir); err != nil {
+		return err
+	}
+
+	patchDir := filepath.Join(c.OutDir, converted.Package.Name)
+	copied := 0
+	for _, p := range converted.Patches {
+		b, err := os.ReadFile(filepath.Join(filepath.Dir(specPath), p))
+		if errors.Is(err, fs.ErrNotExist) {
+			log.Warnf("patch %s isn't next to the spec, copy it to %s", p, patchDir)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("reading patch: %w", err)
+		}
+		if err := os.MkdirAll(patchDir, 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(patchDir, p), b, 0o644); err != nil {
+			return fmt.Errorf("writing patch: %w", err)
+		}
+		copied++
+	}
+	if copied != 0 {
+		log.Infof("Copied %d patches to %s, build with --source-dir %s", copied, patchDir, patchDir)
+	}
+
+	return nil
+}
+
+// macros are the macros of the distribution that specs use, defined as
+// melange builds packages.
+var macros = map[string]string{
+	"buildroot":        "${{targets.contextdir}}",
+	"_arch":            "${{build.arch}}",
+	"_prefix":          "/usr",
+	"_exec_prefix":     "/usr",
+	"_bindir":          "/usr/bin",
+	"_sbindir":         "/usr/sbin",
+	"_libdir":          "/usr/lib",
+	"_libexecdir":      "/usr/libexec",
+	"_includedir":      "/usr/include",
+	"_datadir":         "/usr/share",
+	"_datarootdir":     "/usr/share",
+	"_mandir":          "/usr/share/man",
+	"_infodir":         "/usr/share/info",
+	"_docdir":          "/usr/share/doc",
+	"_defaultdocdir":   "/usr/share/doc",
+	"_pkgdocdir":       "/usr/share/doc/%{name}",
+	"_licensedir":      "/usr/share/licenses",
+	"_sysconfdir":      "/etc",
+	"_localstatedir":   "/var",
+	"_sharedstatedir":  "/var/lib",
+	"_rundir":          "/run",
+	"_unitdir":         "/usr/lib/systemd/system",
+	"_userunitdir":     "/usr/lib/systemd/user",
+	"_tmpfilesdir":     "/usr/lib/tmpfiles.d",
+	"_sysusersdir":     "/usr/lib/sysusers.d",
+	"_smp_mflags":      "-j$(nproc)",
+	"_smp_build_ncpus": "$(nproc)",
+	"__make":           "make",
+	"__python3":        "python3",
+	"python3":          "python3",
+}
+
+// converter converts a spec, and keeps the TODO comments for what it
+// couldn't translate.
+type converter struct {
+	spec      *Spec
+	converted *Converted
+	cfg       *config.Configuration
+	comments  map[string][]string
+
+	// names maps the names of the packages of the spec to their names in
+	// the configuration.
+	names map[string]string
+
+	// The lines of the runs step being built, and the TODO comments for
+	// the next step.
+	runs    []string
+	pending []string
+	fetched bool
+}
+
+// Convert converts the spec file from r.
+func (c Context) Convert(ctx context.Context, r io.Reader) (*Converted, error) {
+	log := clog.FromContext(ctx)
+
+	spec, err := ParseSpec(r, macros)
+	if err != nil {
+		return nil, fmt.Errorf("parsing spec: %w", err)
+	}
+	main := spec.Packages[0]
+
+	cv := &converter{
+		spec:      spec,
+		converted: &Converted{},
+		comments:  map[string][]string{},
+		names:     map[string]string{main.Name: "${{package.name}}"},
+	}
+	cv.cfg = &cv.converted.Configuration
+	for _, pkg := range spec.Packages[1:] {
+		cv.names[pkg.Name] = subpackageName(main.Name, pkg.Name)
+	}
+
+	cfg := cv.cfg
+	cfg.Package = config.Package{
+		Name:        main.Name,
+		Version:     spec.Expand(main.Tags["version"]),
+		Epoch:       0,
+		Description: spec.Expand(main.Tags["summary"]),
+		URL:         spec.Expand(main.Tags["url"]),
+		Copyright:   []config.Copyright{{License: "FIXME"}},
+		Dependencies: config.Dependencies{
+			Runtime: cv.packages("package", main.Requires),
+		},
+		Scriptlets: cv.scriptlets("package", main),
+	}
+	if license := spec.Expand(main.Tags["license"]); license != "" {
+		cfg.Package.Copyright[0].License = spdxExpression(license)
+	}
+	for _, u := range spec.Unsupported {
+		cv.comment("package", "translate %s", u)
+	}
+
+	cfg.Environment = c.environment(cv.packages("environment", spec.BuildRequires))
+
+	// The source is fetched where %prep unpacks it, or first if it doesn't.
+	if !slices.ContainsFunc(spec.Prep, func(line string) bool {
+		f := strings.Fields(line)
+		return len(f) > 0 && (macroName(f[0]) == "setup" || macroName(f[0]) == "autosetup")
+	}) {
+		cv.fetch(nil, nil)
+	}
+	cv.section(spec.Prep)
+	cv.section(spec.Build)
+	cv.section(spec.Install)
+	cv.flush()
+	if spec.Expand(main.Tags["buildarch"]) != "noarch" {
+		cv.add(config.Pipeline{Uses: "strip"})
+	}
+	cv.section(spec.Check)
+	cv.flush()
+	if len(cv.pending) != 0 {
+		cv.comments["pipeline"] = append(cv.comments["pipeline"], cv.pending...)
+		cv.pending = nil
+	}
+
+	for i, pkg := range spec.Packages[1:] {
+		cfg.Subpackages = append(cfg.Subpackages, cv.subpackage(fmt.Sprintf("subpackages.%d", i), pkg))
+	}
+
+	log.Infof("converted %s, with %d TODO comments", main.Name, countComments(cv.comments))
+	cv.converted.Comments = cv.comments
+	return cv.converted, nil
+}
+
+// countComments returns the number of comments.
+func countComments(comments map[string][]string) int {
+	n := 0
+	for _, c := range comments {
+		n += len(c)
+	}
+	return n
+}
+
+// comment adds a TODO comment above the node at the path p of the
+// configuration.
+func (cv *converter) comment(p, format string, args ...any) {
+	cv.comments[p] = append(cv.comments[p], "TODO: "+fmt.Sprintf(format, args...))
+}
+
+// todo adds a TODO comment above the next step of the pipeline.
+func (cv *converter) todo(format string, args ...any) {
+	cv.pending = append(cv.pending, "TODO: "+fmt.Sprintf(format, args...))
+}
+
+// add adds the step to the pipeline, after the runs step being built.
+func (cv *converter) add(step config.Pipeline) {
+	cv.flush()
+	cv.addStep(step)
+}
+
+// addStep adds the step to the pipeline, with the pending TODO comments.
+func (cv *converter) addStep(step config.Pipeline) {
+	p := fmt.Sprintf("pipeline.%d", len(cv.cfg.Pipeline))
+	cv.cfg.Pipeline = append(cv.cfg.Pipeline, step)
+	if len(cv.pending) != 0 {
+		cv.comments[p] = append(cv.comments[p], cv.pending...)
+		cv.pending = nil
+	}
+}
+
+// flush adds the runs step being built to the pipeline, if it runs
+// anything.
+func (cv *converter) flush() {
+	script := strings.Trim(strings.Join(cv.runs, "\n"), "\n")
+	cv.runs = nil
+	if strings.TrimSpace(script) == "" {
+		return
+	}
+	cv.addStep(config.Pipeline{Runs: script + "\n"})
+}
+
+// environment returns the build environment, with the packages that the
+// spec build-requires.
+func (c Context) environment(deps []string) apkotypes.ImageConfiguration {
+	env := apkotypes.ImageConfiguration{
+		Contents: apkotypes.ImageContents{
+			BuildRepositories: c.AdditionalRepositories,
+			Keyring:           c.AdditionalKeyrings,
+			Packages: []string{
+				"build-base",
+				"busybox",
+				"ca-certificates-bundle",
+			},
+		},
+	}
+	for _, p := range deps {
+		if !slices.Contains(env.Contents.Packages, p) {
+			env.Contents.Packages = append(env.Contents.Packages, p)
+		}
+	}
+	return env
+}
+
+// spdxLicenses maps the license names that Fedora used before it moved to
+// SPDX expressions to their SPDX identifiers.
+var spdxLicenses = map[string]string{
+	"AGPLv3":             "AGPL-3.0-only",
+	"AGPLv3+":            "AGPL-3.0-or-later",
+	"ASL 2.0":            "Apache-2.0",
+	"Boost":              "BSL-1.0",
+	"GPLv2":              "GPL-2.0-only",
+	"GPLv2+":             "GPL-2.0-or-later",
+	"GPLv3":              "GPL-3.0-only",
+	"GPLv3+":             "GPL-3.0-or-later",
+	"LGPLv2":             "LGPL-2.0-only",
+	"LGPLv2+":            "LGPL-2.0-or-later",
+	"LGPLv3":             "LGPL-3.0-only",
+	"LGPLv3+":            "LGPL-3.0-or-later",
+	"MPLv1.1":            "MPL-1.1",
+	"MPLv2.0":            "MPL-2.0",
+	"Public Domain":      "LicenseRef-public-domain",
+	"Python":             "Python-2.0",
+	"zlib":               "Zlib",
+	"OpenSSL":            "OpenSSL",
+	"ISC":                "ISC",
+	"MIT":                "MIT",
+	"Artistic 2.0":       "Artistic-2.0",
+	"Artistic clarified": "ClArtistic",
+}
+
+// spdxExpression converts the License of a spec, such as "GPLv2+ or
+// Artistic 2.0", into an SPDX expression. Licenses that are already SPDX
+// identifiers are kept as they are.
+func spdxExpression(license string) string {
+	var out []string
+	for _, f := range strings.Fields(license) {
+		switch lower := strings.ToLower(f); lower {
+		case "or", "and", "with":
+			out = append(out, strings.ToUpper(lower))
+			continue
+		}
+		// Names with spaces in them, such as "ASL 2.0", come in two fields.
+		if n := len(out); n > 0 {
+			if id, ok := spdxLicenses[out[n-1]+" "+f]; ok {
+				out[n-1] = id
+				continue
+			}
+		}
+		if id, ok := spdxLicenses[f]; ok {
+			f = id
+		}
+		out = append(out, f)
+	}
+	return strings.Join(out, " ")
+}
+
+// droppedPackages are build dependencies that only building RPMs needs, or
+// that build-base provides.
+var droppedPackages = []string{
+	"gcc", "gcc-c++", "make", "rpm-build", "redhat-rpm-config", "systemd-rpm-macros",
+	"python3-rpm-macros", "pyproject-rpm-macros", "annobin", "glibc-devel",
+}
+
+// packageNames maps the names of RPM packages to the names of the packages
+// that provide the same.
+var packageNames = map[string]string{
+	"cargo":                "rust",
+	"glib2-devel":          "glib-dev",
+	"golang":               "go",
+	"gtk3-devel":           "gtk-3-dev",
+	"libcurl-devel":        "curl-dev",
+	"libzstd-devel":        "zstd-dev",
+	"ninja-build":          "ninja",
+	"pkgconfig":            "pkgconf",
+	"pkgconf-pkg-config":   "pkgconf",
+	"python3":              "python3",
+	"python3-devel":        "python3-dev",
+	"rust":                 "rust",
+	"zlib-ng-compat-devel": "zlib-dev",
+}
+
+var virtualRegex = regexp.MustCompile(`^([a-z0-9]+)\((.+)\)$`)
+
+// mapPackage maps the name of an RPM package, or of a capability, such as
+// pkgconfig(zlib), to the name of the package, or of the virtual package,
+// that provides the same. It returns false for the ones it can't map, and
+// an empty name for the ones that only building RPMs needs.
+func mapPackage(name string) (string, bool) {
+	if p, ok := packageNames[name]; ok {
+		return p, true
+	}
+	switch {
+	case name == "", slices.Contains(droppedPackages, name):
+		return "", true
+	case strings.HasPrefix(name, "(") || strings.Contains(name, "%"):
+		return "", false
+	case strings.HasPrefix(name, "/usr/bin/") || strings.HasPrefix(name, "/bin/"):
+		return "cmd:" + path.Base(name), true
+	case strings.HasPrefix(name, "/"):
+		return "", false
+	}
+	if m := virtualRegex.FindStringSubmatch(name); m != nil {
+		switch m[1] {
+		case "pkgconfig":
+			return "pc:" + m[2], true
+		case "python3dist":
+			return "py3-" + strings.ToLower(m[2]), true
+		case "perl":
+			return "perl-" + strings.ToLower(strings.ReplaceAll(m[2], "::", "-")), true
+		}
+		return "", false
+	}
+	if rest, ok := strings.CutSuffix(name, "-devel"); ok {
+		name = rest + "-dev"
+	}
+	if rest, ok := strings.CutPrefix(name, "python3-"); ok {
+		name = "py3-" + rest
+	}
+	return name, true
+}
+
+// packages maps the dependencies deps, adding TODO comments above the node
+// at the path p for the ones it can't.
+func (cv *converter) packages(p string, deps []string) []string {
+	var out []string
+	for _, dep := range deps {
+		name := cv.spec.Expand(dep)
+		mapped, ok := cv.names[name]
+		if !ok {
+			mapped, ok = mapPackage(name)
+		}
+		if !ok {
+			cv.comment(p, "translate the dependency %s", dep)
+			continue
+		}
+		if mapped != "" && !slices.Contains(out, mapped) {
+			out = append(out, mapped)
+		}
+	}
+	return out
+}
+
+// macroName returns the name of the macro that a word of a section starts
+// with, such as "configure" for %configure or %{configure}, if it does.
+func macroName(word string) string {
+	if !strings.HasPrefix(word, "%") || strings.HasPrefix(word, "%%") {
+		return ""
+	}
+	return strings.Trim(word, "%{}")
+}
+
+var (
+	buildRootRegex  = regexp.MustCompile(`\$\{?RPM_BUILD_ROOT\}?`)
+	unexpandedRegex = regexp.MustCompile(`%\{[^}]*\}|%\([^)]*\)|(^|\s)%[A-Za-z_][A-Za-z0-9_]+`)
+	patchRegex      = regexp.MustCompile(`^patch(\d*)$`)
+)
+
+// section converts the lines of a section that builds the package into the
+// steps of the pipeline. The macros that pipelines do the same as are
+// replaced by them, and the rest of the lines run as they are, with the
+// macros they use expanded.
+func (cv *converter) section(lines []string) {
+	for _, line := range joinContinuations(lines) {
+		fields := strings.Fields(strings.ReplaceAll(line, "\\\n", " "))
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			cv.runs = append(cv.runs, line)
+			continue
+		}
+		if cv.convertLine(line, fields) {
+			continue
+		}
+		cv.runLine(line)
+	}
+}
+
+// joinContinuations joins the lines that end with a backslash with the
+// lines that follow them, so that the commands they continue are one.
+func joinContinuations(lines []string) []string {
+	var out []string
+	continued := false
+	for _, line := range lines {
+		if continued {
+			out[len(out)-1] += "\n" + line
+		} else {
+			out = append(out, line)
+		}
+		continued = strings.HasSuffix(line, "\\")
+	}
+	return out
+}
+
+// runLine adds line to the runs step being built, with its macros
+// expanded.
+func (cv *converter) runLine(line string) {
+	expanded := buildRootRegex.ReplaceAllLiteralString(cv.spec.Expand(line), "${{targets.contextdir}}")
+	var unexpanded []string
+	for _, m := range unexpandedRegex.FindAllString(expanded, -1) {
+		if m = strings.TrimSpace(m); !slices.Contains(unexpanded, m) {
+			unexpanded = append(unexpanded, m)
+		}
+	}
+	if len(unexpanded) != 0 {
+		cv.todo("expand %s", strings.Join(unexpanded, ", "))
+	}
+	cv.runs = append(cv.runs, expanded)
+}
+
+// convertLine converts the line, with the fields, into a step of the
+// pipeline, if a pipeline does the same as it, and returns whether it did.
+// Conditionals are left out, so that both of their branches are kept, and
+// what only building RPMs needs is dropped.
+func (cv *converter) convertLine(line string, fields []string) bool {
+	opts := cv.spec.Expand(strings.Join(fields[1:], " "))
+	with := func(opts string) map[string]string {
+		if opts == "" {
+			return nil
+		}
+		return map[string]string{"opts": opts}
+	}
+
+	macro := macroName(fields[0])
+	switch macro {
+	case "if", "ifarch", "ifnarch", "ifos", "ifnos", "elif", "elifarch", "elifos":
+		cv.todo("translate the conditional %s, whose branches are all kept", strings.TrimSpace(line))
+		return true
+	case "else", "endif":
+		return true
+
+	case "setup", "autosetup":
+		cv.fetch(fields[0:1], fields[1:])
+		return true
+	case "autopatch":
+		cv.applyPatches(cv.spec.Patches, fields[1:])
+		return true
+
+	case "configure":
+		cv.add(config.Pipeline{Uses: "autoconf/configure", With: with(opts)})
+		return true
+	case "make_build":
+		cv.add(config.Pipeline{Uses: "autoconf/make", With: with(opts)})
+		return true
+	case "make_install", "makeinstall":
+		cv.add(config.Pipeline{Uses: "autoconf/make-install"})
+		return true
+	case "cmake":
+		cv.add(config.Pipeline{Uses: "cmake/configure", With: with(opts)})
+		return true
+	case "cmake_build":
+		cv.add(config.Pipeline{Uses: "cmake/build"})
+		return true
+	case "cmake_install":
+		cv.add(config.Pipeline{Uses: "cmake/install"})
+		return true
+	case "meson":
+		cv.add(config.Pipeline{Uses: "meson/configure", With: with(opts)})
+		return true
+	case "meson_build":
+		cv.add(config.Pipeline{Uses: "meson/compile"})
+		return true
+	case "meson_install":
+		cv.add(config.Pipeline{Uses: "meson/install"})
+		return true
+	case "py3_build", "pyproject_wheel":
+		cv.add(config.Pipeline{Uses: "python/build@v2"})
+		return true
+	case "py3_install", "pyproject_install", "pyproject_save_files", "find_lang":
+		// python/build@v2 installs what it builds, and the files that
+		// %find_lang lists stay in the main package.
+		return true
+	}
+
+	if m := patchRegex.FindStringSubmatch(macro); m != nil {
+		args := fields[1:]
+		if m[1] != "" {
+			args = append([]string{"-P", m[1]}, args...)
+		}
+		cv.patch(args)
+		return true
+	}
+
+	expanded := strings.Fields(cv.spec.Expand(strings.Join(fields, " ")))
+	switch {
+	case len(expanded) >= 1 && expanded[0] == "make" && !slices.ContainsFunc(expanded[1:], func(arg string) bool {
+		return arg != "-j$(nproc)" && arg != "V=1"
+	}):
+		cv.add(config.Pipeline{Uses: "autoconf/make"})
+		return true
+	case len(expanded) >= 3 && expanded[0] == "make" && expanded[1] == "install" &&
+		slices.ContainsFunc(expanded[2:], func(arg string) bool {
+			return buildRootRegex.ReplaceAllLiteralString(arg, "${{targets.contextdir}}") == "DESTDIR=${{targets.contextdir}}"
+		}):
+		cv.add(config.Pipeline{Uses: "autoconf/make-install"})
+		return true
+	case len(expanded) == 3 && expanded[0] == "rm" && expanded[1] == "-rf" &&
+		buildRootRegex.ReplaceAllLiteralString(expanded[2], "${{targets.contextdir}}") == "${{targets.contextdir}}":
+		// The package is installed into an empty directory already.
+		return true
+	}
+	return false
+}
+
+// fetch adds the step that fetches Source0, where %setup or %autosetup,
+// with the arguments args, unpack it. Patches are applied as %autosetup
+// applies them.
+func (cv *converter) fetch(setup, args []string) {
+	if cv.fetched {
+		cv.todo("translate %s, which unpacks a source again", strings.Join(append(setup, args...), " "))
+		return
+	}
+	cv.fetched = true
+	autosetup := len(setup) > 0 && macroName(setup[0]) == "autosetup"
+
+	var unsupported []string
+	strip, patches := "", autosetup
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "-q":
+		case arg == "-n":
+			// fetch strips the directory that the source unpacks into.
+			i++
+		case arg == "-N" && autosetup:
+			patches = false
+		case strings.HasPrefix(arg, "-p") && autosetup:
+			strip = strings.TrimPrefix(arg, "-p")
+			if strip == "" && i+1 < len(args) {
+				i++
+				strip = args[i]
+			}
+		case arg == "-S" && autosetup:
+			// The patches are applied with patch, whatever the VCS.
+			i++
+		default:
+			unsupported = append(unsupported, arg)
+		}
+	}
+	if len(unsupported) != 0 {
+		cv.todo("translate the options %s of %s", strings.Join(unsupported, " "), setup[0])
+	}
+
+	step, update := cv.fetchStep()
+	cv.add(step)
+	cv.cfg.Update = update
+
+	if patches {
+		var opts []string
+		if strip != "" {
+			opts = []string{"-p" + strip}
+		}
+		cv.applyPatches(cv.spec.Patches, opts)
+	}
+}
+
+// fetchStep returns the step that fetches Source0, and the configuration to
+// update the package by. Sources on GitHub are updated by their release
+// tags.
+func (cv *converter) fetchStep() (config.Pipeline, config.Update) {
+	fixme := config.Pipeline{
+		Uses: "fetch",
+		With: map[string]string{
+			"uri":             "FIXME",
+			"expected-sha256": "FIXME",
+		},
+	}
+	noUpdate := config.Update{
+		Enabled:       false,
+		ExcludeReason: "FIXME: find the release-monitoring.org identifier of the package",
+	}
+
+	var source string
+	for _, s := range cv.spec.Sources {
+		if s.N == 0 {
+			source = cv.spec.Expand(s.Value)
+		} else {
+			cv.todo("fetch Source%d, %s", s.N, cv.spec.Expand(s.Value))
+		}
+	}
+	if source == "" {
+		cv.todo("find the URL of the source, which the spec has no Source0 for")
+		return fixme, noUpdate
+	}
+
+	// Such as https://github.com/o/r/archive/v1.0.tar.gz#/r-1.0.tar.gz, to
+	// name the file.
+	source, _, _ = strings.Cut(source, "#")
+	if version := cv.cfg.Package.Version; version != "" && !strings.Contains(version, "%") {
+		source = strings.ReplaceAll(source, version, "${{package.version}}")
+	}
+	u, err := url.Parse(source)
+	if err != nil || u.Host == "" {
+		cv.todo("find the URL of Source0, %s", source)
+		return fixme, noUpdate
+	}
+
+	step := config.Pipeline{
+		Uses: "fetch",
+		With: map[string]string{
+			"uri":             source,
+			"expected-sha256": "FIXME",
+		},
+	}
+	if parts := strings.Split(strings.Trim(u.Path, "/"), "/"); u.Host == "github.com" && len(parts) >= 2 {
+		prefix := ""
+		if strings.Contains(source, "/v${{package.version}}") {
+			prefix = "v"
+		}
+		return step, config.Update{
+			Enabled: true,
+			GitHubMonitor: &config.GitHubMonitor{
+				Identifier:  path.Join(parts[0], parts[1]),
+				StripPrefix: prefix,
+				UseTags:     true,
+			},
+		}
+	}
+	return step, noUpdate
+}
+
+// patch adds the step that applies the patch that %patch, with the
+// arguments args, applies.
+func (cv *converter) patch(args []string) {
+	nr, opts := 0, []string{}
+	var unsupported []string
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "-P" && i+1 < len(args):
+			i++
+			nr, _ = strconv.Atoi(args[i])
+		case strings.HasPrefix(arg, "-P"):
+			nr, _ = strconv.Atoi(strings.TrimPrefix(arg, "-P"))
+		case strings.HasPrefix(arg, "-p"):
+			if arg == "-p" && i+1 < len(args) {
+				i++
+				arg += args[i]
+			}
+			opts = append(opts, arg)
+		case arg == "-b" || arg == "-z":
+			// Backups of the patched files aren't needed.
+			i++
+		case arg == "-E":
+		default:
+			if n, err := strconv.Atoi(arg); err == nil {
+				nr = n
+				continue
+			}
+			unsupported = append(unsupported, arg)
+		}
+	}
+	if len(unsupported) != 0 {
+		cv.todo("translate the options %s of %%patch", strings.Join(unsupported, " "))
+	}
+
+	i := slices.IndexFunc(cv.spec.Patches, func(p Numbered) bool { return p.N == nr })
+	if i < 0 {
+		cv.todo("apply Patch%d, which the spec doesn't have", nr)
+		return
+	}
+	cv.applyPatches(cv.spec.Patches[i:i+1], opts)
+}
+
+// applyPatches adds the step that applies the patches, with the options
+// opts of %autopatch or %patch. Patches applied one after another the same
+// way are applied by the same step.
+func (cv *converter) applyPatches(patches []Numbered, opts []string) {
+	if len(patches) == 0 {
+		return
+	}
+	strip := "1"
+	for _, o := range opts {
+		if s, ok := strings.CutPrefix(o, "-p"); ok {
+			strip = s
+		}
+	}
+
+	var names []string
+	for _, p := range patches {
+		value := cv.spec.Expand(p.Value)
+		if strings.Contains(value, "://") {
+			cv.todo("download Patch%d, %s, next to the configuration", p.N, value)
+		}
+		name := path.Base(value)
+		names = append(names, name)
+		if !slices.Contains(cv.converted.Patches, name) {
+			cv.converted.Patches = append(cv.converted.Patches, name)
+		}
+	}
+
+	// Add to the last step if it applies patches the same way.
+	if n := len(cv.cfg.Pipeline); len(cv.runs) == 0 && len(cv.pending) == 0 && n > 0 {
+		last := &cv.cfg.Pipeline[n-1]
+		if last.Uses == "patch" && stripComponents(last.With["strip-components"]) == strip {
+			last.With["patches"] += " " + strings.Join(names, " ")
+			return
+		}
+	}
+
+	with := map[string]string{"patches": strings.Join(names, " ")}
+	if strip != "1" {
+		with["strip-components"] = strip
+	}
+	cv.add(config.Pipeline{Uses: "patch", With: with})
+}
+
+// stripComponents returns the strip-components of a patch step, which is 1
+// when it isn't set.
+func stripComponents(s string) string {
+	if s == "" {
+		return "1"
+	}
+	return s
+}
+
+// subpackageName returns the name of the subpackage of the main package
+// named main, with -devel renamed -dev.
+func subpackageName(main, name string) string {
+	if rest, ok := strings.CutSuffix(name, "-devel"); ok {
+		name = rest + "-dev"
+	}
+	if rest, ok := strings.CutPrefix(name, main+"-"); ok {
+		return "${{package.name}}-" + rest
+	}
+	return name
+}
+
+// subpackage returns the subpackage for pkg, at the path p of the
+// configuration. The files that its %files lists are moved into it, and
+// otherwise development files and documentation are split into -dev and
+// -doc packages.
+func (cv *converter) subpackage(p string, pkg *Package) config.Subpackage {
+	sp := config.Subpackage{
+		Name:        cv.names[pkg.Name],
+		Description: cv.spec.Expand(pkg.Tags["summary"]),
+		Dependencies: config.Dependencies{
+			Runtime: cv.packages(p, pkg.Requires),
+		},
+		Contents:   cv.contents(p, pkg),
+		Scriptlets: cv.scriptlets(p, pkg),
+	}
+	for _, f := range pkg.FileLists {
+		cv.comment(p, "move the files that %s lists into the subpackage", cv.spec.Expand(f))
+	}
+	if len(sp.Contents) != 0 || len(pkg.FileLists) != 0 {
+		return sp
+	}
+
+	switch {
+	case strings.HasSuffix(sp.Name, "-dev"):
+		sp.Pipeline = []config.Pipeline{{Uses: "split/dev"}}
+	case strings.HasSuffix(sp.Name, "-doc"):
+		sp.Pipeline = []config.Pipeline{{Uses: "split/doc"}}
+	default:
+		cv.comment(p, "move the files of %s into the subpackage, which has no %%files", pkg.Name)
+	}
+	return sp
+}
+
+var fileDirectiveRegex = regexp.MustCompile(`^%(attr|config|verify|lang|caps)(\([^)]*\))?\s*|^%dir\s+`)
+
+// contents returns the globs of the files that the %files of pkg lists,
+// adding TODO comments above the node at the path p for what it can't
+// translate.
+func (cv *converter) contents(p string, pkg *Package) []string {
+	var contents []string
+	for _, line := range pkg.Files {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "%defattr") {
+			continue
+		}
+		for {
+			stripped := fileDirectiveRegex.ReplaceAllString(line, "")
+			if stripped == line {
+				break
+			}
+			line = stripped
+		}
+
+		fields := strings.Fields(cv.spec.Expand(line))
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "%doc", "%license":
+			// The files of the source aren't installed.
+			var absolute []string
+			for _, f := range fields[1:] {
+				if strings.HasPrefix(f, "/") {
+					absolute = append(absolute, f)
+				} else {
+					cv.comment(p, "install %s, which %s takes from the source", f, fields[0])
+				}
+			}
+			fields = absolute
+		case "%ghost", "%exclude":
+			cv.comment(p, "translate %s", line)
+			continue
+		}
+
+		for _, f := range fields {
+			if strings.Contains(f, "%") || !strings.HasPrefix(f, "/") {
+				cv.comment(p, "translate the file %s", f)
+				continue
+			}
+			contents = append(contents, strings.TrimPrefix(f, "/"))
+		}
+	}
+	return contents
+}
+
+// scriptlets returns the scriptlets of pkg, adding TODO comments above the
+// node at the path p for the ones it can't translate.
+func (cv *converter) scriptlets(p string, pkg *Package) *config.Scriptlets {
+	sc := &config.Scriptlets{}
+	set := false
+	for _, s := range []struct {
+		name   string
+		script *string
+	}{
+		{"pre", &sc.PreInstall},
+		{"post", &sc.PostInstall},
+		{"preun", &sc.PreDeinstall},
+		{"postun", &sc.PostDeinstall},
+	} {
+		scriptlet, ok := pkg.Scriptlets[s.name]
+		if !ok {
+			continue
+		}
+		if scriptlet.Interpreter != "" && scriptlet.Interpreter != "/bin/sh" {
+			cv.comment(p, "translate %%%s, which runs %s", s.name, scriptlet.Interpreter)
+			continue
+		}
+		body := cv.spec.Expand(scriptlet.Body)
+		if strings.TrimSpace(body) == "" {
+			continue
+		}
+		if strings.Contains(body, "$1") {
+			cv.comment(p+".scriptlets", "check the uses of $1 in %%%s, which RPM sets to the number of installed instances of the package, and apk to its version", s.name)
+		}
+		*s.script = "#!/bin/sh\n" + body + "\n"
+		set = true
+	}
+	if !set {
+		return nil
+	}
+	return sc
+}
@@ -0,0 +1,96 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rpm
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/chainguard-dev/clog/slogtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerate(t *testing.T) {
+	dir := t.TempDir()
+	c := Context{
+		OutDir:                 dir,
+		AdditionalRepositories: []string{"https://packages.wolfi.dev/os"},
+		AdditionalKeyrings:     []string{"https://packages.wolfi.dev/os/wolfi-signing.rsa.pub"},
+	}
+	require.NoError(t, c.Generate(slogtest.Context(t), filepath.Join("testdata", "hello.spec")))
+
+	got, err := os.ReadFile(filepath.Join(dir, "hello.yaml"))
+	require.NoError(t, err)
+	want, err := os.ReadFile(filepath.Join("testdata", "hello.yaml"))
+	require.NoError(t, err)
+	// The comment has the path that it was converted from.
+	_, rest, _ := strings.Cut(string(got), "\n")
+	assert.Equal(t, string(want), rest)
+
+	for _, p := range []string{"hello-fix-greeting.patch", "hello-no-network-tests.patch"} {
+		assert.FileExists(t, filepath.Join(dir, "hello", p))
+	}
+}
+
+func TestParseRequires(t *testing.T) {
+	got := ParseRequires("gcc, make >= 4.0 pkgconfig(zlib), (foo or bar) %{name}%{?_isa} = %{version}")
+	assert.Equal(t, []string{"gcc", "make", "pkgconfig(zlib)", "(foo or bar)", "%{name}%{?_isa}"}, got)
+}
+
+func TestExpand(t *testing.T) {
+	spec, err := ParseSpec(strings.NewReader(`%global major 1
+%define minor %{major}.2
+Name: foo
+Version: %{minor}.3
+`), map[string]string{"_bindir": "/usr/bin"})
+	require.NoError(t, err)
+
+	for text, want := range map[string]string{
+		"%{name}-%{version}":      "foo-1.2.3",
+		"%name %_bindir":          "foo /usr/bin",
+		"1%{?dist}":               "1",
+		"%{?major:with major}":    "with major",
+		"%{!?with_docs:no docs}":  "no docs",
+		"%{unknown} and %unknown": "%{unknown} and %unknown",
+		"100%%":                   "100%",
+	} {
+		assert.Equal(t, want, spec.Expand(text), text)
+	}
+}
+
+func TestMapPackage(t *testing.T) {
+	for name, want := range map[string]string{
+		"openssl-devel":       "openssl-dev",
+		"python3-pytest":      "py3-pytest",
+		"pkgconfig(glib-2.0)": "pc:glib-2.0",
+		"perl(File::Temp)":    "perl-file-temp",
+		"/usr/bin/git":        "cmd:git",
+		"gcc-c++":             "",
+	} {
+		got, ok := mapPackage(name)
+		assert.True(t, ok, name)
+		assert.Equal(t, want, got, name)
+	}
+	for _, name := range []string{"(foo or bar)", "cmake(Qt5Core)", "%{py3_dist foo}"} {
+		_, ok := mapPackage(name)
+		assert.False(t, ok, name)
+	}
+
+	assert.Equal(t, "GPL-2.0-or-later OR Apache-2.0", spdxExpression("GPLv2+ or ASL 2.0"))
+	assert.Equal(t, "MIT AND BSD-3-Clause", spdxExpression("MIT AND BSD-3-Clause"))
+}
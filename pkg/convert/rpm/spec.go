@@ -0,0 +1,404 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rpm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Spec is a parsed RPM spec file. The bodies of its sections are kept as
+// they are, with their macros unexpanded.
+type Spec struct {
+	// Macros defined by %global and %define, and by the tags of the main
+	// package, such as %{name} and %{version}.
+	Macros map[string]string
+
+	// Sources and Patches by their numbers, such as 0 for Source0, in the
+	// order they're declared.
+	Sources []Numbered
+	Patches []Numbered
+
+	BuildRequires []string
+
+	// Packages, the main package first.
+	Packages []*Package
+
+	Prep, Build, Install, Check []string
+
+	// Unsupported describes what the spec has that isn't parsed, by the
+	// line it's on.
+	Unsupported []string
+
+	// predefined are the macros that are defined before the spec is read.
+	predefined map[string]string
+}
+
+// Numbered is the value of a numbered tag, such as Source0.
+type Numbered struct {
+	N     int
+	Value string
+}
+
+// Package is the main package or a subpackage of a spec.
+type Package struct {
+	// Name is the full name of the package, such as hello-devel.
+	Name string
+
+	// Tags of the package's preamble that can only appear once, with their
+	// names in lower case, such as summary.
+	Tags map[string]string
+
+	Requires []string
+
+	// Files are the lines of %files, and FileLists the files that
+	// %files -f reads them from as well.
+	Files     []string
+	FileLists []string
+
+	// Scriptlets by the names of their sections, such as post.
+	Scriptlets map[string]Scriptlet
+}
+
+// Scriptlet is a script that runs as the package is installed or removed.
+type Scriptlet struct {
+	// Interpreter is the program that -p runs it with, if any.
+	Interpreter string
+	Body        string
+}
+
+var (
+	tagRegex     = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9]*)(\([^)]*\))?\s*:\s*(.*)$`)
+	sectionRegex = regexp.MustCompile(`^%(package|description|prep|build|install|check|clean|files|changelog|pre|post|preun|postun|pretrans|posttrans|triggerin|triggerun|triggerpostun|filetriggerin|filetriggerun|verifyscript|generate_buildrequires|conf)(\s.*)?$`)
+	defineRegex  = regexp.MustCompile(`^%(global|define)\s+([A-Za-z_][A-Za-z0-9_]*)(\([^)]*\))?\s+(.*)$`)
+)
+
+// ParseSpec parses the spec file from r. macros are defined before it's
+// read, as the macros files of a distribution are.
+func ParseSpec(r io.Reader, macros map[string]string) (*Spec, error) {
+	s := &Spec{Macros: map[string]string{}, predefined: macros}
+	main := &Package{Tags: map[string]string{}, Scriptlets: map[string]Scriptlet{}}
+	s.Packages = []*Package{main}
+
+	// The package whose preamble is being read, and where the lines of the
+	// section being read go.
+	current := main
+	section := "preamble"
+	var body *[]string
+	var scriptlet string
+	var scriptletBody []string
+	endScriptlet := func() {
+		if scriptlet != "" {
+			sc := current.Scriptlets[scriptlet]
+			sc.Body = strings.TrimSpace(strings.Join(scriptletBody, "\n"))
+			current.Scriptlets[scriptlet] = sc
+		}
+		scriptlet, scriptletBody = "", nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), " \t")
+
+		if m := sectionRegex.FindStringSubmatch(line); m != nil {
+			endScriptlet()
+			name, args := m[1], strings.Fields(s.Expand(m[2]))
+			pkg, opts, err := s.sectionPackage(name, args)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			section, body = name, nil
+			switch name {
+			case "package":
+				current = pkg
+				s.Packages = append(s.Packages, pkg)
+				section = "preamble"
+			case "prep":
+				body = &s.Prep
+			case "build":
+				body = &s.Build
+			case "install":
+				body = &s.Install
+			case "check":
+				body = &s.Check
+			case "files":
+				current = pkg
+				body = &pkg.Files
+				if f, ok := opts["f"]; ok {
+					pkg.FileLists = append(pkg.FileLists, f)
+				}
+			case "pre", "post", "preun", "postun":
+				current = pkg
+				scriptlet = name
+				pkg.Scriptlets[name] = Scriptlet{Interpreter: opts["p"]}
+			case "description", "changelog", "clean":
+			default:
+				s.Unsupported = append(s.Unsupported, fmt.Sprintf("line %d: %%%s", n, name))
+			}
+			continue
+		}
+
+		switch {
+		case scriptlet != "":
+			scriptletBody = append(scriptletBody, line)
+		case body != nil:
+			*body = append(*body, line)
+		case section == "preamble":
+			if err := s.parsePreamble(current, line, n); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	endScriptlet()
+
+	if main.Name == "" {
+		return nil, fmt.Errorf("spec has no Name")
+	}
+	return s, nil
+}
+
+// sectionPackage returns the package that the section with the arguments
+// args is for, and the values of its options. For a %package section, the
+// package is a new one.
+func (s *Spec) sectionPackage(section string, args []string) (*Package, map[string]string, error) {
+	opts := map[string]string{}
+	var name string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") || len(arg) < 2 {
+			if name == "" {
+				name = s.Packages[0].Name + "-" + arg
+			}
+			continue
+		}
+		opt := arg[1:2]
+		value := arg[2:]
+		if value == "" && i+1 < len(args) {
+			i++
+			value = args[i]
+		}
+		if opt == "n" {
+			name = value
+			continue
+		}
+		opts[opt] = value
+	}
+
+	if section == "package" {
+		if name == "" {
+			return nil, nil, fmt.Errorf("%%package without a name")
+		}
+		return &Package{Name: name, Tags: map[string]string{}, Scriptlets: map[string]Scriptlet{}}, opts, nil
+	}
+	if name == "" {
+		return s.Packages[0], opts, nil
+	}
+	for _, p := range s.Packages {
+		if p.Name == name {
+			return p, opts, nil
+		}
+	}
+	if section == "changelog" || section == "clean" {
+		return s.Packages[0], opts, nil
+	}
+	return nil, nil, fmt.Errorf("%%%s of %s, which has no %%package", section, name)
+}
+
+// parsePreamble parses the line n of the preamble of pkg.
+func (s *Spec) parsePreamble(pkg *Package, line string, n int) error {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+		return nil
+	}
+	if m := defineRegex.FindStringSubmatch(trimmed); m != nil {
+		if m[3] != "" {
+			s.Unsupported = append(s.Unsupported, fmt.Sprintf("line %d: the parametric macro %%%s", n, m[2]))
+			return nil
+		}
+		s.Macros[m[2]] = m[4]
+		return nil
+	}
+	if strings.HasPrefix(trimmed, "%") {
+		if strings.TrimSpace(s.Expand(trimmed)) == "" {
+			// Such as %{?systemd_requires}.
+			return nil
+		}
+		s.Unsupported = append(s.Unsupported, fmt.Sprintf("line %d: %s", n, trimmed))
+		return nil
+	}
+
+	m := tagRegex.FindStringSubmatch(trimmed)
+	if m == nil {
+		return fmt.Errorf("line %d: expected a tag, got %q", n, trimmed)
+	}
+	tag, qualifier, value := strings.ToLower(m[1]), m[2], m[3]
+	if qualifier != "" {
+		// Such as Requires(post), for what the scriptlets need.
+		return nil
+	}
+
+	switch {
+	case tag == "buildrequires":
+		s.BuildRequires = append(s.BuildRequires, ParseRequires(value)...)
+	case tag == "requires":
+		pkg.Requires = append(pkg.Requires, ParseRequires(value)...)
+	case strings.HasPrefix(tag, "source"):
+		nr, err := tagNumber(tag, "source", len(s.Sources))
+		if err != nil {
+			return fmt.Errorf("line %d: %w", n, err)
+		}
+		s.Sources = append(s.Sources, Numbered{N: nr, Value: value})
+	case strings.HasPrefix(tag, "patch"):
+		nr, err := tagNumber(tag, "patch", len(s.Patches))
+		if err != nil {
+			return fmt.Errorf("line %d: %w", n, err)
+		}
+		s.Patches = append(s.Patches, Numbered{N: nr, Value: value})
+	default:
+		pkg.Tags[tag] = value
+		if pkg == s.Packages[0] {
+			switch tag {
+			case "name", "version", "release", "epoch", "summary", "url", "license":
+				s.Macros[tag] = value
+			}
+		}
+		if tag == "name" && pkg == s.Packages[0] {
+			pkg.Name = s.Expand(value)
+		}
+	}
+	return nil
+}
+
+// tagNumber returns the number of a numbered tag, such as source1, with
+// next for a tag without one.
+func tagNumber(tag, prefix string, next int) (int, error) {
+	nr := strings.TrimPrefix(tag, prefix)
+	if nr == "" {
+		return next, nil
+	}
+	i, err := strconv.Atoi(nr)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected tag %q", tag)
+	}
+	return i, nil
+}
+
+// ParseRequires returns the names in a dependency field, such as
+// BuildRequires, without their versions. Rich dependencies, such as
+// (foo or bar), are returned whole.
+func ParseRequires(value string) []string {
+	var names []string
+	skip := false
+	for len(value) > 0 {
+		value = strings.TrimLeft(value, " \t,")
+		if value == "" {
+			break
+		}
+		var token string
+		if value[0] == '(' {
+			depth, end := 0, len(value)
+			for i, r := range value {
+				if r == '(' {
+					depth++
+				} else if r == ')' {
+					depth--
+					if depth == 0 {
+						end = i + 1
+						break
+					}
+				}
+			}
+			token, value = value[:end], value[end:]
+		} else {
+			end := strings.IndexAny(value, " \t,")
+			if end < 0 {
+				end = len(value)
+			}
+			token, value = value[:end], value[end:]
+		}
+
+		switch {
+		case skip:
+			skip = false
+		case strings.Trim(token, "<>=") == "":
+			// The version follows the operator.
+			skip = true
+		default:
+			names = append(names, token)
+		}
+	}
+	return names
+}
+
+var macroRegex = regexp.MustCompile(`%%|%\{(!?\??)([A-Za-z_][A-Za-z0-9_]*)(?::([^{}]*))?\}|%([A-Za-z_][A-Za-z0-9_]*)`)
+
+// Expand expands the macros in text that the spec, or the macros it was
+// parsed with, define. Those that aren't defined are left as they are,
+// except for conditional ones, such as %{?dist}.
+func (s *Spec) Expand(text string) string {
+	for range 10 {
+		expanded := macroRegex.ReplaceAllStringFunc(text, func(m string) string {
+			if m == "%%" {
+				return m
+			}
+			sub := macroRegex.FindStringSubmatch(m)
+			flags, name, alt := sub[1], sub[2], sub[3]
+			if sub[4] != "" {
+				name = sub[4]
+			}
+			value, defined := s.lookup(name)
+			switch flags {
+			case "?":
+				if !defined {
+					return ""
+				}
+				if strings.Contains(m, ":") {
+					return alt
+				}
+				return value
+			case "!?":
+				if defined {
+					return ""
+				}
+				return alt
+			}
+			if !defined {
+				return m
+			}
+			return value
+		})
+		if expanded == text {
+			break
+		}
+		text = expanded
+	}
+	return strings.ReplaceAll(text, "%%", "%")
+}
+
+// lookup returns the value of the macro name.
+func (s *Spec) lookup(name string) (string, bool) {
+	if v, ok := s.Macros[name]; ok {
+		return v, true
+	}
+	v, ok := s.predefined[name]
+	return v, ok
+}
@@ -0,0 +1,83 @@
+%global _hardened_build 1
+
+Name:           hello
+Version:        2.12.1
+Release:        3%{?dist}
+Summary:        Prints a familiar, friendly greeting
+License:        GPLv3+
+URL:            https://www.gnu.org/software/hello/
+Source0:        https://ftp.gnu.org/gnu/%{name}/%{name}-%{version}.tar.gz
+Source1:        hello.sysusers
+Patch0:         hello-fix-greeting.patch
+Patch1:         hello-no-network-tests.patch
+
+BuildRequires:  gcc, make
+BuildRequires:  gettext-devel
+BuildRequires:  pkgconfig(zlib) >= 1.2
+BuildRequires:  perl(Getopt::Long)
+BuildRequires:  (python3-sphinx or python3-docutils)
+Requires:       info
+Requires(post): coreutils
+
+%description
+The GNU Hello program produces a familiar, friendly greeting.
+
+%package        devel
+Summary:        Development files for %{name}
+Requires:       %{name}%{?_isa} = %{version}-%{release}
+Requires:       libhello = %{version}-%{release}
+
+%description    devel
+The header files to build programs that use libhello.
+
+%package -n libhello
+Summary:        The library that greets
+
+%description -n libhello
+The library that prints the greeting.
+
+%prep
+%setup -q
+%patch0 -p1
+%patch -P1 -p1
+
+%build
+%configure --disable-silent-rules \
+    --with-zlib
+%make_build
+
+%install
+rm -rf %{buildroot}
+%make_install
+rm -f %{buildroot}%{_infodir}/dir
+%if %{with sysusers}
+install -Dm644 %{SOURCE1} $RPM_BUILD_ROOT%{_sysusersdir}/hello.conf
+%endif
+%find_lang %{name}
+
+%check
+make check
+
+%post
+if [ $1 -eq 1 ]; then
+  echo "Say hello with %{_bindir}/hello"
+fi
+
+%files -f %{name}.lang
+%license COPYING
+%doc NEWS README
+%{_bindir}/hello
+%{_mandir}/man1/hello.1*
+%{_infodir}/hello.info*
+
+%files devel
+%{_includedir}/hello.h
+%{_libdir}/libhello.so
+
+%files -n libhello
+%attr(0755,root,root) %{_libdir}/libhello.so.*
+%ghost %{_localstatedir}/lib/hello
+
+%changelog
+* Mon Jan 01 2024 Jane Doe <jane@example.com> - 2.12.1-3
+- Rebuild
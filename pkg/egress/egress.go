@@ -0,0 +1,301 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package egress limits the hosts that a build can connect to, with a proxy
+// that only forwards connections to the hosts that a policy allows, and
+// reports every host that the build tried to connect to.
+package egress
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chainguard-dev/clog"
+)
+
+// Policy is a list of the hosts that a build is allowed to connect to.
+type Policy struct {
+	domains []string
+	nets    []*net.IPNet
+}
+
+// NewPolicy returns a policy that allows the hosts in allow, each of which
+// is a domain name, such as github.com, a wildcard for the subdomains of a
+// domain, such as *.githubusercontent.com, an IP address, or a CIDR block.
+func NewPolicy(allow []string) (*Policy, error) {
+	p := &Policy{}
+	for _, a := range allow {
+		switch {
+		case strings.Contains(a, "/"):
+			_, n, err := net.ParseCIDR(a)
+			if err != nil {
+				return nil, fmt.Errorf("invalid egress allowlist entry %q: %w", a, err)
+			}
+			p.nets = append(p.nets, n)
+		case net.ParseIP(a) != nil:
+			ip := net.ParseIP(a)
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			p.nets = append(p.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		default:
+			d := strings.ToLower(strings.TrimSuffix(a, "."))
+			if strings.Contains(strings.TrimPrefix(d, "*."), "*") || d == "" || d == "*." {
+				return nil, fmt.Errorf("invalid egress allowlist entry %q: wildcards are only allowed as *.<domain>", a)
+			}
+			p.domains = append(p.domains, d)
+		}
+	}
+	return p, nil
+}
+
+// allowsName reports whether the policy allows host by its name.
+func (p *Policy) allowsName(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, d := range p.domains {
+		if sub, ok := strings.CutPrefix(d, "*."); ok {
+			if strings.HasSuffix(host, "."+sub) {
+				return true
+			}
+		} else if host == d {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsIP reports whether the policy allows ip.
+func (p *Policy) allowsIP(ip net.IP) bool {
+	return slices.ContainsFunc(p.nets, func(n *net.IPNet) bool { return n.Contains(ip) })
+}
+
+// Contact is a host that a build tried to connect to.
+type Contact struct {
+	Host     string `json:"host"`
+	Allowed  bool   `json:"allowed"`
+	Requests int    `json:"requests"`
+}
+
+// Proxy is an HTTP proxy that enforces a policy. It proxies plain HTTP
+// requests, and tunnels other connections, such as HTTPS, with CONNECT.
+type Proxy struct {
+	policy   *Policy
+	resolver *net.Resolver
+	dialer   *net.Dialer
+	server   *http.Server
+	listener net.Listener
+
+	mu       sync.Mutex
+	contacts map[string]*Contact
+}
+
+// NewProxy returns a proxy that enforces policy, listening on addr, such as
+// 127.0.0.1:0 for any free port on the loopback interface.
+func NewProxy(policy *Policy, addr string) (*Proxy, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	p := &Proxy{
+		policy:   policy,
+		resolver: net.DefaultResolver,
+		dialer:   &net.Dialer{Timeout: 30 * time.Second},
+		listener: l,
+		contacts: map[string]*Contact{},
+	}
+	p.server = &http.Server{Handler: p, ReadHeaderTimeout: 30 * time.Second}
+	return p, nil
+}
+
+// Addr returns the address that the proxy listens on.
+func (p *Proxy) Addr() net.Addr {
+	return p.listener.Addr()
+}
+
+// Serve serves connections to the proxy until ctx is done or Close is called.
+func (p *Proxy) Serve(ctx context.Context) error {
+	p.server.BaseContext = func(net.Listener) context.Context { return ctx }
+	go func() {
+		<-ctx.Done()
+		p.server.Close()
+	}()
+	if err := p.server.Serve(p.listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Close stops the proxy, and closes any connections it's forwarding.
+func (p *Proxy) Close() error {
+	return p.server.Close()
+}
+
+// Report returns every host that was contacted through the proxy, sorted by
+// name.
+func (p *Proxy) Report() []Contact {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	report := make([]Contact, 0, len(p.contacts))
+	for _, c := range p.contacts {
+		report = append(report, *c)
+	}
+	slices.SortFunc(report, func(a, b Contact) int { return strings.Compare(a.Host, b.Host) })
+	return report
+}
+
+func (p *Proxy) record(host string, allowed bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	c, ok := p.contacts[host]
+	if !ok {
+		c = &Contact{Host: host, Allowed: allowed}
+		p.contacts[host] = c
+	}
+	c.Requests++
+}
+
+// dial connects to hostport if the policy allows it. A host that's allowed
+// by name is connected to at whatever it resolves to, while any other host
+// is only connected to at resolved addresses that the policy allows.
+func (p *Proxy) dial(ctx context.Context, hostport string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.policy.allowsName(host) {
+		p.record(host, true)
+		return p.dialer.DialContext(ctx, "tcp", hostport)
+	}
+
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else if addrs, err := p.resolver.LookupIPAddr(ctx, host); err == nil {
+		for _, a := range addrs {
+			ips = append(ips, a.IP)
+		}
+	}
+	for _, ip := range ips {
+		if p.policy.allowsIP(ip) {
+			p.record(host, true)
+			return p.dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), port))
+		}
+	}
+
+	p.record(host, false)
+	clog.FromContext(ctx).Warnf("egress: blocked connection to %s, which isn't in the egress allowlist", host)
+	return nil, errBlocked
+}
+
+var errBlocked = errors.New("blocked by the egress allowlist")
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		p.tunnel(w, r)
+		return
+	}
+	if r.URL.Host == "" {
+		http.Error(w, "this is a proxy", http.StatusBadRequest)
+		return
+	}
+
+	hostport := r.URL.Host
+	if r.URL.Port() == "" {
+		hostport = net.JoinHostPort(r.URL.Hostname(), "80")
+	}
+	conn, err := p.dial(r.Context(), hostport)
+	if err != nil {
+		status := http.StatusBadGateway
+		if errors.Is(err, errBlocked) {
+			status = http.StatusForbidden
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	transport := &http.Transport{
+		DialContext:       func(context.Context, string, string) (net.Conn, error) { return conn, nil },
+		DisableKeepAlives: true,
+	}
+	defer transport.CloseIdleConnections()
+
+	out := r.Clone(r.Context())
+	out.RequestURI = ""
+	out.Header.Del("Proxy-Connection")
+	out.Header.Del("Proxy-Authorization")
+	resp, err := transport.RoundTrip(out)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	for k, vs := range resp.Header {
+		for _, v := range vs {
+			w.Header().Add(k, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body) //nolint:errcheck
+}
+
+func (p *Proxy) tunnel(w http.ResponseWriter, r *http.Request) {
+	upstream, err := p.dial(r.Context(), r.Host)
+	if err != nil {
+		status := http.StatusBadGateway
+		if errors.Is(err, errBlocked) {
+			status = http.StatusForbidden
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	defer upstream.Close()
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "can't tunnel this connection", http.StatusInternalServerError)
+		return
+	}
+	client, buf, err := hj.Hijack()
+	if err != nil {
+		return
+	}
+	defer client.Close()
+	if _, err := io.WriteString(client, "HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+		return
+	}
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(upstream, buf) //nolint:errcheck
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(client, upstream) //nolint:errcheck
+		done <- struct{}{}
+	}()
+	<-done
+}
@@ -0,0 +1,116 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package egress
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicy(t *testing.T) {
+	p, err := NewPolicy([]string{"github.com", "*.githubusercontent.com", "10.0.0.0/8", "192.0.2.1", "2001:db8::1"})
+	require.NoError(t, err)
+
+	for host, want := range map[string]bool{
+		"github.com":                          true,
+		"GitHub.com.":                         true,
+		"api.github.com":                      false,
+		"objects.githubusercontent.com":       true,
+		"githubusercontent.com":               false,
+		"evilgithubusercontent.com":           false,
+		"example.com":                         false,
+		"raw.objects.githubusercontent.com":   true,
+		"githubusercontent.com.example.com":   false,
+		"objects.githubusercontent.com.evil.": false,
+	} {
+		require.Equal(t, want, p.allowsName(host), host)
+	}
+
+	for ip, want := range map[string]bool{
+		"10.1.2.3":    true,
+		"11.0.0.1":    false,
+		"192.0.2.1":   true,
+		"192.0.2.2":   false,
+		"2001:db8::1": true,
+		"2001:db8::2": false,
+	} {
+		require.Equal(t, want, p.allowsIP(net.ParseIP(ip)), ip)
+	}
+
+	for _, bad := range []string{"10.0.0.0/33", "*.*.example.com", "foo*.example.com", "*."} {
+		_, err := NewPolicy([]string{bad})
+		require.Error(t, err, bad)
+	}
+}
+
+func TestProxy(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello") //nolint:errcheck
+	}))
+	defer upstream.Close()
+	tlsUpstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "secure hello") //nolint:errcheck
+	}))
+	defer tlsUpstream.Close()
+
+	policy, err := NewPolicy([]string{"127.0.0.1"})
+	require.NoError(t, err)
+	proxy, err := NewProxy(policy, "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go proxy.Serve(ctx) //nolint:errcheck
+
+	proxyURL := &url.URL{Scheme: "http", Host: proxy.Addr().String()}
+	transport := tlsUpstream.Client().Transport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyURL(proxyURL)
+	client := &http.Client{Transport: transport}
+
+	get := func(u string) (int, string) {
+		resp, err := client.Get(u)
+		if err != nil {
+			return 0, err.Error()
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(body)
+	}
+
+	status, body := get(upstream.URL)
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "hello", body)
+
+	status, body = get(tlsUpstream.URL)
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "secure hello", body)
+
+	status, _ = get("http://blocked.invalid/")
+	require.Equal(t, http.StatusForbidden, status)
+	status, _ = get("https://blocked.invalid/")
+	require.Equal(t, 0, status)
+
+	require.Equal(t, []Contact{
+		{Host: "127.0.0.1", Allowed: true, Requests: 2},
+		{Host: "blocked.invalid", Allowed: false, Requests: 2},
+	}, proxy.Report())
+}
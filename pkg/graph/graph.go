@@ -0,0 +1,363 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package graph builds the graph of the build dependencies between
+// configurations, in which a configuration depends on those that build the
+// packages in its build environment, and finds the cycles in it.
+package graph
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	apko_types "chainguard.dev/apko/pkg/build/types"
+	"github.com/chainguard-dev/clog"
+
+	"chainguard.dev/melange/pkg/build"
+	"chainguard.dev/melange/pkg/config"
+)
+
+// Graph is the graph of the build dependencies between configurations,
+// which are named after the packages they build.
+type Graph struct {
+	// edges are the configurations that each one depends on, in order.
+	edges map[string][]Edge
+}
+
+// Edge is a build dependency of a configuration on another.
+type Edge struct {
+	// To is the configuration that the dependency is on.
+	To string
+	// Needs is the package in the build environment that To provides, as
+	// it's written, without its version constraint.
+	Needs string
+}
+
+// New returns the graph of the build dependencies between the
+// configurations. The packages that a configuration builds are its package,
+// its subpackages and what they provide. A package in the build environment
+// that another configuration builds as a package or subpackage is a
+// dependency on that configuration only, and one that other configurations
+// only provide is one on each of them. Configurations that build packages
+// in their own build environment don't depend on themselves.
+func New(cfgs ...*config.Configuration) (*Graph, error) {
+	// names are the configurations that build each package or subpackage.
+	names := map[string]string{}
+	provides := map[string][]string{}
+	for _, cfg := range cfgs {
+		if cfg.Package.Name == "" {
+			return nil, fmt.Errorf("configuration without a package name")
+		}
+		pkgs := []string{cfg.Package.Name}
+		provides = addProvides(provides, cfg.Package.Name, cfg.Package.Dependencies)
+		for _, sp := range cfg.Subpackages {
+			pkgs = appendUnique(pkgs, sp.Name)
+			provides = addProvides(provides, cfg.Package.Name, sp.Dependencies)
+		}
+		for _, pkg := range pkgs {
+			if other, ok := names[pkg]; ok {
+				return nil, fmt.Errorf("package %q is built by both %q and %q", pkg, other, cfg.Package.Name)
+			}
+			names[pkg] = cfg.Package.Name
+		}
+	}
+
+	g := &Graph{edges: map[string][]Edge{}}
+	for _, cfg := range cfgs {
+		from := cfg.Package.Name
+		g.edges[from] = nil
+		for _, pkg := range buildDependencies(cfg) {
+			providers := provides[pkg]
+			if name, ok := names[pkg]; ok {
+				providers = []string{name}
+			}
+			for _, to := range providers {
+				if to == from {
+					continue
+				}
+				g.edges[from] = append(g.edges[from], Edge{To: to, Needs: pkg})
+			}
+		}
+		slices.SortStableFunc(g.edges[from], func(a, b Edge) int {
+			return strings.Compare(a.To, b.To)
+		})
+	}
+	return g, nil
+}
+
+// Load returns the graph of the build dependencies between the
+// configurations in the directory dir. Files that aren't configurations,
+// because they can't be parsed as one, are skipped with a warning. The
+// pipelines that the configurations use are looked up in pipelineDirs, then
+// in the built-in ones, for the packages that they need.
+func Load(ctx context.Context, dir string, pipelineDirs []string) (*Graph, error) {
+	log := clog.FromContext(ctx)
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		if _, err := os.Stat(dir); err != nil {
+			return nil, err
+		}
+	}
+
+	var cfgs []*config.Configuration
+	// byName are the files of the configurations by their package names.
+	byName := map[string]string{}
+	for _, file := range files {
+		if strings.HasPrefix(filepath.Base(file), ".") {
+			// Such as .yam.yaml.
+			continue
+		}
+		cfg, err := config.ParseConfiguration(ctx, file)
+		if err != nil {
+			log.Warnf("skipping %s: %v", file, err)
+			continue
+		}
+		if err := addPipelineNeeds(ctx, file, cfg, pipelineDirs); err != nil {
+			return nil, fmt.Errorf("compiling %s: %w", file, err)
+		}
+		if other, ok := byName[cfg.Package.Name]; ok {
+			return nil, fmt.Errorf("package %q is built by both %s and %s", cfg.Package.Name, other, file)
+		}
+		byName[cfg.Package.Name] = file
+		cfgs = append(cfgs, cfg)
+	}
+	return New(cfgs...)
+}
+
+// addPipelineNeeds adds the packages that the pipelines of the configuration
+// in file need, including those of the pipelines that they use, to the build
+// environment of cfg, as compiling it does. It's compiled for each
+// architecture, so that the needs of pipelines that only run on some of them
+// are added too.
+func addPipelineNeeds(ctx context.Context, file string, cfg *config.Configuration, pipelineDirs []string) error {
+	env := &cfg.Environment.Contents
+	for _, arch := range apko_types.AllArchs {
+		// Compiling changes the pipelines of the configuration, so each
+		// architecture gets its own copy.
+		c, err := config.ParseConfiguration(ctx, file)
+		if err != nil {
+			return err
+		}
+		b := &build.Build{Configuration: *c, Arch: arch, PipelineDirs: pipelineDirs}
+		if err := b.Compile(ctx); err != nil {
+			return fmt.Errorf("for %s: %w", arch, err)
+		}
+		for _, pkg := range b.Configuration.Environment.Contents.Packages {
+			env.Packages = appendUnique(env.Packages, pkg)
+		}
+	}
+	return nil
+}
+
+// Edges returns the build dependencies of the configuration name, in the
+// order of the configurations they're on.
+func (g *Graph) Edges(name string) []Edge {
+	return g.edges[name]
+}
+
+// Cycle is a cycle of build dependencies, which starts and ends with the
+// configuration From.
+type Cycle struct {
+	From string
+	// Edges are the dependencies along the cycle, the last of which is on
+	// From.
+	Edges []Edge
+}
+
+// String returns the path of the cycle, with the package that each
+// configuration needs from the next in parentheses after it, such as
+// "a -> b (b-dev) -> a (so:liba.so.1)".
+func (c Cycle) String() string {
+	var sb strings.Builder
+	sb.WriteString(c.From)
+	for _, e := range c.Edges {
+		fmt.Fprintf(&sb, " -> %s (%s)", e.To, e.Needs)
+	}
+	return sb.String()
+}
+
+// Cycles returns a cycle of build dependencies for each group of
+// configurations that depend on each other, starting with the first of them
+// by name. The cycles are the shortest ones, and in the order of the
+// configurations they start with.
+func (g *Graph) Cycles() []Cycle {
+	var cycles []Cycle
+	for _, scc := range g.components() {
+		if len(scc) < 2 {
+			continue
+		}
+		cycles = append(cycles, g.shortestCycle(scc))
+	}
+	slices.SortFunc(cycles, func(a, b Cycle) int {
+		return strings.Compare(a.From, b.From)
+	})
+	return cycles
+}
+
+// components returns the strongly connected components of the graph, each
+// sorted by name, using Tarjan's algorithm.
+func (g *Graph) components() [][]string {
+	nodes := make([]string, 0, len(g.edges))
+	for n := range g.edges {
+		nodes = append(nodes, n)
+	}
+	slices.Sort(nodes)
+
+	var (
+		index   = map[string]int{}
+		lowlink = map[string]int{}
+		onStack = map[string]bool{}
+		stack   []string
+		sccs    [][]string
+	)
+	var visit func(n string)
+	visit = func(n string) {
+		index[n] = len(index)
+		lowlink[n] = index[n]
+		stack = append(stack, n)
+		onStack[n] = true
+
+		for _, e := range g.edges[n] {
+			if _, ok := index[e.To]; !ok {
+				visit(e.To)
+				lowlink[n] = min(lowlink[n], lowlink[e.To])
+			} else if onStack[e.To] {
+				lowlink[n] = min(lowlink[n], index[e.To])
+			}
+		}
+
+		if lowlink[n] != index[n] {
+			return
+		}
+		var scc []string
+		for {
+			m := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[m] = false
+			scc = append(scc, m)
+			if m == n {
+				break
+			}
+		}
+		slices.Sort(scc)
+		sccs = append(sccs, scc)
+	}
+	for _, n := range nodes {
+		if _, ok := index[n]; !ok {
+			visit(n)
+		}
+	}
+	return sccs
+}
+
+// shortestCycle returns the shortest cycle through the first configuration
+// of the strongly connected component scc, found breadth first.
+func (g *Graph) shortestCycle(scc []string) Cycle {
+	from := scc[0]
+	// via is the edge that each configuration is first reached by.
+	via := map[string]Edge{}
+	prev := map[string]string{}
+	queue := []string{from}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		for _, e := range g.edges[n] {
+			if !slices.Contains(scc, e.To) {
+				continue
+			}
+			if e.To == from {
+				edges := []Edge{e}
+				for m := n; m != from; m = prev[m] {
+					edges = append(edges, via[m])
+				}
+				slices.Reverse(edges)
+				return Cycle{From: from, Edges: edges}
+			}
+			if _, ok := via[e.To]; ok {
+				continue
+			}
+			via[e.To], prev[e.To] = e, n
+			queue = append(queue, e.To)
+		}
+	}
+	// Every configuration of a component with more than one can reach all
+	// the others, so this doesn't happen.
+	return Cycle{From: from}
+}
+
+// buildDependencies returns the packages in the build environment of the
+// configuration, and those that its pipelines need, without their version
+// constraints. Conflicts, such as !foo, aren't dependencies.
+func buildDependencies(cfg *config.Configuration) []string {
+	var deps []string
+	add := func(pkgs []string) {
+		for _, pkg := range pkgs {
+			if strings.HasPrefix(pkg, "!") {
+				continue
+			}
+			deps = appendUnique(deps, packageName(pkg))
+		}
+	}
+	var addPipelines func(pipelines []config.Pipeline)
+	addPipelines = func(pipelines []config.Pipeline) {
+		for _, p := range pipelines {
+			if p.Needs != nil {
+				add(p.Needs.Packages)
+			}
+			addPipelines(p.Pipeline)
+		}
+	}
+
+	add(cfg.Environment.Contents.Packages)
+	addPipelines(cfg.Pipeline)
+	for _, sp := range cfg.Subpackages {
+		addPipelines(sp.Pipeline)
+	}
+	return deps
+}
+
+// addProvides adds the packages that the dependencies provide to those that
+// the configuration name builds.
+func addProvides(provides map[string][]string, name string, deps config.Dependencies) map[string][]string {
+	for _, p := range deps.Provides {
+		p = packageName(p)
+		provides[p] = appendUnique(provides[p], name)
+	}
+	return provides
+}
+
+// packageName returns the name of the package pkg, without its version
+// constraint, such as foo for foo>=1.2 or so:libfoo.so.1 for
+// so:libfoo.so.1=1.2.
+func packageName(pkg string) string {
+	if i := strings.IndexAny(pkg, "=<>~"); i >= 0 {
+		return pkg[:i]
+	}
+	return pkg
+}
+
+func appendUnique(s []string, v string) []string {
+	if slices.Contains(s, v) {
+		return s
+	}
+	return append(s, v)
+}
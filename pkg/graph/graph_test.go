@@ -0,0 +1,142 @@
+package graph
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	apko_types "chainguard.dev/apko/pkg/build/types"
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/melange/pkg/config"
+)
+
+// cfg returns a configuration of the package name whose build environment
+// has the packages env.
+func cfg(name string, env []string, subpackages ...config.Subpackage) *config.Configuration {
+	return &config.Configuration{
+		Package: config.Package{Name: name},
+		Environment: apko_types.ImageConfiguration{
+			Contents: apko_types.ImageContents{Packages: env},
+		},
+		Subpackages: subpackages,
+	}
+}
+
+func TestCycles(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		cfgs []*config.Configuration
+		want []string
+	}{{
+		name: "no cycles",
+		cfgs: []*config.Configuration{
+			cfg("a", []string{"b", "busybox"}),
+			cfg("b", []string{"c>=1.2"}),
+			cfg("c", nil),
+		},
+	}, {
+		name: "subpackages and provides",
+		cfgs: []*config.Configuration{
+			cfg("a", []string{"b-dev"}),
+			cfg("b", []string{"so:libc.so.1"}, config.Subpackage{Name: "b-dev"}),
+			cfg("c", []string{"a=1.0"}, config.Subpackage{
+				Name:         "libc",
+				Dependencies: config.Dependencies{Provides: []string{"so:libc.so.1=1.0"}},
+			}),
+		},
+		want: []string{"a -> b (b-dev) -> c (so:libc.so.1) -> a (a)"},
+	}, {
+		name: "shortest cycle of each group",
+		cfgs: []*config.Configuration{
+			cfg("a", []string{"b", "c"}),
+			cfg("b", []string{"c"}),
+			cfg("c", []string{"a"}),
+			cfg("x", []string{"y", "a"}),
+			cfg("y", []string{"x"}),
+		},
+		want: []string{
+			"a -> c (c) -> a (a)",
+			"x -> y (y) -> x (x)",
+		},
+	}, {
+		name: "own packages and conflicts",
+		cfgs: []*config.Configuration{
+			cfg("a", []string{"a-bootstrap", "!b"}, config.Subpackage{Name: "a-bootstrap"}),
+			cfg("b", []string{"a"}),
+		},
+	}, {
+		name: "pipeline needs",
+		cfgs: []*config.Configuration{
+			func() *config.Configuration {
+				c := cfg("a", nil)
+				c.Pipeline = []config.Pipeline{{
+					Pipeline: []config.Pipeline{{Needs: &config.Needs{Packages: []string{"b"}}}},
+				}}
+				return c
+			}(),
+			cfg("b", []string{"a"}),
+		},
+		want: []string{"a -> b (b) -> a (a)"},
+	}} {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := New(tt.cfgs...)
+			require.NoError(t, err)
+			var got []string
+			for _, c := range g.Cycles() {
+				got = append(got, c.String())
+			}
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestNewDuplicates(t *testing.T) {
+	_, err := New(cfg("a", nil, config.Subpackage{Name: "b"}), cfg("b", nil))
+	require.ErrorContains(t, err, `package "b" is built by both "a" and "b"`)
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"a.yaml": `package:
+  name: a
+  version: 1.0.0
+environment:
+  contents:
+    packages:
+      - b-dev
+pipeline:
+  - runs: make
+`,
+		"b.yaml": `package:
+  name: b
+  version: 1.0.0
+pipeline:
+  - uses: needs-a
+subpackages:
+  - name: b-dev
+`,
+		"not-a-config.yaml": "- a\n- b\n",
+		".yam.yaml":         "indent: 2\n",
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644))
+	}
+	// b only needs a through the pipeline that it uses, on one architecture.
+	pipelineDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(pipelineDir, "needs-a.yaml"), []byte(`pipeline:
+  - if: ${{build.arch}} == 'riscv64'
+    needs:
+      packages:
+        - a
+    runs: make
+`), 0o644))
+
+	g, err := Load(context.Background(), dir, []string{pipelineDir})
+	require.NoError(t, err)
+	require.Equal(t, []Edge{{To: "b", Needs: "b-dev"}}, g.Edges("a"))
+	cycles := g.Cycles()
+	require.Len(t, cycles, 1)
+	require.Equal(t, "a -> b (b-dev) -> a (a)", cycles[0].String())
+}
@@ -0,0 +1,480 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package jsonpath evaluates JSONPath expressions, such as
+// $.subpackages[*].name, over decoded JSON values.
+//
+// It supports the root $, children by name (.name and ['name']), the
+// wildcard (.* and [*]), recursive descent (..name), indexes, negative ones
+// counting from the end, slices ([start:end]), and filters that compare a
+// child of the current item @ with a literal, such as
+// [?(@.uses == 'fetch')], or check that it exists, such as [?(@.with)].
+package jsonpath
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Path is a parsed JSONPath expression.
+type Path struct {
+	steps []step
+}
+
+type stepKind int
+
+const (
+	child stepKind = iota
+	wildcard
+	index
+	slice
+	filter
+)
+
+// step selects values from each of the values the steps before it did.
+type step struct {
+	kind stepKind
+	// recursive steps select from the values and all of their descendants.
+	recursive bool
+
+	name       string
+	index      int
+	start, end *int
+	filter     *predicate
+}
+
+// predicate is the expression of a filter.
+type predicate struct {
+	path  []string
+	op    string
+	value any
+}
+
+// Parse parses the JSONPath expression expr.
+func Parse(expr string) (*Path, error) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(expr), "$")
+	if !ok {
+		return nil, fmt.Errorf("%q doesn't start with $", expr)
+	}
+
+	p := &Path{}
+	for rest != "" {
+		var s step
+		switch {
+		case strings.HasPrefix(rest, ".."):
+			s.recursive = true
+			rest = rest[2:]
+		case strings.HasPrefix(rest, "."):
+			rest = rest[1:]
+		case strings.HasPrefix(rest, "["):
+			end := closingBracket(rest)
+			if end < 0 {
+				return nil, fmt.Errorf("%q: unclosed [", expr)
+			}
+			if err := parseBracket(rest[1:end], &s); err != nil {
+				return nil, fmt.Errorf("%q: %w", expr, err)
+			}
+			if n := len(p.steps); n > 0 && p.steps[n-1].kind == child && p.steps[n-1].name == "" {
+				// The bracket follows .., such as $..[0].
+				s.recursive = p.steps[n-1].recursive
+				p.steps = p.steps[:n-1]
+			}
+			p.steps = append(p.steps, s)
+			rest = rest[end+1:]
+			continue
+		default:
+			return nil, fmt.Errorf("%q: unexpected %q", expr, rest)
+		}
+
+		if strings.HasPrefix(rest, "[") {
+			// Such as ..[0], which selects from all the descendants.
+			p.steps = append(p.steps, step{kind: child, recursive: s.recursive})
+			continue
+		}
+		name := rest[:nameEnd(rest)]
+		if name == "" {
+			return nil, fmt.Errorf("%q: expected a name after .", expr)
+		}
+		rest = rest[len(name):]
+		if name == "*" {
+			s.kind = wildcard
+		} else {
+			s.kind, s.name = child, name
+		}
+		p.steps = append(p.steps, s)
+	}
+	return p, nil
+}
+
+// nameEnd returns the length of the name at the start of s.
+func nameEnd(s string) int {
+	if strings.HasPrefix(s, "*") {
+		return 1
+	}
+	for i, r := range s {
+		if r == '.' || r == '[' || r == ' ' || r == ')' || r == '=' || r == '!' || r == '<' || r == '>' {
+			return i
+		}
+	}
+	return len(s)
+}
+
+// closingBracket returns the index of the ] that closes the [ at the start
+// of s, ignoring those in quotes, or -1.
+func closingBracket(s string) int {
+	depth := 0
+	var quote rune
+	for i, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '[':
+			depth++
+		case r == ']':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// parseBracket parses the contents of a bracket into s.
+func parseBracket(content string, s *step) error {
+	content = strings.TrimSpace(content)
+	switch {
+	case content == "*":
+		s.kind = wildcard
+		return nil
+
+	case strings.HasPrefix(content, "?"):
+		expr := strings.TrimSpace(content[1:])
+		if !strings.HasPrefix(expr, "(") || !strings.HasSuffix(expr, ")") {
+			return fmt.Errorf("expected a filter in parentheses, got %q", content)
+		}
+		pred, err := parsePredicate(expr[1 : len(expr)-1])
+		if err != nil {
+			return err
+		}
+		s.kind, s.filter = filter, pred
+		return nil
+
+	case strings.HasPrefix(content, "'") || strings.HasPrefix(content, `"`):
+		name, err := unquote(content)
+		if err != nil {
+			return err
+		}
+		s.kind, s.name = child, name
+		return nil
+
+	case strings.Contains(content, ":"):
+		from, to, _ := strings.Cut(content, ":")
+		s.kind = slice
+		for _, bound := range []struct {
+			text string
+			dst  **int
+		}{{from, &s.start}, {to, &s.end}} {
+			if t := strings.TrimSpace(bound.text); t != "" {
+				n, err := strconv.Atoi(t)
+				if err != nil {
+					return fmt.Errorf("invalid slice %q", content)
+				}
+				*bound.dst = &n
+			}
+		}
+		return nil
+	}
+
+	n, err := strconv.Atoi(content)
+	if err != nil {
+		return fmt.Errorf("invalid index %q", content)
+	}
+	s.kind, s.index = index, n
+	return nil
+}
+
+var operators = []string{"==", "!=", "<=", ">=", "<", ">"}
+
+// parsePredicate parses the expression of a filter, such as
+// @.uses == 'fetch'.
+func parsePredicate(expr string) (*predicate, error) {
+	expr = strings.TrimSpace(expr)
+	rest, ok := strings.CutPrefix(expr, "@")
+	if !ok {
+		return nil, fmt.Errorf("filter %q doesn't start with @", expr)
+	}
+
+	pred := &predicate{}
+	for strings.HasPrefix(rest, ".") || strings.HasPrefix(rest, "[") {
+		if strings.HasPrefix(rest, "[") {
+			end := closingBracket(rest)
+			if end < 0 {
+				return nil, fmt.Errorf("filter %q: unclosed [", expr)
+			}
+			name, err := unquote(strings.TrimSpace(rest[1:end]))
+			if err != nil {
+				return nil, fmt.Errorf("filter %q: %w", expr, err)
+			}
+			pred.path = append(pred.path, name)
+			rest = rest[end+1:]
+			continue
+		}
+		rest = rest[1:]
+		name := rest[:nameEnd(rest)]
+		if name == "" || name == "*" {
+			return nil, fmt.Errorf("filter %q: expected a name after .", expr)
+		}
+		pred.path = append(pred.path, name)
+		rest = rest[len(name):]
+	}
+
+	rest = strings.TrimSpace(rest)
+	if rest == "" {
+		// Only checks that the child exists.
+		return pred, nil
+	}
+	for _, op := range operators {
+		if literal, ok := strings.CutPrefix(rest, op); ok {
+			value, err := parseLiteral(strings.TrimSpace(literal))
+			if err != nil {
+				return nil, fmt.Errorf("filter %q: %w", expr, err)
+			}
+			pred.op, pred.value = op, value
+			return pred, nil
+		}
+	}
+	return nil, fmt.Errorf("filter %q: unexpected %q", expr, rest)
+}
+
+// parseLiteral parses a string, number, boolean or null.
+func parseLiteral(s string) (any, error) {
+	switch {
+	case strings.HasPrefix(s, "'") || strings.HasPrefix(s, `"`):
+		return unquote(s)
+	case s == "true":
+		return true, nil
+	case s == "false":
+		return false, nil
+	case s == "null":
+		return nil, nil
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid literal %q", s)
+	}
+	return n, nil
+}
+
+// unquote returns the string in single or double quotes s.
+func unquote(s string) (string, error) {
+	if len(s) < 2 || (s[0] != '\'' && s[0] != '"') || s[len(s)-1] != s[0] {
+		return "", fmt.Errorf("expected a quoted name, got %q", s)
+	}
+	return s[1 : len(s)-1], nil
+}
+
+// Eval returns the values that the path selects from v, which is a value
+// decoded from JSON into any, in the order they're in.
+func (p *Path) Eval(v any) []any {
+	values := []any{v}
+	for _, s := range p.steps {
+		var from []any
+		if s.recursive {
+			for _, v := range values {
+				from = descendants(v, from)
+			}
+		} else {
+			from = values
+		}
+		values = nil
+		for _, v := range from {
+			values = s.selectFrom(v, values)
+		}
+	}
+	return values
+}
+
+// descendants appends v and all of its descendants to out.
+func descendants(v any, out []any) []any {
+	out = append(out, v)
+	for _, c := range children(v) {
+		out = descendants(c, out)
+	}
+	return out
+}
+
+// children returns the values of an object, in the order of their keys,
+// or the items of an array.
+func children(v any) []any {
+	switch v := v.(type) {
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		out := make([]any, 0, len(v))
+		for _, k := range keys {
+			out = append(out, v[k])
+		}
+		return out
+	case []any:
+		return v
+	}
+	return nil
+}
+
+// selectFrom appends the values that the step selects from v to out.
+func (s step) selectFrom(v any, out []any) []any {
+	switch s.kind {
+	case child:
+		if s.name == "" {
+			return append(out, v)
+		}
+		if m, ok := v.(map[string]any); ok {
+			if c, ok := m[s.name]; ok {
+				out = append(out, c)
+			}
+		}
+
+	case wildcard:
+		out = append(out, children(v)...)
+
+	case index:
+		if a, ok := v.([]any); ok {
+			i := s.index
+			if i < 0 {
+				i += len(a)
+			}
+			if i >= 0 && i < len(a) {
+				out = append(out, a[i])
+			}
+		}
+
+	case slice:
+		if a, ok := v.([]any); ok {
+			start, end := bound(s.start, 0, len(a)), bound(s.end, len(a), len(a))
+			if start < end {
+				out = append(out, a[start:end]...)
+			}
+		}
+
+	case filter:
+		for _, c := range children(v) {
+			if s.filter.matches(c) {
+				out = append(out, c)
+			}
+		}
+	}
+	return out
+}
+
+// bound returns the bound of a slice of an array of length n, or def if it
+// isn't set.
+func bound(b *int, def, n int) int {
+	if b == nil {
+		return def
+	}
+	i := *b
+	if i < 0 {
+		i += n
+	}
+	return max(0, min(i, n))
+}
+
+// matches returns whether v matches the predicate.
+func (p *predicate) matches(v any) bool {
+	for _, name := range p.path {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return false
+		}
+		if v, ok = m[name]; !ok {
+			return false
+		}
+	}
+	if p.op == "" {
+		return true
+	}
+
+	switch want := p.value.(type) {
+	case float64:
+		got, ok := number(v)
+		if !ok {
+			return p.op == "!="
+		}
+		switch p.op {
+		case "==":
+			return got == want
+		case "!=":
+			return got != want
+		case "<":
+			return got < want
+		case "<=":
+			return got <= want
+		case ">":
+			return got > want
+		case ">=":
+			return got >= want
+		}
+	case string:
+		got, ok := v.(string)
+		if !ok {
+			return p.op == "!="
+		}
+		switch p.op {
+		case "==":
+			return got == want
+		case "!=":
+			return got != want
+		case "<":
+			return got < want
+		case "<=":
+			return got <= want
+		case ">":
+			return got > want
+		case ">=":
+			return got >= want
+		}
+	default:
+		// Booleans and null can only be compared for equality.
+		switch p.op {
+		case "==":
+			return v == want
+		case "!=":
+			return v != want
+		}
+	}
+	return false
+}
+
+// number returns v as a float64, if it's a number.
+func number(v any) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
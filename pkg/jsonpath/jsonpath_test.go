@@ -0,0 +1,88 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jsonpath
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const document = `{
+  "package": {"name": "hello", "version": "2.12", "epoch": 3},
+  "subpackages": [
+    {"name": "hello-dev", "pipeline": [{"uses": "split/dev"}]},
+    {"name": "hello-doc", "pipeline": [{"uses": "split/doc"}]}
+  ],
+  "pipeline": [
+    {"uses": "fetch", "with": {"uri": "https://example.com/hello.tar.gz"}},
+    {"uses": "autoconf/configure"},
+    {"runs": "make check"}
+  ]
+}`
+
+func TestEval(t *testing.T) {
+	var v any
+	require.NoError(t, json.Unmarshal([]byte(document), &v))
+
+	for _, tt := range []struct {
+		expr string
+		want []any
+	}{
+		{"$", []any{v}},
+		{"$.package.version", []any{"2.12"}},
+		{"$['package']['name']", []any{"hello"}},
+		{"$.package.missing", nil},
+		{"$.subpackages[*].name", []any{"hello-dev", "hello-doc"}},
+		{"$.subpackages.*.name", []any{"hello-dev", "hello-doc"}},
+		{"$.subpackages[-1].name", []any{"hello-doc"}},
+		{"$.subpackages[5].name", nil},
+		{"$.pipeline[1:].uses", []any{"autoconf/configure"}},
+		{"$.pipeline[:-1].uses", []any{"fetch", "autoconf/configure"}},
+		{"$..uses", []any{"fetch", "autoconf/configure", "split/dev", "split/doc"}},
+		{"$..pipeline[0].uses", []any{"fetch", "split/dev", "split/doc"}},
+		{"$.pipeline[?(@.uses == 'fetch')].with.uri", []any{"https://example.com/hello.tar.gz"}},
+		{`$.pipeline[?(@.uses != "fetch")].uses`, []any{"autoconf/configure"}},
+		{"$.pipeline[?(@.runs)].runs", []any{"make check"}},
+		{"$.pipeline[?(@['with'].uri)].uses", []any{"fetch"}},
+		{"$[?(@.epoch > 2)].name", []any{"hello"}},
+		{"$[?(@.epoch < 2)].name", nil},
+	} {
+		t.Run(tt.expr, func(t *testing.T) {
+			p, err := Parse(tt.expr)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, p.Eval(v))
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, expr := range []string{
+		"package.version",
+		"$.",
+		"$.pipeline[0",
+		"$.pipeline[x]",
+		"$.pipeline[?@.uses]",
+		"$.pipeline[?(uses == 'fetch')]",
+		"$.pipeline[?(@.uses ~ 'fetch')]",
+		"$.pipeline[?(@.uses == fetch)]",
+	} {
+		t.Run(expr, func(t *testing.T) {
+			_, err := Parse(expr)
+			require.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package linter
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Baseline lists known findings, which are reported as warnings rather than
+// failing the build. This lets new linters be adopted without first fixing
+// every existing package.
+type Baseline struct {
+	Findings []BaselineFinding `yaml:"findings"`
+}
+
+// BaselineFinding identifies a known finding.
+type BaselineFinding struct {
+	// The name of the linter.
+	Linter string `yaml:"linter"`
+	// The name of the package, or "" for any package.
+	Package string `yaml:"package,omitempty"`
+	// The path within the package, or "" for findings that aren't about a
+	// file.
+	Path string `yaml:"path,omitempty"`
+}
+
+// LoadBaseline reads a baseline from the YAML file at path.
+func LoadBaseline(path string) (*Baseline, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening lint baseline: %w", err)
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	var b Baseline
+	if err := dec.Decode(&b); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("parsing lint baseline %s: %w", path, err)
+	}
+	return &b, nil
+}
+
+// contains reports whether f is a known finding.
+func (b *Baseline) contains(f Finding) bool {
+	if b == nil {
+		return false
+	}
+	return slices.ContainsFunc(b.Findings, func(known BaselineFinding) bool {
+		return known.Linter == f.Linter && (known.Package == "" || known.Package == f.Package) && known.Path == f.Path
+	})
+}
+
+func (r *Report) writeBaseline(w io.Writer) error {
+	var b Baseline
+	for _, f := range r.Findings() {
+		if f.Fixed {
+			continue
+		}
+		b.Findings = append(b.Findings, BaselineFinding{Linter: f.Linter, Package: f.Package, Path: f.Path})
+	}
+	slices.SortFunc(b.Findings, func(a, b BaselineFinding) int {
+		return cmp.Or(
+			cmp.Compare(a.Package, b.Package),
+			cmp.Compare(a.Linter, b.Linter),
+			cmp.Compare(a.Path, b.Path),
+		)
+	})
+	b.Findings = slices.Compact(b.Findings)
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(b); err != nil {
+		return err
+	}
+	return enc.Close()
+}
@@ -0,0 +1,98 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package linter
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// fix is a change that a fixFunc made to a package.
+type fix struct {
+	path   string
+	action string
+}
+
+// fixFunc remediates what its linter fails on in the package at pkg.dir,
+// and returns what it changed. fsys is the same view of the package that the
+// linter had, so ignored paths are left alone.
+type fixFunc func(ctx context.Context, pkg *lintPackage, fsys fs.FS) ([]fix, error)
+
+// removePaths returns a fixFunc that removes every file that fn fails on.
+func removePaths(fn func(ctx context.Context, pkgname, path string) error) fixFunc {
+	return func(ctx context.Context, pkg *lintPackage, fsys fs.FS) ([]fix, error) {
+		var fixes []fix
+		err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err != nil {
+				return err
+			}
+			if d.IsDir() || fn(ctx, pkg.name, path) == nil {
+				return nil
+			}
+			if err := os.Remove(filepath.Join(pkg.dir, filepath.FromSlash(path))); err != nil {
+				return err
+			}
+			fixes = append(fixes, fix{path: path, action: "removed file"})
+			return nil
+		})
+		return fixes, err
+	}
+}
+
+// strayLibtoolArchive fails on the part of devFilesLinter that can be fixed
+// without breaking anything: nothing needs libtool archives at runtime.
+func strayLibtoolArchive(_ context.Context, pkgname, path string) error {
+	if filepath.Ext(path) == ".la" && !strings.HasSuffix(pkgname, "-dev") {
+		return fmt.Errorf("libtool archive")
+	}
+	return nil
+}
+
+func clearSetUIDOrGID(ctx context.Context, pkg *lintPackage, fsys fs.FS) ([]fix, error) {
+	var fixes []fix
+	err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		if isIgnoredPath(path) || d.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		mode := info.Mode()
+		if mode&(fs.ModeSetuid|fs.ModeSetgid) == 0 {
+			return nil
+		}
+		if err := os.Chmod(filepath.Join(pkg.dir, filepath.FromSlash(path)), mode&(fs.ModePerm|fs.ModeSticky)); err != nil {
+			return err
+		}
+		fixes = append(fixes, fix{path: path, action: "cleared setuid/setgid bits"})
+		return nil
+	})
+	return fixes, err
+}
@@ -0,0 +1,84 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package linter
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+
+	"chainguard.dev/melange/pkg/util"
+)
+
+// ignoreFS hides the paths matching any of its patterns, so that a linter
+// never sees them. Hiding a directory hides everything beneath it.
+type ignoreFS struct {
+	fs.FS
+	patterns []string
+}
+
+func (f ignoreFS) ignored(name string) bool {
+	for _, pattern := range f.patterns {
+		// Patterns are validated before linting starts.
+		if ok, _ := util.MatchGlob(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (f ignoreFS) Open(name string) (fs.File, error) {
+	if f.ignored(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return f.FS.Open(name)
+}
+
+func (f ignoreFS) Stat(name string) (fs.FileInfo, error) {
+	if f.ignored(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return fs.Stat(f.FS, name)
+}
+
+func (f ignoreFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if f.ignored(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	entries, err := fs.ReadDir(f.FS, name)
+	if err != nil {
+		return nil, err
+	}
+
+	kept := entries[:0]
+	for _, e := range entries {
+		if !f.ignored(path.Join(name, e.Name())) {
+			kept = append(kept, e)
+		}
+	}
+	return kept, nil
+}
+
+func (f ignoreFS) Readlink(name string) (string, error) {
+	if f.ignored(name) {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrNotExist}
+	}
+	rl, ok := f.FS.(readlinkFS)
+	if !ok {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: errors.ErrUnsupported}
+	}
+	return rl.Readlink(name)
+}
@@ -0,0 +1,129 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package linter
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// readlinkFS is an fs.FS that can read the targets of symbolic links.
+// Both dirFS and go-apk's TarFS implement it.
+type readlinkFS interface {
+	fs.FS
+	Readlink(name string) (string, error)
+}
+
+// osDirFS is os.DirFS, plus Readlink.
+type osDirFS struct {
+	fs.FS
+	dir string
+}
+
+func dirFS(dir string) fs.FS {
+	return osDirFS{FS: os.DirFS(dir), dir: dir}
+}
+
+func (f osDirFS) Stat(name string) (fs.FileInfo, error) {
+	return fs.Stat(f.FS, name)
+}
+
+func (f osDirFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(f.FS, name)
+}
+
+func (f osDirFS) Readlink(name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return os.Readlink(filepath.Join(f.dir, filepath.FromSlash(name)))
+}
+
+// maxLinkHops bounds how many links resolve follows, like the kernel's
+// ELOOP limit.
+const maxLinkHops = 40
+
+// lstat returns the entry for name without following it if it is a link.
+func lstat(fsys fs.FS, name string) (fs.DirEntry, bool) {
+	entries, err := fs.ReadDir(fsys, path.Dir(name))
+	if err != nil {
+		return nil, false
+	}
+	base := path.Base(name)
+	for _, e := range entries {
+		if e.Name() == base {
+			return e, true
+		}
+	}
+	return nil, false
+}
+
+// resolve follows the links in name as if fsys were the root filesystem,
+// and reports whether what name refers to exists. Like the kernel, ".."
+// never climbs above the root.
+func resolve(fsys fs.FS, name string) bool {
+	rl, ok := fsys.(readlinkFS)
+
+	hops := 0
+	cur := "."
+	parts := strings.Split(name, "/")
+	for len(parts) > 0 {
+		part := parts[0]
+		parts = parts[1:]
+
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			cur = path.Dir(cur)
+			continue
+		}
+
+		next := path.Join(cur, part)
+		d, found := lstat(fsys, next)
+		if !found {
+			return false
+		}
+		if d.Type()&fs.ModeSymlink == 0 {
+			cur = next
+			continue
+		}
+
+		hops++
+		if !ok || hops > maxLinkHops {
+			return false
+		}
+		target, err := rl.Readlink(next)
+		if err != nil {
+			return false
+		}
+		if path.IsAbs(target) {
+			cur = "."
+		}
+		parts = append(strings.Split(target, "/"), parts...)
+	}
+	return true
+}
+
+// depName strips any version constraint from a dependency.
+func depName(dep string) string {
+	if i := strings.IndexAny(dep, "<>=~"); i >= 0 {
+		return dep[:i]
+	}
+	return dep
+}
@@ -15,7 +15,9 @@
 package linter
 
 import (
+	"bufio"
 	"bytes"
+	"cmp"
 	"context"
 	"debug/elf"
 	"errors"
@@ -24,24 +26,47 @@ import (
 	"io/fs"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"slices"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/chainguard-dev/clog"
 	"github.com/dustin/go-humanize"
 	"golang.org/x/exp/maps"
+	"golang.org/x/sync/errgroup"
 	"gopkg.in/ini.v1"
 
 	"chainguard.dev/apko/pkg/apk/auth"
 	"chainguard.dev/apko/pkg/apk/expandapk"
+
+	"chainguard.dev/melange/pkg/util"
 )
 
-type linterFunc func(ctx context.Context, pkgname string, fsys fs.FS) error
+type linterFunc func(ctx context.Context, pkg *lintPackage, fsys fs.FS) error
+
+// lintPackage describes the package being linted, for linters that need to
+// know more about it than what is in its filesystem.
+type lintPackage struct {
+	options
+
+	// The name of the package.
+	name string
+	// The directory the package is in, if it is on disk.
+	dir string
+	// The filesystems of the other packages produced by the same build,
+	// keyed by package name.
+	siblings map[string]fs.FS
+}
 
 type linter struct {
-	LinterFunc      linterFunc
+	LinterFunc linterFunc
+	// FixFunc, if set, remediates what LinterFunc fails on.
+	FixFunc         fixFunc
 	Explain         string
 	defaultBehavior defaultBehavior
 }
@@ -57,24 +82,312 @@ const (
 	Warn
 )
 
-func allPaths(fn func(ctx context.Context, pkgname, path string) error) func(ctx context.Context, pkgname string, fsys fs.FS) error {
-	return func(ctx context.Context, pkgname string, fsys fs.FS) error {
-		return fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
-			if err := ctx.Err(); err != nil {
-				return err
-			}
+// Severity determines how a linter failure is reported.
+type Severity string
+
+const (
+	// SeverityError fails the build.
+	SeverityError Severity = "error"
+	// SeverityWarn logs the failure as a warning.
+	SeverityWarn Severity = "warn"
+	// SeverityInfo logs the failure as an informational message.
+	SeverityInfo Severity = "info"
+)
+
+// ParseSeverity returns the Severity named by s.
+func ParseSeverity(s string) (Severity, error) {
+	switch sev := Severity(s); sev {
+	case SeverityError, SeverityWarn, SeverityInfo:
+		return sev, nil
+	}
+	return "", fmt.Errorf("unknown linter severity %q (must be one of %q, %q or %q)", s, SeverityError, SeverityWarn, SeverityInfo)
+}
+
+type options struct {
+	linters      map[string]linter
+	rules        []Rule
+	plugins      map[string]string
+	fix          bool
+	baseline     *Baseline
+	severities   map[string]Severity
+	ignores      map[string][]string
+	report       *Report
+	runtime      []string
+	scriptlets   map[string]string
+	siblingDirs  map[string]string
+	workspaceDir string
+	noProvides   bool
+	rpaths       []string
+	roots        []string
+	maxFileSize  string
+	maxPkgSize   string
+	providers    map[string]Provider
+	environment  string
+	arch         string
+}
+
+// Option configures how a package is linted.
+type Option func(*options)
+
+// WithSeverities overrides the severity of the named linters. A linter named
+// here is run even if it was not passed as a required or warning linter.
+func WithSeverities(severities map[string]Severity) Option {
+	return func(o *options) {
+		o.severities = severities
+	}
+}
+
+// WithIgnores hides the paths matching the given globs from the named
+// linters. A "**" segment in a glob matches any number of directories.
+func WithIgnores(ignores map[string][]string) Option {
+	return func(o *options) {
+		o.ignores = ignores
+	}
+}
+
+// WithReport records every finding in the given report, in addition to
+// logging it or failing the build.
+func WithReport(report *Report) Option {
+	return func(o *options) {
+		o.report = report
+	}
+}
+
+// WithRuntimeDependencies declares the packages that the linted package
+// depends on at runtime.
+func WithRuntimeDependencies(deps []string) Option {
+	return func(o *options) {
+		o.runtime = deps
+	}
+}
+
+// WithScriptlets declares the scriptlets of the linted package, keyed by the
+// names of the control files apk reads them from, such as .post-install.
+func WithScriptlets(scriptlets map[string]string) Option {
+	return func(o *options) {
+		o.scriptlets = scriptlets
+	}
+}
+
+// WithNoProvides declares that the package is intentionally empty.
+func WithNoProvides(noProvides bool) Option {
+	return func(o *options) {
+		o.noProvides = noProvides
+	}
+}
+
+// WithRPaths allows the RPATH and RUNPATH directories matching the given
+// globs, on top of the standard library directories.
+func WithRPaths(rpaths []string) Option {
+	return func(o *options) {
+		o.rpaths = rpaths
+	}
+}
+
+// WithRoots allows the package to create the given top-level directories,
+// on top of the standard ones.
+func WithRoots(roots []string) Option {
+	return func(o *options) {
+		o.roots = roots
+	}
+}
+
+// WithSizeLimits overrides the largest file and the largest total size of
+// files that the size linter accepts, as sizes such as "100MB". Empty
+// limits keep the defaults.
+func WithSizeLimits(maxFileSize, maxPackageSize string) Option {
+	return func(o *options) {
+		o.maxFileSize = maxFileSize
+		o.maxPkgSize = maxPackageSize
+	}
+}
+
+// Provider describes what a package produced by the build provides, and how
+// it settles conflicts with other packages.
+type Provider struct {
+	// The packages and virtuals it provides, including generated ones.
+	Provides []string
+	// Its runtime dependencies, where "!foo" conflicts with foo.
+	Runtime []string
+	// The packages whose files it may replace.
+	Replaces []string
+	// Its provider priority, if it has one.
+	ProviderPriority string
+}
+
+// WithProviders gives what every package produced by the build provides, by
+// package name. Packages only know this once they have been emitted; see
+// LintProvides.
+func WithProviders(providers map[string]Provider) Option {
+	return func(o *options) {
+		o.providers = providers
+	}
+}
+
+// WithArch gives the architecture that the package was built for, as an apk
+// architecture such as "x86_64" or "aarch64".
+func WithArch(arch string) Option {
+	return func(o *options) {
+		o.arch = arch
+	}
+}
+
+// WithBuildEnvironment gives the directory that the build environment was
+// installed to, whose libraries the package can be assumed to find a
+// provider for at runtime.
+func WithBuildEnvironment(dir string) Option {
+	return func(o *options) {
+		o.environment = dir
+	}
+}
+
+// WithWorkspaceDir gives the directory the package was built in on the host,
+// so that linters can recognize it.
+func WithWorkspaceDir(dir string) Option {
+	return func(o *options) {
+		o.workspaceDir = dir
+	}
+}
+
+// WithSiblings gives the directories holding the other packages produced by
+// the same build, keyed by package name, so that linters can resolve
+// references between them.
+func WithSiblings(dirs map[string]string) Option {
+	return func(o *options) {
+		o.siblingDirs = dirs
+	}
+}
+
+// WithRules adds linters defined by the build configuration. They are run on
+// every package at their own severity, unless it is overridden.
+func WithRules(rules []Rule) Option {
+	return func(o *options) {
+		o.rules = rules
+	}
+}
+
+// WithPlugins adds linters implemented by external programs, keyed by
+// linter name. They are run on every package as warnings, unless their
+// severity is overridden.
+func WithPlugins(plugins map[string]string) Option {
+	return func(o *options) {
+		o.plugins = plugins
+	}
+}
+
+// WithFix lets linters that can remediate what they fail on change the
+// package, rather than fail. Only packages linted with LintBuild can be
+// fixed.
+func WithFix(fix bool) Option {
+	return func(o *options) {
+		o.fix = fix
+	}
+}
+
+// WithBaseline reports the findings in the baseline as warnings, even if
+// their linter is required.
+func WithBaseline(baseline *Baseline) Option {
+	return func(o *options) {
+		o.baseline = baseline
+	}
+}
+
+// applyOptions applies opts, and works out which linters are available.
+func applyOptions(opts []Option) (options, error) {
+	o := options{linters: linterMap}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if len(o.rules) == 0 && len(o.plugins) == 0 {
+		return o, nil
+	}
+	var errs []error
+	o.linters = maps.Clone(linterMap)
+	for _, r := range o.rules {
+		if _, found := linterMap[r.Name]; found {
+			errs = append(errs, fmt.Errorf("custom linter %q has the same name as a built-in linter", r.Name))
+			continue
+		}
+		l, err := r.compile()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("custom linter %q: %w", r.Name, err))
+			continue
+		}
+		o.linters[r.Name] = l
+	}
+	for name, program := range o.plugins {
+		if _, found := o.linters[name]; found {
+			errs = append(errs, fmt.Errorf("linter plugin %q has the same name as another linter", name))
+			continue
+		}
+		o.linters[name] = pluginLinter(name, program)
+	}
+	return o, errors.Join(errs...)
+}
+
+// linterSeverities returns the severity of each linter to run, given the required
+// and warning linters and any overrides.
+func (o options) linterSeverities(require, warn []string) map[string]Severity {
+	linters := make(map[string]Severity, len(o.rules)+len(o.plugins)+len(require)+len(warn)+len(o.severities))
+	for _, r := range o.rules {
+		linters[r.Name] = SeverityWarn
+		if r.Severity != "" {
+			linters[r.Name] = r.Severity
+		}
+	}
+	for name := range o.plugins {
+		linters[name] = SeverityWarn
+	}
+	for _, l := range warn {
+		linters[l] = SeverityWarn
+	}
+	for _, l := range require {
+		linters[l] = SeverityError
+	}
+	for l, sev := range o.severities {
+		linters[l] = sev
+	}
+	return linters
+}
+
+func allPaths(fn func(ctx context.Context, pkgname, path string) error) linterFunc {
+	return func(ctx context.Context, pkg *lintPackage, fsys fs.FS) error {
+		return walkPackage(ctx, fsys, func(path string, d fs.DirEntry) error {
 			if d.IsDir() {
 				// Ignore directories
 				return nil
 			}
-			if err := fn(ctx, pkgname, path); err != nil {
-				return fmt.Errorf("%w: %s", err, path)
+			if err := fn(ctx, pkg.name, path); err != nil {
+				return &pathError{path: path, err: err}
 			}
 			return nil
 		})
 	}
 }
 
+// walkPackage calls fn with every path in fsys, like fs.WalkDir. It carries on
+// after fn fails with a *pathError, so that every problem in the package is
+// reported at once, and stops at any other error, including ctx's.
+func walkPackage(ctx context.Context, fsys fs.FS, fn func(path string, d fs.DirEntry) error) error {
+	var errs []error
+	err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		var perr *pathError
+		if err := fn(path, d); !errors.As(err, &perr) {
+			return err
+		}
+		errs = append(errs, perr)
+		return nil
+	})
+	return errors.Join(append(errs, err)...)
+}
+
 func DefaultRequiredLinters() []string {
 	l := slices.DeleteFunc(maps.Keys(linterMap), func(k string) bool { return linterMap[k].defaultBehavior != Require })
 	slices.Sort(l)
@@ -88,11 +401,37 @@ func DefaultWarnLinters() []string {
 }
 
 var linterMap = map[string]linter{
+	"arch": {
+		LinterFunc:      allELFs(archLinter),
+		Explain:         "Make sure the build uses the cross compiler or target toolchain, and doesn't install prebuilt binaries or host tools for another architecture",
+		defaultBehavior: Warn,
+	},
+	"buildpath": {
+		LinterFunc:      buildPathLinter,
+		Explain:         "Make sure the build doesn't record where it ran, e.g. by passing -ffile-prefix-map or -trimpath, or by fixing up installed scripts and configuration",
+		defaultBehavior: Warn,
+	},
+	"crlf": {
+		LinterFunc:      crlfLinter,
+		Explain:         "Convert the files to Unix line endings and UTF-8 without a byte order mark in the pipeline, e.g. with dos2unix",
+		defaultBehavior: Warn,
+	},
 	"dev": {
 		LinterFunc:      allPaths(devLinter),
 		Explain:         "If this package is creating /dev nodes, it should use udev instead; otherwise, remove any files in /dev",
 		defaultBehavior: Require,
 	},
+	"devfiles": {
+		LinterFunc:      allPaths(devFilesLinter),
+		FixFunc:         removePaths(strayLibtoolArchive),
+		Explain:         "Move headers, static archives, libtool and pkg-config files into a -dev subpackage (see split/dev), or remove them",
+		defaultBehavior: Warn,
+	},
+	"docsplit": {
+		LinterFunc:      docSplitLinter,
+		Explain:         "Move the documentation into the -doc subpackage (see split/doc)",
+		defaultBehavior: Warn,
+	},
 	"documentation": {
 		LinterFunc:      allPaths(documentationLinter),
 		Explain:         "Place documentation into a separate package or remove it",
@@ -103,9 +442,19 @@ var linterMap = map[string]linter{
 		Explain:         "This package should be a -compat package",
 		defaultBehavior: Warn,
 	},
+	"multilib": {
+		LinterFunc:      multilibLinter,
+		Explain:         "Install libraries into /usr/lib, e.g. by passing --libdir=/usr/lib to configure or -DCMAKE_INSTALL_LIBDIR=lib to CMake",
+		defaultBehavior: Warn,
+	},
+	"needed": {
+		LinterFunc:      neededLinter,
+		Explain:         "Package the missing library, add a runtime dependency on the package that provides it, or stop linking against it",
+		defaultBehavior: Warn,
+	},
 	"object": {
 		LinterFunc:      allPaths(objectLinter),
-		Explain:         "This package contains intermediate object files",
+		Explain:         "This package contains intermediate build files; install only what is needed at runtime instead of copying the build tree",
 		defaultBehavior: Warn,
 	},
 	"sbom": {
@@ -113,21 +462,65 @@ var linterMap = map[string]linter{
 		Explain:         "Remove any files in /var/lib/db/sbom from the package",
 		defaultBehavior: Ignore, // TODO: needs work to be useful
 	},
+	"rpath": {
+		LinterFunc:      allELFs(rpathLinter),
+		Explain:         "Remove the RPATH/RUNPATH in the pipeline (e.g. with patchelf), make it relative to $ORIGIN, or allow the directory with checks.rpaths",
+		defaultBehavior: Warn,
+	},
+	"scriptlets": {
+		LinterFunc:      scriptletsLinter,
+		Explain:         "Start each scriptlet with a #! line, and add a runtime dependency on the package that provides its interpreter",
+		defaultBehavior: Warn,
+	},
+	// Only warn about secrets until existing packages with test fixtures have
+	// ignored them.
+	"secrets": {
+		LinterFunc:      secretsLinter,
+		Explain:         "Remove the credentials from the package and revoke them; if they are test fixtures, ignore their paths with checks.ignore",
+		defaultBehavior: Warn,
+	},
 	"setuidgid": {
 		LinterFunc:      isSetUIDOrGIDLinter,
+		FixFunc:         clearSetUIDOrGID,
 		Explain:         "Unset the setuid/setgid bit on the relevant files, or remove this linter",
 		defaultBehavior: Warn,
 	},
+	"shebang": {
+		LinterFunc:      shebangLinter,
+		Explain:         "Add a runtime dependency on the package that provides the interpreter, or fix the #! line",
+		defaultBehavior: Warn,
+	},
+	"size": {
+		LinterFunc:      sizeLinter,
+		Explain:         "Remove test data, debug binaries and other files that aren't needed at runtime, split them into a subpackage, or raise the limit with checks.max-file-size or checks.max-package-size",
+		defaultBehavior: Warn,
+	},
 	"srv": {
 		LinterFunc:      allPaths(srvLinter),
 		Explain:         "This package should be a -compat package",
 		defaultBehavior: Warn,
 	},
+	"textrel": {
+		LinterFunc:      allELFs(textrelLinter),
+		Explain:         "Build position-independent code (-fPIC), or fix the assembly that needs text relocations",
+		defaultBehavior: Warn,
+	},
+	"toplevel": {
+		LinterFunc:      topLevelLinter,
+		Explain:         "Install into the standard directories (e.g. /usr, /etc or /var), or allow the directory with checks.roots",
+		defaultBehavior: Warn,
+	},
 	"tempdir": {
 		LinterFunc:      allPaths(tempDirLinter),
+		FixFunc:         removePaths(tempDirLinter),
 		Explain:         "Remove any offending files in temporary dirs in the pipeline",
 		defaultBehavior: Require,
 	},
+	"usrmerge": {
+		LinterFunc:      usrMergeLinter,
+		Explain:         "Install into the matching directory under /usr (e.g. with --bindir, --sbindir or --libdir), and add a symlink from the old path if something needs it",
+		defaultBehavior: Ignore, // Only applies to usr-merged distributions.
+	},
 	"usrlocal": {
 		LinterFunc:      allPaths(usrLocalLinter),
 		Explain:         "This package should be a -compat package",
@@ -135,6 +528,7 @@ var linterMap = map[string]linter{
 	},
 	"varempty": {
 		LinterFunc:      allPaths(varEmptyLinter),
+		FixFunc:         removePaths(varEmptyLinter),
 		Explain:         "Remove any offending files in /var/empty in the pipeline",
 		defaultBehavior: Require,
 	},
@@ -143,20 +537,65 @@ var linterMap = map[string]linter{
 		Explain:         "Change the permissions of any world-writeable files in the package, disable the linter, or make this a -compat package",
 		defaultBehavior: Warn,
 	},
+	"permissions": {
+		LinterFunc:      permissionsLinter,
+		Explain:         "Fix the permissions in the pipeline, or ignore the paths that legitimately need them with checks.ignore",
+		defaultBehavior: Warn,
+	},
+	"symlink": {
+		LinterFunc:      symlinkLinter,
+		Explain:         "Fix the link target, or add a runtime dependency on the package that provides it",
+		defaultBehavior: Warn,
+	},
 	"strip": {
-		LinterFunc:      strippedLinter,
+		LinterFunc:      allELFs(strippedLinter),
 		Explain:         "Properly strip all binaries in the pipeline",
 		defaultBehavior: Warn,
 	},
+	"filename": {
+		LinterFunc:      allPaths(fileNameLinter),
+		Explain:         "Rename files whose names aren't valid UTF-8 or contain control characters in the pipeline",
+		defaultBehavior: Require,
+	},
+	"hardening/pie": {
+		LinterFunc:      allELFs(pieLinter),
+		Explain:         "Build executables as position-independent (-fPIE -pie, or -buildmode=pie for Go), or opt out with checks.severity",
+		defaultBehavior: Warn,
+	},
+	"hardening/relro": {
+		LinterFunc:      allELFs(relroLinter),
+		Explain:         "Link with -Wl,-z,relro,-z,now, or opt out with checks.severity",
+		defaultBehavior: Warn,
+	},
+	"hardening/stackprotector": {
+		LinterFunc:      allELFs(stackProtectorLinter),
+		Explain:         "Compile with -fstack-protector-strong, or opt out with checks.severity",
+		defaultBehavior: Ignore,
+	},
 	"infodir": {
 		LinterFunc:      allPaths(infodirLinter),
 		Explain:         "Remove /usr/share/info/dir from the package (run split/infodir)",
 		defaultBehavior: Require,
 	},
+	"duplicate": {
+		LinterFunc:      duplicateLinter,
+		Explain:         "Make sure each file is installed into only one of the packages produced by the build",
+		defaultBehavior: Warn,
+	},
 	"empty": {
 		LinterFunc:      emptyLinter,
-		Explain:         "Verify that this package is supposed to be empty; if it is, disable this linter; otherwise check the build",
-		defaultBehavior: Ignore, // TODO: Needs to ignore packages that specify no-provides.
+		Explain:         "Check the destination paths in the pipeline; if this package is supposed to be empty, set options.no-provides",
+		defaultBehavior: Warn,
+	},
+	"provides": {
+		LinterFunc:      providesLinter,
+		Explain:         "Make sure only one package provides each name, or settle which one wins with provider-priority, replaces or a !conflict runtime dependency",
+		defaultBehavior: Warn,
+	},
+	"python/bytecode": {
+		LinterFunc:      allPaths(pythonBytecodeLinter),
+		Explain:         "Remove the bytecode from the package, or generate it deterministically with python -m compileall --invalidation-mode unchecked-hash",
+		defaultBehavior: Ignore, // Lots of packages ship bytecode on purpose.
 	},
 	"python/docs": {
 		LinterFunc:      pythonDocsLinter,
@@ -175,6 +614,20 @@ var linterMap = map[string]linter{
 	},
 }
 
+// pathError records the path a linter found a problem at.
+type pathError struct {
+	path string
+	err  error
+}
+
+func (e *pathError) Error() string {
+	return fmt.Sprintf("%s: %s", e.err, e.path)
+}
+
+func (e *pathError) Unwrap() error {
+	return e.err
+}
+
 // Determine if a path should be ignored by a linter
 func isIgnoredPath(path string) bool {
 	return strings.HasPrefix(path, "var/lib/db/sbom/")
@@ -194,10 +647,24 @@ func optLinter(_ context.Context, _, path string) error {
 
 	return nil
 }
+
+// Files and directories that build systems leave in the build tree.
+var (
+	isObjectExtRegex        = regexp.MustCompile(`\.(o|lo|gcno|gcda)$`)
+	isBuildArtifactRegex    = regexp.MustCompile(`(^|/)(CMakeCache\.txt|cmake_install\.cmake|CTestTestfile\.cmake|config\.log|config\.status)$`)
+	isBuildArtifactDirRegex = regexp.MustCompile(`(^|/)(CMakeFiles|\.deps|\.libs)/`)
+)
+
+// objectLinter flags object files, coverage notes and the CMake and
+// Autotools files that escaped from the build tree, which usually means the
+// pipeline installed with an over-broad cp -r or install -D.
 func objectLinter(_ context.Context, _, path string) error {
-	if filepath.Ext(path) == ".o" {
+	if isObjectExtRegex.MatchString(path) {
 		return fmt.Errorf("package contains intermediate object file %q. This is usually wrong. In most cases they should be removed", path)
 	}
+	if isBuildArtifactRegex.MatchString(path) || isBuildArtifactDirRegex.MatchString(path) {
+		return fmt.Errorf("package contains build system file %q. This is usually wrong. In most cases they should be removed", path)
+	}
 	return nil
 }
 
@@ -210,14 +677,50 @@ func documentationLinter(_ context.Context, pkgname, path string) error {
 	return nil
 }
 
-func isSetUIDOrGIDLinter(ctx context.Context, _ string, fsys fs.FS) error {
-	return fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
-		if err := ctx.Err(); err != nil {
-			return err
+var isDocPathRegex = regexp.MustCompile(`^usr/share/(doc|info|man)/`)
+
+// docSplitLinter flags documentation that was left behind in a package that
+// has a -doc subpackage.
+func docSplitLinter(ctx context.Context, pkg *lintPackage, fsys fs.FS) error {
+	if _, ok := pkg.siblings[pkg.name+"-doc"]; !ok {
+		return nil
+	}
+	return walkPackage(ctx, fsys, func(path string, d fs.DirEntry) error {
+		if d.IsDir() {
+			return nil
 		}
-		if err != nil {
-			return err
+		if isDocPathRegex.MatchString(path) {
+			return &pathError{path: path, err: fmt.Errorf("documentation belongs in %s-doc", pkg.name)}
 		}
+		return nil
+	})
+}
+
+var isPkgConfigFileRegex = regexp.MustCompile(`^usr/(lib|share)/pkgconfig/[^/]+\.pc$`)
+
+func devFilesLinter(_ context.Context, pkgname, path string) error {
+	if strings.HasSuffix(pkgname, "-dev") {
+		return nil
+	}
+	switch filepath.Ext(path) {
+	case ".h":
+		return errors.New("package contains headers but is not a -dev package")
+	case ".a":
+		// Static libraries may also go into their own -static package.
+		if !strings.HasSuffix(pkgname, "-static") {
+			return errors.New("package contains static archives but is not a -dev or -static package")
+		}
+	case ".la":
+		return errors.New("package contains libtool archives but is not a -dev package")
+	}
+	if isPkgConfigFileRegex.MatchString(path) {
+		return errors.New("package contains pkg-config files but is not a -dev package")
+	}
+	return nil
+}
+
+func isSetUIDOrGIDLinter(ctx context.Context, _ *lintPackage, fsys fs.FS) error {
+	return walkPackage(ctx, fsys, func(path string, d fs.DirEntry) error {
 		if isIgnoredPath(path) {
 			return nil
 		}
@@ -229,9 +732,9 @@ func isSetUIDOrGIDLinter(ctx context.Context, _ string, fsys fs.FS) error {
 
 		mode := info.Mode()
 		if mode&fs.ModeSetuid != 0 {
-			return fmt.Errorf("file is setuid")
+			return &pathError{path: path, err: errors.New("file is setuid")}
 		} else if mode&fs.ModeSetgid != 0 {
-			return fmt.Errorf("file is setgid")
+			return &pathError{path: path, err: errors.New("file is setgid")}
 		}
 		return nil
 	})
@@ -244,111 +747,894 @@ func sbomLinter(_ context.Context, _, path string) error {
 	return nil
 }
 
-func infodirLinter(_ context.Context, _, path string) error {
-	if strings.HasPrefix(path, "usr/share/info/dir/") {
-		return fmt.Errorf("package writes to /usr/share/info/dir/")
+func infodirLinter(_ context.Context, _, path string) error {
+	if strings.HasPrefix(path, "usr/share/info/dir/") {
+		return fmt.Errorf("package writes to /usr/share/info/dir/")
+	}
+	return nil
+}
+
+func srvLinter(_ context.Context, _, path string) error {
+	if strings.HasPrefix(path, "srv/") {
+		return fmt.Errorf("package writes to /srv")
+	}
+	return nil
+}
+
+var isTempDirRegex = regexp.MustCompile("^(var/)?(tmp|run)/")
+
+func tempDirLinter(_ context.Context, _, path string) error {
+	if isTempDirRegex.MatchString(path) {
+		return fmt.Errorf("package writes to a temp dir")
+	}
+	return nil
+}
+
+// fileNameLinter flags paths that aren't valid UTF-8 or contain control
+// characters (including newlines), which the APKINDEX and most tools that
+// consume it can't represent.
+func fileNameLinter(_ context.Context, _, path string) error {
+	if !utf8.ValidString(path) {
+		return fmt.Errorf("path is not valid UTF-8: %q", path)
+	}
+	if i := strings.IndexFunc(path, unicode.IsControl); i >= 0 {
+		return fmt.Errorf("path contains control character %U: %q", []rune(path[i:])[0], path)
+	}
+	return nil
+}
+
+func usrLocalLinter(_ context.Context, _, path string) error {
+	if strings.HasPrefix(path, "usr/local/") {
+		return fmt.Errorf("/usr/local path found in non-compat package")
+	}
+	return nil
+}
+
+func varEmptyLinter(_ context.Context, _, path string) error {
+	if strings.HasPrefix(path, "var/empty/") {
+		return fmt.Errorf("package writes to /var/empty")
+	}
+	return nil
+}
+
+func worldWriteableLinter(ctx context.Context, _ *lintPackage, fsys fs.FS) error {
+	return walkPackage(ctx, fsys, func(path string, d fs.DirEntry) error {
+		if isIgnoredPath(path) {
+			return nil
+		}
+
+		if !d.Type().IsRegular() { // Don't worry about non-files
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		mode := info.Mode()
+		if mode&0002 != 0 {
+			if mode&0111 != 0 {
+				return &pathError{path: path, err: errors.New("world-writeable executable file found in package (security risk)")}
+			}
+			return &pathError{path: path, err: errors.New("world-writeable file found in package")}
+		}
+		return nil
+	})
+}
+
+// permissionsLinter flags dangerous modes that worldWriteableLinter doesn't:
+// world-writeable directories that anybody can delete other users' files from,
+// files with a blanket 0777 mode, and sticky bits on non-directories, which
+// have no effect on Linux and usually indicate a mistyped mode.
+func permissionsLinter(ctx context.Context, _ *lintPackage, fsys fs.FS) error {
+	return walkPackage(ctx, fsys, func(path string, d fs.DirEntry) error {
+		if isIgnoredPath(path) || path == "." {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		mode := info.Mode()
+		switch {
+		case mode.IsDir():
+			// Sticky world-writeable directories are fine, that's how /tmp works.
+			if mode&0002 != 0 && mode&fs.ModeSticky == 0 {
+				return &pathError{path: path, err: errors.New("world-writeable directory without the sticky bit")}
+			}
+		case mode.IsRegular():
+			if mode.Perm() == 0777 {
+				return &pathError{path: path, err: errors.New("file has mode 0777")}
+			}
+			if mode&fs.ModeSticky != 0 {
+				return &pathError{path: path, err: errors.New("sticky bit set on a file")}
+			}
+		}
+		return nil
+	})
+}
+
+// symlinkLinter flags relative links that climb out of the package root, and
+// links whose targets are in neither the package nor the packages built
+// alongside it. Links into dependencies from elsewhere can't be checked, so
+// dangling links are only reported for packages whose runtime dependencies
+// are all part of the same build.
+func symlinkLinter(ctx context.Context, pkg *lintPackage, fsys fs.FS) error {
+	rl, ok := fsys.(readlinkFS)
+	if !ok {
+		return fmt.Errorf("reading symlinks is not supported by %T", fsys)
+	}
+
+	checkDangling := true
+	for _, dep := range pkg.runtime {
+		if strings.HasPrefix(dep, "!") {
+			// Conflicts don't provide anything.
+			continue
+		}
+		if _, ok := pkg.siblings[depName(dep)]; !ok {
+			checkDangling = false
+			break
+		}
+	}
+
+	return walkPackage(ctx, fsys, func(p string, d fs.DirEntry) error {
+		if isIgnoredPath(p) || d.Type()&fs.ModeSymlink == 0 {
+			return nil
+		}
+
+		target, err := rl.Readlink(p)
+		if err != nil {
+			return fmt.Errorf("reading link %s: %w", p, err)
+		}
+
+		resolved := target
+		if !path.IsAbs(target) {
+			resolved = path.Join(path.Dir(p), target)
+			if resolved == ".." || strings.HasPrefix(resolved, "../") {
+				return &pathError{path: p, err: fmt.Errorf("symlink target %q is outside the package root", target)}
+			}
+		}
+
+		if !checkDangling || resolve(fsys, p) {
+			return nil
+		}
+		for _, sibling := range pkg.siblings {
+			if resolve(sibling, resolved) {
+				return nil
+			}
+		}
+		return &pathError{path: p, err: fmt.Errorf("dangling symlink to %q", target)}
+	})
+}
+
+// Interpreters that scripts in /usr/bin and /bin don't get a generated
+// dependency on, because no package provides them as a command.
+var shebangIgnoredBySCA = []string{"awk", "python", "python3", "sh"}
+
+// Packages that provide commands under a different name.
+var commandProviders = map[string][]string{
+	"awk": {"busybox", "gawk", "mawk"},
+	"sh":  {"bash", "busybox", "dash"},
+}
+
+// shebangLinter flags executable scripts whose interpreter is in neither the
+// package, the packages built alongside it, nor its runtime dependencies,
+// which leaves them unrunnable once installed. Scripts in /usr/bin and /bin
+// get a dependency on most interpreters generated at packaging time, so
+// those aren't checked.
+func shebangLinter(ctx context.Context, pkg *lintPackage, fsys fs.FS) error {
+	return walkPackage(ctx, fsys, func(p string, d fs.DirEntry) error {
+		if isIgnoredPath(p) || !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.Mode()&0111 == 0 {
+			return nil
+		}
+
+		f, err := fsys.Open(p)
+		if err != nil {
+			return fmt.Errorf("opening file: %w", err)
+		}
+		defer f.Close()
+
+		interp, err := readShebang(f)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", p, err)
+		}
+		if interp == "" {
+			return nil
+		}
+
+		cmd := path.Base(interp)
+		if (strings.HasPrefix(p, "usr/bin/") || strings.HasPrefix(p, "bin/")) && !slices.Contains(shebangIgnoredBySCA, cmd) {
+			return nil
+		}
+		if providesInterpreter(pkg, fsys, interp) {
+			return nil
+		}
+		return &pathError{path: p, err: fmt.Errorf("interpreter %s is not provided by the package or its runtime dependencies", interp)}
+	})
+}
+
+// scriptletsLinter flags scriptlets that have no #! line, or whose
+// interpreter is in neither the package, the packages built alongside it, nor
+// its runtime dependencies, which apk fails to run once they're installed.
+func scriptletsLinter(_ context.Context, pkg *lintPackage, fsys fs.FS) error {
+	names := maps.Keys(pkg.scriptlets)
+	slices.Sort(names)
+
+	var errs []error
+	for _, name := range names {
+		interp, err := readShebang(strings.NewReader(pkg.scriptlets[name]))
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", name, err)
+		}
+		switch {
+		case interp == "":
+			errs = append(errs, &pathError{path: name, err: errors.New("scriptlet has no #! line")})
+		case !providesInterpreter(pkg, fsys, interp):
+			errs = append(errs, &pathError{path: name, err: fmt.Errorf("interpreter %s is not provided by the package or its runtime dependencies", interp)})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// readShebang returns the interpreter named by the #! line at the start of
+// r: an absolute path, or the name of a command that /usr/bin/env looks up.
+// It returns "" for files that aren't scripts.
+func readShebang(r io.Reader) (string, error) {
+	line, err := bufio.NewReader(io.LimitReader(r, 256)).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	if !strings.HasPrefix(line, "#!") {
+		return "", nil
+	}
+
+	fields := strings.Fields(line[2:])
+	if len(fields) == 0 {
+		return "", nil
+	}
+	if path.Base(fields[0]) != "env" {
+		return fields[0], nil
+	}
+
+	// Skip env's options and variable assignments.
+	for _, field := range fields[1:] {
+		if !strings.HasPrefix(field, "-") && !strings.Contains(field, "=") {
+			return field, nil
+		}
+	}
+	return fields[0], nil
+}
+
+func providesInterpreter(pkg *lintPackage, fsys fs.FS, interp string) bool {
+	candidates := []string{interp}
+	if !path.IsAbs(interp) {
+		candidates = []string{"usr/bin/" + interp, "bin/" + interp, "usr/sbin/" + interp, "sbin/" + interp}
+	}
+	for _, candidate := range candidates {
+		if resolve(fsys, candidate) {
+			return true
+		}
+		for _, sibling := range pkg.siblings {
+			if resolve(sibling, candidate) {
+				return true
+			}
+		}
+	}
+
+	cmd := path.Base(interp)
+	// A versioned interpreter, such as python3.12, is usually provided by a
+	// package named after the language, such as python-3.12.
+	stem := strings.TrimRight(cmd, "0123456789.")
+	for _, dep := range pkg.runtime {
+		name := depName(dep)
+		switch {
+		case name == cmd, name == "cmd:"+cmd, slices.Contains(commandProviders[cmd], name):
+			return true
+		case stem != "" && strings.HasPrefix(name, stem):
+			return true
+		}
+	}
+	return false
+}
+
+var elfMagic = []byte{'\x7f', 'E', 'L', 'F'}
+
+var isObjectFileRegex = regexp.MustCompile(`\.(a|so|dylib)(\..*)?`)
+
+// allELFs calls fn with every executable and shared object in the package
+// that is an ELF file.
+func allELFs(fn func(ctx context.Context, pkg *lintPackage, path string, file *elf.File) error) linterFunc {
+	return func(ctx context.Context, pkg *lintPackage, fsys fs.FS) error {
+		return walkPackage(ctx, fsys, func(path string, d fs.DirEntry) error {
+			if isIgnoredPath(path) {
+				return nil
+			}
+			// Debug info split out of ELF files, such as by split/debug, is
+			// meant to keep what they're stripped of, and isn't run or
+			// loaded.
+			if strings.HasPrefix(path, "usr/lib/debug/") {
+				return nil
+			}
+
+			if !d.Type().IsRegular() {
+				// Don't worry about non-files
+				return nil
+			}
+
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+
+			if info.Size() < int64(len(elfMagic)) {
+				// This is definitely not an ELF file.
+				return nil
+			}
+
+			ext := filepath.Ext(path)
+			mode := info.Mode()
+			if mode&0111 == 0 && !isObjectFileRegex.MatchString(ext) {
+				// Not an executable or library
+				return nil
+			}
+
+			f, err := fsys.Open(path)
+			if err != nil {
+				return fmt.Errorf("opening file: %w", err)
+			}
+			defer f.Close()
+
+			// Both os.DirFS and go-apk return a file that implements ReaderAt.
+			// We don't have any other callers, so this should never fail.
+			readerAt, ok := f.(io.ReaderAt)
+			if !ok {
+				return fmt.Errorf("fs.File does not impl ReaderAt: %T", f)
+			}
+
+			hdr := make([]byte, len(elfMagic))
+			if _, err := readerAt.ReadAt(hdr, 0); err != nil {
+				return fmt.Errorf("failed to read %d bytes for magic ELF header: %w", len(elfMagic), err)
+			}
+
+			if !bytes.Equal(elfMagic, hdr) {
+				// No magic header, definitely not ELF.
+				return nil
+			}
+
+			file, err := elf.NewFile(readerAt)
+			if err != nil {
+				return fmt.Errorf("Could not open file %q as executable: %v\n", path, err)
+			}
+			defer file.Close()
+
+			if err := fn(ctx, pkg, path, file); err != nil {
+				return &pathError{path: path, err: err}
+			}
+			return nil
+		})
+	}
+}
+
+func strippedLinter(_ context.Context, _ *lintPackage, _ string, file *elf.File) error {
+	// No debug sections or symbol tables allowed
+	for _, sec := range file.Sections {
+		switch {
+		case strings.HasPrefix(sec.Name, ".debug"), strings.HasPrefix(sec.Name, ".zdebug"):
+			return fmt.Errorf("ELF file is not stripped (has %s section)", sec.Name)
+		case sec.Type == elf.SHT_SYMTAB:
+			return errors.New("ELF file is not stripped (has a symbol table)")
+		}
+	}
+	return nil
+}
+
+// textrelLinter flags shared objects and PIE executables with text
+// relocations, which need writeable code pages and are refused by hardened
+// kernels. They usually mean upstream stopped compiling with -fPIC.
+func textrelLinter(_ context.Context, _ *lintPackage, _ string, file *elf.File) error {
+	if file.Type != elf.ET_DYN {
+		return nil
+	}
+
+	textrel, err := file.DynValue(elf.DT_TEXTREL)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", elf.DT_TEXTREL, err)
+	}
+	flags, err := file.DynValue(elf.DT_FLAGS)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", elf.DT_FLAGS, err)
+	}
+	if len(textrel) > 0 || slices.ContainsFunc(flags, func(f uint64) bool { return elf.DynFlag(f)&elf.DF_TEXTREL != 0 }) {
+		return errors.New("ELF file has text relocations")
+	}
+	return nil
+}
+
+// Top-level directories that any package may create.
+var standardRoots = []string{"bin", "boot", "etc", "home", "lib", "opt", "sbin", "srv", "usr", "var"}
+
+// topLevelLinter flags files in the root directory, and top-level
+// directories that are neither standard nor allowed in checks.roots.
+func topLevelLinter(_ context.Context, pkg *lintPackage, fsys fs.FS) error {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, e := range entries {
+		name := e.Name()
+		switch {
+		case !e.IsDir() && e.Type()&fs.ModeSymlink == 0:
+			errs = append(errs, &pathError{path: name, err: errors.New("file in the root directory")})
+		case slices.Contains(standardRoots, name), slices.Contains(pkg.roots, name):
+			// Allowed
+		default:
+			errs = append(errs, &pathError{path: name, err: fmt.Errorf("non-standard top-level directory /%s", name)})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+var isUnmergedPathRegex = regexp.MustCompile("^(bin|sbin|lib)/")
+
+// usrMergeLinter flags files installed into /bin, /sbin or /lib, which
+// usr-merged distributions replace with links into /usr. Links are allowed,
+// since those are how compatibility paths are provided.
+func usrMergeLinter(ctx context.Context, _ *lintPackage, fsys fs.FS) error {
+	return walkPackage(ctx, fsys, func(path string, d fs.DirEntry) error {
+		if d.IsDir() || d.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+		if isUnmergedPathRegex.MatchString(path) {
+			return &pathError{path: path, err: fmt.Errorf("file should be in /usr/%s", path)}
+		}
+		return nil
+	})
+}
+
+var isMultilibPathRegex = regexp.MustCompile("^(usr/)?lib(32|64)/")
+
+// multilibLinter flags files installed into lib64 or lib32 directories,
+// which nothing searches, since everything goes into /usr/lib. Links are
+// allowed, since the ABI fixes some paths, such as the dynamic loader's.
+func multilibLinter(ctx context.Context, _ *lintPackage, fsys fs.FS) error {
+	return walkPackage(ctx, fsys, func(path string, d fs.DirEntry) error {
+		if d.IsDir() || d.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+		if isMultilibPathRegex.MatchString(path) {
+			return &pathError{path: path, err: errors.New("file in a multilib directory")}
+		}
+		return nil
+	})
+}
+
+// The largest file and package that the size linter accepts by default.
+const (
+	defaultMaxFileSize    = "100MB"
+	defaultMaxPackageSize = "1GB"
+)
+
+// sizeLimits returns the largest file and package size that the size linter
+// accepts.
+func (o options) sizeLimits() (uint64, uint64, error) {
+	maxFile, err := humanize.ParseBytes(cmp.Or(o.maxFileSize, defaultMaxFileSize))
+	if err != nil {
+		return 0, 0, fmt.Errorf("parsing max file size: %w", err)
+	}
+	maxPkg, err := humanize.ParseBytes(cmp.Or(o.maxPkgSize, defaultMaxPackageSize))
+	if err != nil {
+		return 0, 0, fmt.Errorf("parsing max package size: %w", err)
+	}
+	return maxFile, maxPkg, nil
+}
+
+// sizeLinter flags files, and packages whose files add up to, more than the
+// configured limits, which usually means test datasets or unstripped debug
+// builds were installed by accident.
+func sizeLinter(ctx context.Context, pkg *lintPackage, fsys fs.FS) error {
+	maxFile, maxPkg, err := pkg.sizeLimits()
+	if err != nil {
+		return err
+	}
+
+	var total uint64
+	err = walkPackage(ctx, fsys, func(path string, d fs.DirEntry) error {
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size := uint64(info.Size())
+		total += size
+		if size > maxFile {
+			return &pathError{path: path, err: fmt.Errorf("file is %s, which is more than %s", humanize.Bytes(size), humanize.Bytes(maxFile))}
+		}
+		return nil
+	})
+	if total > maxPkg {
+		err = errors.Join(err, fmt.Errorf("package is %s, which is more than %s", humanize.Bytes(total), humanize.Bytes(maxPkg)))
+	}
+	return err
+}
+
+// Libraries that the C library provides, which packages don't declare
+// dependencies on.
+var isLibcSonameRegex = regexp.MustCompile(`^(ld-linux[^/]*\.so\.[0-9]+|ld-musl-[^/]+\.so\.1|libc\.musl-[^/]+\.so\.1|lib(c|m|dl|rt|pthread|util|resolv|anl|crypt|nsl)\.so\.[0-9]+)$`)
+
+// neededLinter flags DT_NEEDED libraries that neither the package, the
+// packages built alongside it, its so: runtime dependencies nor the build
+// environment provide. A library that was only in the build workspace, such
+// as one built in-tree but never installed, is missing once the package is
+// installed. Without the build environment, nothing can be concluded about
+// libraries from elsewhere, so only packages linted as part of a build are
+// checked.
+func neededLinter(ctx context.Context, pkg *lintPackage, fsys fs.FS) error {
+	if pkg.environment == "" {
+		return nil
+	}
+
+	// Libraries can be found through RPATHs or links from anywhere in the
+	// package and its siblings, so any file with the right name will do.
+	fileNames := map[string]bool{}
+	for _, f := range append([]fs.FS{fsys}, maps.Values(pkg.siblings)...) {
+		if err := fs.WalkDir(f, ".", func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() {
+				fileNames[d.Name()] = true
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	env := dirFS(pkg.environment)
+
+	return allELFs(func(_ context.Context, pkg *lintPackage, _ string, file *elf.File) error {
+		needed, err := file.DynString(elf.DT_NEEDED)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", elf.DT_NEEDED, err)
+		}
+
+		var missing []string
+		for _, soname := range needed {
+			provided := isLibcSonameRegex.MatchString(soname) ||
+				fileNames[soname] ||
+				slices.ContainsFunc(pkg.runtime, func(dep string) bool { return depName(dep) == "so:"+soname }) ||
+				resolve(env, "usr/lib/"+soname) ||
+				resolve(env, "lib/"+soname)
+			if !provided {
+				missing = append(missing, soname)
+			}
+		}
+		if len(missing) > 0 {
+			return fmt.Errorf("ELF file needs %s, which nothing provides", strings.Join(missing, ", "))
+		}
+		return nil
+	})(ctx, pkg, fsys)
+}
+
+// isExecutable reports whether file is a program rather than a library:
+// either it isn't relocatable, or it asks for an interpreter.
+func isExecutable(file *elf.File) bool {
+	if file.Type == elf.ET_EXEC {
+		return true
 	}
-	return nil
+	return slices.ContainsFunc(file.Progs, func(p *elf.Prog) bool { return p.Type == elf.PT_INTERP })
 }
 
-func srvLinter(_ context.Context, _, path string) error {
-	if strings.HasPrefix(path, "srv/") {
-		return fmt.Errorf("package writes to /srv")
+// pieLinter flags executables that aren't position-independent, and so
+// can't have their address randomized.
+func pieLinter(_ context.Context, _ *lintPackage, _ string, file *elf.File) error {
+	if file.Type == elf.ET_EXEC {
+		return errors.New("executable is not position-independent (PIE)")
 	}
 	return nil
 }
 
-var isTempDirRegex = regexp.MustCompile("^(var/)?(tmp|run)/")
+// relroLinter flags dynamically linked executables and shared objects
+// without full RELRO, whose GOT stays writeable.
+func relroLinter(_ context.Context, _ *lintPackage, _ string, file *elf.File) error {
+	if file.Section(".dynamic") == nil {
+		// Statically linked
+		return nil
+	}
+	if !slices.ContainsFunc(file.Progs, func(p *elf.Prog) bool { return p.Type == elf.PT_GNU_RELRO }) {
+		return errors.New("ELF file has no RELRO segment")
+	}
 
-func tempDirLinter(_ context.Context, _, path string) error {
-	if isTempDirRegex.MatchString(path) {
-		return fmt.Errorf("package writes to a temp dir")
+	bindNow, err := file.DynValue(elf.DT_BIND_NOW)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", elf.DT_BIND_NOW, err)
+	}
+	flags, err := file.DynValue(elf.DT_FLAGS)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", elf.DT_FLAGS, err)
+	}
+	flags1, err := file.DynValue(elf.DT_FLAGS_1)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", elf.DT_FLAGS_1, err)
+	}
+	if len(bindNow) == 0 &&
+		!slices.ContainsFunc(flags, func(f uint64) bool { return elf.DynFlag(f)&elf.DF_BIND_NOW != 0 }) &&
+		!slices.ContainsFunc(flags1, func(f uint64) bool { return elf.DynFlag1(f)&elf.DF_1_NOW != 0 }) {
+		return errors.New("ELF file has partial RELRO (not linked with -z now)")
 	}
 	return nil
 }
 
-func usrLocalLinter(_ context.Context, _, path string) error {
-	if strings.HasPrefix(path, "usr/local/") {
-		return fmt.Errorf("/usr/local path found in non-compat package")
+// stackProtectorLinter flags dynamically linked C and C++ executables that
+// don't call into the stack protector. Go binaries never use it.
+func stackProtectorLinter(_ context.Context, _ *lintPackage, _ string, file *elf.File) error {
+	if !isExecutable(file) || file.Section(".dynamic") == nil || file.Section(".note.go.buildid") != nil {
+		return nil
+	}
+
+	syms, err := file.ImportedSymbols()
+	if err != nil && !errors.Is(err, elf.ErrNoSymbols) {
+		return fmt.Errorf("reading imported symbols: %w", err)
+	}
+	if !slices.ContainsFunc(syms, func(sym elf.ImportedSymbol) bool {
+		return sym.Name == "__stack_chk_fail" || sym.Name == "__stack_chk_guard"
+	}) {
+		return errors.New("executable is not built with the stack protector")
 	}
 	return nil
 }
 
-func varEmptyLinter(_ context.Context, _, path string) error {
-	if strings.HasPrefix(path, "var/empty/") {
-		return fmt.Errorf("package writes to /var/empty")
+// The ELF machine and class of each apk architecture.
+var archMachines = map[string]struct {
+	machine elf.Machine
+	class   elf.Class
+}{
+	"aarch64":     {elf.EM_AARCH64, elf.ELFCLASS64},
+	"armhf":       {elf.EM_ARM, elf.ELFCLASS32},
+	"armv7":       {elf.EM_ARM, elf.ELFCLASS32},
+	"loongarch64": {elf.EM_LOONGARCH, elf.ELFCLASS64},
+	"ppc64le":     {elf.EM_PPC64, elf.ELFCLASS64},
+	"riscv64":     {elf.EM_RISCV, elf.ELFCLASS64},
+	"s390x":       {elf.EM_S390, elf.ELFCLASS64},
+	"x86":         {elf.EM_386, elf.ELFCLASS32},
+	"x86_64":      {elf.EM_X86_64, elf.ELFCLASS64},
+}
+
+// archLinter flags executables and shared objects built for a machine other
+// than the package's architecture, which usually means host tools or
+// prebuilt binaries ended up in a cross-built package. BPF objects run in
+// the kernel, so they are fine everywhere.
+func archLinter(_ context.Context, pkg *lintPackage, _ string, file *elf.File) error {
+	if pkg.arch == "" || file.Machine == elf.EM_BPF {
+		return nil
+	}
+	if pkg.arch == "noarch" {
+		return fmt.Errorf("ELF file for %s in a noarch package", file.Machine)
+	}
+	want, ok := archMachines[pkg.arch]
+	if !ok {
+		return nil
+	}
+	if file.Machine != want.machine || file.Class != want.class {
+		return fmt.Errorf("ELF file is for %s (%s), not %s", file.Machine, file.Class, pkg.arch)
 	}
 	return nil
 }
 
-func worldWriteableLinter(ctx context.Context, pkgname string, fsys fs.FS) error {
-	return fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
-		if err := ctx.Err(); err != nil {
-			return err
-		}
+// Directories that a library search path may always contain.
+var standardRPaths = []string{"/lib", "/usr/lib"}
 
+// rpathLinter flags RPATH and RUNPATH entries that point anywhere other than
+// the standard library directories, paths relative to $ORIGIN and
+// directories the package allows in checks.rpaths. Entries pointing into
+// the build workspace are called out, since they never exist at runtime.
+func rpathLinter(_ context.Context, pkg *lintPackage, _ string, file *elf.File) error {
+	for _, tag := range []elf.DynTag{elf.DT_RPATH, elf.DT_RUNPATH} {
+		values, err := file.DynString(tag)
 		if err != nil {
-			return err
+			return fmt.Errorf("reading %s: %w", tag, err)
 		}
-		if isIgnoredPath(path) {
-			return nil
+		for _, value := range values {
+			for _, dir := range strings.Split(value, ":") {
+				if err := checkRPath(pkg, dir); err != nil {
+					return fmt.Errorf("%s %q: %w", tag, value, err)
+				}
+			}
 		}
+	}
+	return nil
+}
 
-		if !d.Type().IsRegular() { // Don't worry about non-files
+func checkRPath(pkg *lintPackage, dir string) error {
+	switch {
+	case dir == "":
+		return errors.New("empty entry searches the current directory")
+	case strings.HasPrefix(dir, "$ORIGIN"), strings.HasPrefix(dir, "${ORIGIN}"):
+		return nil
+	case !path.IsAbs(dir):
+		return fmt.Errorf("%s is relative to the current directory", dir)
+	case dir == "/home/build" || strings.HasPrefix(dir, "/home/build/"):
+		return fmt.Errorf("%s is in the build workspace", dir)
+	}
+
+	dir = path.Clean(dir)
+	if slices.Contains(standardRPaths, dir) {
+		return nil
+	}
+	for _, pattern := range pkg.rpaths {
+		// Patterns are validated before linting starts.
+		if ok, _ := util.MatchGlob(pattern, strings.TrimPrefix(dir, "/")); ok {
 			return nil
 		}
+	}
+	return fmt.Errorf("%s is not a standard library directory", dir)
+}
 
-		info, err := d.Info()
-		if err != nil {
-			return err
-		}
+// duplicateLinter flags files that other packages from the same build ship
+// too, since apk refuses to install two packages that own the same file. The
+// files of all the packages are grouped by path, and each group is reported
+// once, by the first package in it by name.
+func duplicateLinter(ctx context.Context, pkg *lintPackage, fsys fs.FS) error {
+	if len(pkg.siblings) == 0 {
+		return nil
+	}
 
-		mode := info.Mode()
-		if mode&0002 != 0 {
-			if mode&0111 != 0 {
-				return fmt.Errorf("world-writeable executable file found in package (security risk): %s", path)
+	// owners are the packages that ship each file, in the order of their
+	// names.
+	owners := map[string][]string{}
+	packages := maps.Clone(pkg.siblings)
+	packages[pkg.name] = fsys
+	names := maps.Keys(packages)
+	slices.Sort(names)
+	for _, name := range names {
+		if err := fs.WalkDir(packages[name], ".", func(path string, d fs.DirEntry, err error) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() && !isIgnoredPath(path) {
+				owners[path] = append(owners[path], name)
 			}
-			return fmt.Errorf("world-writeable file found in package: %s", path)
+			return nil
+		}); err != nil {
+			return fmt.Errorf("walking package %s: %w", name, err)
 		}
-		return nil
-	})
+	}
+
+	paths := maps.Keys(owners)
+	slices.Sort(paths)
+	var errs []error
+	for _, path := range paths {
+		if group := owners[path]; len(group) > 1 && group[0] == pkg.name {
+			errs = append(errs, &pathError{path: path, err: fmt.Errorf("file is also in %s", strings.Join(group[1:], ", "))})
+		}
+	}
+	return errors.Join(errs...)
 }
 
-var elfMagic = []byte{'\x7f', 'E', 'L', 'F'}
+var isScriptOrConfigPathRegex = regexp.MustCompile(`^(usr/)?s?bin/|^etc/`)
 
-var isObjectFileRegex = regexp.MustCompile(`\.(a|so|dylib)(\..*)?`)
+// Byte order marks, which interpreters read as part of the #! line.
+var byteOrderMarks = []struct {
+	bom      []byte
+	encoding string
+}{
+	{[]byte{0xef, 0xbb, 0xbf}, "UTF-8"},
+	{[]byte{0xff, 0xfe}, "UTF-16"},
+	{[]byte{0xfe, 0xff}, "UTF-16"},
+}
 
-func strippedLinter(ctx context.Context, _ string, fsys fs.FS) error {
-	return fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
-		if err := ctx.Err(); err != nil {
-			return err
+// crlfLinter flags text files in the bin directories and /etc that have
+// DOS line endings or a byte order mark, which break interpreters and most
+// configuration parsers.
+func crlfLinter(ctx context.Context, _ *lintPackage, fsys fs.FS) error {
+	return walkPackage(ctx, fsys, func(path string, d fs.DirEntry) error {
+		if !d.Type().IsRegular() || !isScriptOrConfigPathRegex.MatchString(path) {
+			return nil
 		}
+
+		f, err := fsys.Open(path)
 		if err != nil {
-			return err
-		}
-		if isIgnoredPath(path) {
-			return nil
+			return fmt.Errorf("opening file: %w", err)
 		}
+		defer f.Close()
 
-		if !d.Type().IsRegular() {
-			// Don't worry about non-files
+		br := bufio.NewReader(f)
+		head, err := br.Peek(512)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("reading %s: %w", path, err)
+		}
+		for _, m := range byteOrderMarks {
+			if bytes.HasPrefix(head, m.bom) {
+				return &pathError{path: path, err: fmt.Errorf("file starts with a %s byte order mark", m.encoding)}
+			}
+		}
+		if bytes.IndexByte(head, 0) >= 0 {
+			// Binary file
 			return nil
 		}
 
-		info, err := d.Info()
+		found, err := scanReader(br, 1, func(chunk []byte) string {
+			if bytes.Contains(chunk, []byte("\r\n")) {
+				return "CRLF"
+			}
+			return ""
+		})
 		if err != nil {
-			return err
+			return fmt.Errorf("reading %s: %w", path, err)
+		}
+		if found != "" {
+			return &pathError{path: path, err: errors.New("file has DOS (CRLF) line endings")}
 		}
+		return nil
+	})
+}
 
-		if info.Size() < int64(len(elfMagic)) {
-			// This is definitely not an ELF file.
-			return nil
+// providesLinter flags names, including generated so: and cmd: virtuals,
+// that another package from the same build provides too, unless one of the
+// packages has a provider priority, replaces the other or conflicts with it.
+// apk can't choose between such providers.
+func providesLinter(_ context.Context, pkg *lintPackage, _ fs.FS) error {
+	self, ok := pkg.providers[pkg.name]
+	if !ok {
+		return nil
+	}
+
+	var errs []error
+	others := maps.Keys(pkg.providers)
+	slices.Sort(others)
+	for _, other := range others {
+		p := pkg.providers[other]
+		switch {
+		case other == pkg.name:
+			continue
+		case self.ProviderPriority != "", p.ProviderPriority != "":
+			continue
+		case slices.Contains(self.Replaces, other), slices.Contains(p.Replaces, pkg.name):
+			continue
+		case slices.Contains(self.Runtime, "!"+other), slices.Contains(p.Runtime, "!"+pkg.name):
+			continue
 		}
 
-		ext := filepath.Ext(path)
-		mode := info.Mode()
-		if mode&0111 == 0 && !isObjectFileRegex.MatchString(ext) {
-			// Not an executable or library
+		for _, prov := range self.Provides {
+			name := depName(prov)
+			if name == other || slices.ContainsFunc(p.Provides, func(q string) bool { return depName(q) == name }) {
+				errs = append(errs, fmt.Errorf("%s is also provided by %s", name, other))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Paths that only exist inside the build environment.
+var buildPaths = []string{"/home/build", "/melange-out/"}
+
+// buildPathLinter flags text files and ELF string tables that mention the
+// build workspace, which makes builds irreproducible and leaks details about
+// the builder. Other binary files are skipped.
+func buildPathLinter(ctx context.Context, pkg *lintPackage, fsys fs.FS) error {
+	needles := slices.Clone(buildPaths)
+	if pkg.workspaceDir != "" {
+		needles = append(needles, pkg.workspaceDir)
+	}
+
+	return walkPackage(ctx, fsys, func(path string, d fs.DirEntry) error {
+		if isIgnoredPath(path) || !d.Type().IsRegular() {
 			return nil
 		}
 
@@ -358,38 +1644,158 @@ func strippedLinter(ctx context.Context, _ string, fsys fs.FS) error {
 		}
 		defer f.Close()
 
-		// Both os.DirFS and go-apk return a file that implements ReaderAt.
-		// We don't have any other callers, so this should never fail.
+		found, err := findBuildPath(f, needles)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", path, err)
+		}
+		if found != "" {
+			return &pathError{path: path, err: fmt.Errorf("file contains build path %q", found)}
+		}
+		return nil
+	})
+}
+
+// findBuildPath returns the first of needles that appears in the text or ELF
+// string tables of f.
+func findBuildPath(f fs.File, needles []string) (string, error) {
+	br := bufio.NewReader(f)
+	head, err := br.Peek(512)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+
+	if bytes.HasPrefix(head, elfMagic) {
 		readerAt, ok := f.(io.ReaderAt)
 		if !ok {
-			return fmt.Errorf("fs.File does not impl ReaderAt: %T", f)
+			return "", fmt.Errorf("fs.File does not impl ReaderAt: %T", f)
+		}
+		file, err := elf.NewFile(readerAt)
+		if err != nil {
+			// Not something we understand, so it can't be loaded either.
+			return "", nil
+		}
+		defer file.Close()
+
+		for _, sec := range file.Sections {
+			if sec.Type != elf.SHT_STRTAB {
+				continue
+			}
+			data, err := sec.Data()
+			if err != nil {
+				return "", fmt.Errorf("reading section %s: %w", sec.Name, err)
+			}
+			for _, needle := range needles {
+				if bytes.Contains(data, []byte(needle)) {
+					return needle, nil
+				}
+			}
+		}
+		return "", nil
+	}
+
+	if bytes.IndexByte(head, 0) >= 0 {
+		// Binary file
+		return "", nil
+	}
+
+	overlap := 0
+	for _, needle := range needles {
+		overlap = max(overlap, len(needle)-1)
+	}
+	return scanReader(br, overlap, func(chunk []byte) string {
+		for _, needle := range needles {
+			if bytes.Contains(chunk, []byte(needle)) {
+				return needle
+			}
 		}
+		return ""
+	})
+}
 
-		hdr := make([]byte, len(elfMagic))
-		if _, err := readerAt.ReadAt(hdr, 0); err != nil {
-			return fmt.Errorf("failed to read %d bytes for magic ELF header: %w", len(elfMagic), err)
+// scanReader calls find with successive chunks of r until it returns
+// something other than "". Consecutive chunks share overlap bytes, so that
+// find sees anything up to overlap+1 bytes long whole.
+func scanReader(r io.Reader, overlap int, find func(chunk []byte) string) (string, error) {
+	buf := make([]byte, 0, 64*1024+overlap)
+	for {
+		n, err := r.Read(buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		if found := find(buf); found != "" {
+			return found, nil
 		}
+		if errors.Is(err, io.EOF) {
+			return "", nil
+		} else if err != nil {
+			return "", err
+		}
+		if len(buf) == cap(buf) {
+			keep := min(overlap, len(buf))
+			buf = append(buf[:0], buf[len(buf)-keep:]...)
+		}
+	}
+}
 
-		if !bytes.Equal(elfMagic, hdr) {
-			// No magic header, definitely not ELF.
+// High-confidence patterns for credentials, and what they look like.
+var secretPatterns = []struct {
+	re   *regexp.Regexp
+	what string
+}{{
+	re:   regexp.MustCompile(`-----BEGIN (?:[A-Z0-9]+ )*PRIVATE KEY(?: BLOCK)?-----`),
+	what: "a private key",
+}, {
+	re:   regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`),
+	what: "an AWS access key ID",
+}, {
+	re:   regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36}|github_pat_[A-Za-z0-9_]{82})\b`),
+	what: "a GitHub token",
+}, {
+	re:   regexp.MustCompile(`\bxox[abprs]-[0-9]{10,13}-[0-9A-Za-z-]{10,}`),
+	what: "a Slack token",
+}}
+
+// secretsLinter flags files that contain private keys or access tokens.
+// AWS's documentation examples, which end in EXAMPLE, are allowed.
+func secretsLinter(ctx context.Context, _ *lintPackage, fsys fs.FS) error {
+	return walkPackage(ctx, fsys, func(path string, d fs.DirEntry) error {
+		if isIgnoredPath(path) || !d.Type().IsRegular() {
 			return nil
 		}
 
-		file, err := elf.NewFile(readerAt)
+		f, err := fsys.Open(path)
 		if err != nil {
-			return fmt.Errorf("Could not open file %q as executable: %v\n", path, err)
+			return fmt.Errorf("opening file: %w", err)
 		}
-		defer file.Close()
+		defer f.Close()
 
-		// No debug sections allowed
-		if file.Section(".debug") != nil || file.Section(".zdebug") != nil {
-			return fmt.Errorf("ELF file is not stripped")
+		found, err := scanReader(f, 128, func(chunk []byte) string {
+			for _, p := range secretPatterns {
+				for _, m := range p.re.FindAll(chunk, -1) {
+					if !bytes.HasSuffix(m, []byte("EXAMPLE")) {
+						return p.what
+					}
+				}
+			}
+			return ""
+		})
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", path, err)
+		}
+		if found != "" {
+			return &pathError{path: path, err: fmt.Errorf("file contains what looks like %s", found)}
 		}
 		return nil
 	})
 }
 
-func emptyLinter(_ context.Context, pkgname string, fsys fs.FS) error {
+// emptyLinter flags packages with nothing in them, which usually means the
+// pipeline installed to the wrong place. Only packages marked no-provides,
+// such as meta packages, are expected to be empty; a split that went wrong
+// still has its runtime dependencies.
+func emptyLinter(_ context.Context, pkg *lintPackage, fsys fs.FS) error {
+	if pkg.noProvides {
+		return nil
+	}
+
 	foundfile := false
 	err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
@@ -446,7 +1852,17 @@ func getPythonSitePackages(fsys fs.FS) (matches []string, err error) {
 	return
 }
 
-func pythonDocsLinter(_ context.Context, _ string, fsys fs.FS) error {
+func pythonBytecodeLinter(_ context.Context, _, path string) error {
+	if ext := filepath.Ext(path); ext == ".pyc" || ext == ".pyo" {
+		return errors.New("package contains Python bytecode")
+	}
+	if slices.Contains(strings.Split(path, "/"), "__pycache__") {
+		return errors.New("package contains a __pycache__ directory")
+	}
+	return nil
+}
+
+func pythonDocsLinter(_ context.Context, _ *lintPackage, fsys fs.FS) error {
 	packages, err := getPythonSitePackages(fsys)
 	if err != nil {
 		return err
@@ -462,7 +1878,7 @@ func pythonDocsLinter(_ context.Context, _ string, fsys fs.FS) error {
 	return nil
 }
 
-func pythonMultiplePackagesLinter(_ context.Context, _ string, fsys fs.FS) error {
+func pythonMultiplePackagesLinter(_ context.Context, _ *lintPackage, fsys fs.FS) error {
 	packages, err := getPythonSitePackages(fsys)
 	if err != nil {
 		return err
@@ -518,7 +1934,7 @@ func pythonMultiplePackagesLinter(_ context.Context, _ string, fsys fs.FS) error
 	return nil
 }
 
-func pythonTestLinter(_ context.Context, _ string, fsys fs.FS) error {
+func pythonTestLinter(_ context.Context, _ *lintPackage, fsys fs.FS) error {
 	packages, err := getPythonSitePackages(fsys)
 	if err != nil {
 		return err
@@ -534,56 +1950,222 @@ func pythonTestLinter(_ context.Context, _ string, fsys fs.FS) error {
 	return nil
 }
 
-func lintPackageFS(ctx context.Context, pkgname string, fsys fs.FS, linters []string) error {
+func lintPackageFS(ctx context.Context, pkg *lintPackage, fsys fs.FS, linters map[string]Severity) error {
+	pkgname := pkg.name
+
 	// If this is a compat package, do nothing.
 	if strings.HasSuffix(pkgname, "-compat") {
 		return nil
 	}
 
+	log := clog.FromContext(ctx)
 	errs := []error{}
-	for _, linterName := range linters {
-		if err := ctx.Err(); err != nil {
-			return err
+	names := maps.Keys(linters)
+	slices.Sort(names)
+
+	results, err := runLinters(ctx, pkg, fsys, names)
+	if err != nil {
+		return err
+	}
+
+	if pkg.fix && pkg.dir != "" {
+		fixed := false
+		for i, linterName := range names {
+			linter := pkg.linters[linterName]
+			if results[i] == nil || linter.FixFunc == nil {
+				continue
+			}
+			fixes, ferr := linter.FixFunc(ctx, pkg, linterFS(pkg, fsys, linterName))
+			for _, f := range fixes {
+				log.Infof("linter %q fixed %s in package %q: %s", linterName, f.path, pkgname, f.action)
+				pkg.report.add(Finding{
+					Linter:   linterName,
+					Package:  pkgname,
+					Path:     f.path,
+					Message:  f.action,
+					Explain:  linter.Explain,
+					Severity: linters[linterName],
+					Fixed:    true,
+				})
+			}
+			fixed = fixed || len(fixes) > 0
+			if ferr != nil {
+				errs = append(errs, fmt.Errorf("linter %q failed to fix package %q: %w", linterName, pkgname, ferr))
+			}
+		}
+		if fixed {
+			// Anything that is left couldn't be fixed. This includes what
+			// other linters found, since fixes can remove files.
+			if results, err = runLinters(ctx, pkg, fsys, names); err != nil {
+				return err
+			}
+		}
+	}
+
+	for i, linterName := range names {
+		if results[i] == nil {
+			continue
 		}
-		linter := linterMap[linterName]
-		if err := linter.LinterFunc(ctx, linterName, fsys); err != nil {
-			errs = append(errs, fmt.Errorf("linter %q failed on package %q: %w; suggest: %s", linterName, pkgname, err, linter.Explain))
+		linter := pkg.linters[linterName]
+		for _, err := range unjoin(results[i]) {
+			f := newFinding(linterName, pkgname, linters[linterName], linter.Explain, err)
+			if f.Severity == SeverityError && pkg.baseline.contains(f) {
+				// Known problems don't fail the build.
+				f.Severity = SeverityWarn
+			}
+			pkg.report.add(f)
+
+			err = fmt.Errorf("linter %q failed on package %q: %w; suggest: %s", linterName, pkgname, err, linter.Explain)
+			switch f.Severity {
+			case SeverityError:
+				errs = append(errs, err)
+			case SeverityWarn:
+				log.Warn(err.Error())
+			case SeverityInfo:
+				log.Info(err.Error())
+			}
 		}
 	}
 
 	return errors.Join(errs...)
 }
 
-func checkLinters(linters []string) error {
+// runLinters runs the named linters on the package concurrently, and returns
+// what each of them failed with, in the same order as names.
+func runLinters(ctx context.Context, pkg *lintPackage, fsys fs.FS, names []string) ([]error, error) {
+	results := make([]error, len(names))
+	var g errgroup.Group
+	g.SetLimit(runtime.GOMAXPROCS(0))
+	for i, linterName := range names {
+		g.Go(func() error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			results[i] = pkg.linters[linterName].LinterFunc(ctx, pkg, linterFS(pkg, fsys, linterName))
+			return nil
+		})
+	}
+	return results, g.Wait()
+}
+
+// linterFS returns the view of fsys that the named linter checks.
+func linterFS(pkg *lintPackage, fsys fs.FS, linterName string) fs.FS {
+	if patterns := pkg.ignores[linterName]; len(patterns) > 0 {
+		return ignoreFS{FS: fsys, patterns: patterns}
+	}
+	return fsys
+}
+
+// unjoin returns the errors joined into err by errors.Join, or just err.
+func unjoin(err error) []error {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		return joined.Unwrap()
+	}
+	return []error{err}
+}
+
+func checkLinters(linters map[string]Severity, o options) error {
 	var errs []error
-	for _, linterName := range linters {
-		if _, found := linterMap[linterName]; !found {
+	names := maps.Keys(linters)
+	slices.Sort(names)
+	for _, linterName := range names {
+		if _, found := o.linters[linterName]; !found {
 			errs = append(errs, fmt.Errorf("unknown linter: %q", linterName))
 		}
+		if _, err := ParseSeverity(string(linters[linterName])); err != nil {
+			errs = append(errs, fmt.Errorf("linter %q: %w", linterName, err))
+		}
+	}
+	for linterName, patterns := range o.ignores {
+		if _, found := o.linters[linterName]; !found {
+			errs = append(errs, fmt.Errorf("ignored paths given for unknown linter: %q", linterName))
+		}
+		for _, pattern := range patterns {
+			if err := util.ValidateGlob(pattern); err != nil {
+				errs = append(errs, fmt.Errorf("linter %q: %w", linterName, err))
+			}
+		}
+	}
+	for _, root := range o.roots {
+		if root == "" || strings.Contains(root, "/") {
+			errs = append(errs, fmt.Errorf("allowed root %q is not a top-level directory name", root))
+		}
+	}
+	if _, _, err := o.sizeLimits(); err != nil {
+		errs = append(errs, err)
+	}
+	for _, pattern := range o.rpaths {
+		if err := util.ValidateGlob(pattern); err != nil {
+			errs = append(errs, fmt.Errorf("allowed rpath: %w", err))
+		}
 	}
 	return errors.Join(errs...)
 }
 
 // Lint the given build directory at the given path
-func LintBuild(ctx context.Context, packageName string, path string, require, warn []string) error {
-	if err := checkLinters(append(require, warn...)); err != nil {
+func LintBuild(ctx context.Context, packageName string, path string, require, warn []string, opts ...Option) error {
+	o, err := applyOptions(opts)
+	if err != nil {
+		return err
+	}
+
+	linters := o.linterSeverities(require, warn)
+	if err := checkLinters(linters, o); err != nil {
 		return err
 	}
 
 	log := clog.FromContext(ctx)
-	fsys := os.DirFS(path)
+	fsys := dirFS(path)
 
-	if err := lintPackageFS(ctx, packageName, fsys, warn); err != nil {
-		log.Warn(err.Error())
+	pkg := &lintPackage{
+		options:  o,
+		name:     packageName,
+		dir:      path,
+		siblings: make(map[string]fs.FS, len(o.siblingDirs)),
+	}
+	for name, dir := range o.siblingDirs {
+		if name != packageName {
+			pkg.siblings[name] = dirFS(dir)
+		}
 	}
+
 	log.Infof("linting apk: %s", packageName)
-	return lintPackageFS(ctx, packageName, fsys, require)
+	return lintPackageFS(ctx, pkg, fsys, linters)
+}
+
+// LintProvides runs the provides linter, if it is enabled, on a package that
+// has been emitted, whose provides are given with WithProviders.
+func LintProvides(ctx context.Context, packageName string, require, warn []string, opts ...Option) error {
+	o, err := applyOptions(opts)
+	if err != nil {
+		return err
+	}
+
+	linters := o.linterSeverities(require, warn)
+	if err := checkLinters(linters, o); err != nil {
+		return err
+	}
+	sev, ok := linters["provides"]
+	if !ok {
+		return nil
+	}
+
+	// The provides linter doesn't look at the package's files.
+	pkg := &lintPackage{options: o, name: packageName}
+	return lintPackageFS(ctx, pkg, nil, map[string]Severity{"provides": sev})
 }
 
 // Lint the given APK at the given path
-func LintAPK(ctx context.Context, path string, require, warn []string) error {
+func LintAPK(ctx context.Context, path string, require, warn []string, opts ...Option) error {
 	log := clog.FromContext(ctx)
-	if err := checkLinters(append(require, warn...)); err != nil {
+
+	o, err := applyOptions(opts)
+	if err != nil {
+		return err
+	}
+
+	linters := o.linterSeverities(require, warn)
+	if err := checkLinters(linters, o); err != nil {
 		return err
 	}
 
@@ -632,7 +2214,7 @@ func LintAPK(ctx context.Context, path string, require, warn []string) error {
 		return fmt.Errorf("could not read from package: %w", err)
 	}
 
-	cfg, err := ini.Load(data)
+	cfg, err := ini.LoadSources(ini.LoadOptions{AllowShadows: true}, data)
 	if err != nil {
 		return fmt.Errorf("could not load .PKGINFO file: %w", err)
 	}
@@ -642,9 +2224,41 @@ func LintAPK(ctx context.Context, path string, require, warn []string) error {
 		return fmt.Errorf("pkgname is nonexistent")
 	}
 
+	pkg := &lintPackage{options: o, name: pkgname}
+	if pkg.arch == "" {
+		pkg.arch = cfg.Section("").Key("arch").String()
+	}
+	if key, err := cfg.Section("").GetKey("depend"); err == nil {
+		pkg.runtime = key.ValueWithShadows()
+	}
+	if pkg.scriptlets == nil {
+		if pkg.scriptlets, err = controlScriptlets(exp.ControlFS); err != nil {
+			return err
+		}
+	}
+
 	log.Infof("linting apk: %s (size: %s)", pkgname, humanize.Bytes(uint64(exp.Size)))
-	if err := lintPackageFS(ctx, pkgname, exp.TarFS, warn); err != nil {
-		log.Warn(err.Error())
+	return lintPackageFS(ctx, pkg, exp.TarFS, linters)
+}
+
+// controlScriptlets returns the scriptlets in the control section of an apk,
+// keyed by the names of their files.
+func controlScriptlets(fsys fs.FS) (map[string]string, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("reading the control section: %w", err)
+	}
+	scriptlets := map[string]string{}
+	for _, e := range entries {
+		name := e.Name()
+		if !e.Type().IsRegular() || name == ".PKGINFO" || strings.HasPrefix(name, ".SIGN.") {
+			continue
+		}
+		b, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", name, err)
+		}
+		scriptlets[name] = string(b)
 	}
-	return lintPackageFS(ctx, pkgname, exp.TarFS, require)
+	return scriptlets, nil
 }
@@ -0,0 +1,149 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package linter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// pluginInput describes the package being linted to a linter plugin. It is
+// written to the plugin's standard input.
+type pluginInput struct {
+	// The name of the package.
+	Name string `json:"name"`
+	// The directory the package's contents are in.
+	Root string `json:"root"`
+	// The packages it declares runtime dependencies on.
+	Runtime []string `json:"runtime,omitempty"`
+}
+
+// pluginOutput is what a linter plugin writes to its standard output.
+type pluginOutput struct {
+	Findings []struct {
+		// The path within the package, if the problem is specific to a file.
+		Path string `json:"path,omitempty"`
+		// A description of the problem.
+		Message string `json:"message"`
+	} `json:"findings"`
+}
+
+// pluginLinter runs program with the root of the package as its argument
+// and a pluginInput on its standard input, and fails with the findings
+// from its pluginOutput. Packages that aren't on disk, or whose linter has
+// ignored paths, are extracted to a temporary directory first, so the
+// plugin sees exactly what a built-in linter would.
+func pluginLinter(name, program string) linter {
+	return linter{
+		LinterFunc: func(ctx context.Context, pkg *lintPackage, fsys fs.FS) error {
+			root := pkg.dir
+			if _, ignoring := fsys.(ignoreFS); ignoring || root == "" {
+				tmp, err := os.MkdirTemp("", "melange-lint-")
+				if err != nil {
+					return fmt.Errorf("creating temporary directory: %w", err)
+				}
+				defer os.RemoveAll(tmp)
+				if err := extractFS(fsys, tmp); err != nil {
+					return fmt.Errorf("extracting package: %w", err)
+				}
+				root = tmp
+			}
+
+			in, err := json.Marshal(pluginInput{Name: pkg.name, Root: root, Runtime: pkg.runtime})
+			if err != nil {
+				return err
+			}
+
+			var stdout, stderr bytes.Buffer
+			cmd := exec.CommandContext(ctx, program, root)
+			cmd.Stdin = bytes.NewReader(in)
+			cmd.Stdout = &stdout
+			cmd.Stderr = &stderr
+			if err := cmd.Run(); err != nil {
+				return fmt.Errorf("running %s: %w: %s", program, err, strings.TrimSpace(stderr.String()))
+			}
+
+			var out pluginOutput
+			if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+				return fmt.Errorf("parsing output of %s: %w", program, err)
+			}
+
+			errs := make([]error, 0, len(out.Findings))
+			for _, f := range out.Findings {
+				if f.Path == "" {
+					errs = append(errs, errors.New(f.Message))
+				} else {
+					errs = append(errs, &pathError{path: f.Path, err: errors.New(f.Message)})
+				}
+			}
+			return errors.Join(errs...)
+		},
+		Explain: fmt.Sprintf("See the documentation of the %q linter plugin (%s)", name, program),
+	}
+}
+
+// extractFS copies the directories, regular files and links in fsys to dir.
+func extractFS(fsys fs.FS, dir string) error {
+	return fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dir, filepath.FromSlash(path))
+
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, 0o755)
+		case d.Type()&fs.ModeSymlink != 0:
+			rl, ok := fsys.(readlinkFS)
+			if !ok {
+				return fmt.Errorf("reading symlinks is not supported by %T", fsys)
+			}
+			link, err := rl.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case d.Type().IsRegular():
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			src, err := fsys.Open(path)
+			if err != nil {
+				return err
+			}
+			defer src.Close()
+			dst, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, info.Mode().Perm())
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(dst, src); err != nil {
+				dst.Close()
+				return err
+			}
+			return dst.Close()
+		}
+		return nil
+	})
+}
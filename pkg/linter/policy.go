@@ -0,0 +1,106 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package linter
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"golang.org/x/exp/maps"
+	"gopkg.in/yaml.v3"
+)
+
+// PolicyAction is what a Policy does with a linter.
+type PolicyAction string
+
+const (
+	// The linter must pass.
+	PolicyEnforce PolicyAction = "enforce"
+	// The linter only generates warnings.
+	PolicyWarn PolicyAction = "warn"
+	// The linter isn't run.
+	PolicySkip PolicyAction = "skip"
+)
+
+// Policy decides which linters fail the build, which only warn and which
+// aren't run, for every build that uses it. This lets the set of blocking
+// linters be managed for a whole repository, and differ between local and
+// CI builds, rather than in each build configuration. Linters it doesn't
+// mention keep their default behavior.
+type Policy struct {
+	Linters map[string]PolicyAction `yaml:"linters"`
+}
+
+// LoadPolicy reads a policy from the YAML file at path.
+func LoadPolicy(path string) (*Policy, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening lint policy: %w", err)
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	var p Policy
+	if err := dec.Decode(&p); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("parsing lint policy %s: %w", path, err)
+	}
+
+	var errs []error
+	names := maps.Keys(p.Linters)
+	slices.Sort(names)
+	for _, name := range names {
+		switch p.Linters[name] {
+		case PolicyEnforce, PolicyWarn, PolicySkip:
+		default:
+			errs = append(errs, fmt.Errorf("lint policy %s: linter %q: unknown action %q (must be one of %q, %q or %q)", path, name, p.Linters[name], PolicyEnforce, PolicyWarn, PolicySkip))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// Apply returns the required and warning linters with the policy applied.
+func (p *Policy) Apply(require, warn []string) ([]string, []string) {
+	if p == nil {
+		return require, warn
+	}
+
+	require = slices.DeleteFunc(slices.Clone(require), func(name string) bool {
+		_, ok := p.Linters[name]
+		return ok
+	})
+	warn = slices.DeleteFunc(slices.Clone(warn), func(name string) bool {
+		_, ok := p.Linters[name]
+		return ok
+	})
+
+	names := maps.Keys(p.Linters)
+	slices.Sort(names)
+	for _, name := range names {
+		switch p.Linters[name] {
+		case PolicyEnforce:
+			require = append(require, name)
+		case PolicyWarn:
+			warn = append(warn, name)
+		}
+	}
+	return require, warn
+}
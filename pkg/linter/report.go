@@ -0,0 +1,310 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package linter
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+)
+
+// Finding is a single problem found by a linter.
+type Finding struct {
+	// The name of the linter that found the problem.
+	Linter string `json:"linter"`
+	// The name of the package the problem was found in.
+	Package string `json:"package"`
+	// The path within the package, if the problem is specific to a file.
+	Path string `json:"path,omitempty"`
+	// A description of the problem.
+	Message string `json:"message"`
+	// A suggestion for how to fix the problem.
+	Explain string `json:"explain"`
+	// How the problem was reported.
+	Severity Severity `json:"severity"`
+	// Whether the problem was fixed, in which case Message says how.
+	Fixed bool `json:"fixed,omitempty"`
+}
+
+func newFinding(linterName, pkgname string, severity Severity, explain string, err error) Finding {
+	f := Finding{
+		Linter:   linterName,
+		Package:  pkgname,
+		Message:  err.Error(),
+		Explain:  explain,
+		Severity: severity,
+	}
+
+	var pe *pathError
+	if errors.As(err, &pe) {
+		f.Path = pe.path
+		f.Message = pe.err.Error()
+	}
+
+	return f
+}
+
+// Report collects the findings of one or more lint runs. It is safe for
+// concurrent use, so a single Report can be shared across packages and
+// architectures.
+type Report struct {
+	mu       sync.Mutex
+	findings []Finding
+}
+
+func (r *Report) add(f Finding) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.findings = append(r.findings, f)
+}
+
+// Findings returns the findings collected so far, sorted by package, linter
+// and path. Identical findings, such as those reported by each architecture of
+// a multi-architecture build, are only returned once.
+func (r *Report) Findings() []Finding {
+	r.mu.Lock()
+	findings := slices.Clone(r.findings)
+	r.mu.Unlock()
+
+	slices.SortFunc(findings, func(a, b Finding) int {
+		return cmp.Or(
+			cmp.Compare(a.Package, b.Package),
+			cmp.Compare(a.Linter, b.Linter),
+			cmp.Compare(a.Path, b.Path),
+			cmp.Compare(a.Message, b.Message),
+			cmp.Compare(a.Severity, b.Severity),
+			compareBool(a.Fixed, b.Fixed),
+		)
+	})
+	return slices.Compact(findings)
+}
+
+func compareBool(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return 1
+	default:
+		return -1
+	}
+}
+
+// ReportFormats returns the formats that a Report can be written in.
+func ReportFormats() []string {
+	return []string{"baseline", "json", "sarif"}
+}
+
+// Write encodes the report to w in the given format.
+func (r *Report) Write(w io.Writer, format string) error {
+	switch format {
+	case "baseline":
+		return r.writeBaseline(w)
+	case "json":
+		return r.writeJSON(w)
+	case "sarif":
+		return r.writeSARIF(w)
+	default:
+		return fmt.Errorf("unknown lint report format %q (must be one of %q)", format, ReportFormats())
+	}
+}
+
+// The subset of SARIF 2.1.0 needed to describe linter findings.
+// See https://docs.oasis-open.org/sarif/sarif/v2.1.0/sarif-v2.1.0.html
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+	Help             sarifMessage `json:"help"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation *sarifPhysicalLocation `json:"physicalLocation,omitempty"`
+	LogicalLocations []sarifLogicalLocation `json:"logicalLocations"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifLogicalLocation struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+}
+
+// sarifLevels maps linter severities to SARIF result levels.
+var sarifLevels = map[Severity]string{
+	SeverityError: "error",
+	SeverityWarn:  "warning",
+	SeverityInfo:  "note",
+}
+
+func (r *Report) writeSARIF(w io.Writer) error {
+	findings := r.Findings()
+
+	rules := []sarifRule{}
+	seen := map[string]bool{}
+	results := make([]sarifResult, 0, len(findings))
+	for _, f := range findings {
+		if !seen[f.Linter] {
+			seen[f.Linter] = true
+			rules = append(rules, sarifRule{
+				ID:               f.Linter,
+				ShortDescription: sarifMessage{Text: fmt.Sprintf("melange linter %q", f.Linter)},
+				Help:             sarifMessage{Text: f.Explain},
+			})
+		}
+
+		loc := sarifLocation{
+			LogicalLocations: []sarifLogicalLocation{{Name: f.Package, Kind: "package"}},
+		}
+		if f.Path != "" {
+			loc.PhysicalLocation = &sarifPhysicalLocation{
+				ArtifactLocation: sarifArtifactLocation{URI: f.Path},
+			}
+		}
+
+		result := sarifResult{
+			RuleID:    f.Linter,
+			Level:     sarifLevels[f.Severity],
+			Message:   sarifMessage{Text: fmt.Sprintf("%s (package %s)", f.Message, f.Package)},
+			Locations: []sarifLocation{loc},
+		}
+		if f.Fixed {
+			// Fixed problems didn't make it into the package.
+			result.Level = "note"
+			result.Message.Text = fmt.Sprintf("fixed: %s (package %s)", f.Message, f.Package)
+		}
+		results = append(results, result)
+	}
+	slices.SortFunc(rules, func(a, b sarifRule) int { return cmp.Compare(a.ID, b.ID) })
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs: []sarifRun{{
+			Tool: sarifTool{
+				Driver: sarifDriver{
+					Name:           "melange",
+					InformationURI: "https://github.com/chainguard-dev/melange",
+					Rules:          rules,
+				},
+			},
+			Results: results,
+		}},
+	})
+}
+
+// jsonSummary is the "json" report format: the findings along with how many
+// there are of each kind, so that dashboards can track them across builds
+// without counting them up.
+type jsonSummary struct {
+	jsonCounts
+	Packages []jsonPackage `json:"packages"`
+}
+
+type jsonPackage struct {
+	Name string `json:"name"`
+	jsonCounts
+	Findings []Finding `json:"findings"`
+}
+
+type jsonCounts struct {
+	// The number of findings, not counting fixed ones.
+	Total int `json:"total"`
+	// The number of findings by severity and by linter, not counting fixed
+	// ones.
+	Severities map[Severity]int `json:"severities"`
+	Linters    map[string]int   `json:"linters"`
+	// The number of problems that were fixed.
+	Fixed int `json:"fixed"`
+}
+
+func (c *jsonCounts) add(f Finding) {
+	if f.Fixed {
+		c.Fixed++
+		return
+	}
+	c.Total++
+	c.Severities[f.Severity]++
+	c.Linters[f.Linter]++
+}
+
+func newJSONCounts() jsonCounts {
+	return jsonCounts{Severities: map[Severity]int{}, Linters: map[string]int{}}
+}
+
+func (r *Report) writeJSON(w io.Writer) error {
+	summary := jsonSummary{jsonCounts: newJSONCounts(), Packages: []jsonPackage{}}
+	for _, f := range r.Findings() {
+		// Findings are sorted by package.
+		if n := len(summary.Packages); n == 0 || summary.Packages[n-1].Name != f.Package {
+			summary.Packages = append(summary.Packages, jsonPackage{Name: f.Package, jsonCounts: newJSONCounts()})
+		}
+		pkg := &summary.Packages[len(summary.Packages)-1]
+		pkg.add(f)
+		pkg.Findings = append(pkg.Findings, f)
+		summary.add(f)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(summary)
+}
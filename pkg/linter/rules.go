@@ -0,0 +1,123 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package linter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+
+	"chainguard.dev/melange/pkg/util"
+)
+
+// Rule is a linter defined in a build configuration rather than in melange.
+// It fails on every file whose path matches Path and PathRegex, and whose
+// contents match Content, where each of them is only checked if it is set.
+type Rule struct {
+	// The name of the linter.
+	Name string
+	// A glob that paths must match.
+	Path string
+	// A regular expression that paths must match.
+	PathRegex string
+	// A regular expression that file contents must match.
+	Content string
+	// The message to report for each matching file.
+	Message string
+	// The severity to report matches at, or "" for SeverityWarn.
+	Severity Severity
+}
+
+// How much consecutive chunks of a file overlap when matching Content, and
+// so how long a match is guaranteed to be found.
+const ruleContentOverlap = 4096
+
+func (r Rule) compile() (linter, error) {
+	if r.Path == "" && r.PathRegex == "" && r.Content == "" {
+		return linter{}, errors.New("at least one of path, path-regex or content must be set")
+	}
+	if r.Message == "" {
+		return linter{}, errors.New("message must be set")
+	}
+
+	if r.Path != "" {
+		if err := util.ValidateGlob(r.Path); err != nil {
+			return linter{}, err
+		}
+	}
+
+	var pathRegex, contentRegex *regexp.Regexp
+	if r.PathRegex != "" {
+		re, err := regexp.Compile(r.PathRegex)
+		if err != nil {
+			return linter{}, fmt.Errorf("compiling path-regex: %w", err)
+		}
+		pathRegex = re
+	}
+	if r.Content != "" {
+		re, err := regexp.Compile(r.Content)
+		if err != nil {
+			return linter{}, fmt.Errorf("compiling content: %w", err)
+		}
+		contentRegex = re
+	}
+
+	return linter{
+		LinterFunc: func(ctx context.Context, _ *lintPackage, fsys fs.FS) error {
+			return walkPackage(ctx, fsys, func(path string, d fs.DirEntry) error {
+				if isIgnoredPath(path) || d.IsDir() {
+					return nil
+				}
+
+				if r.Path != "" {
+					// The glob was validated above.
+					if ok, _ := util.MatchGlob(r.Path, path); !ok {
+						return nil
+					}
+				}
+				if pathRegex != nil && !pathRegex.MatchString(path) {
+					return nil
+				}
+
+				if contentRegex != nil {
+					if !d.Type().IsRegular() {
+						return nil
+					}
+
+					f, err := fsys.Open(path)
+					if err != nil {
+						return fmt.Errorf("opening file: %w", err)
+					}
+					defer f.Close()
+
+					found, err := scanReader(f, ruleContentOverlap, func(chunk []byte) string {
+						return string(contentRegex.Find(chunk))
+					})
+					if err != nil {
+						return fmt.Errorf("reading %s: %w", path, err)
+					}
+					if found == "" {
+						return nil
+					}
+				}
+
+				return &pathError{path: path, err: errors.New(r.Message)}
+			})
+		},
+		Explain: fmt.Sprintf("See the definition of the %q linter in the build configuration", r.Name),
+	}, nil
+}
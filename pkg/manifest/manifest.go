@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	apkotypes "chainguard.dev/apko/pkg/build/types"
 	"chainguard.dev/melange/pkg/config"
@@ -16,6 +18,11 @@ import (
 type GeneratedMelangeConfig struct {
 	config.Configuration `yaml:",inline"`
 	GeneratedFromComment string `yaml:"-"`
+
+	// Comments to write above the nodes at their paths, with the keys of
+	// mappings and the indexes of sequences separated by dots, such as
+	// "pipeline.2" for the third step of the pipeline.
+	Comments map[string][]string `yaml:"-"`
 }
 
 func (m *GeneratedMelangeConfig) SetPackage(pkg config.Package) {
@@ -61,6 +68,11 @@ func (m *GeneratedMelangeConfig) Write(ctx context.Context, dir string) error {
 	if err := n.Encode(m); err != nil {
 		return fmt.Errorf("encoding YAML to node %s: %w", manifestPath, err)
 	}
+	for p, lines := range m.Comments {
+		if node := lookupNode(&n, p); node != nil {
+			node.HeadComment = "# " + strings.Join(lines, "\n# ")
+		}
+	}
 
 	if err := formatted.NewEncoder(f).AutomaticConfig().Encode(&n); err != nil {
 		return fmt.Errorf("encoding YAML to file %s: %w", manifestPath, err)
@@ -69,3 +81,36 @@ func (m *GeneratedMelangeConfig) Write(ctx context.Context, dir string) error {
 	clog.FromContext(ctx).Infof("Generated melange config: %s", manifestPath)
 	return nil
 }
+
+// lookupNode returns the node at the path p in n, or nil if there isn't
+// one. For values of mappings, it's their key, which comments go above.
+func lookupNode(n *yaml.Node, p string) *yaml.Node {
+	if n.Kind == yaml.DocumentNode && len(n.Content) > 0 {
+		n = n.Content[0]
+	}
+	parts := strings.Split(p, ".")
+	for j, part := range parts {
+		var next *yaml.Node
+		switch n.Kind {
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(n.Content); i += 2 {
+				if n.Content[i].Value == part {
+					if j == len(parts)-1 {
+						return n.Content[i]
+					}
+					next = n.Content[i+1]
+					break
+				}
+			}
+		case yaml.SequenceNode:
+			if i, err := strconv.Atoi(part); err == nil && i >= 0 && i < len(n.Content) {
+				next = n.Content[i]
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		n = next
+	}
+	return n
+}
@@ -0,0 +1,110 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package progress
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// Matches the escape sequences that programs in the guest use for colors
+// and moving the cursor, which would garble the display.
+var escapeRegex = regexp.MustCompile(`\x1b\[[0-9;?]*[ -/]*[@-~]|\x1b[@-_]`)
+
+// handler adds log records to the tail of the log that a display shows.
+type handler struct {
+	d *Display
+	// Decides which records are logged.
+	level slog.Handler
+	attrs string
+	group string
+}
+
+// Handler returns a log handler that adds records to the tail of the log
+// that the display shows, for those records that next would handle.
+func (d *Display) Handler(next slog.Handler) slog.Handler {
+	return &handler{d: d, level: next}
+}
+
+func (h *handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.level.Enabled(ctx, level)
+}
+
+func (h *handler) Handle(_ context.Context, rec slog.Record) error {
+	var attrs strings.Builder
+	attrs.WriteString(h.attrs)
+	rec.Attrs(func(a slog.Attr) bool {
+		writeAttr(&attrs, h.group, a)
+		return true
+	})
+
+	lines := strings.Split(rec.Message, "\n")
+	for i, l := range lines {
+		lines[i] = sanitize(l)
+	}
+	lines[0] = rec.Level.String() + " " + lines[0]
+	lines[len(lines)-1] += sanitize(attrs.String())
+	h.d.addLog(lines...)
+	return nil
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var sb strings.Builder
+	sb.WriteString(h.attrs)
+	for _, a := range attrs {
+		writeAttr(&sb, h.group, a)
+	}
+	return &handler{d: h.d, level: h.level.WithAttrs(attrs), attrs: sb.String(), group: h.group}
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return &handler{d: h.d, level: h.level.WithGroup(name), attrs: h.attrs, group: h.group + name + "."}
+}
+
+func writeAttr(sb *strings.Builder, group string, a slog.Attr) {
+	v := a.Value.Resolve()
+	if v.Kind() == slog.KindGroup {
+		for _, ga := range v.Group() {
+			writeAttr(sb, group+a.Key+".", ga)
+		}
+		return
+	}
+	if a.Key == "" {
+		return
+	}
+	fmt.Fprintf(sb, " %s%s=%s", group, a.Key, v)
+}
+
+// sanitize removes escape sequences and other control characters from line.
+func sanitize(line string) string {
+	line = escapeRegex.ReplaceAllString(line, "")
+	// Programs that redraw a line with carriage returns are left with what
+	// they drew last.
+	if i := strings.LastIndexByte(strings.TrimRight(line, "\r"), '\r'); i >= 0 {
+		line = line[i+1:]
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '\t':
+			return ' '
+		case r < ' ' || r == 0x7f:
+			return -1
+		}
+		return r
+	}, line)
+}
@@ -0,0 +1,407 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package progress shows the progress of builds in a terminal: the steps
+// that are running and how long they've taken, the status of subpackages,
+// and the tail of the build log, in place of the whole log.
+package progress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"chainguard.dev/melange/pkg/build"
+	"golang.org/x/term"
+)
+
+// How many lines of the log are kept to show the tail of, and how many
+// finished steps of each build are shown.
+const (
+	maxLogLines      = 1000
+	maxFinishedSteps = 5
+)
+
+// How often the display is redrawn.
+const refreshInterval = 250 * time.Millisecond
+
+// Display shows the progress of builds in a terminal, from their events and
+// their logs.
+type Display struct {
+	out *os.File
+
+	mu     sync.Mutex
+	builds []*buildState
+	log    []string
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type buildState struct {
+	pkg, arch        string
+	started          time.Time
+	finished         bool
+	duration         time.Duration
+	err              string
+	steps            []*stepState
+	subpackages      []*subpackageState
+	subpackagesByKey map[string]*subpackageState
+}
+
+type stepState struct {
+	n, parent  int
+	name       string
+	subpackage string
+	started    time.Time
+	finished   bool
+	duration   time.Duration
+	failed     bool
+}
+
+// The states that a subpackage's pipeline can be in.
+const (
+	pending = iota
+	running
+	succeeded
+	failed
+	skipped
+)
+
+type subpackageState struct {
+	name     string
+	state    int
+	started  time.Time
+	duration time.Duration
+}
+
+// New returns a display that draws on out, which must be a terminal.
+func New(out *os.File) *Display {
+	return &Display{out: out}
+}
+
+// IsTerminal reports whether f is a terminal that a display can draw on.
+func IsTerminal(f *os.File) bool {
+	return term.IsTerminal(int(f.Fd()))
+}
+
+// Handle updates the display with an event of a build. It can be called
+// from any goroutine.
+func (d *Display) Handle(e build.Event) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	b := d.build(e.Package, e.Arch)
+	if b == nil {
+		b = &buildState{pkg: e.Package, arch: e.Arch, started: e.Time, subpackagesByKey: map[string]*subpackageState{}}
+		d.builds = append(d.builds, b)
+	}
+	duration := time.Duration(e.Duration * float64(time.Second))
+
+	switch e.Type {
+	case build.EventBuildStarted:
+		b.started = e.Time
+		for _, name := range e.Subpackages {
+			b.subpackage(name)
+		}
+	case build.EventBuildFinished:
+		b.finished, b.duration, b.err = true, duration, e.Error
+	case build.EventStepStarted:
+		b.steps = append(b.steps, &stepState{n: e.Step, parent: e.Parent, name: e.Name, subpackage: e.Subpackage, started: e.Time})
+	case build.EventStepFinished:
+		for _, s := range b.steps {
+			if s.n == e.Step {
+				s.finished, s.duration, s.failed = true, duration, e.Error != ""
+			}
+		}
+	case build.EventSubpackageStarted:
+		sp := b.subpackage(e.Subpackage)
+		sp.state, sp.started = running, e.Time
+	case build.EventSubpackageFinished:
+		sp := b.subpackage(e.Subpackage)
+		sp.state, sp.duration = succeeded, duration
+		if e.Error != "" {
+			sp.state = failed
+		}
+	case build.EventSubpackageSkipped:
+		b.subpackage(e.Subpackage).state = skipped
+	}
+}
+
+func (d *Display) build(pkg, arch string) *buildState {
+	for _, b := range d.builds {
+		if b.pkg == pkg && b.arch == arch {
+			return b
+		}
+	}
+	return nil
+}
+
+func (b *buildState) subpackage(name string) *subpackageState {
+	sp, ok := b.subpackagesByKey[name]
+	if !ok {
+		sp = &subpackageState{name: name}
+		b.subpackagesByKey[name] = sp
+		b.subpackages = append(b.subpackages, sp)
+	}
+	return sp
+}
+
+// addLog adds lines to the tail of the log.
+func (d *Display) addLog(lines ...string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.log = append(d.log, lines...)
+	if n := len(d.log) - maxLogLines; n > 0 {
+		d.log = append(d.log[:0], d.log[n:]...)
+	}
+}
+
+// Start takes over the terminal, and redraws the display on it until Stop
+// is called.
+func (d *Display) Start() {
+	d.stop, d.done = make(chan struct{}), make(chan struct{})
+
+	// Draw on the alternate screen, so that what was on the terminal before
+	// is back once the builds are done.
+	fmt.Fprint(d.out, "\x1b[?1049h\x1b[?25l")
+	go func() {
+		defer close(d.done)
+		t := time.NewTicker(refreshInterval)
+		defer t.Stop()
+		for {
+			d.draw()
+			select {
+			case <-d.stop:
+				return
+			case <-t.C:
+			}
+		}
+	}()
+}
+
+// Stop gives the terminal back, and writes what the builds did to it, with
+// the tail of the log if any of them failed.
+func (d *Display) Stop() {
+	close(d.stop)
+	<-d.done
+	fmt.Fprint(d.out, "\x1b[?25h\x1b[?1049l")
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	width, _ := d.size()
+	lines := d.render(now, width)
+	for _, b := range d.builds {
+		if b.err != "" {
+			lines = append(lines, "", "Last lines of the log:")
+			lines = append(lines, tail(d.log, 30)...)
+			break
+		}
+	}
+	io.WriteString(d.out, strings.Join(lines, "\n")+"\n") //nolint:errcheck
+}
+
+func (d *Display) size() (int, int) {
+	width, height, err := term.GetSize(int(d.out.Fd()))
+	if err != nil {
+		return 80, 24
+	}
+	return width, height
+}
+
+func (d *Display) draw() {
+	d.mu.Lock()
+	width, height := d.size()
+	lines := d.render(time.Now(), width)
+
+	// The rest of the screen is the tail of the log.
+	if room := height - len(lines) - 1; room > 0 {
+		lines = append(lines, dim(strings.Repeat("─", width)))
+		for _, l := range tail(d.log, room-1) {
+			lines = append(lines, truncate(l, width))
+		}
+	}
+	d.mu.Unlock()
+
+	if len(lines) > height {
+		lines = lines[:height]
+	}
+	var sb strings.Builder
+	sb.WriteString("\x1b[H")
+	for i, l := range lines {
+		if i > 0 {
+			sb.WriteString("\r\n")
+		}
+		sb.WriteString(l)
+		sb.WriteString("\x1b[K")
+	}
+	sb.WriteString("\x1b[J")
+	io.WriteString(d.out, sb.String()) //nolint:errcheck
+}
+
+// render returns the lines showing the progress of each build at now, no
+// wider than width.
+func (d *Display) render(now time.Time, width int) []string {
+	var lines []string
+	for _, b := range d.builds {
+		status, elapsed := spinner(now), now.Sub(b.started)
+		switch {
+		case b.finished && b.err != "":
+			status, elapsed = red("✗"), b.duration
+		case b.finished:
+			status, elapsed = green("✓"), b.duration
+		}
+		lines = append(lines, row(status, b.pkg+" "+b.arch, bold, elapsed, width, 0))
+
+		// The last few top-level steps of the main pipeline that have
+		// finished, and every step that's running.
+		var finished []*stepState
+		for _, s := range b.steps {
+			if s.finished && s.parent == 0 && s.subpackage == "" {
+				finished = append(finished, s)
+			}
+		}
+		if n := len(finished) - maxFinishedSteps; n > 0 {
+			lines = append(lines, row(" ", fmt.Sprintf("%d more steps", n), dim, -1, width, 1))
+			finished = finished[n:]
+		}
+		for _, s := range b.steps {
+			if s.finished && (s.failed || slices.Contains(finished, s)) {
+				mark := green("✓")
+				if s.failed {
+					mark = red("✗")
+				}
+				lines = append(lines, row(mark, s.label(), nil, s.duration, width, 1+b.depth(s)))
+			} else if !s.finished && !b.finished {
+				lines = append(lines, row(yellow("▶"), s.label(), nil, now.Sub(s.started), width, 1+b.depth(s)))
+			}
+		}
+
+		if len(b.subpackages) != 0 {
+			var sb strings.Builder
+			n := len("subpackages:")
+			sb.WriteString(dim("subpackages:"))
+			for _, sp := range b.subpackages {
+				mark, text := dim("·"), sp.name
+				switch sp.state {
+				case running:
+					mark, text = yellow("▶"), sp.name+" "+formatDuration(now.Sub(sp.started))
+				case succeeded:
+					mark = green("✓")
+				case failed:
+					mark = red("✗")
+				case skipped:
+					mark, text = dim("-"), sp.name+" (skipped)"
+				}
+				if n+3+utf8.RuneCountInString(text) > width-4 {
+					sb.WriteString(" …")
+					break
+				}
+				sb.WriteString("  " + mark + " " + text)
+				n += 3 + utf8.RuneCountInString(text)
+			}
+			lines = append(lines, "  "+sb.String())
+		}
+	}
+	return lines
+}
+
+// depth returns how deeply s is nested in other steps.
+func (b *buildState) depth(s *stepState) int {
+	depth := 0
+	for parent := s.parent; parent != 0; depth++ {
+		next := 0
+		for _, p := range b.steps {
+			if p.n == parent {
+				next = p.parent
+			}
+		}
+		parent = next
+	}
+	return depth
+}
+
+func (s *stepState) label() string {
+	if s.subpackage != "" {
+		return s.subpackage + ": " + s.name
+	}
+	return s.name
+}
+
+// row returns a line no wider than width with mark and text, in style if
+// it isn't nil, indented by indent, and with elapsed on the right if it
+// isn't negative.
+func row(mark, text string, style func(string) string, elapsed time.Duration, width, indent int) string {
+	prefix := strings.Repeat("  ", indent) + mark + " "
+	used := 2*indent + 2
+	var right string
+	if elapsed >= 0 {
+		right = formatDuration(elapsed)
+	}
+	text = truncate(text, max(width-used-len(right)-1, 1))
+	pad := max(width-used-utf8.RuneCountInString(text)-len(right), 1)
+	if style != nil {
+		text = style(text)
+	}
+	return prefix + text + strings.Repeat(" ", pad) + dim(right)
+}
+
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	if d < time.Minute {
+		return fmt.Sprintf("%ds", d/time.Second)
+	}
+	if d < time.Hour {
+		return fmt.Sprintf("%dm%02ds", d/time.Minute, (d%time.Minute)/time.Second)
+	}
+	return fmt.Sprintf("%dh%02dm", d/time.Hour, (d%time.Hour)/time.Minute)
+}
+
+// spinner returns a frame of a spinner at now.
+func spinner(now time.Time) string {
+	frames := []rune("⠋⠙⠹⠸⠼⠴⠦⠧⠇⠏")
+	return yellow(string(frames[now.UnixMilli()/100%int64(len(frames))]))
+}
+
+func tail(lines []string, n int) []string {
+	if len(lines) > n {
+		return lines[len(lines)-n:]
+	}
+	return lines
+}
+
+// truncate cuts s, which has no escape codes, to width runes.
+func truncate(s string, width int) string {
+	r := []rune(s)
+	if len(r) <= width {
+		return s
+	}
+	if width <= 1 {
+		return string(r[:width])
+	}
+	return string(r[:width-1]) + "…"
+}
+
+func bold(s string) string   { return "\x1b[1m" + s + "\x1b[0m" }
+func dim(s string) string    { return "\x1b[2m" + s + "\x1b[0m" }
+func red(s string) string    { return "\x1b[31m" + s + "\x1b[0m" }
+func green(s string) string  { return "\x1b[32m" + s + "\x1b[0m" }
+func yellow(s string) string { return "\x1b[33m" + s + "\x1b[0m" }
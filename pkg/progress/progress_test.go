@@ -0,0 +1,90 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package progress
+
+import (
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"chainguard.dev/melange/pkg/build"
+	"github.com/stretchr/testify/require"
+)
+
+func plain(lines []string) string {
+	return escapeRegex.ReplaceAllString(strings.Join(lines, "\n"), "")
+}
+
+func TestRender(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	at := func(s int) time.Time { return start.Add(time.Duration(s) * time.Second) }
+
+	d := New(nil)
+	for _, e := range []build.Event{
+		{Type: build.EventBuildStarted, Time: at(0), Subpackages: []string{"foo-dev", "foo-doc", "foo-static"}},
+		{Type: build.EventStepStarted, Time: at(0), Step: 1, Name: "fetch"},
+		{Type: build.EventStepFinished, Time: at(4), Step: 1, Name: "fetch", Duration: 4},
+		{Type: build.EventStepStarted, Time: at(4), Step: 2, Name: "autoconf/make"},
+		{Type: build.EventStepStarted, Time: at(5), Step: 3, Parent: 2, Name: "make -j8"},
+		{Type: build.EventSubpackageSkipped, Subpackage: "foo-static"},
+	} {
+		e.Package, e.Arch = "foo", "x86_64"
+		d.Handle(e)
+	}
+
+	got := plain(d.render(at(65), 40))
+	require.Equal(t, strings.Join([]string{
+		"⠋ foo x86_64                       1m05s",
+		"  ✓ fetch                             4s",
+		"  ▶ autoconf/make                  1m01s",
+		"    ▶ make -j8                     1m00s",
+		"  subpackages:  · foo-dev  · foo-doc …",
+	}, "\n"), got)
+
+	for _, e := range []build.Event{
+		{Type: build.EventStepFinished, Step: 3, Duration: 70},
+		{Type: build.EventStepFinished, Step: 2, Duration: 71},
+		{Type: build.EventSubpackageStarted, Time: at(75), Subpackage: "foo-dev"},
+		{Type: build.EventSubpackageFinished, Subpackage: "foo-dev", Duration: 1, Error: "boom"},
+		{Type: build.EventBuildFinished, Duration: 80, Error: "boom"},
+	} {
+		e.Package, e.Arch = "foo", "x86_64"
+		d.Handle(e)
+	}
+
+	got = plain(d.render(at(90), 80))
+	require.Equal(t, strings.Join([]string{
+		"✗ foo x86_64                                                               1m20s",
+		"  ✓ fetch                                                                     4s",
+		"  ✓ autoconf/make                                                          1m11s",
+		"  subpackages:  ✗ foo-dev  · foo-doc  - foo-static (skipped)",
+	}, "\n"), got)
+}
+
+func TestHandler(t *testing.T) {
+	d := New(nil)
+	log := slog.New(d.Handler(slog.NewTextHandler(nil, &slog.HandlerOptions{Level: slog.LevelInfo}))).With("arch", "x86_64")
+
+	log.Debug("hidden")
+	log.Info("\x1b[32mok\x1b[0m\tthere", "step", "make")
+	log.WithGroup("g").Warn("downloading 10%\rdownloaded\nsecond line", "n", 1)
+
+	require.Equal(t, []string{
+		"INFO ok there arch=x86_64 step=make",
+		"WARN downloaded",
+		"second line arch=x86_64 g.n=1",
+	}, d.log)
+}
@@ -125,7 +125,7 @@ func New(ctx context.Context, opts ...Option) renovate.Renovator {
 		// Look for fetch nodes.
 		it := yit.FromNode(pipelineNode).
 			RecurseNodes().
-			Filter(yit.WithMapValue("fetch"))
+			Filter(renovate.UsesPipeline("fetch"))
 
 		for fetchNode, ok := it(); ok; fetchNode, ok = it() {
 			if err := updateFetch(ctx, rc, fetchNode, bcfg.TargetVersion); err != nil {
@@ -136,7 +136,7 @@ func New(ctx context.Context, opts ...Option) renovate.Renovator {
 		// Look for git-checkout nodes.
 		it = yit.FromNode(pipelineNode).
 			RecurseNodes().
-			Filter(yit.WithMapValue("git-checkout"))
+			Filter(renovate.UsesPipeline("git-checkout"))
 
 		for gitCheckoutNode, ok := it(); ok; gitCheckoutNode, ok = it() {
 			if err := updateGitCheckout(ctx, gitCheckoutNode, bcfg.ExpectedCommit); err != nil {
@@ -103,7 +103,7 @@ func New(opts ...Option) renovate.Renovator {
 		// Look for fetch nodes.
 		it := yit.FromNode(pipelineNode).
 			RecurseNodes().
-			Filter(yit.WithMapValue("fetch"))
+			Filter(renovate.UsesPipeline("fetch"))
 
 		for fetchNode, ok := it(); ok; fetchNode, ok = it() {
 			if err := visitFetch(ctx, fetchNode, cfg); err != nil {
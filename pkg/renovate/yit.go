@@ -19,6 +19,8 @@ import (
 
 	"github.com/dprotaso/go-yit"
 	"gopkg.in/yaml.v3"
+
+	"chainguard.dev/melange/pkg/config"
 )
 
 // NodeFromMapping takes a yaml.Node (a mapping) and uses yit
@@ -33,3 +35,11 @@ func NodeFromMapping(parentNode *yaml.Node, key string) (*yaml.Node, error) {
 
 	return nil, fmt.Errorf("key '%s' not found in mapping", key)
 }
+
+// UsesPipeline returns a predicate that matches pipeline steps that use the
+// pipeline with the given name, at any version.
+func UsesPipeline(name string) yit.Predicate {
+	return yit.WithMapKeyValue(yit.WithValue("uses"), func(node *yaml.Node) bool {
+		return config.UsesName(node.Value) == name
+	})
+}
@@ -0,0 +1,193 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sbom
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"chainguard.dev/apko/pkg/sbom/generator/spdx"
+	"github.com/github/go-spdx/v2/spdxexp"
+	"github.com/spdx/tools-golang/spdx/v2/common"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+	"sigs.k8s.io/release-utils/version"
+
+	"chainguard.dev/melange/pkg/sbom/cyclonedx"
+)
+
+const (
+	// FormatSPDX is the format of SPDX 2.3 JSON SBOMs.
+	FormatSPDX = "spdx"
+	// FormatCycloneDX is the format of CycloneDX 1.5 JSON SBOMs.
+	FormatCycloneDX = "cyclonedx"
+)
+
+// Formats returns the formats that SBOMs can be written in.
+func Formats() []string {
+	return []string{FormatCycloneDX, FormatSPDX}
+}
+
+// ToCycloneDX returns the Document converted to its CycloneDX
+// representation. The described package is the component of its metadata,
+// and the packages it's generated from, such as its upstream sources, are
+// the ancestors in that component's pedigree. The other packages, such as
+// the build configuration, are the BOM's components. It returns an error if
+// the Document doesn't describe a package.
+func (d Document) ToCycloneDX() (cyclonedx.Document, error) {
+	if d.Describes == nil {
+		return cyclonedx.Document{}, errors.New("document doesn't describe a package")
+	}
+
+	generatedFrom := map[string]bool{}
+	describedBy := map[string]bool{}
+	for _, r := range d.Relationships {
+		if r.Element != d.Describes.ID() {
+			continue
+		}
+		switch r.Type {
+		case common.TypeRelationshipGeneratedFrom:
+			generatedFrom[r.Related] = true
+		case common.TypeRelationshipDescribeBy:
+			describedBy[r.Related] = true
+		}
+	}
+
+	described := d.Describes.toCycloneDX(cyclonedx.TypeLibrary, d.LicensingInfos)
+	var components []cyclonedx.Component
+	for _, p := range d.Packages {
+		switch id := p.ID(); {
+		case id == d.Describes.ID():
+		case generatedFrom[id]:
+			if described.Pedigree == nil {
+				described.Pedigree = &cyclonedx.Pedigree{}
+			}
+			described.Pedigree.Ancestors = append(described.Pedigree.Ancestors, p.toCycloneDX(cyclonedx.TypeLibrary, d.LicensingInfos))
+		case describedBy[id]:
+			components = append(components, p.toCycloneDX(cyclonedx.TypeFile, d.LicensingInfos))
+		default:
+			components = append(components, p.toCycloneDX(cyclonedx.TypeLibrary, d.LicensingInfos))
+		}
+	}
+
+	return cyclonedx.Document{
+		BOMFormat:    cyclonedx.BOMFormat,
+		SpecVersion:  cyclonedx.SpecVersion,
+		SerialNumber: d.getCycloneDXSerialNumber(),
+		Version:      1,
+		Metadata: cyclonedx.Metadata{
+			Timestamp: d.CreatedTime.UTC().Format(time.RFC3339),
+			Tools: &cyclonedx.Tools{
+				Components: []cyclonedx.Component{{
+					Type:     cyclonedx.TypeApplication,
+					Supplier: &cyclonedx.OrganizationalEntity{Name: "Chainguard, Inc"},
+					Name:     "melange",
+					Version:  version.GetVersionInfo().GitVersion,
+				}},
+			},
+			Component: &described,
+		},
+		Components: components,
+	}, nil
+}
+
+// getCycloneDXSerialNumber returns the serial number of the CycloneDX
+// document, a UUID derived from the described package, as the SPDX namespace
+// is, so that builds are reproducible.
+func (d Document) getCycloneDXSerialNumber() string {
+	h := sha1.Sum([]byte(fmt.Sprintf("apk-%s-%s-%s", d.Describes.Namespace, d.Describes.Name, d.Describes.Version)))
+	// Those of a version 5 UUID, which are name based with SHA-1.
+	h[6] = h[6]&0x0f | 0x50
+	h[8] = h[8]&0x3f | 0x80
+	return fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", h[0:4], h[4:6], h[6:8], h[8:10], h[10:16])
+}
+
+// toCycloneDX returns the Package converted to a CycloneDX component of the
+// type typ. The texts of the custom licenses it declares are taken from
+// licensingInfos.
+func (p Package) toCycloneDX(typ string, licensingInfos map[string]string) cyclonedx.Component {
+	c := cyclonedx.Component{
+		BOMRef:    p.ID(),
+		Type:      typ,
+		Name:      p.Name,
+		Version:   p.Version,
+		Hashes:    p.getCycloneDXHashes(),
+		Licenses:  cycloneDXLicenses(p.LicenseDeclared, licensingInfos),
+		Copyright: strings.TrimSpace(p.Copyright),
+	}
+	if p.Namespace != "" {
+		c.Supplier = &cyclonedx.OrganizationalEntity{Name: cases.Title(language.English).String(p.Namespace)}
+	}
+	if p.PURL != nil {
+		c.PURL = p.PURL.ToString()
+	}
+	return c
+}
+
+// cycloneDXHashAlgorithms are the names of the hash algorithms in CycloneDX
+// by the keys of Package.Checksums.
+var cycloneDXHashAlgorithms = map[string]string{
+	"MD5":    "MD5",
+	"SHA1":   "SHA-1",
+	"SHA256": "SHA-256",
+	"SHA384": "SHA-384",
+	"SHA512": "SHA-512",
+}
+
+func (p Package) getCycloneDXHashes() []cyclonedx.Hash {
+	var result []cyclonedx.Hash
+	for algo, value := range p.Checksums {
+		name, ok := cycloneDXHashAlgorithms[strings.ToUpper(strings.ReplaceAll(algo, "-", ""))]
+		if !ok {
+			// CycloneDX only has a fixed list of algorithms.
+			continue
+		}
+		result = append(result, cyclonedx.Hash{Algorithm: name, Content: value})
+	}
+	slices.SortFunc(result, func(a, b cyclonedx.Hash) int {
+		return strings.Compare(a.Algorithm, b.Algorithm)
+	})
+	return result
+}
+
+// cycloneDXLicenses returns the licenses of a component whose SPDX license
+// expression is expr: a single license, by its SPDX identifier or, if it's a
+// custom one, by its name and the text of it in licensingInfos, or else the
+// expression. Components without a license, or with NOASSERTION, have none.
+func cycloneDXLicenses(expr string, licensingInfos map[string]string) []cyclonedx.LicenseChoice {
+	expr = strings.TrimSpace(expr)
+	if expr == "" || expr == spdx.NOASSERTION {
+		return nil
+	}
+	if strings.ContainsAny(expr, " ()") {
+		return []cyclonedx.LicenseChoice{{Expression: expr}}
+	}
+
+	if strings.HasPrefix(expr, "LicenseRef-") {
+		l := &cyclonedx.License{Name: expr}
+		if text, ok := licensingInfos[expr]; ok {
+			l.Text = &cyclonedx.AttachedText{ContentType: "text/plain", Content: text}
+		}
+		return []cyclonedx.LicenseChoice{{License: l}}
+	}
+	if valid, _ := spdxexp.ValidateLicenses([]string{expr}); !valid {
+		return []cyclonedx.LicenseChoice{{License: &cyclonedx.License{Name: expr}}}
+	}
+	return []cyclonedx.LicenseChoice{{License: &cyclonedx.License{ID: expr}}}
+}
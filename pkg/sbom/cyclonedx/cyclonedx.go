@@ -0,0 +1,107 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cyclonedx has the types of the parts of CycloneDX 1.5 JSON
+// documents that melange writes.
+//
+// See https://cyclonedx.org/docs/1.5/json/.
+package cyclonedx
+
+const (
+	BOMFormat   = "CycloneDX"
+	SpecVersion = "1.5"
+
+	// Component types.
+	TypeApplication = "application"
+	TypeFile        = "file"
+	TypeLibrary     = "library"
+)
+
+// Document is a CycloneDX BOM.
+type Document struct {
+	BOMFormat    string   `json:"bomFormat"`
+	SpecVersion  string   `json:"specVersion"`
+	SerialNumber string   `json:"serialNumber,omitempty"`
+	Version      int      `json:"version"`
+	Metadata     Metadata `json:"metadata"`
+	// Components are the components of the BOM other than the one it
+	// describes.
+	Components []Component `json:"components,omitempty"`
+}
+
+// Metadata is the metadata of a BOM.
+type Metadata struct {
+	Timestamp string `json:"timestamp,omitempty"`
+	Tools     *Tools `json:"tools,omitempty"`
+	// Component is the component the BOM describes.
+	Component *Component `json:"component,omitempty"`
+}
+
+// Tools are the tools used to create a BOM.
+type Tools struct {
+	Components []Component `json:"components,omitempty"`
+}
+
+// Component is a component of a BOM, such as a package.
+type Component struct {
+	BOMRef      string                `json:"bom-ref,omitempty"`
+	Type        string                `json:"type"`
+	Supplier    *OrganizationalEntity `json:"supplier,omitempty"`
+	Name        string                `json:"name"`
+	Version     string                `json:"version,omitempty"`
+	Description string                `json:"description,omitempty"`
+	Hashes      []Hash                `json:"hashes,omitempty"`
+	Licenses    []LicenseChoice       `json:"licenses,omitempty"`
+	Copyright   string                `json:"copyright,omitempty"`
+	PURL        string                `json:"purl,omitempty"`
+	Pedigree    *Pedigree             `json:"pedigree,omitempty"`
+}
+
+// OrganizationalEntity is an organization, such as a supplier.
+type OrganizationalEntity struct {
+	Name string `json:"name,omitempty"`
+}
+
+// Hash is a hash of a component, such as {"alg": "SHA-256", ...}.
+type Hash struct {
+	Algorithm string `json:"alg"`
+	Content   string `json:"content"`
+}
+
+// LicenseChoice is either a license or an SPDX license expression, which
+// is the only item of the licenses of a component.
+type LicenseChoice struct {
+	License    *License `json:"license,omitempty"`
+	Expression string   `json:"expression,omitempty"`
+}
+
+// License is a license by its SPDX identifier, or by its name and text.
+type License struct {
+	ID   string        `json:"id,omitempty"`
+	Name string        `json:"name,omitempty"`
+	Text *AttachedText `json:"text,omitempty"`
+}
+
+// AttachedText is text, such as that of a license.
+type AttachedText struct {
+	ContentType string `json:"contentType,omitempty"`
+	Content     string `json:"content"`
+}
+
+// Pedigree is where a component comes from.
+type Pedigree struct {
+	// Ancestors are the components that the component is derived from, such
+	// as its upstream sources.
+	Ancestors []Component `json:"ancestors,omitempty"`
+}
@@ -0,0 +1,99 @@
+package sbom
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spdx/tools-golang/spdx/v2/common"
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/melange/pkg/sbom/cyclonedx"
+)
+
+func TestToCycloneDX(t *testing.T) {
+	pkg := &Package{
+		Name:            "foo",
+		Version:         "1.2.3-r0",
+		Copyright:       "Copyright Foo\n",
+		LicenseDeclared: "Apache-2.0",
+		Namespace:       "wolfi",
+	}
+	src := &Package{
+		Name:            "foo-source",
+		Version:         "1.2.3",
+		LicenseDeclared: "MIT OR Apache-2.0",
+		Checksums:       map[string]string{"SHA256": "abcd", "SHA512": "ef01", "BLAKE3": "2345"},
+	}
+	cfg := &Package{
+		Name:            "foo.yaml",
+		Version:         "deadbeef",
+		LicenseDeclared: "LicenseRef-foo",
+	}
+	other := &Package{Name: "bar", LicenseDeclared: "NOASSERTION"}
+
+	doc := NewDocument()
+	doc.CreatedTime = time.Unix(0, 0)
+	doc.AddPackageAndSetDescribed(pkg)
+	doc.AddPackage(other)
+	doc.AddPackage(cfg)
+	doc.AddRelationship(doc.Describes, cfg, common.TypeRelationshipDescribeBy)
+	doc.AddPackage(src)
+	doc.AddRelationship(doc.Describes, src, common.TypeRelationshipGeneratedFrom)
+	doc.LicensingInfos = map[string]string{"LicenseRef-foo": "The Foo License"}
+
+	got, err := doc.ToCycloneDX()
+	require.NoError(t, err)
+	require.Equal(t, "CycloneDX", got.BOMFormat)
+	require.Equal(t, "1.5", got.SpecVersion)
+	require.Regexp(t, `^urn:uuid:[0-9a-f]{8}-[0-9a-f]{4}-5[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, got.SerialNumber)
+	again, err := doc.ToCycloneDX()
+	require.NoError(t, err)
+	require.Equal(t, got.SerialNumber, again.SerialNumber, "serial numbers are reproducible")
+	require.Equal(t, "1970-01-01T00:00:00Z", got.Metadata.Timestamp)
+
+	require.Equal(t, &cyclonedx.Component{
+		BOMRef:    "SPDXRef-Package-foo-1.2.3-r0",
+		Type:      cyclonedx.TypeLibrary,
+		Supplier:  &cyclonedx.OrganizationalEntity{Name: "Wolfi"},
+		Name:      "foo",
+		Version:   "1.2.3-r0",
+		Licenses:  []cyclonedx.LicenseChoice{{License: &cyclonedx.License{ID: "Apache-2.0"}}},
+		Copyright: "Copyright Foo",
+		Pedigree: &cyclonedx.Pedigree{
+			Ancestors: []cyclonedx.Component{{
+				BOMRef:  "SPDXRef-Package-foo-source-1.2.3",
+				Type:    cyclonedx.TypeLibrary,
+				Name:    "foo-source",
+				Version: "1.2.3",
+				Hashes: []cyclonedx.Hash{
+					{Algorithm: "SHA-256", Content: "abcd"},
+					{Algorithm: "SHA-512", Content: "ef01"},
+				},
+				Licenses: []cyclonedx.LicenseChoice{{Expression: "MIT OR Apache-2.0"}},
+			}},
+		},
+	}, got.Metadata.Component)
+
+	require.Equal(t, []cyclonedx.Component{{
+		BOMRef: "SPDXRef-Package-bar-",
+		Type:   cyclonedx.TypeLibrary,
+		Name:   "bar",
+	}, {
+		BOMRef:  "SPDXRef-Package-foo.yaml-deadbeef",
+		Type:    cyclonedx.TypeFile,
+		Name:    "foo.yaml",
+		Version: "deadbeef",
+		Licenses: []cyclonedx.LicenseChoice{{License: &cyclonedx.License{
+			Name: "LicenseRef-foo",
+			Text: &cyclonedx.AttachedText{ContentType: "text/plain", Content: "The Foo License"},
+		}}},
+	}}, got.Components)
+}
+
+func TestToCycloneDXWithoutDescribedPackage(t *testing.T) {
+	doc := NewDocument()
+	doc.AddPackage(&Package{Name: "foo", Version: "1.2.3-r0"})
+
+	_, err := doc.ToCycloneDX()
+	require.Error(t, err)
+}
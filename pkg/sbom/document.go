@@ -12,7 +12,7 @@ import (
 )
 
 // Document is a representation of an SBOM information provided by the build
-// process. It is later converted to an SPDX document.
+// process. It is later converted to an SPDX or CycloneDX document.
 type Document struct {
 	CreatedTime time.Time
 	Describes   *Package
@@ -14,7 +14,7 @@
 
 // Package sbom captures the internal data model of the SBOMs melange produces
 // into a private, generalized bill of materials model (with relationship data)
-// designed to be converted to specific formats — for now, just SPDX.
+// designed to be converted to specific formats, SPDX and CycloneDX.
 package sbom
 
 import (
@@ -83,6 +83,11 @@ type Package struct {
 	// only ExternalRef of type "purl" to the SPDX package. (A package
 	// should have only one PURL external ref.)
 	PURL *purl.PackageURL
+
+	// Background information about how the package was obtained, such as which
+	// parts of an upstream source repository were used. It's usually left
+	// blank.
+	SourceInfo string
 }
 
 // ToSPDX returns the Package converted to its SPDX representation.
@@ -92,7 +97,7 @@ func (p Package) ToSPDX(ctx context.Context) spdx.Package {
 	if p.LicenseDeclared == "" {
 		log.Warnf("%s: no license specified, defaulting to %s", p.ID(), spdx.NOASSERTION)
 		p.LicenseDeclared = spdx.NOASSERTION
-	} else {
+	} else if p.LicenseDeclared != spdx.NOASSERTION {
 		valid, bad := spdxexp.ValidateLicenses([]string{p.LicenseDeclared})
 		if !valid {
 			log.Warnf("invalid license: %s", strings.Join(bad, ", "))
@@ -108,6 +113,7 @@ func (p Package) ToSPDX(ctx context.Context) spdx.Package {
 		LicenseDeclared:  p.LicenseDeclared,
 		DownloadLocation: spdx.NOASSERTION,
 		CopyrightText:    p.Copyright,
+		SourceInfo:       p.SourceInfo,
 		Checksums:        p.getChecksums(),
 		ExternalRefs:     p.getExternalRefs(),
 		Originator:       p.getSupplier(), // yes, we use this value for both fields (for now)
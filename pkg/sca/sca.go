@@ -15,6 +15,7 @@
 package sca
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"debug/buildinfo"
@@ -23,6 +24,7 @@ import (
 	"io"
 	"io/fs"
 	"path/filepath"
+	"regexp"
 	"slices"
 	"strings"
 	"unicode"
@@ -587,6 +589,123 @@ func generateRubyDeps(ctx context.Context, hdl SCAHandle, generated *config.Depe
 	return nil
 }
 
+var (
+	rubyGemspecNameRe = regexp.MustCompile(`^\s*\w+\.name\s*=\s*(?:%q<([^>]+)>|["']([^"']+)["'])`)
+	rubyGemspecDepRe  = regexp.MustCompile(`^\s*\w+\.add_(runtime_)?dependency[\s(]+(?:%q<([^>]+)>|["']([^"']+)["'])`)
+)
+
+// parseRubyGemspec returns the name of the gem that an installed gemspec
+// describes, and the names of the gems it depends on at runtime.
+// Development dependencies are not included.
+//
+// Gemspecs written by RubyGems before 3.5 list the runtime dependencies with
+// add_runtime_dependency, and all dependencies again with add_dependency in
+// fallbacks for old versions of RubyGems, so add_dependency only lists the
+// runtime dependencies of gemspecs without add_runtime_dependency.
+func parseRubyGemspec(r io.Reader) (string, []string, error) {
+	var name string
+	var runtime, deps []string
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if m := rubyGemspecNameRe.FindStringSubmatch(line); m != nil && name == "" {
+			name = m[1] + m[2]
+		} else if m := rubyGemspecDepRe.FindStringSubmatch(line); m != nil {
+			if m[1] != "" {
+				runtime = append(runtime, m[2]+m[3])
+			} else {
+				deps = append(deps, m[2]+m[3])
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", nil, err
+	}
+
+	if len(runtime) != 0 {
+		return name, runtime, nil
+	}
+	return name, deps, nil
+}
+
+// generateRubyGemDeps generates a rubyX.Y-gem: provider for each gem that is
+// installed in the system gem directory and, for packages with the
+// ruby-gem-depends option, depends on the gems that they need at runtime.
+// Like pc: dependencies, this can generate depends on gems that no package
+// provides yet, which is why they're opt-in until the providers are built.
+func generateRubyGemDeps(ctx context.Context, hdl SCAHandle, generated *config.Dependencies) error {
+	log := clog.FromContext(ctx)
+	log.Infof("scanning for ruby gemspecs...")
+
+	fsys, err := hdl.Filesystem()
+	if err != nil {
+		return err
+	}
+
+	specDirs, err := fs.Glob(fsys, "usr/lib/ruby/gems/[0-9]*.[0-9]*.[0-9]*/specifications")
+	if err != nil {
+		return err
+	}
+
+	for _, specDir := range specDirs {
+		majorMinorMicro := filepath.Base(filepath.Dir(specDir))
+		prefix := fmt.Sprintf("ruby%s-gem:", strings.TrimSuffix(majorMinorMicro, filepath.Ext(majorMinorMicro)))
+
+		specs, err := fs.Glob(fsys, filepath.Join(specDir, "*.gemspec"))
+		if err != nil {
+			return err
+		}
+		defaults, err := fs.Glob(fsys, filepath.Join(specDir, "default", "*.gemspec"))
+		if err != nil {
+			return err
+		}
+
+		provided := map[string]bool{}
+		var deps []string
+		for _, spec := range append(specs, defaults...) {
+			f, err := fsys.Open(spec)
+			if err != nil {
+				return err
+			}
+			name, specDeps, err := parseRubyGemspec(f)
+			f.Close()
+			if err != nil {
+				return fmt.Errorf("parsing %s: %w", spec, err)
+			}
+			if name == "" {
+				log.Warnf("  %s does not name its gem, skipping", spec)
+				continue
+			}
+
+			log.Infof("  found gem %s in %s", name, spec)
+			provided[name] = true
+			generated.Provides = append(generated.Provides, fmt.Sprintf("%s%s=%s", prefix, name, hdl.Version()))
+			deps = append(deps, specDeps...)
+		}
+
+		if !hdl.Options().RubyGemDepends {
+			continue
+		}
+
+		// Several of the gems can need the same one.
+		slices.Sort(deps)
+		deps = slices.Compact(deps)
+		for _, dep := range deps {
+			if provided[dep] {
+				continue
+			}
+			if slices.Contains(hdl.BaseDependencies().Runtime, prefix+dep) {
+				continue
+			}
+			log.Infof("  found gem dependency %s", dep)
+			generated.Runtime = append(generated.Runtime, prefix+dep)
+		}
+	}
+
+	return nil
+}
+
 func sonameLibver(soname string) string {
 	parts := strings.Split(soname, ".so.")
 	if len(parts) < 2 {
@@ -704,14 +823,18 @@ func generateShbangDeps(ctx context.Context, hdl SCAHandle, generated *config.De
 }
 
 // Analyze runs the SCA analyzers on a given SCA handle, modifying the generated dependencies
-// set as needed.
+// set as needed. If the package's scan-paths option lists any directories, only
+// those are scanned.
 func Analyze(ctx context.Context, hdl SCAHandle, generated *config.Dependencies) error {
+	hdl = scopeHandle(hdl)
+
 	generators := []DependencyGenerator{
 		generateSharedObjectNameDeps,
 		generateCmdProviders,
 		generatePkgConfigDeps,
 		generatePythonDeps,
 		generateRubyDeps,
+		generateRubyGemDeps,
 		generateShbangDeps,
 	}
 
@@ -24,12 +24,15 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"io/fs"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
 	"strings"
 	"testing"
+	"testing/fstest"
 	"time"
 
 	"chainguard.dev/apko/pkg/apk/apk"
@@ -167,6 +170,43 @@ func TestExecableSharedObjects(t *testing.T) {
 	}
 }
 
+func TestScanPaths(t *testing.T) {
+	ctx := slogtest.Context(t)
+	th := handleFromApk(ctx, t, "libcap-2.69-r0.apk", "libcap.yaml")
+	defer th.exp.Close()
+
+	// The libraries are in usr/lib, so nothing is found outside of it.
+	th.cfg.Package.Options = &config.PackageOption{ScanPaths: []string{"/var/lib", "usr/bin"}}
+	got := config.Dependencies{}
+	if err := Analyze(ctx, th, &got); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(config.Dependencies{}, got); diff != "" {
+		t.Errorf("Analyze(): (-want, +got):\n%s", diff)
+	}
+
+	th.cfg.Package.Options = &config.PackageOption{ScanPaths: []string{"usr/lib/"}}
+	got = config.Dependencies{}
+	if err := Analyze(ctx, th, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := config.Dependencies{
+		Runtime: util.Dedup([]string{
+			"so:ld-linux-aarch64.so.1",
+			"so:libc.so.6",
+			"so:libcap.so.2",
+			"so:libpsx.so.2",
+		}),
+		Provides: util.Dedup([]string{
+			"so:libcap.so.2=2",
+			"so:libpsx.so.2=2",
+		}),
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Analyze(): (-want, +got):\n%s", diff)
+	}
+}
+
 func TestVendoredPkgConfig(t *testing.T) {
 	ctx := slogtest.Context(t)
 	// Generated by:
@@ -212,13 +252,149 @@ func TestRubySca(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	want := config.Dependencies{Runtime: []string{"ruby-3.2"}}
+	want := config.Dependencies{
+		Runtime:  []string{"ruby-3.2"},
+		Provides: []string{"ruby3.2-gem:base64=0.2.0-r2"},
+	}
 
 	if diff := cmp.Diff(want, got); diff != "" {
 		t.Errorf("Analyze(): (-want, +got):\n%s", diff)
 	}
 }
 
+func TestParseRubyGemspec(t *testing.T) {
+	rackTest, err := os.ReadFile(filepath.Join("testdata", "ruby-gems", "rack-test-2.0.2.gemspec"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		desc     string
+		spec     string
+		wantName string
+		wantDeps []string
+	}{{
+		desc: "RubyGems 3.5",
+		// An excerpt of the gemspec that gem install writes for rails-html-sanitizer.
+		spec: `# -*- encoding: utf-8 -*-
+# stub: rails-html-sanitizer 1.6.0 ruby lib
+
+Gem::Specification.new do |s|
+  s.name = "rails-html-sanitizer".freeze
+  s.version = "1.6.0"
+
+  s.specification_version = 4
+
+  s.add_dependency(%q<loofah>.freeze, ["~> 2.21".freeze])
+  s.add_dependency(%q<nokogiri>.freeze, ["~> 1.14".freeze])
+  s.add_development_dependency(%q<minitest>.freeze, [">= 0".freeze])
+end
+`,
+		wantName: "rails-html-sanitizer",
+		wantDeps: []string{"loofah", "nokogiri"},
+	}, {
+		desc: "RubyGems 3.4",
+		spec: `Gem::Specification.new do |s|
+  s.name = "rails-html-sanitizer".freeze
+  s.add_runtime_dependency(%q<loofah>.freeze, ["~> 2.21".freeze])
+  s.add_runtime_dependency(%q<nokogiri>.freeze, ["~> 1.14".freeze])
+  s.add_development_dependency(%q<minitest>.freeze, [">= 0".freeze])
+end
+`,
+		wantName: "rails-html-sanitizer",
+		wantDeps: []string{"loofah", "nokogiri"},
+	}, {
+		// The development dependencies are in the fallbacks for old
+		// versions of RubyGems too.
+		desc:     "RubyGems 2.7",
+		spec:     string(rackTest),
+		wantName: "rack-test",
+		wantDeps: []string{"rack"},
+	}} {
+		name, deps, err := parseRubyGemspec(strings.NewReader(c.spec))
+		if err != nil {
+			t.Fatalf("%s: %v", c.desc, err)
+		}
+		if name != c.wantName {
+			t.Errorf("%s: name: want %q, got %q", c.desc, c.wantName, name)
+		}
+		if diff := cmp.Diff(c.wantDeps, deps); diff != "" {
+			t.Errorf("%s: deps: (-want, +got):\n%s", c.desc, diff)
+		}
+	}
+}
+
+// mapHandle is a testHandle for a package whose contents are in a MapFS.
+type mapHandle struct {
+	testHandle
+	fsys mapFS
+}
+
+func (h *mapHandle) Filesystem() (SCAFS, error) {
+	return h.fsys, nil
+}
+
+type mapFS struct {
+	fstest.MapFS
+}
+
+func (mapFS) Readlink(name string) (string, error) {
+	return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+}
+
+func TestRubyGemDeps(t *testing.T) {
+	ctx := slogtest.Context(t)
+
+	// Gemspecs written by RubyGems 2.7, which need some of the same gems.
+	fsys := fstest.MapFS{}
+	for _, spec := range []string{"sinatra-2.2.4.gemspec", "rack-protection-2.2.4.gemspec", "rack-test-2.0.2.gemspec"} {
+		data, err := os.ReadFile(filepath.Join("testdata", "ruby-gems", spec))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fsys[path.Join("usr/lib/ruby/gems/3.2.0/specifications", spec)] = &fstest.MapFile{Data: data}
+	}
+	h := &mapHandle{
+		testHandle: testHandle{
+			pkg: apk.Package{Name: "ruby3.2-sinatra", Version: "2.2.4-r0"},
+			cfg: &config.Configuration{},
+		},
+		fsys: mapFS{fsys},
+	}
+	provides := []string{
+		"ruby3.2-gem:rack-protection=2.2.4-r0",
+		"ruby3.2-gem:rack-test=2.2.4-r0",
+		"ruby3.2-gem:sinatra=2.2.4-r0",
+	}
+
+	// Nothing provides the gems yet, so packages only depend on them if they
+	// opt in.
+	got := config.Dependencies{}
+	if err := generateRubyGemDeps(ctx, h, &got); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(config.Dependencies{Provides: provides}, got); diff != "" {
+		t.Errorf("generateRubyGemDeps(): (-want, +got):\n%s", diff)
+	}
+
+	h.cfg.Package.Options = &config.PackageOption{RubyGemDepends: true}
+	got = config.Dependencies{}
+	if err := generateRubyGemDeps(ctx, h, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := config.Dependencies{
+		Runtime: []string{
+			"ruby3.2-gem:mustermann",
+			"ruby3.2-gem:rack",
+			"ruby3.2-gem:tilt",
+		},
+		Provides: provides,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("generateRubyGemDeps(): (-want, +got):\n%s", diff)
+	}
+}
+
 func TestUnstableSonames(t *testing.T) {
 	ctx := slogtest.Context(t)
 	// Generated by:
@@ -0,0 +1,112 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sca
+
+import (
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// scopedHandle is an SCAHandle whose package filesystem only has the files in
+// the directories that the package's scan-paths option lists.
+type scopedHandle struct {
+	SCAHandle
+	paths []string
+}
+
+// scopeHandle returns hdl, scoped to the scan-paths of its options, if it
+// has any.
+func scopeHandle(hdl SCAHandle) SCAHandle {
+	var paths []string
+	for _, p := range hdl.Options().ScanPaths {
+		p = path.Clean(strings.TrimPrefix(p, "/"))
+		if p == "." {
+			// The whole package is scanned.
+			return hdl
+		}
+		paths = append(paths, p)
+	}
+	if len(paths) == 0 {
+		return hdl
+	}
+	return &scopedHandle{SCAHandle: hdl, paths: paths}
+}
+
+func (h *scopedHandle) Filesystem() (SCAFS, error) {
+	fsys, err := h.SCAHandle.Filesystem()
+	if err != nil {
+		return nil, err
+	}
+	return &scopedFS{SCAFS: fsys, paths: h.paths}, nil
+}
+
+// scopedFS is an SCAFS that only has the files in paths, and the directories
+// that lead to them.
+type scopedFS struct {
+	SCAFS
+	paths []string
+}
+
+// visible returns whether name is in one of the paths, or leads to one.
+func (f *scopedFS) visible(name string) bool {
+	if name == "." {
+		return true
+	}
+	for _, p := range f.paths {
+		if name == p || strings.HasPrefix(name, p+"/") || strings.HasPrefix(p, name+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *scopedFS) Open(name string) (fs.File, error) {
+	if !f.visible(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return f.SCAFS.Open(name)
+}
+
+func (f *scopedFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !f.visible(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	entries, err := fs.ReadDir(f.SCAFS, name)
+	if err != nil {
+		return nil, err
+	}
+	visible := entries[:0]
+	for _, e := range entries {
+		if f.visible(path.Join(name, e.Name())) {
+			visible = append(visible, e)
+		}
+	}
+	return visible, nil
+}
+
+func (f *scopedFS) Stat(name string) (fs.FileInfo, error) {
+	if !f.visible(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return f.SCAFS.Stat(name)
+}
+
+func (f *scopedFS) Readlink(name string) (string, error) {
+	if !f.visible(name) {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrNotExist}
+	}
+	return f.SCAFS.Readlink(name)
+}
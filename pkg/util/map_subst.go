@@ -16,11 +16,24 @@ package util
 
 import (
 	"fmt"
+	"regexp"
 	"strconv"
 
 	"chainguard.dev/melange/pkg/cond"
 )
 
+var stepOutputRegex = regexp.MustCompile(`^steps\.([a-zA-Z0-9_]+)\.outputs\.([a-zA-Z0-9_]+)$`)
+
+// StepOutputPrefix starts the names of the environment variables that step
+// outputs are passed to later steps in.
+const StepOutputPrefix = "MELANGE_OUTPUTS__"
+
+// StepOutputVariable returns the environment variable that the output name of
+// the step with the given id is passed to later steps in.
+func StepOutputVariable(id, name string) string {
+	return StepOutputPrefix + id + "__" + name
+}
+
 // Given a string and a map, replace the variables in the string with values in the map
 func MutateStringFromMap(with map[string]string, input string) (string, error) {
 	lookupWith := func(key string) (string, error) {
@@ -33,6 +46,12 @@ func MutateStringFromMap(with map[string]string, input string) (string, error) {
 			return val, nil
 		}
 
+		// Step outputs are only known once the step has run, so they are
+		// left for the shell to expand.
+		if m := stepOutputRegex.FindStringSubmatch(key); m != nil {
+			return fmt.Sprintf("${%s}", StepOutputVariable(m[1], m[2])), nil
+		}
+
 		return "", fmt.Errorf("variable %s not defined", key)
 	}
 
@@ -40,9 +59,9 @@ func MutateStringFromMap(with map[string]string, input string) (string, error) {
 }
 
 // Given a string and a map, replace the variables in the string with quoted values in the map.
-// Currently, an "if" statement in a melange config can only have quoted strings or ${{variables}}
-// as comparision values with == and !=. If we want to be able to resolve an "if" that can be fed
-// back into melange, we need to maintain that requirement, so all variables get quoted once replaced.
+// The values in an "if" statement in a melange config can only be quoted strings or ${{variables}}.
+// If we want to be able to resolve an "if" that can be fed back into melange, we need to maintain
+// that requirement, so all variables get quoted once replaced.
 func MutateAndQuoteStringFromMap(with map[string]string, input string) (string, error) {
 	lookupWith := func(key string) (string, error) {
 		if val, ok := with[key]; ok {
@@ -54,6 +73,10 @@ func MutateAndQuoteStringFromMap(with map[string]string, input string) (string,
 			return strconv.Quote(val), nil
 		}
 
+		if stepOutputRegex.MatchString(key) {
+			return "", fmt.Errorf("step output %s can't be used here, as it is only known once the step has run", key)
+		}
+
 		return "", fmt.Errorf("variable %s not defined", key)
 	}
 
@@ -23,8 +23,10 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"path"
 	"slices"
 	"sort"
+	"strings"
 )
 
 // DownloadFile downloads a file and returns a path to it in temporary storage.
@@ -123,3 +125,43 @@ func Dedup[S ~[]E, E cmp.Ordered](s S) S {
 	slices.Sort(s)
 	return slices.Compact(s)
 }
+
+// MatchGlob reports whether the slash-separated name matches pattern. Each
+// segment of the pattern is matched with path.Match, and a "**" segment
+// matches any number of segments, including none. A leading "/" in the
+// pattern is ignored, since package paths are relative.
+func MatchGlob(pattern, name string) (bool, error) {
+	return matchGlobSegments(strings.Split(strings.TrimPrefix(pattern, "/"), "/"), strings.Split(name, "/"))
+}
+
+// ValidateGlob returns an error if pattern is not a valid MatchGlob pattern.
+func ValidateGlob(pattern string) error {
+	for _, segment := range strings.Split(strings.TrimPrefix(pattern, "/"), "/") {
+		if _, err := path.Match(segment, ""); err != nil {
+			return fmt.Errorf("invalid glob %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+func matchGlobSegments(pattern, name []string) (bool, error) {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if ok, err := matchGlobSegments(pattern[1:], name[i:]); ok || err != nil {
+					return ok, err
+				}
+			}
+			return false, nil
+		}
+
+		if len(name) == 0 {
+			return false, nil
+		}
+		if ok, err := path.Match(pattern[0], name[0]); err != nil || !ok {
+			return false, err
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0, nil
+}
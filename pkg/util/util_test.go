@@ -12,3 +12,29 @@ func TestDedup(t *testing.T) {
 
 	require.Equal(t, len(b), 12, "the deduplicated list should have 12 elements")
 }
+
+func TestMatchGlob(t *testing.T) {
+	for _, c := range []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"usr/local/share/foo/**", "usr/local/share/foo/bar/baz.txt", true},
+		{"usr/local/share/foo/**", "usr/local/share/foo", true},
+		{"usr/local/share/foo/**", "usr/local/share/foobar/baz.txt", false},
+		{"/usr/lib/*.so", "usr/lib/libfoo.so", true},
+		{"usr/lib/*.so", "usr/lib/foo/libfoo.so", false},
+		{"usr/**/*.la", "usr/lib/foo/libfoo.la", true},
+		{"usr/**/*.la", "usr/libfoo.la", true},
+		{"usr/bin/foo", "usr/bin/foo", true},
+		{"usr/bin/foo", "usr/bin/foo/bar", false},
+	} {
+		got, err := MatchGlob(c.pattern, c.name)
+		require.NoError(t, err)
+		require.Equal(t, c.want, got, "MatchGlob(%q, %q)", c.pattern, c.name)
+	}
+
+	_, err := MatchGlob("usr/[", "usr/lib")
+	require.Error(t, err)
+	require.Error(t, ValidateGlob("usr/[/**"))
+	require.NoError(t, ValidateGlob("usr/**/*.la"))
+}